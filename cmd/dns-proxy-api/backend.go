@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"acme-dns-tools/internal/api"
+	"acme-dns-tools/internal/commands"
+	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/logging"
+	"acme-dns-tools/internal/metrics"
+	"acme-dns-tools/internal/provider"
+	"acme-dns-tools/internal/redact"
+)
+
+const cliPath = "/usr/local/bin/dns-proxy-cli"
+
+// sshfpTTL matches the publish-sshfp default.
+const sshfpTTL = 3600
+
+// recordBackend is an api.RecordBackend whose provider credentials can be
+// checked and reloaded.
+type recordBackend interface {
+	api.RecordBackend
+	// CheckCredentials validates the DNS provider credentials read-only.
+	CheckCredentials() error
+	// Reload applies a changed CLI config file.
+	Reload(cfg map[string]string) error
+}
+
+// libraryBackend calls the provider packages in-process, with the
+// credentials of the CLI config file.
+type libraryBackend struct {
+	mu  sync.RWMutex
+	cfg map[string]string
+	p   provider.Provider
+}
+
+func newLibraryBackend(cfg map[string]string) (*libraryBackend, error) {
+	b := &libraryBackend{}
+	if err := b.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *libraryBackend) Reload(cfg map[string]string) error {
+	p, err := provider.New(cfg)
+	if err != nil {
+		return err
+	}
+	redact.SetSecrets("provider", append(cpanel.APIKeys(cfg), provider.Secrets(cfg)...)...)
+	b.mu.Lock()
+	b.cfg, b.p = cfg, p
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *libraryBackend) load() (map[string]string, provider.Provider) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.cfg, b.p
+}
+
+// cpanelConfigFor returns the cPanel account for domain, for operations
+// only the cPanel provider supports.
+func (b *libraryBackend) cpanelConfigFor(op, domain string) (*cpanel.CPanelConfig, error) {
+	cfg, _ := b.load()
+	if name := provider.Name(cfg); name != provider.Default {
+		return nil, &api.UnsupportedError{Op: op, Provider: name}
+	}
+	return cpanel.NewCPanelConfigForDomain(cfg, domain)
+}
+
+func (b *libraryBackend) SetTxt(ctx context.Context, domain, key, value, mode string) (string, error) {
+	cfg, p := b.load()
+	return withContext(ctx, func() (string, error) {
+		if mode == api.ModeReplace {
+			r, ok := p.(provider.Replacer)
+			if !ok {
+				return "", &api.UnsupportedError{Op: "replace mode", Provider: provider.Name(cfg)}
+			}
+			return "", r.ReplaceTxtRecords(domain, key, value)
+		}
+		return "", p.CreateTxtRecord(domain, key, value)
+	})
+}
+
+// DryRunSetTxt compares value with the record's current values, read
+// through the provider.
+func (b *libraryBackend) DryRunSetTxt(ctx context.Context, domain, key, value, mode string) (string, error) {
+	cfg, p := b.load()
+	l, ok := p.(provider.Lister)
+	if !ok {
+		return "", &api.UnsupportedError{Op: "dry run", Provider: provider.Name(cfg)}
+	}
+	return withContext(ctx, func() (string, error) {
+		var out strings.Builder
+		err := commands.DryRunSetTxt(&out, l, domain, key, value, mode)
+		return out.String(), err
+	})
+}
+
+func (b *libraryBackend) DeleteTxt(ctx context.Context, domain, key, value string) (string, error) {
+	_, p := b.load()
+	return withContext(ctx, func() (string, error) {
+		err := p.DeleteTxtRecord(domain, key, value)
+		if errors.Is(err, provider.ErrRecordNotFound) {
+			return "TXT record not present; nothing to delete.", nil
+		}
+		return "", err
+	})
+}
+
+func (b *libraryBackend) PublishSSHFP(ctx context.Context, host string, keys []string, prune, dryRun bool) (string, error) {
+	return withContext(ctx, func() (string, error) {
+		cpCfg, err := b.cpanelConfigFor("SSHFP publishing", host)
+		if err != nil {
+			return "", err
+		}
+		var out strings.Builder
+		err = commands.PublishSSHFP(&out, cpCfg, host, keys, sshfpTTL, prune, dryRun)
+		return out.String(), err
+	})
+}
+
+func (b *libraryBackend) CheckCredentials() error {
+	_, p := b.load()
+	v, ok := p.(provider.Validator)
+	if !ok {
+		return nil
+	}
+	if err := v.Validate(); err != nil {
+		return fmt.Errorf("credential check failed: %w", err)
+	}
+	return nil
+}
+
+// inflight tracks provider calls still running, including those withContext
+// stopped waiting for, so a shutdown can let them finish.
+var inflight sync.WaitGroup
+
+// withContext runs fn until ctx expires. The provider clients have their own
+// timeouts, so an abandoned call still ends; its outcome is unknown.
+func withContext(ctx context.Context, fn func() (string, error)) (string, error) {
+	type result struct {
+		out string
+		err error
+	}
+	done := make(chan result, 1)
+	inflight.Add(1)
+	go func() {
+		defer inflight.Done()
+		out, err := fn()
+		done <- result{out, err}
+	}()
+	select {
+	case r := <-done:
+		return r.out, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// waitProviderCalls waits up to timeout for provider calls that are still
+// running and reports whether all of them finished.
+func waitProviderCalls(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// execBackend runs dns-proxy-cli for every operation (EXEC_CLI=true), for
+// hosts where the API must not read the provider credentials itself.
+type execBackend struct{}
+
+// run returns the CLI's standard output. Its standard error, with the cPanel
+// trace when the API logs at debug level, is only logged at debug level.
+func (execBackend) run(ctx context.Context, stdin string, args ...string) (string, error) {
+	flags := []string{"--config=" + cliConfigPath}
+	if logging.DebugEnabled() {
+		flags = append(flags, "--debug")
+	}
+	cmd := exec.CommandContext(ctx, cliPath, append(flags, args...)...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var stdout, stderr strings.Builder
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if stderr.Len() > 0 {
+		logging.Debugf("%s %s: %s", cliPath, args[0], redact.String(stderr.String()))
+	}
+	if err != nil && ctx.Err() != nil {
+		return stdout.String(), ctx.Err()
+	}
+	if err != nil {
+		return stdout.String(), fmt.Errorf("%s %s: %w", cliPath, args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+func (e execBackend) SetTxt(ctx context.Context, domain, key, value, mode string) (string, error) {
+	return e.run(ctx, "", "set-txt", "--domain", domain, "--key", key, "--value", value, "--mode", mode)
+}
+
+func (e execBackend) DryRunSetTxt(ctx context.Context, domain, key, value, mode string) (string, error) {
+	return e.run(ctx, "", "set-txt", "--domain", domain, "--key", key, "--value", value, "--mode", mode, "--dry-run")
+}
+
+func (e execBackend) DeleteTxt(ctx context.Context, domain, key, value string) (string, error) {
+	return e.run(ctx, "", "delete-txt", "--domain", domain, "--key", key, "--value", value, "--ignore-missing")
+}
+
+func (e execBackend) PublishSSHFP(ctx context.Context, host string, keys []string, prune, dryRun bool) (string, error) {
+	args := []string{"publish-sshfp", "--domain", host, "--key", "-", "--prune=" + strconv.FormatBool(prune)}
+	if dryRun {
+		args = append(args, "--dry-run")
+	}
+	return e.run(ctx, strings.Join(keys, "\n")+"\n", args...)
+}
+
+func (e execBackend) CheckCredentials() error {
+	// Only logged, so the CLI's explanation is worth keeping
+	if out, err := e.run(context.Background(), "", "check-credentials"); err != nil {
+		return fmt.Errorf("credential check failed: %w: %s", err, strings.TrimSpace(redact.String(out)))
+	}
+	return nil
+}
+
+// Reload is a no-op: the CLI reads its config file on every run.
+func (execBackend) Reload(map[string]string) error {
+	return nil
+}
+
+var (
+	providerCalls = metrics.NewCounterVec("dns_proxy_provider_calls_total",
+		"DNS changes sent to the provider, by operation and outcome (ok, error or timeout).", "operation", "outcome")
+	providerDuration = metrics.NewHistogramVec("dns_proxy_provider_call_duration_seconds",
+		"Duration of DNS changes sent to the provider, by operation.", metrics.DefaultBuckets, "operation")
+)
+
+// meteredBackend counts the calls of a backend, their outcome and duration.
+type meteredBackend struct {
+	recordBackend
+}
+
+func (m meteredBackend) SetTxt(ctx context.Context, domain, key, value, mode string) (string, error) {
+	start := time.Now()
+	out, err := m.recordBackend.SetTxt(ctx, domain, key, value, mode)
+	observe(ctx, "set_txt", start, err)
+	return out, err
+}
+
+func (m meteredBackend) DeleteTxt(ctx context.Context, domain, key, value string) (string, error) {
+	start := time.Now()
+	out, err := m.recordBackend.DeleteTxt(ctx, domain, key, value)
+	observe(ctx, "delete_txt", start, err)
+	return out, err
+}
+
+func (m meteredBackend) PublishSSHFP(ctx context.Context, host string, keys []string, prune, dryRun bool) (string, error) {
+	start := time.Now()
+	out, err := m.recordBackend.PublishSSHFP(ctx, host, keys, prune, dryRun)
+	if !dryRun {
+		observe(ctx, "sshfp", start, err)
+	}
+	return out, err
+}
+
+func observe(ctx context.Context, operation string, start time.Time, err error) {
+	providerDuration.ObserveSince(start, operation)
+	outcome := "ok"
+	if err != nil && ctx.Err() != nil {
+		outcome = "timeout"
+	} else if err != nil {
+		outcome = "error"
+	}
+	providerCalls.Inc(operation, outcome)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"acme-dns-tools/internal/api"
+)
+
+func TestUnsupportedOperationsAre501(t *testing.T) {
+	b, err := newLibraryBackend(map[string]string{"DNS_PROVIDER": "cloudflare", "cloudflare_api_token": "cf-token-123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.PublishSSHFP(context.Background(), "web1.example.com", []string{"ssh-ed25519 AAAA"}, false, true)
+	if err == nil {
+		t.Fatal("PublishSSHFP succeeded with the cloudflare provider")
+	}
+	var unsupported *api.UnsupportedError
+	if !errors.As(err, &unsupported) {
+		t.Errorf("error %v is not an api.UnsupportedError, so the handler would not answer 501", err)
+	}
+	if !strings.Contains(err.Error(), "cloudflare") {
+		t.Errorf("error %q does not name the provider", err)
+	}
+}
@@ -2,95 +2,370 @@ package main
 
 import (
 	"acme-dns-tools/internal/api"
+	"acme-dns-tools/internal/challenges"
 	"acme-dns-tools/internal/config"
-	"encoding/json"
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/fcrdns"
+	"acme-dns-tools/internal/history"
+	"acme-dns-tools/internal/logging"
+	"acme-dns-tools/internal/maintenance"
+	"acme-dns-tools/internal/metrics"
+	"acme-dns-tools/internal/netmatch"
+	"acme-dns-tools/internal/quota"
+	"acme-dns-tools/internal/ratelimit"
+	"acme-dns-tools/internal/readiness"
+	"acme-dns-tools/internal/redact"
+	"acme-dns-tools/internal/server"
+	"acme-dns-tools/internal/usage"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
-	"os/exec"
+	"os"
 	"strings"
+	"time"
 )
 
-const configPath = "/etc/acme-dns-tools/dns-proxy-api.conf"
-const defaultCertsBaseDir = "/etc/letsencrypt/live"
+// Config file locations (--config, --cli-config); dns-proxy-cli is run with
+// the same --config under EXEC_CLI.
+var (
+	configPath    = "/etc/acme-dns-tools/dns-proxy-api.conf"
+	cliConfigPath = "/etc/acme-dns-tools/dns-proxy-cli.conf"
+)
 
 func main() {
-	cfg := config.LoadConfig(configPath)
+	flag.StringVar(&configPath, "config", configPath, "API config file")
+	flag.StringVar(&cliConfigPath, "cli-config", cliConfigPath, "DNS provider config file")
+	flag.Parse()
+
+	// Redact tokens, challenge values and key material from every log line
+	log.SetOutput(redact.Writer(os.Stderr))
+
+	// Environment variables (ACME_DNS_TOOLS_<KEY>) override the file; every
+	// missing or malformed key is reported before anything starts
+	raw := api.Schema.MustLoad(configPath)
+	cfg := api.DecodeConfig(raw)
 
-	// --- DNS management API key (existing) ---
-	apiKey := cfg["DNS_RESOLVER_API_TOKEN"]
-	if apiKey == "" {
-		log.Fatal("DNS_RESOLVER_API_TOKEN not found in config file")
+	// --- Log format: text (default) or json, one record per line ---
+	if err := logging.Setup(redact.Writer(os.Stderr), cfg.LogFormat); err != nil {
+		log.Fatalf("LOG_FORMAT: %v", err)
 	}
 
-	// --- Cert serving: Bearer token ---
-	certBearerToken := cfg["CERT_BEARER_TOKEN"]
-	if certBearerToken == "" {
-		log.Fatal("CERT_BEARER_TOKEN not found in config file")
+	// --- Log level (info by default; toggle debug at runtime with SIGUSR1) ---
+	if level := cfg.LogLevel; level != "" {
+		if err := logging.SetLevel(level); err != nil {
+			log.Fatalf("LOG_LEVEL: %v", err)
+		}
 	}
+	logging.ToggleOnSIGUSR1()
 
-	// --- Cert serving: DNS allowlist (comma-separated hostnames, FCrDNS) ---
-	certDNSAllowlistRaw := cfg["CERT_DNS_ALLOWLIST"]
-	if certDNSAllowlistRaw == "" {
-		log.Fatal("CERT_DNS_ALLOWLIST not found in config file")
+	// --- Tokens and certificate allowlists (reloaded when the file changes) ---
+	initialSettings, err := loadSettings(raw)
+	if err != nil {
+		log.Fatal(err)
 	}
-	var certDNSAllowlist []string
-	for _, h := range strings.Split(certDNSAllowlistRaw, ",") {
-		h = strings.TrimSpace(h)
-		if h != "" {
-			certDNSAllowlist = append(certDNSAllowlist, h)
-		}
+	settings := api.NewLiveSettings(initialSettings)
+
+	// --- Cert serving: FCrDNS lookup timeout per step (optional) ---
+	if cfg.FCrDNSTimeout > 0 {
+		fcrdns.LookupTimeout = cfg.FCrDNSTimeout
+	}
+
+	// --- Abuse protection: per-IP rate limit and authentication lockout (0 disables) ---
+	limits := ratelimit.Config{
+		Rate:            cfg.RateLimitRPS,
+		Burst:           cfg.RateLimitBurst,
+		LockoutFailures: cfg.AuthLockoutFailures,
+		Lockout:         cfg.AuthLockout,
 	}
+	if limits.Burst == 0 {
+		limits.Burst = int(2 * limits.Rate)
+	}
+
+	// --- Listener: address, timeouts and shutdown grace period ---
+	// The default write timeout leaves /check_txt its full wait
+	srvOpts := cfg.Options(cfg.PropagationTimeout + 30*time.Second)
 
-	// --- Cert serving: base directory (optional, defaults to letsencrypt live) ---
-	certsBaseDir := cfg["CERT_BASE_DIR"]
-	if certsBaseDir == "" {
-		certsBaseDir = defaultCertsBaseDir
+	// --- Cert serving: remember failed FCrDNS checks briefly (0 disables) ---
+	var fcrdnsCache *fcrdns.NegativeCache
+	if ttl := cfg.FCrDNSNegativeCacheTTL; ttl > 0 {
+		fcrdnsCache = fcrdns.NewNegativeCache(ttl)
 	}
 
-	// --- TLS (optional) ---
-	tlsCert := cfg["TLS_CERT"]
-	tlsKey := cfg["TLS_KEY"]
+	// --- Cert serving: client certificates, signatures and audit log (optional) ---
+	clientAuth, clientCAs := certClientAuth(cfg)
+	signer := certSigner(cfg)
+	certAudit := certAuditLog(cfg)
 
-	// --- /set_txt handler (existing) ---
-	http.HandleFunc("/set_txt", func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		expected := "Bearer " + apiKey
-		if authHeader != expected {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// --- Per-token quotas and usage metering (reported on /admin/usage) ---
+	quotas := quota.New(quotaLimits(cfg))
+	usageTracker := usage.New()
+
+	// --- Maintenance mode (admin endpoint only if ADMIN_API_TOKEN is set) ---
+	maint := maintenance.New(cfg.MaintenanceRetryAfter)
+	if cfg.MaintenanceMode {
+		maint.Enable("enabled in config file")
+	}
+
+	// --- Certificate Transparency monitoring (optional) ---
+	startCTMonitor(cfg)
+
+	// --- Hot reload of tokens and allowlists (on change or SIGHUP) ---
+	api.Schema.Watch(configPath, 5*time.Second, func(cfg config.Values) {
+		next, err := loadSettings(cfg)
+		if err != nil {
+			log.Printf("config: keeping previous tokens and allowlists: %v", err)
 			return
 		}
+		settings.Store(next)
+		// A host refused under the old allowlist may be allowed now
+		fcrdnsCache.Reset()
+		log.Printf("config: reloaded tokens and allowlists from %s", configPath)
+	})
 
-		var req struct {
-			Domain string `json:"domain"`
-			Key    string `json:"key"`
-			Value  string `json:"value"`
-		}
-		err := json.NewDecoder(r.Body).Decode(&req)
-		if err != nil || req.Domain == "" || req.Key == "" || req.Value == "" {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
+	// --- DNS backend and provider credential validation ---
+	ready := readiness.New()
+	backend := newBackend(cfg, ready)
+
+	// --- Record operation history (optional) ---
+	var recordHistory *history.Store
+	if path := cfg.HistoryFile; path != "" {
+		recordHistory, err = history.Open(path)
+		if err != nil {
+			log.Fatalf("failed to open HISTORY_FILE %s: %v", path, err)
 		}
+	}
+
+	// --- Challenge cleanup on shutdown (CLEANUP_TIMEOUT=0 disables) ---
+	var outstanding *challenges.Tracker
+	if cfg.CleanupTimeout > 0 {
+		outstanding = challenges.New()
+	}
+
+	// --- Disk and storage health (reported on /readyz and in metrics) ---
+	checkStorage(cfg, ready, recordHistory)
+
+	// --- StatsD push (optional, alternative to scraping /metrics) ---
+	startStatsD(cfg)
+
+	mux := http.NewServeMux()
+
+	// --- /set_txt, /delete_txt and /v1/sshfp handlers ---
+	records := api.RecordsConfig{
+		Settings:          settings,
+		Backend:           backend,
+		ACMEChallengeOnly: cfg.ACMEChallengeOnly,
+		Quotas:            quotas,
+		Usage:             usageTracker,
+		History:           recordHistory,
+		Challenges:        outstanding,
+	}
+	setTxtHandler := metrics.Instrument("set_txt", maint.Guard(api.SetTxtHandler(records)))
+	deleteTxtHandler := metrics.Instrument("delete_txt", maint.Guard(api.DeleteTxtHandler(records)))
+	mux.Handle("/set_txt", setTxtHandler)
+	mux.Handle("/delete_txt", deleteTxtHandler)
+	mux.Handle("/v1/sshfp", metrics.Instrument("sshfp", maint.Guard(api.SSHFPHandler(records))))
+
+	// --- /check_txt handler (waits for a TXT value to propagate) ---
+	mux.Handle("/check_txt", metrics.Instrument("check_txt", api.CheckTxtHandler(api.CheckTxtConfig{
+		Settings: settings,
+		Timeout:  cfg.PropagationTimeout,
+		Interval: cfg.PropagationInterval,
+	})))
 
-		cmd := exec.Command("/usr/local/bin/dns-proxy-cli", "set-txt", "--domain", req.Domain, "--key", req.Key, "--value", req.Value)
-		output, err := cmd.CombinedOutput()
+	// --- lego httpreq compatibility (HTTPREQ_ENDPOINT=<api>/httpreq) ---
+	if cfg.HTTPReqEnabled {
+		mux.HandleFunc("/httpreq/present", api.HTTPReqHandler(setTxtHandler))
+		mux.HandleFunc("/httpreq/cleanup", api.HTTPReqHandler(deleteTxtHandler))
+		log.Println("httpreq: serving lego's httpreq endpoints on /httpreq/")
+	}
+
+	// --- acme-dns compatibility (/acmedns/register, /acmedns/update) ---
+	if zone := cfg.ACMEDNSZone; zone != "" {
+		acmeDNS, err := api.NewACMEDNS(api.ACMEDNSConfig{
+			Settings:         settings,
+			Zone:             zone,
+			AccountsFile:     cfg.ACMEDNSAccountsFile,
+			OpenRegistration: cfg.ACMEDNSOpenRegistration,
+			SetTxt:           setTxtHandler,
+			DeleteTxt:        deleteTxtHandler,
+		})
 		if err != nil {
-			log.Printf("dns-proxy-cli error: %v, output: %s", err, string(output))
-			http.Error(w, string(output), http.StatusInternalServerError)
-			return
+			log.Fatalf("failed to load ACMEDNS_ACCOUNTS_FILE: %v", err)
 		}
+		mux.HandleFunc("/acmedns/register", acmeDNS.Register)
+		mux.HandleFunc("/acmedns/update", acmeDNS.Update)
+		log.Printf("acme-dns: serving /acmedns/register and /acmedns/update for accounts below %s", zone)
+	}
 
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("TXT record set"))
+	// --- /certs/ handler (new: pull-based cert serving) ---
+	var certsHandler http.Handler = api.CertsHandler(api.CertsConfig{
+		Settings:    settings,
+		BaseDir:     cfg.CertBaseDir,
+		Quotas:      quotas,
+		Signer:      signer,
+		Usage:       usageTracker,
+		FCrDNSCache: fcrdnsCache,
+		ClientAuth:  clientAuth,
 	})
+	if certAudit != nil {
+		certsHandler = logging.Audit(certAudit, certsHandler)
+	}
+	mux.Handle("/certs/", metrics.Instrument("certs", certsHandler))
 
-	// --- /certs/ handler (new: pull-based cert serving) ---
-	http.Handle("/certs/", api.CertsHandler(certBearerToken, certDNSAllowlist, certsBaseDir))
+	// --- /admin/ handlers ---
+	// The endpoints exist if ADMIN_API_TOKEN was set at startup; the token
+	// itself is reloaded with the others
+	if initialSettings.AdminToken != "" {
+		mux.Handle("/admin/maintenance", admin(settings, func(token string) http.Handler { return maint.AdminHandler(token) }))
+		mux.Handle("/admin/usage", admin(settings, func(token string) http.Handler { return usageTracker.AdminHandler(token) }))
+		mux.Handle("/admin/log-level", admin(settings, func(token string) http.Handler { return logging.AdminHandler(token) }))
+		if cfg.PprofEnabled {
+			mux.Handle("/admin/debug/pprof/", admin(settings, api.PprofHandler))
+			log.Println("pprof profiles enabled on /admin/debug/pprof/")
+		}
+	}
 
-	if tlsCert != "" && tlsKey != "" {
-		log.Println("dns-proxy API listening on :5000 (TLS)...")
-		log.Fatal(http.ListenAndServeTLS(":5000", tlsCert, tlsKey, nil))
+	// --- /readyz handler (unauthenticated; names failing checks only) ---
+	mux.HandleFunc("/readyz", ready.Handler())
+
+	// --- /v1/keyauth handler (DNS-01 value helper) ---
+	mux.HandleFunc("/v1/keyauth", api.KeyAuthHandler(settings.APIToken))
+
+	// --- /v1/records/history handler ---
+	if recordHistory != nil {
+		mux.HandleFunc("/v1/records/history", recordHistory.Handler(settings.HistoryAccess))
+	}
+
+	// --- /v1/capabilities handler (feature discovery) ---
+	mux.HandleFunc("/v1/capabilities", api.CapabilitiesHandler(capabilities(cfg, initialSettings, clientAuth)))
+
+	// --- /metrics handler (Prometheus text format), optionally on its own listener ---
+	if cfg.MetricsEnabled {
+		serveMetrics(cfg, mux)
+	}
+
+	// On SIGTERM/SIGINT: stop accepting connections, let in-flight requests and
+	// provider calls finish, then remove the challenge records this process created
+	srv := srvOpts.Server(wrapHandler(cfg, limits, mux))
+	if clientCAs != nil {
+		// Only /certs/ insists on a certificate; other clients may connect without one
+		srv.TLSConfig = &tls.Config{ClientCAs: clientCAs, ClientAuth: tls.VerifyClientCertIfGiven}
+	}
+	if cfg.TLSCert != "" && cfg.TLSKey != "" {
+		log.Printf("dns-proxy API listening on %s (TLS)...", srvOpts.Addr)
 	} else {
-		log.Println("dns-proxy API listening on :5000 (plain HTTP)...")
-		log.Fatal(http.ListenAndServe(":5000", nil))
+		log.Printf("dns-proxy API listening on %s (plain HTTP)...", srvOpts.Addr)
+	}
+	if err := server.Serve(srv, cfg.TLSCert, cfg.TLSKey, srvOpts.ShutdownTimeout, nil); err != nil {
+		log.Fatal(err)
+	}
+	if !waitProviderCalls(srvOpts.ShutdownTimeout) {
+		log.Printf("shutdown: provider calls still running after %s; their outcome is unknown", srvOpts.ShutdownTimeout)
+	}
+	if outstanding != nil {
+		cleanupChallenges(outstanding, cfg.CleanupTimeout, backend, recordHistory)
+	}
+	log.Println("dns-proxy API stopped")
+}
+
+// admin serves the handler h builds for the current ADMIN_API_TOKEN, so a
+// rotated token applies without a restart.
+func admin(settings *api.LiveSettings, h func(token string) http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h(settings.Load().AdminToken).ServeHTTP(w, r)
+	})
+}
+
+// loadSettings reads the runtime-replaceable settings: the API, cert and
+// admin tokens, the FCrDNS and IP allowlists and the per-host access map
+// (CERT_ACCESS.<hostname or *.suffix>=<name>,<name>,...).
+func loadSettings(cfg config.Values) (api.Settings, error) {
+	c := api.DecodeConfig(cfg)
+	s := api.Settings{
+		APIToken:        c.APIToken,
+		CertBearerToken: c.CertBearerToken,
+		CertAccess:      api.CertAccess{},
+		AdminToken:      c.AdminToken,
+	}
+	if s.APIToken == "" {
+		return s, errors.New("DNS_RESOLVER_API_TOKEN not found in config file")
+	}
+	if s.CertBearerToken == "" {
+		return s, errors.New("CERT_BEARER_TOKEN not found in config file")
+	}
+
+	tenants, err := api.ParseTenants(cfg)
+	if err != nil {
+		return s, err
+	}
+	clients, err := api.ParseClients(cfg, tenants)
+	if err != nil {
+		return s, err
+	}
+	for _, c := range clients {
+		if c.Token == s.APIToken || c.Token == s.CertBearerToken {
+			return s, fmt.Errorf("CLIENT.%s.TOKEN must differ from DNS_RESOLVER_API_TOKEN and CERT_BEARER_TOKEN", c.Name)
+		}
+	}
+	s.Clients, s.Tenants = clients, tenants
+
+	s.DNSAllowlist = c.CertDNSAllow
+	ipAllowlist, err := netmatch.Parse(strings.Join(c.CertIPAllow, ","))
+	if err != nil {
+		return s, fmt.Errorf("CERT_IP_ALLOWLIST: %w", err)
+	}
+	s.IPAllowlist = ipAllowlist
+	// Hosts identified by client certificate alone need no allowlist
+	if len(s.DNSAllowlist) == 0 && len(s.IPAllowlist) == 0 && c.CertClientAuth != api.CertAuthMTLS {
+		return s, errors.New("neither CERT_DNS_ALLOWLIST nor CERT_IP_ALLOWLIST found in config file")
+	}
+
+	for k, v := range cfg {
+		host, ok := strings.CutPrefix(k, "CERT_ACCESS.")
+		if !ok {
+			continue
+		}
+		var names []string
+		for _, n := range strings.Split(v, ",") {
+			if n = strings.TrimSpace(n); n != "" {
+				names = append(names, n)
+			}
+		}
+		s.CertAccess[dnsname.Canonical(host)] = names
+	}
+
+	// Replaced rather than added to, so every reload does not grow the list
+	secrets := []string{s.APIToken, s.CertBearerToken, s.AdminToken}
+	for _, c := range clients {
+		secrets = append(secrets, c.Token)
+	}
+	redact.SetSecrets("settings", secrets...)
+	return s, nil
+}
+
+// deleteChallenge removes a challenge record and records the outcome in the
+// history.
+func deleteChallenge(ctx context.Context, backend recordBackend, recordHistory *history.Store, rec challenges.Record) error {
+	_, err := backend.DeleteTxt(ctx, rec.Domain, rec.Key, rec.Value)
+	entry := history.Entry{
+		Operation: history.OpDelete,
+		Domain:    rec.Domain,
+		Key:       rec.Key,
+		Client:    "shutdown cleanup",
+		Outcome:   history.OutcomeOK,
+		Summary:   "TXT record deleted on shutdown",
+	}
+	if err != nil {
+		entry.Outcome = history.OutcomeError
+		entry.Summary = redact.String(err.Error(), rec.Value)
+		err = errors.New(entry.Summary)
+	}
+	if herr := recordHistory.Append(entry); herr != nil {
+		log.Printf("history: %v", herr)
 	}
+	return err
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acme-dns-tools/internal/api"
+	"acme-dns-tools/internal/config"
+	"acme-dns-tools/internal/redact"
+)
+
+func TestAdminTokenReload(t *testing.T) {
+	cfg := config.Values{
+		"DNS_RESOLVER_API_TOKEN": "api-token",
+		"CERT_BEARER_TOKEN":      "cert-token",
+		"CERT_DNS_ALLOWLIST":     "web1.example.com",
+		"ADMIN_API_TOKEN":        "admin-old",
+	}
+	s, err := loadSettings(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := api.NewLiveSettings(s)
+	h := admin(settings, func(token string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer "+token {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			}
+		})
+	})
+	status := func(token string) int {
+		r := httptest.NewRequest("GET", "/admin/usage", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	cfg["ADMIN_API_TOKEN"] = "admin-new"
+	s, err = loadSettings(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.Store(s)
+	if status("admin-old") != http.StatusUnauthorized || status("admin-new") != http.StatusOK {
+		t.Errorf("after the reload: old token %d, new token %d; want 401 and 200", status("admin-old"), status("admin-new"))
+	}
+	if got := redact.String("token admin-new"); strings.Contains(got, "admin-new") {
+		t.Errorf("rotated admin token not redacted: %q", got)
+	}
+}
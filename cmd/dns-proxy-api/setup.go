@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"acme-dns-tools/internal/api"
+	"acme-dns-tools/internal/challenges"
+	"acme-dns-tools/internal/config"
+	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/ctmonitor"
+	"acme-dns-tools/internal/history"
+	"acme-dns-tools/internal/logging"
+	"acme-dns-tools/internal/metrics"
+	"acme-dns-tools/internal/netmatch"
+	"acme-dns-tools/internal/provider"
+	"acme-dns-tools/internal/quota"
+	"acme-dns-tools/internal/ratelimit"
+	"acme-dns-tools/internal/readiness"
+	"acme-dns-tools/internal/redact"
+	"acme-dns-tools/internal/signing"
+)
+
+// certClientAuth returns how /certs/ identifies hosts (CERT_CLIENT_AUTH)
+// and the CAs their client certificates must chain to, if any.
+func certClientAuth(cfg api.Config) (string, *x509.CertPool) {
+	mode := cfg.CertClientAuth
+	var clientCAs *x509.CertPool
+	if path := cfg.CertClientCA; path != "" {
+		if cfg.TLSCert == "" || cfg.TLSKey == "" {
+			log.Fatal("CERT_CLIENT_CA requires TLS_CERT and TLS_KEY")
+		}
+		var err error
+		clientCAs, err = api.LoadClientCAs(path)
+		if err != nil {
+			log.Fatalf("failed to load CERT_CLIENT_CA: %v", err)
+		}
+		if mode == "" {
+			mode = api.CertAuthBoth
+		}
+	}
+	switch mode {
+	case "", api.CertAuthFCrDNS:
+		mode = api.CertAuthFCrDNS
+	default:
+		if clientCAs == nil {
+			log.Fatalf("CERT_CLIENT_AUTH=%s requires CERT_CLIENT_CA", mode)
+		}
+		log.Printf("certs: identifying hosts by client certificate (CERT_CLIENT_AUTH=%s)", mode)
+	}
+	return mode, clientCAs
+}
+
+// certSigner loads CERT_SIGNING_KEY; nil if served files are not signed.
+func certSigner(cfg api.Config) *signing.Signer {
+	path := cfg.CertSigningKey
+	if path == "" {
+		return nil
+	}
+	signer, err := signing.LoadSigner(path)
+	if err != nil {
+		log.Fatalf("failed to load CERT_SIGNING_KEY %s: %v", path, err)
+	}
+	log.Printf("certs: signing served files; minisign public key: %s", signer.PublicKey())
+	return signer
+}
+
+// certAuditLog opens CERT_AUDIT_LOG (JSON, rotated); nil if unset.
+func certAuditLog(cfg api.Config) *slog.Logger {
+	path := cfg.CertAuditLog
+	if path == "" {
+		return nil
+	}
+	f, err := logging.OpenRotatingFile(path, int64(cfg.CertAuditLogMaxMB)<<20, cfg.CertAuditLogKeep)
+	if err != nil {
+		log.Fatalf("CERT_AUDIT_LOG: %v", err)
+	}
+	log.Printf("certs: writing audit log to %s", path)
+	return slog.New(slog.NewJSONHandler(redact.Writer(f), nil))
+}
+
+// quotaLimits returns the per-token budgets; zero means unlimited.
+func quotaLimits(cfg api.Config) map[string]quota.Limits {
+	return map[string]quota.Limits{
+		quota.OpMutation: {
+			Hourly: cfg.QuotaMutationsHourly,
+			Daily:  cfg.QuotaMutationsDaily,
+		},
+		quota.OpCertDownload: {
+			Hourly: cfg.QuotaCertDownloadsHourly,
+			Daily:  cfg.QuotaCertDownloadsDaily,
+		},
+	}
+}
+
+// startCTMonitor watches Certificate Transparency logs for CT_MONITOR_DOMAINS,
+// if set.
+func startCTMonitor(cfg api.Config) {
+	if len(cfg.CTMonitorDomains) == 0 {
+		return
+	}
+	if cfg.CTMonitorInterval < time.Minute {
+		log.Fatalf("CT_MONITOR_INTERVAL must be at least 60 seconds, got %d", int(cfg.CTMonitorInterval.Seconds()))
+	}
+	// certbot keeps every certificate it ever issued next to live/ in archive/
+	certDirs := []string{cfg.CertBaseDir}
+	if archive := filepath.Join(filepath.Dir(cfg.CertBaseDir), "archive"); archive != cfg.CertBaseDir {
+		certDirs = append(certDirs, archive)
+	}
+	monitor := ctmonitor.New(ctmonitor.Config{
+		Domains:  cfg.CTMonitorDomains,
+		CertDirs: certDirs,
+		Interval: cfg.CTMonitorInterval,
+		Webhook:  cfg.CTMonitorWebhook,
+	})
+	go monitor.Run()
+}
+
+// newBackend returns the DNS backend: the provider packages in-process, or
+// dns-proxy-cli (EXEC_CLI=true). It validates the provider credentials if
+// VALIDATE_CREDENTIALS is set, and reloads (and re-validates) them when the
+// CLI config changes; failures then mark the instance not ready.
+func newBackend(cfg api.Config, ready *readiness.Checks) recordBackend {
+	var backend recordBackend = execBackend{}
+	if !cfg.ExecCLI {
+		cliCfg, err := provider.Schema.Load(cliConfigPath)
+		if err != nil {
+			log.Fatalf("failed to load DNS provider config: %v", err)
+		}
+		lb, err := newLibraryBackend(cliCfg)
+		if err != nil {
+			log.Fatalf("%s: %v", cliConfigPath, err)
+		}
+		backend = lb
+		// The cPanel call trace is only wanted when debugging
+		cpanel.SetDebugOutput(logging.DebugWriter(redact.Writer(os.Stderr)))
+	} else {
+		log.Printf("running %s for every DNS change (EXEC_CLI=true)", cliPath)
+	}
+	backend = meteredBackend{backend}
+
+	if cfg.ValidateCredentials {
+		if err := backend.CheckCredentials(); err != nil {
+			log.Fatalf("refusing to start: %v", err)
+		}
+		log.Println("DNS provider credentials validated")
+	}
+	provider.Schema.Watch(cliConfigPath, 5*time.Second, func(cliCfg config.Values) {
+		err := backend.Reload(cliCfg)
+		if err == nil && cfg.ValidateCredentials {
+			err = backend.CheckCredentials()
+		}
+		ready.Set("credentials", err)
+		if err != nil {
+			log.Printf("config: %s changed and is now invalid: %v", cliConfigPath, err)
+			return
+		}
+		log.Printf("config: reloaded DNS provider settings from %s", cliConfigPath)
+	})
+	return backend
+}
+
+// checkStorage reports the free space of the cert directory and, with
+// history enabled, of the state directory and the history file on /readyz
+// and in metrics.
+func checkStorage(cfg api.Config, ready *readiness.Checks, recordHistory *history.Store) {
+	certDirCheck := readiness.DiskCheck{
+		Name:     "cert_base_dir",
+		Dir:      cfg.CertBaseDir,
+		MinFree:  uint64(cfg.DiskMinFreeMB) << 20,
+		Writable: cfg.CertBaseDirWritable,
+	}
+	ready.RunEvery(certDirCheck.Name, cfg.HealthCheckInterval, certDirCheck.Run, log.Printf)
+	if recordHistory == nil {
+		return
+	}
+	stateDirCheck := readiness.DiskCheck{
+		Name:     "state_dir",
+		Dir:      filepath.Dir(recordHistory.Path()),
+		MinFree:  uint64(cfg.DiskMinFreeMB) << 20,
+		Writable: true,
+	}
+	ready.RunEvery(stateDirCheck.Name, cfg.HealthCheckInterval, stateDirCheck.Run, log.Printf)
+	ready.RunEvery("history_file", cfg.HealthCheckInterval, recordHistory.Verify, log.Printf)
+}
+
+// startStatsD pushes metrics to STATSD_ADDR, if set.
+func startStatsD(cfg api.Config) {
+	addr := cfg.StatsDAddr
+	if addr == "" {
+		return
+	}
+	prefix := cfg.StatsDPrefix
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	err := metrics.StartStatsD(metrics.StatsDConfig{
+		Addr:     addr,
+		Prefix:   prefix,
+		Interval: cfg.StatsDFlush,
+	})
+	if err != nil {
+		log.Fatalf("failed to set up StatsD export to %s: %v", addr, err)
+	}
+	log.Printf("statsd: pushing metrics to %s every %s", addr, cfg.StatsDFlush)
+}
+
+// serveMetrics serves /metrics on mux, or on its own listener if
+// METRICS_LISTEN is set, and keeps the certificate expiry gauges current.
+func serveMetrics(cfg api.Config, mux *http.ServeMux) {
+	if addr := cfg.MetricsListen; addr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		metricsSrv := &http.Server{Addr: addr, Handler: metricsMux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			log.Fatal(metricsSrv.ListenAndServe())
+		}()
+		log.Printf("metrics: serving /metrics on %s (plain HTTP)", addr)
+	} else {
+		mux.Handle("/metrics", metrics.Handler())
+	}
+	go func() {
+		for {
+			if err := api.UpdateCertExpiry(cfg.CertBaseDir); err != nil {
+				log.Printf("metrics: cannot read certificate expiry: %v", err)
+			}
+			time.Sleep(cfg.HealthCheckInterval)
+		}
+	}()
+}
+
+// wrapHandler adds what applies to every route: the per-IP rate limit and
+// lockout, the base path, request logging and trusted proxies.
+func wrapHandler(cfg api.Config, limits ratelimit.Config, handler http.Handler) http.Handler {
+	// Per-IP rate limit and lockout after repeated authentication failures;
+	// health checks and scrapes are never throttled
+	if limits.Rate > 0 || limits.LockoutFailures > 0 {
+		handler = ratelimit.New(limits).Handler(handler, "/readyz", "/metrics")
+	}
+
+	// All routes live under basePath; the mux only ever sees the path below it
+	basePath := "/" + strings.Trim(cfg.BasePath, "/")
+	if basePath != "/" {
+		root := http.NewServeMux()
+		root.Handle(basePath+"/", http.StripPrefix(basePath, handler))
+		handler = root
+		log.Printf("dns-proxy API serving routes under %s/", basePath)
+	}
+
+	// One structured record per request; health checks and scrapes only at
+	// debug level. The records show the path as received, base path included
+	quiet := []string{"/readyz", "/metrics"}
+	if basePath != "/" {
+		for i := range quiet {
+			quiet[i] = basePath + quiet[i]
+		}
+	}
+	handler = logging.Requests(handler, quiet...)
+
+	// Behind a reverse proxy, take the client address from X-Forwarded-For,
+	// but only on connections from the proxies themselves
+	if raw := strings.Join(cfg.TrustedProxies, ","); raw != "" {
+		trusted, err := netmatch.Parse(raw)
+		if err != nil {
+			log.Fatalf("TRUSTED_PROXIES: %v", err)
+		}
+		handler = netmatch.TrustProxies(trusted, handler)
+		log.Printf("trusting X-Forwarded-For from %s", raw)
+	}
+	return handler
+}
+
+// capabilities describes what this instance offers on /v1/capabilities.
+// Features that depend on the settings reflect those at startup.
+func capabilities(cfg api.Config, s api.Settings, clientAuth string) api.Capabilities {
+	adminEnabled := s.AdminToken != ""
+	limits := quotaLimits(cfg)
+	return api.Capabilities{
+		Providers:   provider.Names(),
+		CertFormats: []string{"pem"},
+		CertFiles:   api.CertFiles(),
+		SetTxtModes: []string{api.ModeAppend, api.ModeReplace},
+		Features: map[string]bool{
+			"acme_challenge_only": cfg.ACMEChallengeOnly,
+			"cert_access_map":     len(s.CertAccess) > 0,
+			"cert_client_certs":   clientAuth != api.CertAuthFCrDNS,
+			"cert_audit_log":      cfg.CertAuditLog != "",
+			"client_tokens":       len(s.Clients) > 0,
+			"tenants":             len(s.Tenants) > 0,
+			"cert_bundle":         true,
+			"cert_pins":           true,
+			"cert_meta":           true,
+			"cert_etag":           true,
+			"cert_range":          true,
+			"delete_txt":          true,
+			"check_txt":           true,
+			"dry_run":             true,
+			"request_deadline":    true,
+			"key_authorization":   true,
+			"record_history":      cfg.HistoryFile != "",
+			"cert_signatures":     cfg.CertSigningKey != "",
+			"ct_monitor":          len(cfg.CTMonitorDomains) > 0,
+			"maintenance_admin":   adminEnabled,
+			"usage_reporting":     adminEnabled,
+			"pprof":               adminEnabled && cfg.PprofEnabled,
+			"metrics":             cfg.MetricsEnabled,
+			"statsd":              cfg.StatsDAddr != "",
+			"sshfp":               !cfg.ACMEChallengeOnly,
+			"challenge_cleanup":   cfg.CleanupTimeout > 0,
+			"credential_check":    cfg.ValidateCredentials,
+			"quotas":              limits[quota.OpMutation] != (quota.Limits{}) || limits[quota.OpCertDownload] != (quota.Limits{}),
+			"async_jobs":          false,
+			"push_delivery":       false,
+			"acme_dns_compat":     cfg.ACMEDNSZone != "",
+			"httpreq_compat":      cfg.HTTPReqEnabled,
+			"rate_limit":          cfg.RateLimitRPS > 0,
+			"auth_lockout":        cfg.AuthLockoutFailures > 0,
+		},
+	}
+}
+
+// cleanupChallenges deletes the challenge records this process created and
+// did not delete, within timeout.
+func cleanupChallenges(outstanding *challenges.Tracker, timeout time.Duration, backend recordBackend, recordHistory *history.Store) {
+	log.Printf("deleting %d outstanding challenge record(s)", len(outstanding.Outstanding()))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	deleted, left := outstanding.Cleanup(ctx, func(ctx context.Context, rec challenges.Record) error {
+		return deleteChallenge(ctx, backend, recordHistory, rec)
+	}, log.Printf)
+	log.Printf("cleanup: deleted %d challenge record(s), %d left in the zone", deleted, len(left))
+	for _, rec := range left {
+		log.Printf("cleanup: left %s.%s", rec.Key, rec.Domain)
+	}
+}
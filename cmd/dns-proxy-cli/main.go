@@ -1,56 +1,75 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"acme-dns-tools/internal/commands"
 	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/logging"
+	"acme-dns-tools/internal/provider"
+	"acme-dns-tools/internal/redact"
 )
 
-func loadCPanelConfig(path string) map[string]string {
-	cfg := make(map[string]string)
-	file, err := os.Open(path)
-	if err != nil {
-		log.Fatalf("Failed to open config file: %v", err)
-	}
-	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 {
-			cfg[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
-		}
-	}
-	return cfg
-}
-
 func main() {
+	// Keep tokens and challenge values out of logs and captured output
+	log.SetOutput(redact.Writer(os.Stderr))
+	// The cPanel call trace includes zone dumps and record values; it goes
+	// to stderr with --debug and nowhere otherwise
+	cpanel.SetDebugOutput(io.Discard)
+
 	ignoreErrors := false
+	configPath := "/etc/acme-dns-tools/dns-proxy-cli.conf"
 	filteredArgs := []string{}
-	for _, arg := range os.Args[1:] {
+	for i := 1; i < len(os.Args); i++ {
+		arg := os.Args[i]
 		if arg == "-i" || arg == "--ignore-errors" {
 			ignoreErrors = true
+		} else if arg == "--debug" {
+			cpanel.SetDebugOutput(redact.Writer(os.Stderr))
+		} else if arg == "--config" {
+			if i+1 == len(os.Args) {
+				fmt.Println("Error: --config requires a file")
+				os.Exit(1)
+			}
+			i++
+			configPath = os.Args[i]
+		} else if path, ok := strings.CutPrefix(arg, "--config="); ok {
+			configPath = path
+		} else if format, ok := strings.CutPrefix(arg, "--log-format="); ok {
+			// Structured records on stderr for hooks whose output is collected
+			if err := logging.Setup(redact.Writer(os.Stderr), format); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
 		} else {
 			filteredArgs = append(filteredArgs, arg)
 		}
 	}
 
 	if len(filteredArgs) < 1 {
-		fmt.Println("Usage: dns-proxy-cli [-i|--ignore-errors] <command> [options]")
+		fmt.Println("Usage: dns-proxy-cli [-i|--ignore-errors] [--debug] [--config <file>] [--log-format=text|json] <command> [options]")
 		fmt.Println("Commands:")
-		fmt.Println("  set-txt --domain <domain> --key <key> --value <value>")
-		fmt.Println("  delete-txt --domain <domain> --key <key> --value <value>")
+		fmt.Println("  set-txt --domain <domain> --key <key> --value <value> [--mode append|replace] [--dry-run]")
+		fmt.Println("  delete-txt --domain <domain> --key <key> --value <value> [--ignore-missing]")
 		fmt.Println("  edit-txt --domain <domain> --key <key> --old-value <old-value> --new-value <new-value>")
 		fmt.Println("  list-txt --domain <domain> [--key <key>]")
+		fmt.Println("  export-zone --domain <zone> [--output <file>]")
+		fmt.Println("  import-zone --domain <zone> --file <zone-file> [--dry-run]")
+		fmt.Println("  delegate-cname --domain <domain> --target <target> [--skip-check]")
+		fmt.Println("  verify-fcrdns --server <api-url> [--ip <addr>] [--hostname <expected-hostname>]")
+		fmt.Println("  apply -f <manifest> [--prune] [--dry-run]")
+		fmt.Println("  check-txt --domain <domain> --key <key> --value <value> [--resolvers] [--wait [--timeout 120] [--interval 5]]")
+		fmt.Println("  check-credentials")
+		fmt.Println("  publish-tlsa --domain <host> --cert <cert.pem>[,<cert.pem>...] [--port 443] [--proto tcp] [--prune] [--dry-run]")
+		fmt.Println("  publish-sshfp --domain <host> --key <key.pub>[,<key.pub>...|-] [--prune] [--dry-run]")
+		fmt.Println("  migrate-config [--api <dns-proxy-api.conf>] [--cli <dns-proxy-cli.conf>] [--output <file>]")
 		os.Exit(1)
 	}
 
@@ -69,6 +88,7 @@ func main() {
 
 	// Parse arguments based on command
 	args := parseCommandArgs(subcmd, filteredArgs[1:])
+	canonicalizeArgs(subcmd, args)
 
 	// Validate arguments
 	if err := cmd.ValidateArgs(args); err != nil {
@@ -80,9 +100,77 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Load cPanel config
-	cfg := loadCPanelConfig("/etc/acme-dns-tools/dns-proxy-cli.conf")
-	cpCfg, err := cpanel.NewCPanelConfig(cfg)
+	// Commands that don't talk to cPanel run without credentials
+	if sc, ok := cmd.(commands.StandaloneCommand); ok && sc.Standalone() {
+		if err := cmd.Execute(nil, args); err != nil {
+			log.Printf("%v", err)
+			if ignoreErrors {
+				os.Exit(0)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Load the DNS provider config; ACME_DNS_TOOLS_<KEY> variables override it
+	cfg := provider.Schema.MustLoad(configPath)
+	for _, key := range cpanel.APIKeys(cfg) {
+		redact.AddSecret(key)
+	}
+	for _, secret := range provider.Secrets(cfg) {
+		redact.AddSecret(secret)
+	}
+
+	// Other DNS providers only support the TXT record commands, which also
+	// go through the provider when a zone has a fallback, so they can fail over
+	pc, isProviderCommand := cmd.(commands.ProviderCommand)
+	if provider.Name(cfg) != provider.Default || (isProviderCommand && provider.HasFallback(cfg)) {
+		p, err := provider.New(cfg)
+		if !isProviderCommand {
+			err = fmt.Errorf("%s is only supported with the cpanel provider (DNS_PROVIDER=%s)", subcmd, provider.Name(cfg))
+		} else if err == nil {
+			err = pc.ExecuteProvider(p, args)
+		}
+		// Failover webhooks are posted in the background
+		provider.WaitAlerts(10 * time.Second)
+		if err != nil {
+			log.Printf("%v", err)
+			if ignoreErrors {
+				os.Exit(0)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Credential checks cover every account in the file
+	if ac, ok := cmd.(commands.AccountsCommand); ok {
+		if err := ac.ExecuteAccounts(cfg, args); err != nil {
+			log.Printf("%v", err)
+			if ignoreErrors {
+				os.Exit(0)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Manifests may span zones served by different cPanel profiles
+	if mc, ok := cmd.(commands.MultiDomainCommand); ok {
+		configFor := func(domain string) (*cpanel.CPanelConfig, error) {
+			return cpanel.NewCPanelConfigForDomain(cfg, domain)
+		}
+		if err := mc.ExecuteMulti(configFor, args); err != nil {
+			log.Printf("%v", err)
+			if ignoreErrors {
+				os.Exit(0)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	cpCfg, err := cpanel.NewCPanelConfigForDomain(cfg, args["domain"])
 	if err != nil {
 		log.Printf("%v", err)
 		if ignoreErrors {
@@ -101,22 +189,55 @@ func main() {
 	}
 }
 
+// canonicalizeArgs normalizes the domain (and TXT record key) arguments
+// once, so every command treats "EXAMPLE.com." like "example.com".
+func canonicalizeArgs(subcmd string, args map[string]string) {
+	if _, ok := args["domain"]; !ok {
+		return
+	}
+	switch subcmd {
+	case "set-txt", "delete-txt", "edit-txt", "list-txt", "check-txt":
+		args["domain"], args["key"] = dnsname.Record(args["domain"], args["key"])
+	default:
+		args["domain"] = dnsname.Canonical(args["domain"])
+	}
+}
+
 func parseCommandArgs(subcmd string, args []string) map[string]string {
 	var cmdFlags *flag.FlagSet
 
 	switch subcmd {
-	case "set-txt", "delete-txt":
+	case "set-txt":
 		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
 		domain := cmdFlags.String("domain", "", "Domain name")
 		key := cmdFlags.String("key", "", "TXT record key")
 		value := cmdFlags.String("value", "", "TXT record value")
+		mode := cmdFlags.String("mode", "append", "append: add alongside existing values; replace: remove other values for the key")
+		dryRun := cmdFlags.Bool("dry-run", false, "Validate credentials and show what would change without changing the zone")
 
 		cmdFlags.Parse(args)
 
 		return map[string]string{
-			"domain": *domain,
-			"key":    *key,
-			"value":  *value,
+			"domain":  *domain,
+			"key":     *key,
+			"value":   *value,
+			"mode":    *mode,
+			"dry-run": fmt.Sprint(*dryRun),
+		}
+	case "delete-txt":
+		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
+		domain := cmdFlags.String("domain", "", "Domain name")
+		key := cmdFlags.String("key", "", "TXT record key")
+		value := cmdFlags.String("value", "", "TXT record value")
+		ignoreMissing := cmdFlags.Bool("ignore-missing", false, "Succeed if the record does not exist (for cleanup hooks)")
+
+		cmdFlags.Parse(args)
+
+		return map[string]string{
+			"domain":         *domain,
+			"key":            *key,
+			"value":          *value,
+			"ignore-missing": fmt.Sprint(*ignoreMissing),
 		}
 	case "edit-txt":
 		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
@@ -144,6 +265,150 @@ func parseCommandArgs(subcmd string, args []string) map[string]string {
 			"domain": *domain,
 			"key":    *key,
 		}
+	case "export-zone":
+		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
+		domain := cmdFlags.String("domain", "", "Zone name")
+		output := cmdFlags.String("output", "", "Output file (default: stdout)")
+
+		cmdFlags.Parse(args)
+
+		return map[string]string{
+			"domain": *domain,
+			"output": *output,
+		}
+	case "import-zone":
+		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
+		domain := cmdFlags.String("domain", "", "Zone name")
+		file := cmdFlags.String("file", "", "Zone file to import")
+		dryRun := cmdFlags.Bool("dry-run", false, "Show what would be added without changing the zone")
+
+		cmdFlags.Parse(args)
+
+		return map[string]string{
+			"domain":  *domain,
+			"file":    *file,
+			"dry-run": fmt.Sprint(*dryRun),
+		}
+	case "delegate-cname":
+		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
+		domain := cmdFlags.String("domain", "", "Domain to delegate validation for")
+		target := cmdFlags.String("target", "", "Host name the _acme-challenge record should point to")
+		skipCheck := cmdFlags.Bool("skip-check", false, "Do not require the target to resolve")
+
+		cmdFlags.Parse(args)
+
+		return map[string]string{
+			"domain":     *domain,
+			"target":     *target,
+			"skip-check": fmt.Sprint(*skipCheck),
+		}
+	case "verify-fcrdns":
+		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
+		server := cmdFlags.String("server", "", "dns-proxy-api base URL, used to find the source address")
+		ip := cmdFlags.String("ip", "", "Client address to verify (default: source address towards --server)")
+		hostname := cmdFlags.String("hostname", "", "Only accept this PTR hostname, as the server's allowlist would")
+
+		cmdFlags.Parse(args)
+
+		return map[string]string{
+			"server":   *server,
+			"ip":       *ip,
+			"hostname": *hostname,
+		}
+	case "apply":
+		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
+		file := cmdFlags.String("file", "", "Manifest file (YAML or JSON)")
+		cmdFlags.StringVar(file, "f", "", "Shorthand for --file")
+		prune := cmdFlags.Bool("prune", false, "Remove values of the listed names that are not in the manifest")
+		dryRun := cmdFlags.Bool("dry-run", false, "Show what would change without changing any zone")
+
+		cmdFlags.Parse(args)
+
+		return map[string]string{
+			"file":    *file,
+			"prune":   fmt.Sprint(*prune),
+			"dry-run": fmt.Sprint(*dryRun),
+		}
+	case "check-txt":
+		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
+		domain := cmdFlags.String("domain", "", "Domain name")
+		key := cmdFlags.String("key", "", "TXT record key")
+		value := cmdFlags.String("value", "", "Expected TXT record value")
+		resolvers := cmdFlags.Bool("resolvers", false, "Also query Google, Cloudflare and Quad9 public resolvers")
+		wait := cmdFlags.Bool("wait", false, "Poll until every server returns the value")
+		timeout := cmdFlags.String("timeout", "120", "Seconds to wait with --wait")
+		interval := cmdFlags.String("interval", "5", "Seconds between checks with --wait")
+
+		cmdFlags.Parse(args)
+
+		return map[string]string{
+			"domain":    *domain,
+			"key":       *key,
+			"value":     *value,
+			"resolvers": fmt.Sprint(*resolvers),
+			"wait":      fmt.Sprint(*wait),
+			"timeout":   *timeout,
+			"interval":  *interval,
+		}
+	case "publish-tlsa":
+		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
+		domain := cmdFlags.String("domain", "", "Host name of the service")
+		cert := cmdFlags.String("cert", "", "Certificate PEM file(s), comma separated (e.g. current and next)")
+		port := cmdFlags.String("port", "443", "Service port")
+		proto := cmdFlags.String("proto", "tcp", "Service protocol")
+		usage := cmdFlags.String("usage", "3", "Certificate usage (3 = DANE-EE)")
+		selector := cmdFlags.String("selector", "1", "Selector (0 = full certificate, 1 = public key)")
+		matching := cmdFlags.String("matching-type", "1", "Matching type (0 = full, 1 = SHA-256, 2 = SHA-512)")
+		ttl := cmdFlags.String("ttl", "3600", "TTL of added records")
+		prune := cmdFlags.Bool("prune", false, "Remove TLSA records at the name that match none of the certificates")
+		dryRun := cmdFlags.Bool("dry-run", false, "Show what would change without changing the zone")
+
+		cmdFlags.Parse(args)
+
+		return map[string]string{
+			"domain":        *domain,
+			"cert":          *cert,
+			"port":          *port,
+			"proto":         *proto,
+			"usage":         *usage,
+			"selector":      *selector,
+			"matching-type": *matching,
+			"ttl":           *ttl,
+			"prune":         fmt.Sprint(*prune),
+			"dry-run":       fmt.Sprint(*dryRun),
+		}
+	case "publish-sshfp":
+		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
+		domain := cmdFlags.String("domain", "", "Host name the keys belong to")
+		key := cmdFlags.String("key", "", "OpenSSH public key file(s), comma separated, or - for stdin")
+		ttl := cmdFlags.String("ttl", "3600", "TTL of added records")
+		prune := cmdFlags.Bool("prune", false, "Remove SSHFP records at the name that match none of the keys")
+		dryRun := cmdFlags.Bool("dry-run", false, "Show what would change without changing the zone")
+
+		cmdFlags.Parse(args)
+
+		return map[string]string{
+			"domain":  *domain,
+			"key":     *key,
+			"ttl":     *ttl,
+			"prune":   fmt.Sprint(*prune),
+			"dry-run": fmt.Sprint(*dryRun),
+		}
+	case "check-credentials":
+		return map[string]string{}
+	case "migrate-config":
+		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
+		apiPath := cmdFlags.String("api", "/etc/acme-dns-tools/dns-proxy-api.conf", "API config file")
+		cliPath := cmdFlags.String("cli", "/etc/acme-dns-tools/dns-proxy-cli.conf", "DNS provider config file")
+		output := cmdFlags.String("output", "", "Merged config file (default: stdout)")
+
+		cmdFlags.Parse(args)
+
+		return map[string]string{
+			"api":    *apiPath,
+			"cli":    *cliPath,
+			"output": *output,
+		}
 	default:
 		return nil
 	}
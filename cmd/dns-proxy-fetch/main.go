@@ -0,0 +1,590 @@
+// dns-proxy-fetch pulls certificates from dns-proxy-api's /certs/ endpoint,
+// installs them when they changed and reloads the services that use them.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"acme-dns-tools/internal/certfetch"
+	"acme-dns-tools/internal/config"
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/logging"
+)
+
+const defaultConfigPath = "/etc/acme-dns-tools/dns-proxy-fetch.conf"
+
+// settings is the parsed fetch config.
+type settings struct {
+	servers       []*server // in failover order
+	domains       []string
+	dir           string
+	files         []string
+	perms         perms                // for domains without their own
+	domainPerms   map[string]perms     // FETCH_OWNER.<domain> etc.
+	p12           map[string]p12Target // by domain, for those with FETCH_P12
+	interval      time.Duration
+	reloadHook    string
+	reloadTimeout time.Duration
+	logFormat     string
+}
+
+// server is one dns-proxy-api instance certificates are fetched from.
+type server struct {
+	name    string
+	client  *certfetch.Client
+	domains []string // the domains it holds; empty for all of them
+}
+
+// serves reports whether sv holds the certificate of domain.
+func (sv *server) serves(domain string) bool {
+	return len(sv.domains) == 0 || contains(sv.domains, domain)
+}
+
+// perms are the owner and modes given to installed files.
+type perms struct {
+	owner   certfetch.Owner
+	mode    os.FileMode
+	keyMode os.FileMode // privkey.pem
+}
+
+// p12Target is a PKCS#12 file built from the fetched PEM files.
+type p12Target struct {
+	path string
+	opts certfetch.P12Options
+}
+
+func main() {
+	configPath := flag.String("config", defaultConfigPath, "config file")
+	once := flag.Bool("once", false, "fetch once and exit (non-zero on failure)")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config file: %v", err)
+	}
+	s, err := loadSettings(cfg)
+	if err != nil {
+		log.Fatalf("%s: %v", *configPath, err)
+	}
+	if err := logging.Setup(os.Stderr, s.logFormat); err != nil {
+		log.Fatalf("LOG_FORMAT: %v", err)
+	}
+
+	if *once {
+		if err := poll(s); err != nil {
+			log.Fatalf("fetch: %v", err)
+		}
+		return
+	}
+
+	var urls []string
+	for _, sv := range s.servers {
+		urls = append(urls, sv.client.BaseURL)
+	}
+	log.Printf("fetch: polling %s every %s for %s", strings.Join(urls, ", "), s.interval, strings.Join(s.domains, ", "))
+	for {
+		if err := poll(s); err != nil {
+			log.Printf("fetch: %v", err)
+		}
+		time.Sleep(s.interval)
+	}
+}
+
+// loadSettings applies the environment overrides and defaults of schema to
+// cfg, checks it and parses it.
+func loadSettings(cfg config.Values) (*settings, error) {
+	cfg = schema.Apply(cfg)
+	if err := schema.Check(cfg); err != nil {
+		return nil, err
+	}
+	var c fetchConfig
+	config.Decode(cfg, &c)
+
+	s := &settings{
+		dir:           c.Dir,
+		files:         c.Files,
+		domainPerms:   make(map[string]perms),
+		p12:           make(map[string]p12Target),
+		interval:      c.Interval,
+		reloadHook:    c.ReloadHook,
+		reloadTimeout: c.ReloadTimeout,
+		logFormat:     c.LogFormat,
+	}
+	for _, d := range c.Domains {
+		s.domains = append(s.domains, dnsname.Canonical(d))
+	}
+	servers, err := parseServers(c, cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.servers = servers
+	for _, sv := range s.servers {
+		for _, d := range sv.domains {
+			if !contains(s.domains, d) {
+				return nil, fmt.Errorf("SERVER.%s.DOMAINS: %s is not in FETCH_DOMAINS", sv.name, d)
+			}
+		}
+	}
+	for _, domain := range s.domains {
+		if len(s.serversFor(domain)) == 0 {
+			return nil, fmt.Errorf("no server holds %s; add it to a SERVER.<name>.DOMAINS", domain)
+		}
+	}
+	if !contains(s.files, "fullchain.pem") && !contains(s.files, "cert.pem") {
+		return nil, errors.New("FETCH_FILES must include fullchain.pem or cert.pem")
+	}
+	s.perms, err = parsePerms("", c.Owner, c.Mode, c.KeyMode, perms{owner: certfetch.Owner{UID: -1, GID: -1}, mode: 0644, keyMode: 0600})
+	if err != nil {
+		return nil, err
+	}
+	// Services reading different certificates often run as different users
+	for _, domain := range s.domains {
+		suffix := "." + domain
+		owner, mode, keyMode := cfg["FETCH_OWNER"+suffix], cfg["FETCH_MODE"+suffix], cfg["FETCH_KEY_MODE"+suffix]
+		if owner == "" && mode == "" && keyMode == "" {
+			continue
+		}
+		if s.domainPerms[domain], err = parsePerms(suffix, owner, mode, keyMode, s.perms); err != nil {
+			return nil, err
+		}
+	}
+	for _, domain := range s.domains {
+		t, err := parseP12(c, cfg, domain)
+		if err != nil {
+			return nil, err
+		}
+		if t.path != "" {
+			s.p12[domain] = t
+		}
+	}
+	if len(s.p12) > 0 && !contains(s.files, "privkey.pem") {
+		return nil, errors.New("FETCH_FILES must include privkey.pem for FETCH_P12")
+	}
+	for _, sv := range s.servers {
+		if c.Attempts > 0 {
+			sv.client.Attempts = c.Attempts
+		}
+		if c.RetryDelay > 0 {
+			sv.client.RetryDelay = c.RetryDelay
+		}
+	}
+	return s, nil
+}
+
+// poll fetches every domain and installs those whose certificate changed. If
+// the reload hook then fails, every domain installed in this pass is rolled
+// back and the hook is run again so services pick the previous files up.
+func poll(s *settings) error {
+	var installs []*certfetch.Installation
+	var changed, failed []string
+	for _, domain := range s.domains {
+		inst, err := fetchDomain(s, domain)
+		if err != nil {
+			log.Printf("fetch: %s: %v", domain, err)
+			failed = append(failed, domain)
+			continue
+		}
+		if inst != nil {
+			installs = append(installs, inst)
+			changed = append(changed, domain)
+		}
+	}
+
+	if len(changed) > 0 && s.reloadHook != "" {
+		if err := runHook(s); err != nil {
+			log.Printf("fetch: reload hook failed: %v; rolling back %s", err, strings.Join(changed, ", "))
+			for _, inst := range installs {
+				if rbErr := inst.Rollback(); rbErr != nil {
+					log.Printf("fetch: rollback failed: %v", rbErr)
+				}
+			}
+			if err := runHook(s); err != nil {
+				log.Printf("fetch: reload hook failed again after rollback: %v", err)
+			}
+			return fmt.Errorf("reload hook failed; kept the previous certificates for %s", strings.Join(changed, ", "))
+		}
+		log.Printf("fetch: reloaded after updating %s", strings.Join(changed, ", "))
+	}
+	// The new files are in use; drop the copies kept for rollback, old
+	// private keys included
+	for _, inst := range installs {
+		if err := inst.Commit(); err != nil {
+			log.Printf("fetch: removing previous versions: %v", err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to fetch %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// fetchDomain fetches domain from the first of its servers that works,
+// trying them in failover order. It returns nil if nothing changed.
+func fetchDomain(s *settings, domain string) (*certfetch.Installation, error) {
+	servers := s.serversFor(domain)
+	var failures []string
+	var lastErr error
+	for i, sv := range servers {
+		inst, err := fetchFrom(s, sv, domain)
+		if err == nil && inst == nil {
+			// The certificate is current, but its owner or modes may have
+			// been changed in the config since it was installed
+			return nil, applyPerms(s, domain)
+		}
+		if err == nil {
+			return inst, nil
+		}
+		if i < len(servers)-1 {
+			log.Printf("fetch: %s: server %s failed, trying %s: %v", domain, sv.name, servers[i+1].name, err)
+		}
+		failures = append(failures, sv.name+": "+err.Error())
+		lastErr = err
+	}
+	if len(failures) == 1 {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("every server failed (%s)", strings.Join(failures, "; "))
+}
+
+// serversFor returns the servers holding domain, in failover order.
+func (s *settings) serversFor(domain string) []*server {
+	var servers []*server
+	for _, sv := range s.servers {
+		if sv.serves(domain) {
+			servers = append(servers, sv)
+		}
+	}
+	return servers
+}
+
+// fetchFrom downloads the files of domain from sv and installs them if the
+// certificate differs from the installed one. It returns nil if nothing
+// changed.
+func fetchFrom(s *settings, sv *server, domain string) (*certfetch.Installation, error) {
+	dir := filepath.Join(s.dir, domain)
+	// A missing .p12 (just configured, or deleted to change its password)
+	// is built even if the certificate did not change
+	target, hasP12 := s.p12[domain]
+	p12Missing := false
+	if hasP12 {
+		_, err := os.Stat(target.path)
+		p12Missing = err != nil
+	}
+	// /meta tells whether anything changed and what fullchain.pem must
+	// hash to; without it (older APIs) the files are downloaded unchecked
+	meta, err := sv.client.Meta(domain)
+	if err != nil {
+		logging.Debugf("fetch: %s: %v; downloading the files", domain, err)
+	} else if !p12Missing && unchanged(s, meta, dir) {
+		return nil, nil
+	}
+
+	data := make(map[string][]byte, len(s.files))
+	for _, name := range s.files {
+		sum := ""
+		if name == "fullchain.pem" {
+			sum = meta.FullchainSHA256
+		}
+		b, err := sv.client.FetchVerified(domain, name, sum)
+		if err != nil {
+			return nil, err
+		}
+		data[name] = b
+	}
+
+	leafFile := "fullchain.pem"
+	if _, ok := data[leafFile]; !ok {
+		leafFile = "cert.pem"
+	}
+	leaf, err := certfetch.Leaf(data[leafFile])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", leafFile, err)
+	}
+	if key, ok := data["privkey.pem"]; ok {
+		if err := certfetch.KeyMatches(leaf, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if current, err := os.ReadFile(filepath.Join(dir, leafFile)); err == nil {
+		if old, err := certfetch.Leaf(current); err == nil {
+			if certfetch.Same(old, leaf) && !p12Missing {
+				return nil, nil
+			}
+			if leaf.NotAfter.Before(old.NotAfter) {
+				return nil, fmt.Errorf("served certificate (expires %s) is older than the installed one (expires %s)",
+					leaf.NotAfter.Format(time.RFC3339), old.NotAfter.Format(time.RFC3339))
+			}
+		}
+	}
+
+	p := s.permsFor(domain)
+	files := make([]certfetch.File, 0, len(s.files))
+	for _, name := range s.files {
+		files = append(files, certfetch.File{Path: filepath.Join(dir, name), Data: data[name], Mode: p.modeOf(name)})
+	}
+	if hasP12 {
+		chain, ok := data["fullchain.pem"]
+		if !ok {
+			chain = append(append([]byte{}, data["cert.pem"]...), data["chain.pem"]...)
+		}
+		p12, err := certfetch.EncodePKCS12(chain, data["privkey.pem"], target.opts)
+		if err != nil {
+			return nil, fmt.Errorf("PKCS#12: %w", err)
+		}
+		files = append(files, certfetch.File{Path: target.path, Data: p12, Mode: p.keyMode})
+	}
+	inst, err := certfetch.Install(files, p.owner)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("fetch: installed %s (serial %x, expires %s)", domain, leaf.SerialNumber, leaf.NotAfter.Format(time.RFC3339))
+	return inst, nil
+}
+
+// permsFor returns the owner and modes of domain's files.
+func (s *settings) permsFor(domain string) perms {
+	if p, ok := s.domainPerms[domain]; ok {
+		return p
+	}
+	return s.perms
+}
+
+// modeOf returns the mode of the fetched file name.
+func (p perms) modeOf(name string) os.FileMode {
+	if name == "privkey.pem" {
+		return p.keyMode
+	}
+	return p.mode
+}
+
+// applyPerms gives the installed files of domain, PKCS#12 copy included,
+// their configured owner and modes.
+func applyPerms(s *settings, domain string) error {
+	p := s.permsFor(domain)
+	files := make([]certfetch.File, 0, len(s.files)+1)
+	for _, name := range s.files {
+		files = append(files, certfetch.File{Path: filepath.Join(s.dir, domain, name), Mode: p.modeOf(name)})
+	}
+	if target, ok := s.p12[domain]; ok {
+		files = append(files, certfetch.File{Path: target.path, Mode: p.keyMode})
+	}
+	changed, err := certfetch.ApplyPermissions(files, p.owner)
+	if err != nil {
+		return fmt.Errorf("applying owner and modes: %w", err)
+	}
+	if changed {
+		log.Printf("fetch: %s: applied the configured owner and modes", domain)
+	}
+	return nil
+}
+
+// unchanged reports whether meta describes the certificate installed in
+// dir, so the files (the private key in particular) need not be downloaded
+// again.
+func unchanged(s *settings, meta certfetch.Meta, dir string) bool {
+	leafFile := "fullchain.pem"
+	if !contains(s.files, leafFile) {
+		leafFile = "cert.pem"
+	}
+	current, err := os.ReadFile(filepath.Join(dir, leafFile))
+	if err != nil {
+		return false
+	}
+	installed, err := certfetch.Leaf(current)
+	if err != nil {
+		return false
+	}
+	return meta.Describes(installed)
+}
+
+func runHook(s *settings) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.reloadTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "/bin/sh", "-c", s.reloadHook).CombinedOutput()
+	if output := strings.TrimRight(string(out), "\n"); err != nil && output != "" {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+	return err
+}
+
+// parseServers returns the servers in failover order: FETCH_URL with
+// CERT_BEARER_TOKEN, if set, followed by those named in FETCH_SERVERS, each
+// configured with
+//
+//	SERVER.<name>.URL=https://acme2.example.com:5000
+//	SERVER.<name>.TOKEN=<its CERT_BEARER_TOKEN>
+//	SERVER.<name>.DOMAINS=example.com           (optional; default every domain)
+//	SERVER.<name>.CLIENT_CERT / CLIENT_KEY      (optional; default FETCH_CLIENT_*)
+func parseServers(c fetchConfig, cfg config.Values) ([]*server, error) {
+	var servers []*server
+	if c.URL != "" || c.Token != "" {
+		if c.URL == "" || c.Token == "" {
+			return nil, errors.New("FETCH_URL and CERT_BEARER_TOKEN must be set together")
+		}
+		sv := &server{name: "default", client: certfetch.NewClient(c.URL, c.Token)}
+		if c.ClientCert != "" || c.ClientKey != "" {
+			if err := sv.client.UseClientCertificate(c.ClientCert, c.ClientKey); err != nil {
+				return nil, fmt.Errorf("FETCH_CLIENT_CERT/FETCH_CLIENT_KEY: %w", err)
+			}
+		}
+		servers = append(servers, sv)
+	}
+
+	names := c.Servers
+	for _, name := range names {
+		prefix := "SERVER." + name + "."
+		if cfg[prefix+"URL"] == "" || cfg[prefix+"TOKEN"] == "" {
+			return nil, fmt.Errorf("%sURL and %sTOKEN are required", prefix, prefix)
+		}
+		sv := &server{name: name, client: certfetch.NewClient(cfg[prefix+"URL"], cfg[prefix+"TOKEN"])}
+		for _, d := range splitList(cfg[prefix+"DOMAINS"]) {
+			sv.domains = append(sv.domains, dnsname.Canonical(d))
+		}
+		certFile, keyFile := cfg[prefix+"CLIENT_CERT"], cfg[prefix+"CLIENT_KEY"]
+		if certFile == "" && keyFile == "" {
+			certFile, keyFile = c.ClientCert, c.ClientKey
+		}
+		if certFile != "" || keyFile != "" {
+			if err := sv.client.UseClientCertificate(certFile, keyFile); err != nil {
+				return nil, fmt.Errorf("%sCLIENT_CERT/%sCLIENT_KEY: %w", prefix, prefix, err)
+			}
+		}
+		servers = append(servers, sv)
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("FETCH_URL and CERT_BEARER_TOKEN, or FETCH_SERVERS, are required")
+	}
+
+	// Settings of a server missing from FETCH_SERVERS are most likely a typo
+	for k := range cfg {
+		rest, ok := strings.CutPrefix(k, "SERVER.")
+		if !ok {
+			continue
+		}
+		if i := strings.LastIndex(rest, "."); i <= 0 || !contains(names, rest[:i]) {
+			return nil, fmt.Errorf("%s: server not listed in FETCH_SERVERS", k)
+		}
+	}
+	return servers, nil
+}
+
+// parsePerms parses the FETCH_OWNER, FETCH_MODE and FETCH_KEY_MODE values
+// named with suffix; empty ones keep the values of base.
+func parsePerms(suffix, owner, mode, keyMode string, base perms) (perms, error) {
+	p := base
+	if owner != "" {
+		o, err := parseOwner(owner)
+		if err != nil {
+			return p, fmt.Errorf("FETCH_OWNER%s: %w", suffix, err)
+		}
+		p.owner = o
+	}
+	for _, m := range []struct {
+		key, value string
+		dst        *os.FileMode
+	}{{"FETCH_MODE", mode, &p.mode}, {"FETCH_KEY_MODE", keyMode, &p.keyMode}} {
+		if m.value != "" {
+			n, err := strconv.ParseUint(m.value, 8, 32)
+			if err != nil || n > 0777 {
+				return p, fmt.Errorf("%s%s must be an octal file mode, got %q", m.key, suffix, m.value)
+			}
+			*m.dst = os.FileMode(n)
+		}
+	}
+	return p, nil
+}
+
+// parseP12 reads the FETCH_P12* settings for domain: those of c, each
+// overridden by the same key with a .<domain> suffix in cfg. A relative
+// FETCH_P12 is inside the domain's directory.
+func parseP12(c fetchConfig, cfg config.Values, domain string) (p12Target, error) {
+	get := func(key, base string) string {
+		if v := cfg[key+"."+domain]; v != "" {
+			return v
+		}
+		return base
+	}
+	path := get("FETCH_P12", c.P12)
+	if path == "" {
+		return p12Target{}, nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.Dir, domain, path)
+	}
+	t := p12Target{path: path, opts: certfetch.P12Options{Password: get("FETCH_P12_PASSWORD", c.P12Password), FriendlyName: get("FETCH_P12_NAME", c.P12Name)}}
+	if t.opts.Password == "" {
+		return t, fmt.Errorf("FETCH_P12_PASSWORD is required for the PKCS#12 file of %s", domain)
+	}
+	if t.opts.FriendlyName == "" {
+		t.opts.FriendlyName = domain
+	}
+	switch v := get("FETCH_P12_LEGACY", strconv.FormatBool(c.P12Legacy)); v {
+	case "false":
+	case "true":
+		t.opts.Legacy = true
+	default:
+		return t, fmt.Errorf("FETCH_P12_LEGACY.%s must be true or false, got %q", domain, v)
+	}
+	return t, nil
+}
+
+// parseOwner resolves "user", "user:group" or ":group" (names or IDs).
+func parseOwner(v string) (certfetch.Owner, error) {
+	owner := certfetch.Owner{UID: -1, GID: -1}
+	name, group, _ := strings.Cut(v, ":")
+	if name != "" {
+		id := name
+		if u, err := user.Lookup(name); err == nil {
+			id = u.Uid
+		}
+		uid, err := strconv.Atoi(id)
+		if err != nil {
+			return owner, fmt.Errorf("unknown user %q", name)
+		}
+		owner.UID = uid
+	}
+	if group != "" {
+		id := group
+		if g, err := user.LookupGroup(group); err == nil {
+			id = g.Gid
+		}
+		gid, err := strconv.Atoi(id)
+		if err != nil {
+			return owner, fmt.Errorf("unknown group %q", group)
+		}
+		owner.GID = gid
+	}
+	return owner, nil
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
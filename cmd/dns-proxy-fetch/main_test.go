@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"acme-dns-tools/internal/certfetch"
+)
+
+func TestLoadSettingsPerDomainPerms(t *testing.T) {
+	s, err := loadSettings(map[string]string{
+		"FETCH_URL":                       "https://acme.example.com",
+		"CERT_BEARER_TOKEN":               "token",
+		"FETCH_DOMAINS":                   "example.com, Mail.Example.org",
+		"FETCH_OWNER":                     "0:0",
+		"FETCH_KEY_MODE":                  "0640",
+		"FETCH_OWNER.mail.example.org":    "8:12",
+		"FETCH_KEY_MODE.mail.example.org": "0600",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.domainPerms["example.com"]; ok {
+		t.Error("example.com has its own permissions, want the defaults")
+	}
+	if s.perms.owner.UID != 0 || s.perms.mode != 0644 || s.perms.keyMode != 0640 {
+		t.Errorf("default perms = %+v", s.perms)
+	}
+	mail, ok := s.domainPerms["mail.example.org"]
+	if !ok {
+		t.Fatal("mail.example.org has no permissions of its own")
+	}
+	want := perms{mode: 0644, keyMode: os.FileMode(0600)}
+	want.owner.UID, want.owner.GID = 8, 12
+	if mail != want {
+		t.Errorf("mail.example.org perms = %+v, want %+v", mail, want)
+	}
+}
+
+func TestLoadSettingsRejectsBadMode(t *testing.T) {
+	_, err := loadSettings(map[string]string{
+		"FETCH_URL":              "https://acme.example.com",
+		"CERT_BEARER_TOKEN":      "token",
+		"FETCH_DOMAINS":          "example.com",
+		"FETCH_MODE.example.com": "rw-r--r--",
+	})
+	if err == nil {
+		t.Fatal("loadSettings accepted FETCH_MODE.example.com=rw-r--r--")
+	}
+}
+
+func TestLoadSettingsP12(t *testing.T) {
+	s, err := loadSettings(map[string]string{
+		"FETCH_URL":                          "https://acme.example.com",
+		"CERT_BEARER_TOKEN":                  "token",
+		"FETCH_DOMAINS":                      "example.com,unifi.example.com",
+		"FETCH_DIR":                          "/srv/certs",
+		"FETCH_P12_PASSWORD":                 "secret",
+		"FETCH_P12.unifi.example.com":        "/var/lib/unifi/cert.p12",
+		"FETCH_P12_NAME.unifi.example.com":   "unifi",
+		"FETCH_P12_LEGACY.unifi.example.com": "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.p12["example.com"]; ok {
+		t.Error("example.com has a PKCS#12 file, want none")
+	}
+	want := p12Target{path: "/var/lib/unifi/cert.p12", opts: certfetch.P12Options{Password: "secret", FriendlyName: "unifi", Legacy: true}}
+	if got := s.p12["unifi.example.com"]; got != want {
+		t.Errorf("unifi.example.com PKCS#12 = %+v, want %+v", got, want)
+	}
+
+	for name, cfg := range map[string]map[string]string{
+		"no password": {"FETCH_P12": "cert.p12"},
+		"no key":      {"FETCH_P12": "cert.p12", "FETCH_P12_PASSWORD": "secret", "FETCH_FILES": "fullchain.pem"},
+		"bad legacy":  {"FETCH_P12": "cert.p12", "FETCH_P12_PASSWORD": "secret", "FETCH_P12_LEGACY": "yes"},
+	} {
+		cfg["FETCH_URL"], cfg["CERT_BEARER_TOKEN"], cfg["FETCH_DOMAINS"] = "https://acme.example.com", "token", "example.com"
+		if _, err := loadSettings(cfg); err == nil {
+			t.Errorf("%s: loadSettings succeeded", name)
+		}
+	}
+}
+
+// testFiles returns fullchain.pem and privkey.pem of a self-signed
+// certificate for example.com.
+func testFiles(t *testing.T) map[string][]byte {
+	t.Helper()
+	return testFilesSerial(t, 42)
+}
+
+// testFilesSerial is testFiles for a certificate with the given serial.
+func testFilesSerial(t *testing.T, serial int64) map[string][]byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string][]byte{
+		"fullchain.pem": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		"privkey.pem":   pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+	}
+}
+
+// serveFiles starts a certificate API serving files for every domain.
+func serveFiles(t *testing.T, files map[string][]byte) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[filepath.Base(r.URL.Path)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestFetchDomainBuildsMissingP12 checks that a .p12 configured after the
+// certificate was installed is built although the certificate is unchanged.
+func TestFetchDomainBuildsMissingP12(t *testing.T) {
+	srv := serveFiles(t, testFiles(t))
+	dir := t.TempDir()
+	cfg := map[string]string{
+		"FETCH_URL":         srv.URL,
+		"CERT_BEARER_TOKEN": "token",
+		"FETCH_DOMAINS":     "example.com",
+		"FETCH_DIR":         dir,
+	}
+	s, err := loadSettings(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst, err := fetchDomain(s, "example.com"); err != nil || inst == nil {
+		t.Fatalf("first fetch = %v, %v; want an installation", inst, err)
+	}
+
+	cfg["FETCH_P12"], cfg["FETCH_P12_PASSWORD"] = "cert.p12", "secret"
+	if s, err = loadSettings(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if inst, err := fetchDomain(s, "example.com"); err != nil || inst == nil {
+		t.Fatalf("fetch with FETCH_P12 = %v, %v; want an installation", inst, err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "example.com", "cert.p12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("cert.p12 mode = %o, want the key mode 600", info.Mode().Perm())
+	}
+	if inst, err := fetchDomain(s, "example.com"); err != nil || inst != nil {
+		t.Errorf("third fetch = %v, %v; want nothing to do", inst, err)
+	}
+}
+
+// TestFetchDomainAppliesChangedModes checks that new FETCH_MODE and
+// FETCH_KEY_MODE settings reach the files of an unchanged certificate.
+func TestFetchDomainAppliesChangedModes(t *testing.T) {
+	srv := serveFiles(t, testFiles(t))
+	dir := t.TempDir()
+	cfg := map[string]string{
+		"FETCH_URL":         srv.URL,
+		"CERT_BEARER_TOKEN": "token",
+		"FETCH_DOMAINS":     "example.com",
+		"FETCH_DIR":         dir,
+	}
+	s, err := loadSettings(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst, err := fetchDomain(s, "example.com"); err != nil || inst == nil {
+		t.Fatalf("first fetch = %v, %v; want an installation", inst, err)
+	}
+
+	cfg["FETCH_MODE"], cfg["FETCH_KEY_MODE"] = "640", "640"
+	if s, err = loadSettings(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if inst, err := fetchDomain(s, "example.com"); err != nil || inst != nil {
+		t.Fatalf("second fetch = %v, %v; want nothing to install", inst, err)
+	}
+	for _, name := range []string{"fullchain.pem", "privkey.pem"} {
+		info, err := os.Stat(filepath.Join(dir, "example.com", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0640 {
+			t.Errorf("%s mode = %o, want 640", name, info.Mode().Perm())
+		}
+	}
+}
+
+// TestPollRemovesPreviousKey checks that a renewal leaves no copy of the
+// old private key once the reload hook has succeeded.
+func TestPollRemovesPreviousKey(t *testing.T) {
+	dir := t.TempDir()
+	cfg := map[string]string{
+		"FETCH_URL":         serveFiles(t, testFiles(t)).URL,
+		"CERT_BEARER_TOKEN": "token",
+		"FETCH_DOMAINS":     "example.com",
+		"FETCH_DIR":         dir,
+		"RELOAD_HOOK":       "true",
+	}
+	for i := range 2 {
+		if i == 1 {
+			// Renewed: the server now has a new certificate and key
+			cfg["FETCH_URL"] = serveFiles(t, testFilesSerial(t, 43)).URL
+		}
+		s, err := loadSettings(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := poll(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "example.com", "fullchain.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf, err := certfetch.Leaf(data); err != nil || leaf.SerialNumber.Int64() != 43 {
+		t.Fatalf("installed certificate %v, %v; want the renewed one", leaf, err)
+	}
+	for _, name := range []string{"fullchain.pem.prev", "privkey.pem.prev"} {
+		if _, err := os.Stat(filepath.Join(dir, "example.com", name)); !os.IsNotExist(err) {
+			t.Errorf("%s after the renewal: %v, want it removed", name, err)
+		}
+	}
+}
+
+func TestFetchDomainFailsOver(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := serveFiles(t, testFiles(t))
+
+	dir := t.TempDir()
+	s, err := loadSettings(map[string]string{
+		"FETCH_DOMAINS":            "example.com,other.example.org",
+		"FETCH_DIR":                dir,
+		"FETCH_SERVERS":            "primary,secondary,other",
+		"FETCH_ATTEMPTS":           "1",
+		"SERVER.primary.URL":       down.URL,
+		"SERVER.primary.TOKEN":     "token1",
+		"SERVER.primary.DOMAINS":   "example.com",
+		"SERVER.secondary.URL":     up.URL,
+		"SERVER.secondary.TOKEN":   "token2",
+		"SERVER.secondary.DOMAINS": "example.com",
+		"SERVER.other.URL":         down.URL,
+		"SERVER.other.TOKEN":       "token3",
+		"SERVER.other.DOMAINS":     "other.example.org",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst, err := fetchDomain(s, "example.com"); err != nil || inst == nil {
+		t.Fatalf("fetchDomain(example.com) = %v, %v; want an installation from secondary", inst, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "example.com", "fullchain.pem")); err != nil {
+		t.Error(err)
+	}
+	// other.example.org is only on a server that is down
+	if _, err := fetchDomain(s, "other.example.org"); err == nil {
+		t.Error("fetchDomain(other.example.org) succeeded")
+	}
+}
+
+func TestLoadSettingsServers(t *testing.T) {
+	base := map[string]string{
+		"FETCH_URL":         "https://acme.example.com",
+		"CERT_BEARER_TOKEN": "token",
+		"FETCH_DOMAINS":     "example.com",
+	}
+	for name, extra := range map[string]map[string]string{
+		"no token":          {"FETCH_SERVERS": "b", "SERVER.b.URL": "https://b.example.com"},
+		"unlisted server":   {"SERVER.c.URL": "https://c.example.com", "SERVER.c.TOKEN": "t"},
+		"unknown domain":    {"FETCH_SERVERS": "b", "SERVER.b.URL": "https://b.example.com", "SERVER.b.TOKEN": "t", "SERVER.b.DOMAINS": "example.net"},
+		"url without token": {"CERT_BEARER_TOKEN": ""},
+	} {
+		cfg := make(map[string]string)
+		for k, v := range base {
+			cfg[k] = v
+		}
+		for k, v := range extra {
+			cfg[k] = v
+		}
+		if _, err := loadSettings(cfg); err == nil {
+			t.Errorf("%s: loadSettings succeeded", name)
+		}
+	}
+
+	// Without FETCH_URL, a domain no server holds is an error
+	if _, err := loadSettings(map[string]string{
+		"FETCH_DOMAINS":    "example.com,example.net",
+		"FETCH_SERVERS":    "b",
+		"SERVER.b.URL":     "https://b.example.com",
+		"SERVER.b.TOKEN":   "t",
+		"SERVER.b.DOMAINS": "example.com",
+	}); err == nil {
+		t.Error("loadSettings accepted example.net without a server")
+	}
+}
+
+func TestLoadSettingsSchema(t *testing.T) {
+	t.Setenv("ACME_DNS_TOOLS_FETCH_DIR", "/srv/certs")
+	s, err := loadSettings(map[string]string{
+		"FETCH_URL":         "https://acme.example.com",
+		"CERT_BEARER_TOKEN": "token",
+		"FETCH_DOMAINS":     "example.com",
+		"FETCH_DIR":         "/etc/ssl/acme",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.dir != "/srv/certs" || s.interval != time.Hour || s.reloadTimeout != time.Minute {
+		t.Errorf("dir %s, interval %s, reload timeout %s; want /srv/certs, 1h and 1m", s.dir, s.interval, s.reloadTimeout)
+	}
+
+	_, err = loadSettings(map[string]string{
+		"FETCH_URL":         "https://acme.example.com",
+		"CERT_BEARER_TOKEN": "token",
+		"FETCH_INTERVAL":    "hourly",
+		"FETCH_ATTEMPTS":    "0",
+	})
+	for _, key := range []string{"FETCH_DOMAINS", "FETCH_INTERVAL", "FETCH_ATTEMPTS"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("error %v does not mention %s", err, key)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+
+	"acme-dns-tools/internal/config"
+)
+
+// fetchConfig holds the keys of dns-proxy-fetch.conf besides the SERVER.*
+// family and the per-domain FETCH_OWNER, FETCH_MODE, FETCH_KEY_MODE and
+// FETCH_P12* overrides; durations are in seconds.
+type fetchConfig struct {
+	// Servers, in failover order: FETCH_URL first, then FETCH_SERVERS
+	URL        string   `config:"FETCH_URL"`
+	Token      string   `config:"CERT_BEARER_TOKEN"`
+	Servers    []string `config:"FETCH_SERVERS"`
+	ClientCert string   `config:"FETCH_CLIENT_CERT"`
+	ClientKey  string   `config:"FETCH_CLIENT_KEY"`
+
+	// Certificates and where they are installed
+	Domains []string `config:"FETCH_DOMAINS,required"`
+	Dir     string   `config:"FETCH_DIR" default:"/etc/ssl/acme"`
+	Files   []string `config:"FETCH_FILES" default:"fullchain.pem,privkey.pem"`
+	Owner   string   `config:"FETCH_OWNER"`
+	Mode    string   `config:"FETCH_MODE"`
+	KeyMode string   `config:"FETCH_KEY_MODE"`
+
+	// PKCS#12 copies
+	P12         string `config:"FETCH_P12"`
+	P12Password string `config:"FETCH_P12_PASSWORD"`
+	P12Name     string `config:"FETCH_P12_NAME"`
+	P12Legacy   bool   `config:"FETCH_P12_LEGACY"`
+
+	// Polling, downloads and the reload hook
+	Interval      time.Duration `config:"FETCH_INTERVAL,positive" default:"3600"`
+	Attempts      int           `config:"FETCH_ATTEMPTS,positive"`
+	RetryDelay    time.Duration `config:"FETCH_RETRY_DELAY,positive"`
+	ReloadHook    string        `config:"RELOAD_HOOK"`
+	ReloadTimeout time.Duration `config:"RELOAD_TIMEOUT,positive" default:"60"`
+	LogFormat     string        `config:"LOG_FORMAT" oneof:"text,json"`
+}
+
+// schema checks dns-proxy-fetch.conf against fetchConfig.
+var schema = config.SchemaOf(fetchConfig{})
@@ -1,63 +1,93 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	"acme-dns-tools/internal/api"
+	"acme-dns-tools/internal/commands"
 	"acme-dns-tools/internal/config"
-	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/provider"
+	"acme-dns-tools/internal/server"
 )
 
-func loadToken(path string) string {
-	file, err := os.Open(path)
-	if err != nil {
-		log.Fatalf("Failed to open config file: %v", err)
-	}
-	defer file.Close()
-	var apiKey string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "DNS_RESOLVER_API_TOKEN" {
-			apiKey = strings.TrimSpace(parts[1])
+// proxyConfig holds the keys of dns-proxy-api.conf this server uses.
+type proxyConfig struct {
+	APIToken string `config:"DNS_RESOLVER_API_TOKEN,required" env:"API_KEY"`
+	server.Config
+}
+
+var schema = config.SchemaOf(proxyConfig{})
+
+// providerBackend implements api.RecordBackend by calling the configured
+// DNS provider directly.
+type providerBackend struct {
+	p    provider.Provider
+	name string
+}
+
+func (b providerBackend) SetTxt(_ context.Context, domain, key, value, mode string) (string, error) {
+	if mode == api.ModeReplace {
+		r, ok := b.p.(provider.Replacer)
+		if !ok {
+			return "", &api.UnsupportedError{Op: "replace mode", Provider: b.name}
 		}
+		return "", r.ReplaceTxtRecords(domain, key, value)
+	}
+	return "", b.p.CreateTxtRecord(domain, key, value)
+}
+
+func (b providerBackend) DryRunSetTxt(_ context.Context, domain, key, value, mode string) (string, error) {
+	l, ok := b.p.(provider.Lister)
+	if !ok {
+		return "", &api.UnsupportedError{Op: "dry run", Provider: b.name}
 	}
-	if apiKey == "" {
-		log.Fatal("DNS_RESOLVER_API_TOKEN not found in config file")
+	var out strings.Builder
+	err := commands.DryRunSetTxt(&out, l, domain, key, value, mode)
+	return out.String(), err
+}
+
+func (b providerBackend) DeleteTxt(_ context.Context, domain, key, value string) (string, error) {
+	err := b.p.DeleteTxtRecord(domain, key, value)
+	if errors.Is(err, provider.ErrRecordNotFound) {
+		return "TXT record not present; nothing to delete.", nil
 	}
-	return apiKey
+	return "", err
+}
+
+func (b providerBackend) PublishSSHFP(context.Context, string, []string, bool, bool) (string, error) {
+	return "", &api.UnsupportedError{Op: "SSHFP publishing", Provider: b.name}
 }
 
 func main() {
-	apiToken := loadToken("/etc/acme-dns-tools/dns-proxy-api.conf")
+	configPath := flag.String("config", "/etc/acme-dns-tools/dns-proxy-api.conf", "API config file")
+	cliConfigPath := flag.String("cli-config", "/etc/acme-dns-tools/dns-proxy-cli.conf", "DNS provider config file")
+	flag.Parse()
+
+	var cfg proxyConfig
+	config.Decode(schema.MustLoad(*configPath), &cfg)
+	opts := cfg.Options(2 * time.Minute)
 
-	// Adapter that implements api.TxtRecordSetter by using internal cPanel client
-	cfgMap := config.LoadConfig("/etc/acme-dns-tools/dns-proxy-cli.conf")
-	cpCfg, err := cpanel.NewCPanelConfig(cfgMap)
+	cfgMap := provider.Schema.MustLoad(*cliConfigPath)
+	p, err := provider.New(cfgMap)
 	if err != nil {
-		log.Fatalf("failed to load cPanel config: %v", err)
+		log.Fatalf("failed to load DNS provider config: %v", err)
 	}
 
-	type internalSetter struct{
-		cp *cpanel.CPanelConfig
+	records := api.RecordsConfig{
+		Settings: api.NewLiveSettings(api.Settings{APIToken: cfg.APIToken}),
+		Backend:  providerBackend{p: p, name: provider.Name(cfgMap)},
 	}
+	http.HandleFunc("/set_txt", api.SetTxtHandler(records))
+	http.HandleFunc("/delete_txt", api.DeleteTxtHandler(records))
 
-	func (s *internalSetter) CreateTxtRecord(domain, key, value string) error {
-		return s.cp.CreateTxtRecord(domain, key, value)
+	log.Printf("dns-proxy API listening on %s...", opts.Addr)
+	if err := server.Serve(opts.Server(nil), "", "", opts.ShutdownTimeout, nil); err != nil {
+		log.Fatal(err)
 	}
-
-	setter := &internalSetter{cp: cpCfg}
-	http.HandleFunc("/set_txt", api.SetTxtHandler(apiToken, setter))
-
-	log.Println("dns-proxy API listening on :5000...")
-	log.Fatal(http.ListenAndServe(":5000", nil))
 }
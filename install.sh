@@ -64,6 +64,7 @@ build() {
   make all
   ok "dns-proxy-api built"
   ok "dns-proxy-cli built"
+  ok "dns-proxy-fetch built"
   echo ""
 }
 
@@ -89,6 +90,11 @@ install_bins() {
 
   install -m 0755 -D "$TOPDIR/dns-proxy-cli" "$INSTALL_DIR/dns-proxy-cli"
   ok "Installed: $INSTALL_DIR/dns-proxy-cli"
+
+  # Certificate client for downstream hosts; copy it there together with a
+  # /etc/acme-dns-tools/dns-proxy-fetch.conf (see README)
+  install -m 0755 -D "$TOPDIR/dns-proxy-fetch" "$INSTALL_DIR/dns-proxy-fetch"
+  ok "Installed: $INSTALL_DIR/dns-proxy-fetch"
   echo ""
 }
 
@@ -110,26 +116,157 @@ install_configs() {
 # /etc/acme-dns-tools/dns-proxy-api.conf
 
 # --- DNS management API (existing) ---
-# Bearer token for the /set_txt endpoint (used by certbot hooks on remote hosts)
+# Bearer token for the /set_txt and /delete_txt endpoints (used by certbot hooks on remote hosts)
 DNS_RESOLVER_API_TOKEN=REPLACE_WITH_API_KEY_FROM_DNS_RESOLVER
 
-# --- cPanel credentials (used internally by dns-proxy-cli) ---
-# These are only needed if dns-proxy-cli is invoked by the API process.
-# They are typically configured in /etc/dns-proxy-cli.conf instead.
+# Only allow _acme-challenge records to be created through the API
+ACME_CHALLENGE_ONLY=true
+
+# --- DNS provider credentials ---
+# The API reads them from /etc/acme-dns-tools/dns-proxy-cli.conf, shared
+# with dns-proxy-cli.
+# At startup the API validates them (like "dns-proxy-cli check-credentials") and
+# refuses to start if any account is rejected; set to false to skip the check
+# VALIDATE_CREDENTIALS=true
+
+# Run dns-proxy-cli for every DNS change instead of calling the provider
+# in-process (slower; only for setups where the API cannot read the CLI config)
+# EXEC_CLI=false
+
+# --- Listener ---
+# Address to listen on (default :5000)
+# LISTEN_ADDR=127.0.0.1:5000
+# Seconds for reading a request, writing the answer (default PROPAGATION_TIMEOUT + 30)
+# and keeping idle connections; SHUTDOWN_TIMEOUT is the grace period on SIGTERM
+# HTTP_READ_TIMEOUT=30
+# HTTP_WRITE_TIMEOUT=150
+# HTTP_IDLE_TIMEOUT=120
+# SHUTDOWN_TIMEOUT=30
+
+# --- Propagation checks (/check_txt) ---
+# Seconds to wait for a TXT value to reach every authoritative server, and between checks
+# PROPAGATION_TIMEOUT=120
+# PROPAGATION_INTERVAL=5
+
+# --- Compatibility endpoints (optional) ---
+# lego httpreq on /httpreq/present and /httpreq/cleanup
+# HTTPREQ_ENABLED=true
+# acme-dns API on /acmedns/register and /acmedns/update; accounts get names below ACMEDNS_ZONE
+# ACMEDNS_ZONE=acme.example.com
+# ACMEDNS_ACCOUNTS_FILE=/var/lib/acme-dns-tools/acmedns-accounts.json
+# ACMEDNS_OPEN_REGISTRATION=false
 
 # --- Cert serving (pull model) ---
 # Bearer token that remote hosts must present to GET /certs/{domain}/{file}
 CERT_BEARER_TOKEN=REPLACE_WITH_RANDOM_CERT_BEARER_TOKEN
 
 # Comma-separated list of hostnames allowed to pull certificates (FCrDNS check)
+# "*.nodes.example.com" allows every host below nodes.example.com
 CERT_DNS_ALLOWLIST=REPLACE_WITH_ALLOWED_HOSTNAME
 
+# Optional: IPs/CIDR ranges allowed without FCrDNS, e.g. hosts on a VPN without PTR records
+# CERT_IP_ALLOWLIST=10.8.0.0/16,fd00:8::/64
+
+# Optional: only let a host fetch certificates covering the names it serves
+# CERT_ACCESS.web1.example.com=www.example.com,shop.example.com
+
+# Optional: identify hosts by TLS client certificate (needs TLS_CERT/TLS_KEY below)
+# CERT_CLIENT_AUTH: both (FCrDNS and a certificate for the verified name, default),
+# mtls (certificate only; CERT_DNS_ALLOWLIST may stay empty) or fcrdns
+# CERT_CLIENT_CA=/etc/acme-dns-tools/client-ca.pem
+# CERT_CLIENT_AUTH=both
+
+# Optional: per-client tokens limited to operations (set_txt, delete_txt, sshfp, certs) and domains
+# CLIENT.certbot-web.TOKEN=<random token>
+# CLIENT.certbot-web.OPERATIONS=set_txt,delete_txt
+# CLIENT.certbot-web.DOMAINS=example.com
+
+# Optional: tenants isolate the clients of separate customers (zones, cert directory, quotas, history)
+# TENANT.customer1.DOMAINS=customer1.com
+# TENANT.customer1.CERT_DIR=/srv/certs/customer1/live
+# CLIENT.customer1-certbot.TOKEN=<random token>
+# CLIENT.customer1-certbot.TENANT=customer1
+
 # Optional: override the base directory for certificate files
 # Defaults to /etc/letsencrypt/live if omitted
 # CERT_BASE_DIR=/etc/letsencrypt/live
 
+# --- Certificate Transparency monitoring (optional) ---
+# Alert when crt.sh lists a certificate for these domains that is not held locally
+# CT_MONITOR_DOMAINS=example.com
+# CT_MONITOR_INTERVAL=3600
+# CT_MONITOR_WEBHOOK=https://hooks.example.com/ct
+
+# --- Admin endpoints ---
+# Bearer token for /admin/* endpoints (maintenance mode); omit to disable them
+# ADMIN_API_TOKEN=<random admin token>
+# Also serve Go runtime profiles on /admin/debug/pprof/ (requires ADMIN_API_TOKEN)
+# PPROF_ENABLED=true
+
+# --- Maintenance mode ---
+# Start with mutating endpoints disabled (503); toggle at runtime via /admin/maintenance
+# MAINTENANCE_MODE=true
+# MAINTENANCE_RETRY_AFTER=300
+
+# --- Per-token quotas (optional, unset or 0 = unlimited) ---
+# QUOTA_MUTATIONS_HOURLY=20
+# QUOTA_MUTATIONS_DAILY=100
+# QUOTA_CERT_DOWNLOADS_HOURLY=50
+# QUOTA_CERT_DOWNLOADS_DAILY=200
+
+# --- Per-address rate limit and authentication lockout (optional, unset or 0 = off) ---
+# RATE_LIMIT_RPS=5
+# RATE_LIMIT_BURST=20
+# AUTH_LOCKOUT_FAILURES=5
+# AUTH_LOCKOUT_SECONDS=60
+
+# --- Record operation history (optional) ---
+# Append-only log of /set_txt and /delete_txt operations; enables GET /v1/records/history
+# HISTORY_FILE=/var/lib/acme-dns-tools/history.jsonl
+
+# --- Challenge cleanup on shutdown ---
+# Seconds allowed for deleting outstanding _acme-challenge records on SIGTERM; 0 disables
+# CLEANUP_TIMEOUT=30
+
+# --- Disk health checks (reported on /readyz and in metrics) ---
+# Minimum free space (MB) for CERT_BASE_DIR and the history directory; 0 disables
+# DISK_MIN_FREE_MB=100
+# HEALTH_CHECK_INTERVAL=60
+# Also require CERT_BASE_DIR to be writable (certbot runs here as the same user)
+# CERT_BASE_DIR_WRITABLE=true
+
+# --- Logging ---
+# info (default) or debug; toggle at runtime with SIGUSR1 or /admin/log-level
+# LOG_LEVEL=info
+# text (default) or json, one record per line on stderr
+# LOG_FORMAT=json
+# Separate audit log of every /certs/ request (JSON, rotated at CERT_AUDIT_LOG_MAX_MB)
+# CERT_AUDIT_LOG=/var/log/acme-dns-tools/certs-audit.jsonl
+# CERT_AUDIT_LOG_MAX_MB=10
+# CERT_AUDIT_LOG_KEEP=5
+
+# --- Metrics ---
+# Set to true to expose Prometheus metrics on GET /metrics
+# METRICS_ENABLED=true
+# Serve /metrics on a separate listener instead of the API port
+# METRICS_LISTEN=127.0.0.1:9102
+# Or push them to StatsD (UDP host:port); prefix and flush interval are optional
+# STATSD_ADDR=127.0.0.1:8125
+# STATSD_PREFIX=acme
+# STATSD_FLUSH_INTERVAL=10
+
+# --- Detached signatures for served files (optional) ---
+# Ed25519 key (openssl genpkey -algorithm ed25519); enables /certs/{domain}/{file}.sig
+# CERT_SIGNING_KEY=/etc/acme-dns-tools/cert-signing.key
+
+# --- Reverse proxy (optional) ---
+# Serve all routes below this path prefix, e.g. /acme-dns/set_txt
+# BASE_PATH=/acme-dns/
+# Reverse proxies whose X-Forwarded-For header carries the real client address
+# TRUSTED_PROXIES=127.0.0.1
+
 # --- TLS for the API listener itself ---
-# Set both to enable HTTPS on port 5000; omit to run plain HTTP.
+# Set both to enable HTTPS on LISTEN_ADDR; omit to run plain HTTP.
 # These are the cert/key for the acme-proxy host (this machine), not for served certs.
 # TLS_CERT=/etc/letsencrypt/live/acme.iveronsoft.ro/fullchain.pem
 # TLS_KEY=/etc/letsencrypt/live/acme.iveronsoft.ro/privkey.pem
@@ -156,6 +293,18 @@ cpanel_user=YOUR_CPANEL_USERNAME
 
 # cPanel API token (created in cPanel → Manage API Tokens)
 cpanel_apikey=YOUR_CPANEL_API_TOKEN
+
+# Optional: reach cPanel through a proxy (http://, https:// or socks5://)
+# cpanel_proxy=socks5://127.0.0.1:1080
+
+# Optional: use another DNS host instead of cPanel (cloudflare or rfc2136)
+# DNS_PROVIDER=cloudflare
+# cloudflare_api_token=
+# DNS_PROVIDER=rfc2136
+# rfc2136_server=ns1.example.com:53
+# rfc2136_tsig_key=acme-update
+# rfc2136_tsig_secret=
+# rfc2136_zone=example.com
 EOF
     chmod 600 "$CLI_CONF"
     ok "Created: $CLI_CONF"
@@ -195,10 +344,19 @@ depend() {
     use logger
 }
 
+extra_started_commands="reload"
+
 start_pre() {
     checkpath --directory /var/run
     checkpath --file --mode 0644 /var/log/dns-proxy-api.log
 }
+
+# Re-read tokens, allowlists and DNS provider credentials without a restart
+reload() {
+    ebegin "Reloading ${name}"
+    start-stop-daemon --signal HUP --pidfile "${pidfile}"
+    eend $?
+}
 EOF
     chmod +x "$OPENRC_INIT"
     ok "Created: $OPENRC_INIT"
@@ -221,8 +379,10 @@ After=network.target
 [Service]
 Type=simple
 ExecStart=/usr/local/bin/dns-proxy-api
+ExecReload=/bin/kill -HUP $MAINPID
 Restart=on-failure
 RestartSec=5
+TimeoutStopSec=120
 StandardOutput=journal
 StandardError=journal
 
@@ -292,6 +452,7 @@ summary() {
   echo ""
   echo "  Binaries  : $INSTALL_DIR/dns-proxy-api"
   echo "              $INSTALL_DIR/dns-proxy-cli"
+  echo "              $INSTALL_DIR/dns-proxy-fetch"
   echo "  API config: $API_CONF"
   echo "  CLI config: $CLI_CONF"
   if [ "$INIT_SYSTEM" = "openrc" ];  then echo "  Init      : $OPENRC_INIT"; fi
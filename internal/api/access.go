@@ -0,0 +1,82 @@
+package api
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/fcrdns"
+)
+
+// CertAccess restricts which certificates a verified client may fetch. It
+// maps client host names (exact, or "*.suffix" patterns as in the allowlist)
+// to the names that host serves; a client may only fetch a certificate whose
+// SANs cover at least one of its names.
+//
+// An empty CertAccess allows every verified client to fetch every domain.
+// Once it has entries, clients without a matching entry are refused.
+type CertAccess map[string][]string
+
+// namesFor returns the served names configured for hostname. An exact entry
+// wins over patterns, and the longest matching pattern over shorter ones.
+func (a CertAccess) namesFor(hostname string) ([]string, bool) {
+	if names, ok := a[dnsname.Canonical(hostname)]; ok {
+		return names, true
+	}
+	var best string
+	for pattern := range a {
+		if strings.HasPrefix(pattern, "*.") && fcrdns.Matches(hostname, pattern) && len(pattern) > len(best) {
+			best = pattern
+		}
+	}
+	if best == "" {
+		return nil, false
+	}
+	return a[best], true
+}
+
+// allows reports whether the client verified as hostname may fetch the
+// certificate stored in baseDir/domain.
+func (a CertAccess) allows(hostname, baseDir, domain string) bool {
+	if len(a) == 0 {
+		return true
+	}
+	names, ok := a.namesFor(hostname)
+	if !ok {
+		return false
+	}
+	cert, err := leafCertificate(filepath.Join(baseDir, domain))
+	if err != nil {
+		return false
+	}
+	for _, name := range names {
+		if cert.VerifyHostname(name) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// leafCertificate reads the first certificate of a certbot-style domain
+// directory.
+func leafCertificate(dir string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "cert.pem"))
+	if err != nil {
+		if data, err = os.ReadFile(filepath.Join(dir, "fullchain.pem")); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, os.ErrNotExist
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
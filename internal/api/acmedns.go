@@ -0,0 +1,358 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/history"
+	"acme-dns-tools/internal/logging"
+	"acme-dns-tools/internal/metrics"
+	"acme-dns-tools/internal/ratelimit"
+)
+
+// acmeDNSKeep is how many TXT values an account keeps, as in acme-dns: a
+// certificate for example.com and *.example.com needs two at once.
+const acmeDNSKeep = 2
+
+// ACMEDNSConfig bundles the dependencies of the acme-dns endpoints.
+type ACMEDNSConfig struct {
+	Settings *LiveSettings
+	// Zone holds the account records, _acme-challenge.<subdomain>.<Zone>;
+	// it must be served by the DNS provider.
+	Zone string
+	// AccountsFile persists the registrations.
+	AccountsFile string
+	// OpenRegistration lets anyone call /register, as acme-dns does;
+	// otherwise it takes a token granted set_txt for Zone.
+	OpenRegistration bool
+	// SetTxt and DeleteTxt are the /set_txt and /delete_txt handlers.
+	SetTxt, DeleteTxt http.Handler
+}
+
+// ACMEDNSRegistration is the answer of POST /register.
+type ACMEDNSRegistration struct {
+	Username   string   `json:"username"`
+	Password   string   `json:"password"`
+	FullDomain string   `json:"fulldomain"`
+	Subdomain  string   `json:"subdomain"`
+	AllowFrom  []string `json:"allowfrom"`
+}
+
+// ACMEDNSUpdate is the body of POST /update.
+type ACMEDNSUpdate struct {
+	Subdomain string `json:"subdomain"`
+	TXT       string `json:"txt"`
+}
+
+type acmeDNSAccount struct {
+	Username  string   `json:"username"`
+	KeyHash   string   `json:"key_hash"` // hex SHA-256 of the password
+	Subdomain string   `json:"subdomain"`
+	AllowFrom []string `json:"allowfrom,omitempty"`
+	Values    []string `json:"values,omitempty"` // TXT values published, oldest first
+
+	mu sync.Mutex // serializes updates; Values also changes under ACMEDNS.mu
+}
+
+// ACMEDNS serves the /register and /update endpoints of acme-dns, so its
+// clients (certbot-acme-dns, lego's acmedns provider, Traefik, cert-manager)
+// can use this API unchanged. Each account owns one name below the zone;
+// users point _acme-challenge.<their domain> there with a CNAME and /update
+// publishes values through the /set_txt handler.
+type ACMEDNS struct {
+	cfg ACMEDNSConfig
+
+	mu       sync.Mutex
+	accounts map[string]*acmeDNSAccount // by username
+}
+
+// NewACMEDNS loads the registrations from cfg.AccountsFile, if it exists.
+func NewACMEDNS(cfg ACMEDNSConfig) (*ACMEDNS, error) {
+	cfg.Zone = dnsname.Canonical(cfg.Zone)
+	a := &ACMEDNS{cfg: cfg, accounts: make(map[string]*acmeDNSAccount)}
+	data, err := os.ReadFile(cfg.AccountsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var accounts []*acmeDNSAccount
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.AccountsFile, err)
+	}
+	for _, acct := range accounts {
+		a.accounts[acct.Username] = acct
+	}
+	return a, nil
+}
+
+// fullDomain is the name whose TXT values acct publishes.
+func (a *ACMEDNS) fullDomain(acct *acmeDNSAccount) string {
+	return dnsname.ChallengeLabel + "." + acct.Subdomain + "." + a.cfg.Zone
+}
+
+// save writes every account to the accounts file, replacing it atomically.
+func (a *ACMEDNS) save() error {
+	a.mu.Lock()
+	accounts := make([]*acmeDNSAccount, 0, len(a.accounts))
+	for _, acct := range a.accounts {
+		accounts = append(accounts, acct)
+	}
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	path := a.cfg.AccountsFile
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Register creates an account. The body may restrict its updates to
+// source networks: {"allowfrom": ["192.0.2.0/24"]}.
+func (a *ACMEDNS) Register(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var registrar *Client
+	if !a.cfg.OpenRegistration {
+		if registrar = a.cfg.Settings.Load().Authorize(w, r, OpSetTxt, "acmedns_register"); registrar == nil {
+			return
+		}
+		if !registrar.AllowsDomain(a.cfg.Zone) {
+			log.Printf("acmedns_register: refused client %s (%s) – %s not among its domains", registrar.Name, registrar.ID(), a.cfg.Zone)
+			metrics.AuthDenials.Inc("acmedns_register", metrics.ReasonScope)
+			writeACMEDNSError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+	}
+
+	var req struct {
+		AllowFrom []string `json:"allowfrom"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeACMEDNSError(w, http.StatusBadRequest, "malformed_json_payload")
+		return
+	}
+	for _, cidr := range req.AllowFrom {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			writeACMEDNSError(w, http.StatusBadRequest, "invalid_allowfrom_cidr")
+			return
+		}
+	}
+
+	password := randomToken(30)
+	keyHash := sha256.Sum256([]byte(password))
+	acct := &acmeDNSAccount{
+		Username:  randomUUID(),
+		KeyHash:   hex.EncodeToString(keyHash[:]),
+		Subdomain: randomUUID(),
+		AllowFrom: req.AllowFrom,
+	}
+	a.mu.Lock()
+	a.accounts[acct.Username] = acct
+	a.mu.Unlock()
+	if err := a.save(); err != nil {
+		a.mu.Lock()
+		delete(a.accounts, acct.Username)
+		a.mu.Unlock()
+		log.Printf("acmedns_register: %v", err)
+		writeACMEDNSError(w, http.StatusInternalServerError, "db_error")
+		return
+	}
+
+	fullDomain := a.fullDomain(acct)
+	logging.Annotate(r, "domain", fullDomain)
+	if registrar == nil {
+		registrar = acct.client(password, fullDomain)
+	}
+	Audit(registrar, r, "acmedns_register", fullDomain, history.OutcomeOK)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ACMEDNSRegistration{
+		Username:   acct.Username,
+		Password:   password,
+		FullDomain: fullDomain,
+		Subdomain:  acct.Subdomain,
+		AllowFrom:  append([]string{}, req.AllowFrom...),
+	})
+}
+
+// Update publishes a TXT value for the account named by the X-Api-User and
+// X-Api-Key headers and removes all but the two most recent ones.
+func (a *ACMEDNS) Update(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, key := r.Header.Get("X-Api-User"), r.Header.Get("X-Api-Key")
+	a.mu.Lock()
+	acct := a.accounts[user]
+	a.mu.Unlock()
+	keyHash := sha256.Sum256([]byte(key))
+	if acct == nil || key == "" || subtle.ConstantTimeCompare([]byte(hex.EncodeToString(keyHash[:])), []byte(acct.KeyHash)) != 1 {
+		metrics.AuthDenials.Inc("acmedns_update", metrics.ReasonBearer)
+		writeACMEDNSError(w, http.StatusUnauthorized, "forbidden")
+		return
+	}
+	ratelimit.Authenticated(r)
+	fullDomain := a.fullDomain(acct)
+	client := acct.client(key, fullDomain)
+	logging.Annotate(r, "token", client.ID(), "client", client.Name, "domain", fullDomain)
+	if !acct.allows(r.RemoteAddr) {
+		log.Printf("acmedns_update: refused %s to account %s – address not in allowfrom", r.RemoteAddr, acct.Username)
+		metrics.AuthDenials.Inc("acmedns_update", metrics.ReasonScope)
+		writeACMEDNSError(w, http.StatusUnauthorized, "forbidden")
+		return
+	}
+
+	var req ACMEDNSUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeACMEDNSError(w, http.StatusBadRequest, "malformed_json_payload")
+		return
+	}
+	if req.Subdomain != acct.Subdomain {
+		metrics.AuthDenials.Inc("acmedns_update", metrics.ReasonScope)
+		writeACMEDNSError(w, http.StatusUnauthorized, "forbidden")
+		return
+	}
+	// DNS-01 values are base64url SHA-256 digests
+	if len(req.TXT) != 43 || !isBase64URL(req.TXT) {
+		writeACMEDNSError(w, http.StatusBadRequest, "bad_txt")
+		return
+	}
+
+	acct.mu.Lock()
+	defer acct.mu.Unlock()
+	r = withClient(r, client)
+	domain, label := strings.TrimPrefix(fullDomain, dnsname.ChallengeLabel+"."), dnsname.ChallengeLabel
+	if !contains(acct.Values, req.TXT) {
+		rec := newRecorder()
+		forward(rec, r, a.cfg.SetTxt, http.MethodPost, SetTxtRequest{Domain: domain, Key: label, Value: req.TXT, Mode: ModeAppend})
+		if !rec.ok() {
+			if retry := rec.header.Get("Retry-After"); retry != "" {
+				w.Header().Set("Retry-After", retry)
+			}
+			writeACMEDNSError(w, rec.status, strings.TrimSpace(rec.body.String()))
+			return
+		}
+		a.mu.Lock()
+		acct.Values = append(acct.Values, req.TXT)
+		a.mu.Unlock()
+	}
+	// A value that cannot be removed now is retried on the next update
+	for len(acct.Values) > acmeDNSKeep {
+		rec := newRecorder()
+		forward(rec, r, a.cfg.DeleteTxt, http.MethodPost, DeleteTxtRequest{Domain: domain, Key: label, Value: acct.Values[0]})
+		if !rec.ok() {
+			log.Printf("acmedns_update: could not remove an old value of %s: %s", fullDomain, strings.TrimSpace(rec.body.String()))
+			break
+		}
+		a.mu.Lock()
+		acct.Values = acct.Values[1:]
+		a.mu.Unlock()
+	}
+	if err := a.save(); err != nil {
+		log.Printf("acmedns_update: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"txt": req.TXT})
+}
+
+// client is the identity acct acts as towards /set_txt and /delete_txt:
+// limited to its own name, with the password as token.
+func (acct *acmeDNSAccount) client(password, fullDomain string) *Client {
+	return &Client{
+		Name:       "acme-dns:" + acct.Username,
+		Token:      password,
+		Operations: []string{OpSetTxt, OpDeleteTxt},
+		Domains:    []string{strings.TrimPrefix(fullDomain, dnsname.ChallengeLabel+".")},
+	}
+}
+
+// allows reports whether remoteAddr is within the account's allowfrom
+// networks; an account without any accepts every address.
+func (acct *acmeDNSAccount) allows(remoteAddr string) bool {
+	if len(acct.AllowFrom) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	for _, cidr := range acct.AllowFrom {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeACMEDNSError answers in the {"error": "..."} format of acme-dns.
+func writeACMEDNSError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// randomUUID returns a random (version 4) UUID.
+func randomUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// randomToken returns n random bytes, base64url encoded.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestACMEDNS(t *testing.T, cfg RecordsConfig, open bool) (*ACMEDNS, ACMEDNSConfig) {
+	t.Helper()
+	acfg := ACMEDNSConfig{
+		Settings:         cfg.Settings,
+		Zone:             "Auth.Example.",
+		AccountsFile:     filepath.Join(t.TempDir(), "acmedns", "accounts.json"),
+		OpenRegistration: open,
+		SetTxt:           SetTxtHandler(cfg),
+		DeleteTxt:        DeleteTxtHandler(cfg),
+	}
+	a, err := NewACMEDNS(acfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, acfg
+}
+
+func register(t *testing.T, a *ACMEDNS, token, body string) (*httptest.ResponseRecorder, ACMEDNSRegistration) {
+	t.Helper()
+	w := serve(http.HandlerFunc(a.Register), http.MethodPost, "/acmedns/register", token, body)
+	var reg ACMEDNSRegistration
+	if w.Code == http.StatusCreated {
+		if err := json.Unmarshal(w.Body.Bytes(), &reg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w, reg
+}
+
+func update(a *ACMEDNS, reg ACMEDNSRegistration, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/acmedns/update", strings.NewReader(body))
+	r.Header.Set("X-Api-User", reg.Username)
+	r.Header.Set("X-Api-Key", reg.Password)
+	w := httptest.NewRecorder()
+	a.Update(w, r)
+	return w
+}
+
+// txtValue returns a well-formed DNS-01 value made of c.
+func txtValue(c string) string {
+	return strings.Repeat(c, 43)
+}
+
+func TestACMEDNSRegister(t *testing.T) {
+	a, acfg := newTestACMEDNS(t, recordsConfig(t, &fakeBackend{}), false)
+
+	for _, tc := range []struct {
+		name, token, body string
+		want              int
+	}{
+		{"no token", "", "", http.StatusUnauthorized},
+		{"token for other domains", "web-token", "", http.StatusForbidden},
+		{"bad allowfrom", "api-token", `{"allowfrom": ["192.0.2.1"]}`, http.StatusBadRequest},
+		{"bad JSON", "api-token", `{"allowfrom":`, http.StatusBadRequest},
+	} {
+		if w, _ := register(t, a, tc.token, tc.body); w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+
+	w, reg := register(t, a, "api-token", `{"allowfrom": ["192.0.2.0/24"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register = %d %q", w.Code, w.Body.String())
+	}
+	if reg.Username == "" || len(reg.Password) < 40 || reg.FullDomain != "_acme-challenge."+reg.Subdomain+".auth.example" {
+		t.Errorf("registration = %+v", reg)
+	}
+
+	// The account survives a restart, without its password in the clear
+	b, err := NewACMEDNS(acfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct := b.accounts[reg.Username]; acct == nil || acct.Subdomain != reg.Subdomain || strings.Contains(acct.KeyHash, reg.Password) {
+		t.Errorf("reloaded account = %+v", acct)
+	}
+
+	// acme-dns lets anyone register
+	open, _ := newTestACMEDNS(t, recordsConfig(t, &fakeBackend{}), true)
+	if w, _ := register(t, open, "", ""); w.Code != http.StatusCreated {
+		t.Errorf("open registration = %d", w.Code)
+	}
+}
+
+func TestACMEDNSUpdate(t *testing.T) {
+	backend := &fakeBackend{}
+	cfg := recordsConfig(t, backend)
+	a, _ := newTestACMEDNS(t, cfg, true)
+	_, reg := register(t, a, "", "")
+	record := "_acme-challenge." + reg.Subdomain + ".auth.example"
+
+	for _, v := range []string{"a", "b", "b", "c"} {
+		body := `{"subdomain": "` + reg.Subdomain + `", "txt": "` + txtValue(v) + `"}`
+		if w := update(a, reg, body); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), txtValue(v)) {
+			t.Fatalf("update %s = %d %q", v, w.Code, w.Body.String())
+		}
+	}
+	// A repeated value is not published twice; the third one pushes out the oldest
+	want := []string{
+		"set " + record + " " + txtValue("a") + " append",
+		"set " + record + " " + txtValue("b") + " append",
+		"set " + record + " " + txtValue("c") + " append",
+		"delete " + record + " " + txtValue("a"),
+	}
+	if strings.Join(backend.calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("backend calls =\n%s\nwant\n%s", strings.Join(backend.calls, "\n"), strings.Join(want, "\n"))
+	}
+	if got := a.accounts[reg.Username].Values; strings.Join(got, ",") != txtValue("b")+","+txtValue("c") {
+		t.Errorf("account values = %q", got)
+	}
+
+	// Changes go through /set_txt, which records them as the account
+	entries, _ := cfg.History.Query(reg.Subdomain+".auth.example", "", 0)
+	if len(entries) != 4 || entries[0].TokenName != "acme-dns:"+reg.Username {
+		t.Errorf("history = %+v", entries)
+	}
+}
+
+func TestACMEDNSUpdateErrors(t *testing.T) {
+	backend := &fakeBackend{}
+	a, _ := newTestACMEDNS(t, recordsConfig(t, backend), false)
+	_, reg := register(t, a, "api-token", "")
+	_, fenced := register(t, a, "api-token", `{"allowfrom": ["10.0.0.0/8"]}`)
+	body := func(sub, txt string) string { return `{"subdomain": "` + sub + `", "txt": "` + txt + `"}` }
+
+	wrongKey := reg
+	wrongKey.Password = "guess"
+	for _, tc := range []struct {
+		name string
+		reg  ACMEDNSRegistration
+		body string
+		want int
+	}{
+		{"wrong key", wrongKey, body(reg.Subdomain, txtValue("a")), http.StatusUnauthorized},
+		{"other subdomain", reg, body(fenced.Subdomain, txtValue("a")), http.StatusUnauthorized},
+		{"address not in allowfrom", fenced, body(fenced.Subdomain, txtValue("a")), http.StatusUnauthorized},
+		{"short value", reg, body(reg.Subdomain, "abc"), http.StatusBadRequest},
+		{"value not base64url", reg, body(reg.Subdomain, txtValue("+")), http.StatusBadRequest},
+		{"bad JSON", reg, "{", http.StatusBadRequest},
+	} {
+		if w := update(a, tc.reg, tc.body); w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+	if len(backend.calls) != 0 {
+		t.Errorf("refused updates reached the backend: %q", backend.calls)
+	}
+
+	// A failed change is reported in the acme-dns error format and not remembered
+	backend.err = errors.New("zone dump with secrets")
+	w := update(a, reg, body(reg.Subdomain, txtValue("a")))
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusInternalServerError || resp["error"] != "Failed to set TXT record" {
+		t.Errorf("failed update = %d %q", w.Code, w.Body.String())
+	}
+	if got := a.accounts[reg.Username].Values; len(got) != 0 {
+		t.Errorf("failed value was remembered: %q", got)
+	}
+}
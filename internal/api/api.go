@@ -1,45 +1,402 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+
+	"acme-dns-tools/internal/challenges"
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/history"
+	"acme-dns-tools/internal/logging"
+	"acme-dns-tools/internal/metrics"
+	"acme-dns-tools/internal/quota"
+	"acme-dns-tools/internal/redact"
+	"acme-dns-tools/internal/usage"
+)
+
+// Set modes for SetTxtRequest.Mode. Append (the default) adds the value next
+// to existing ones, so concurrent challenges for the same name survive;
+// replace removes every other value for the name.
+const (
+	ModeAppend  = "append"
+	ModeReplace = "replace"
 )
 
 type SetTxtRequest struct {
 	Domain string `json:"domain"`
 	Key    string `json:"key"`
 	Value  string `json:"value"`
+	Mode   string `json:"mode,omitempty"`
+}
+
+// DeleteTxtRequest is the body of /delete_txt.
+type DeleteTxtRequest struct {
+	Domain string `json:"domain"`
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+}
+
+// SSHFPRequest is the body of POST /v1/sshfp.
+type SSHFPRequest struct {
+	Host  string   `json:"host"`
+	Keys  []string `json:"keys"` // OpenSSH public key lines
+	Prune bool     `json:"prune"`
+}
+
+// RecordBackend performs the DNS changes behind the mutation endpoints.
+// Every method returns the command output (the dry-run report, or what to
+// log at debug level) and an error for the server log only: provider
+// responses can carry zone contents, so callers get a fixed message.
+type RecordBackend interface {
+	SetTxt(ctx context.Context, domain, key, value, mode string) (string, error)
+	// DryRunSetTxt describes what SetTxt would change.
+	DryRunSetTxt(ctx context.Context, domain, key, value, mode string) (string, error)
+	// DeleteTxt treats a record that is already gone as deleted.
+	DeleteTxt(ctx context.Context, domain, key, value string) (string, error)
+	PublishSSHFP(ctx context.Context, host string, keys []string, prune, dryRun bool) (string, error)
+}
+
+// UnsupportedError reports an operation the configured DNS provider cannot
+// perform; the handlers answer it with 501 Not Implemented rather than
+// blaming the provider with a 502.
+type UnsupportedError struct {
+	Op       string
+	Provider string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s is not supported by the %s DNS provider", e.Op, e.Provider)
+}
+
+// errorStatus returns the status for a failed backend call: 501 for
+// operations the provider does not support, fallback otherwise.
+func errorStatus(err error, fallback int) int {
+	var unsupported *UnsupportedError
+	if errors.As(err, &unsupported) {
+		return http.StatusNotImplemented
+	}
+	return fallback
+}
+
+// writeBackendError answers a failed backend call with msg, or with the
+// reason an operation is not supported. Other errors and the backend output
+// stay in the server log.
+func writeBackendError(w http.ResponseWriter, err error, msg string, fallback int) {
+	status := errorStatus(err, fallback)
+	if status == http.StatusNotImplemented {
+		msg = err.Error()
+	}
+	http.Error(w, msg, status)
+}
+
+// RecordsConfig bundles the dependencies of the /set_txt, /delete_txt and
+// /v1/sshfp handlers. Quotas, Usage, History and Challenges are optional.
+type RecordsConfig struct {
+	Settings *LiveSettings
+	Backend  RecordBackend
+	// ACMEChallengeOnly refuses every record but _acme-challenge TXT ones.
+	ACMEChallengeOnly bool
+	Quotas            *quota.Limiter
+	Usage             *usage.Tracker
+	History           *history.Store
+	// Challenges collects the challenge records created, for the cleanup
+	// on shutdown.
+	Challenges *challenges.Tracker
+}
+
+// mayChange checks that client may change the TXT record key at domain,
+// and answers 403 otherwise.
+func (cfg RecordsConfig) mayChange(w http.ResponseWriter, client *Client, endpoint, domain, key string) bool {
+	if cfg.ACMEChallengeOnly && !IsACMEChallengeKey(key) {
+		log.Printf("%s: refused key %q for %s – only _acme-challenge records are allowed", endpoint, key, domain)
+		metrics.AuthDenials.Inc(endpoint, metrics.ReasonScope)
+		writeACMEChallengeOnly(w)
+		return false
+	}
+	return allowsDomain(w, client, endpoint, domain)
+}
+
+// allowsDomain checks that domain is among the client's domains, and
+// answers 403 otherwise.
+func allowsDomain(w http.ResponseWriter, client *Client, endpoint, domain string) bool {
+	if !client.AllowsDomain(domain) {
+		log.Printf("%s: refused %s to client %s (%s) – not among its domains", endpoint, domain, client.Name, client.ID())
+		metrics.AuthDenials.Inc(endpoint, metrics.ReasonScope)
+		http.Error(w, "Forbidden – token may not modify this domain", http.StatusForbidden)
+		return false
+	}
+	return true
 }
 
-type TxtRecordSetter interface {
-	CreateTxtRecord(domain, key, value string) error
+func writeACMEChallengeOnly(w http.ResponseWriter) {
+	http.Error(w, "Forbidden – only _acme-challenge records may be modified", http.StatusForbidden)
 }
 
-func SetTxtHandler(apiKey string, setter TxtRecordSetter) http.HandlerFunc {
+// allowMutation consumes one mutation from the client's quota, and answers
+// 429 once it is exhausted.
+func (cfg RecordsConfig) allowMutation(w http.ResponseWriter, client *Client, endpoint string) bool {
+	if cfg.Quotas == nil {
+		return true
+	}
+	if ok, retryAfter := client.AllowQuota(cfg.Quotas, quota.OpMutation); !ok {
+		log.Printf("%s: mutation quota exhausted for token %s", endpoint, client.ID())
+		quota.WriteExceeded(w, retryAfter)
+		return false
+	}
+	return true
+}
+
+// txtChange is one TXT record change on its way through a handler.
+type txtChange struct {
+	endpoint string // set_txt or delete_txt
+	client   *Client
+	value    string
+	entry    history.Entry
+	// Messages: "Failed to set TXT record", "TXT record set" and "setting
+	// the TXT record" (for a missed deadline)
+	failed, done, doing string
+}
+
+var (
+	setChange    = txtChange{endpoint: "set_txt", failed: "Failed to set TXT record", done: "TXT record set", doing: "setting the TXT record"}
+	deleteChange = txtChange{endpoint: "delete_txt", failed: "Failed to delete TXT record", done: "TXT record deleted", doing: "deleting the TXT record"}
+)
+
+// start returns a copy of c for a change of domain/key by client, with its
+// history entry filled in.
+func (c txtChange) start(r *http.Request, client *Client, op, domain, key, mode, value string) *txtChange {
+	c.client, c.value = client, value
+	c.entry = history.Entry{
+		Operation: op,
+		Domain:    domain,
+		Key:       key,
+		Mode:      mode,
+		Token:     client.ID(),
+		TokenName: client.Name,
+		Tenant:    client.TenantName(),
+		Client:    r.RemoteAddr,
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		c.entry.Client = host
+	}
+	return &c
+}
+
+// finish records the outcome of the change in the history, the audit log
+// and the usage report, and answers the request. Provider errors and the
+// backend output can echo the zone and the challenge value, so they only go
+// to the server log; the caller gets a fixed message.
+func (cfg RecordsConfig) finish(ctx context.Context, w http.ResponseWriter, r *http.Request, c *txtChange, output string, err error) {
+	e := &c.entry
+	logging.Debugf("%s: backend output: %s", c.endpoint, redact.String(output, c.value))
+	if err != nil {
+		log.Printf("%s: %s: %s", c.endpoint, c.failed, redact.String(err.Error(), c.value))
+		e.Outcome, e.Summary = history.OutcomeError, c.failed
+		if ctx.Err() != nil {
+			e.Summary = "request deadline exceeded; outcome unknown"
+		}
+	} else {
+		cfg.Usage.Record(c.client.ID(), quota.OpMutation, e.Domain, 0)
+		e.Outcome, e.Summary = history.OutcomeOK, c.done
+	}
+	if herr := cfg.History.Append(*e); herr != nil {
+		log.Printf("history: %v", herr)
+	}
+	Audit(c.client, r, c.endpoint, e.Key+"."+e.Domain, e.Outcome)
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		WriteDeadlineExceeded(w, c.doing)
+	case err != nil:
+		writeBackendError(w, err, c.failed, http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(c.done))
+	}
+}
+
+// SetTxtHandler serves POST /set_txt. With ?dry_run=true it reports what
+// would change without changing the zone or counting against quotas.
+func SetTxtHandler(cfg RecordsConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		expected := "Bearer " + apiKey
-		if authHeader != expected {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		client := cfg.Settings.Load().Authorize(w, r, OpSetTxt, "set_txt")
+		if client == nil {
 			return
 		}
 
 		var req SetTxtRequest
 		err := json.NewDecoder(r.Body).Decode(&req)
+		req.Domain, req.Key = dnsname.Record(req.Domain, req.Key)
+		logging.Annotate(r, "domain", req.Domain, "key", req.Key)
 		if err != nil || req.Domain == "" || req.Key == "" || req.Value == "" {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
+		if req.Mode == "" {
+			req.Mode = ModeAppend
+		}
+		if req.Mode != ModeAppend && req.Mode != ModeReplace {
+			http.Error(w, "Invalid mode – expected append or replace", http.StatusBadRequest)
+			return
+		}
+		if !cfg.mayChange(w, client, "set_txt", req.Domain, req.Key) {
+			return
+		}
 
-		err = setter.CreateTxtRecord(req.Domain, req.Key, req.Value)
+		// Callers with their own timeout get a 504 instead of a severed connection
+		ctx, cancel, err := RequestContext(r)
 		if err != nil {
-			log.Println("cPanel error:", err)
-			http.Error(w, "Failed to set TXT record", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		defer cancel()
 
+		// A dry run stops before the mutation and does not count against quotas
+		if r.URL.Query().Get("dry_run") == "true" {
+			output, err := cfg.Backend.DryRunSetTxt(ctx, req.Domain, req.Key, req.Value, req.Mode)
+			if err != nil && ctx.Err() != nil {
+				WriteDeadlineExceeded(w, "checking the zone")
+				return
+			}
+			if err != nil {
+				log.Printf("set_txt: dry run failed: %s", redact.String(err.Error(), req.Value))
+				logging.Debugf("set_txt: backend output: %s", redact.String(output, req.Value))
+				writeBackendError(w, err, "Failed to read the zone", http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(redact.String(output, req.Value)))
+			return
+		}
+
+		if !cfg.allowMutation(w, client, "set_txt") {
+			return
+		}
+		c := setChange.start(r, client, history.OpCreate, req.Domain, req.Key, req.Mode, req.Value)
+		output, err := cfg.Backend.SetTxt(ctx, req.Domain, req.Key, req.Value, req.Mode)
+		if err == nil && IsACMEChallengeKey(req.Key) {
+			if req.Mode == ModeReplace {
+				cfg.Challenges.RemoveName(req.Domain, req.Key)
+			}
+			cfg.Challenges.Add(challenges.Record{Domain: req.Domain, Key: req.Key, Value: req.Value})
+		}
+		cfg.finish(ctx, w, r, c, output, err)
+	}
+}
+
+// DeleteTxtHandler serves DELETE (or POST) /delete_txt, so cleanup hooks can
+// remove the challenge record once validation is done. Deleting a record
+// that no longer exists is not an error, so hooks can safely retry.
+func DeleteTxtHandler(cfg RecordsConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := cfg.Settings.Load().Authorize(w, r, OpDeleteTxt, "delete_txt")
+		if client == nil {
+			return
+		}
+		if r.Method != http.MethodDelete && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "DELETE, POST")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req DeleteTxtRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		req.Domain, req.Key = dnsname.Record(req.Domain, req.Key)
+		logging.Annotate(r, "domain", req.Domain, "key", req.Key)
+		if err != nil || req.Domain == "" || req.Key == "" || req.Value == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !cfg.mayChange(w, client, "delete_txt", req.Domain, req.Key) {
+			return
+		}
+
+		ctx, cancel, err := RequestContext(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+
+		if !cfg.allowMutation(w, client, "delete_txt") {
+			return
+		}
+		c := deleteChange.start(r, client, history.OpDelete, req.Domain, req.Key, "", req.Value)
+		output, err := cfg.Backend.DeleteTxt(ctx, req.Domain, req.Key, req.Value)
+		if err == nil {
+			cfg.Challenges.Remove(challenges.Record{Domain: req.Domain, Key: req.Key, Value: req.Value})
+		}
+		cfg.finish(ctx, w, r, c, output, err)
+	}
+}
+
+// SSHFPHandler serves POST /v1/sshfp, which publishes SSHFP records for a
+// host's SSH public keys. With ?dry_run=true it reports what would change.
+func SSHFPHandler(cfg RecordsConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := cfg.Settings.Load().Authorize(w, r, OpSSHFP, "sshfp")
+		if client == nil {
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if cfg.ACMEChallengeOnly {
+			metrics.AuthDenials.Inc("sshfp", metrics.ReasonScope)
+			writeACMEChallengeOnly(w)
+			return
+		}
+
+		var req SSHFPRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		req.Host = dnsname.Canonical(req.Host)
+		logging.Annotate(r, "domain", req.Host)
+		if err != nil || req.Host == "" || len(req.Keys) == 0 {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !allowsDomain(w, client, "sshfp", req.Host) {
+			return
+		}
+
+		ctx, cancel, err := RequestContext(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+
+		dryRun := r.URL.Query().Get("dry_run") == "true"
+		if !dryRun && !cfg.allowMutation(w, client, "sshfp") {
+			return
+		}
+
+		output, err := cfg.Backend.PublishSSHFP(ctx, req.Host, req.Keys, req.Prune, dryRun)
+		if err != nil && !dryRun {
+			Audit(client, r, "sshfp", req.Host, history.OutcomeError)
+		}
+		if err != nil && ctx.Err() != nil {
+			WriteDeadlineExceeded(w, "publishing SSHFP records")
+			return
+		}
+		if err != nil {
+			log.Printf("sshfp: failed to publish SSHFP records for %s: %s", req.Host, redact.String(err.Error()))
+			logging.Debugf("sshfp: backend output: %s", redact.String(output))
+			writeBackendError(w, err, "Failed to publish SSHFP records", http.StatusBadGateway)
+			return
+		}
+		if !dryRun {
+			cfg.Usage.Record(client.ID(), quota.OpMutation, req.Host, 0)
+			Audit(client, r, "sshfp", req.Host, history.OutcomeOK)
+		}
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("TXT record set"))
+		w.Write([]byte(redact.String(output)))
 	}
 }
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"acme-dns-tools/internal/challenges"
+	"acme-dns-tools/internal/history"
+	"acme-dns-tools/internal/quota"
+)
+
+// fakeBackend records the calls it gets and fails them with err.
+type fakeBackend struct {
+	calls  []string
+	output string
+	err    error
+}
+
+func (b *fakeBackend) SetTxt(_ context.Context, domain, key, value, mode string) (string, error) {
+	b.calls = append(b.calls, "set "+key+"."+domain+" "+value+" "+mode)
+	return b.output, b.err
+}
+
+func (b *fakeBackend) DryRunSetTxt(_ context.Context, domain, key, value, mode string) (string, error) {
+	b.calls = append(b.calls, "dry-run "+key+"."+domain)
+	return b.output, b.err
+}
+
+func (b *fakeBackend) DeleteTxt(_ context.Context, domain, key, value string) (string, error) {
+	b.calls = append(b.calls, "delete "+key+"."+domain+" "+value)
+	return b.output, b.err
+}
+
+func (b *fakeBackend) PublishSSHFP(_ context.Context, host string, keys []string, prune, dryRun bool) (string, error) {
+	b.calls = append(b.calls, "sshfp "+host)
+	return b.output, b.err
+}
+
+func recordsConfig(t *testing.T, b *fakeBackend) RecordsConfig {
+	t.Helper()
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return RecordsConfig{
+		Settings: NewLiveSettings(Settings{
+			APIToken: "api-token",
+			Clients: []Client{{
+				Name:       "web",
+				Token:      "web-token",
+				Operations: []string{OpSetTxt, OpDeleteTxt, OpSSHFP},
+				Domains:    []string{"web.example"},
+			}},
+		}),
+		Backend:    b,
+		History:    store,
+		Challenges: challenges.New(),
+	}
+}
+
+func serve(h http.Handler, method, target, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestSetTxtHandler(t *testing.T) {
+	b := &fakeBackend{}
+	cfg := recordsConfig(t, b)
+	h := SetTxtHandler(cfg)
+
+	w := serve(h, http.MethodPost, "/set_txt", "api-token", `{"domain":"Example.COM.","key":"_acme-challenge","value":"v1"}`)
+	if w.Code != http.StatusOK || w.Body.String() != "TXT record set" {
+		t.Fatalf("set_txt = %d %q", w.Code, w.Body.String())
+	}
+	if len(b.calls) != 1 || b.calls[0] != "set _acme-challenge.example.com v1 append" {
+		t.Errorf("backend calls = %q", b.calls)
+	}
+	if got := cfg.Challenges.Outstanding(); len(got) != 1 || got[0].Value != "v1" {
+		t.Errorf("outstanding challenges = %+v", got)
+	}
+	entries, err := cfg.History.Query("example.com", "", 10)
+	if err != nil || len(entries) != 1 || entries[0].Outcome != history.OutcomeOK || entries[0].TokenName != "api" {
+		t.Errorf("history = %+v, %v", entries, err)
+	}
+
+	for _, tc := range []struct {
+		name, token, body string
+		want              int
+	}{
+		{"no token", "", `{"domain":"example.com","key":"k","value":"v"}`, http.StatusUnauthorized},
+		{"missing value", "api-token", `{"domain":"example.com","key":"k"}`, http.StatusBadRequest},
+		{"bad mode", "api-token", `{"domain":"example.com","key":"k","value":"v","mode":"merge"}`, http.StatusBadRequest},
+		{"other domain", "web-token", `{"domain":"example.com","key":"k","value":"v"}`, http.StatusForbidden},
+	} {
+		if w := serve(h, http.MethodPost, "/set_txt", tc.token, tc.body); w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+	if len(b.calls) != 1 {
+		t.Errorf("refused requests reached the backend: %q", b.calls)
+	}
+}
+
+func TestSetTxtHandlerKeepsBackendErrorsInTheLog(t *testing.T) {
+	b := &fakeBackend{output: "fetchzone: TXT \"secret-value\"", err: errors.New(`cpanel: zone dump "secret-value"`)}
+	cfg := recordsConfig(t, b)
+
+	w := serve(SetTxtHandler(cfg), http.MethodPost, "/set_txt", "api-token", `{"domain":"example.com","key":"_acme-challenge","value":"secret-value"}`)
+	if w.Code != http.StatusInternalServerError || strings.TrimSpace(w.Body.String()) != "Failed to set TXT record" {
+		t.Errorf("set_txt = %d %q", w.Code, w.Body.String())
+	}
+	if got := cfg.Challenges.Outstanding(); len(got) != 0 {
+		t.Errorf("failed change was tracked as a challenge: %+v", got)
+	}
+	entries, _ := cfg.History.Query("", "", 10)
+	if len(entries) != 1 || entries[0].Outcome != history.OutcomeError || strings.Contains(entries[0].Summary, "secret") {
+		t.Errorf("history = %+v", entries)
+	}
+
+	b.err = &UnsupportedError{Op: "replace mode", Provider: "rfc2136"}
+	w = serve(SetTxtHandler(cfg), http.MethodPost, "/set_txt", "api-token", `{"domain":"example.com","key":"k","value":"v","mode":"replace"}`)
+	if w.Code != http.StatusNotImplemented || !strings.Contains(w.Body.String(), "rfc2136") {
+		t.Errorf("unsupported set_txt = %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestSetTxtHandlerDryRun(t *testing.T) {
+	b := &fakeBackend{output: "Would add \"v\" to _acme-challenge.example.com"}
+	cfg := recordsConfig(t, b)
+	cfg.Quotas = quota.New(map[string]quota.Limits{quota.OpMutation: {Hourly: 1}})
+	h := SetTxtHandler(cfg)
+
+	for i := 0; i < 2; i++ {
+		w := serve(h, http.MethodPost, "/set_txt?dry_run=true", "api-token", `{"domain":"example.com","key":"_acme-challenge","value":"v"}`)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Would add") {
+			t.Fatalf("dry run %d = %d %q", i, w.Code, w.Body.String())
+		}
+	}
+	if got := cfg.Challenges.Outstanding(); len(got) != 0 {
+		t.Errorf("dry run was tracked as a challenge: %+v", got)
+	}
+	// Dry runs left the hourly budget of one mutation untouched
+	if w := serve(h, http.MethodPost, "/set_txt", "api-token", `{"domain":"example.com","key":"_acme-challenge","value":"v"}`); w.Code != http.StatusOK {
+		t.Errorf("set_txt after dry runs = %d", w.Code)
+	}
+}
+
+func TestMutationQuota(t *testing.T) {
+	b := &fakeBackend{}
+	cfg := recordsConfig(t, b)
+	cfg.Quotas = quota.New(map[string]quota.Limits{quota.OpMutation: {Hourly: 1}})
+	body := `{"domain":"example.com","key":"_acme-challenge","value":"v"}`
+
+	if w := serve(SetTxtHandler(cfg), http.MethodPost, "/set_txt", "api-token", body); w.Code != http.StatusOK {
+		t.Fatalf("first set_txt = %d", w.Code)
+	}
+	w := serve(DeleteTxtHandler(cfg), http.MethodDelete, "/delete_txt", "api-token", body)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("delete_txt over quota = %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if len(b.calls) != 1 {
+		t.Errorf("backend calls = %q", b.calls)
+	}
+}
+
+func TestDeleteTxtHandler(t *testing.T) {
+	b := &fakeBackend{}
+	cfg := recordsConfig(t, b)
+	cfg.Challenges.Add(challenges.Record{Domain: "web.example", Key: "_acme-challenge", Value: "v"})
+	h := DeleteTxtHandler(cfg)
+
+	if w := serve(h, http.MethodGet, "/delete_txt", "web-token", ""); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "DELETE, POST" {
+		t.Errorf("GET = %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+	w := serve(h, http.MethodDelete, "/delete_txt", "web-token", `{"domain":"web.example","key":"_acme-challenge","value":"v"}`)
+	if w.Code != http.StatusOK || w.Body.String() != "TXT record deleted" {
+		t.Fatalf("delete_txt = %d %q", w.Code, w.Body.String())
+	}
+	if len(b.calls) != 1 || b.calls[0] != "delete _acme-challenge.web.example v" {
+		t.Errorf("backend calls = %q", b.calls)
+	}
+	if got := cfg.Challenges.Outstanding(); len(got) != 0 {
+		t.Errorf("deleted challenge still outstanding: %+v", got)
+	}
+
+	b.err = errors.New("provider timeout")
+	w = serve(h, http.MethodPost, "/delete_txt", "web-token", `{"domain":"web.example","key":"_acme-challenge","value":"v"}`)
+	if w.Code != http.StatusInternalServerError || strings.TrimSpace(w.Body.String()) != "Failed to delete TXT record" {
+		t.Errorf("failed delete_txt = %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestACMEChallengeOnly(t *testing.T) {
+	b := &fakeBackend{}
+	cfg := recordsConfig(t, b)
+	cfg.ACMEChallengeOnly = true
+
+	if w := serve(SetTxtHandler(cfg), http.MethodPost, "/set_txt", "api-token", `{"domain":"example.com","key":"www","value":"v"}`); w.Code != http.StatusForbidden {
+		t.Errorf("set_txt of www = %d, want 403", w.Code)
+	}
+	if w := serve(SSHFPHandler(cfg), http.MethodPost, "/v1/sshfp", "api-token", `{"host":"example.com","keys":["ssh-ed25519 AAAA"]}`); w.Code != http.StatusForbidden {
+		t.Errorf("sshfp = %d, want 403", w.Code)
+	}
+	if w := serve(SetTxtHandler(cfg), http.MethodPost, "/set_txt", "api-token", `{"domain":"example.com","key":"_acme-challenge.www","value":"v"}`); w.Code != http.StatusOK {
+		t.Errorf("set_txt of _acme-challenge.www = %d, want 200", w.Code)
+	}
+	if len(b.calls) != 1 {
+		t.Errorf("backend calls = %q", b.calls)
+	}
+}
+
+func TestSSHFPHandler(t *testing.T) {
+	b := &fakeBackend{output: "Added SSHFP 4 2 abcd for host.web.example"}
+	cfg := recordsConfig(t, b)
+	h := SSHFPHandler(cfg)
+
+	for _, tc := range []struct {
+		name, body string
+		want       int
+	}{
+		{"no keys", `{"host":"host.web.example"}`, http.StatusBadRequest},
+		{"other domain", `{"host":"host.example.com","keys":["ssh-ed25519 AAAA"]}`, http.StatusForbidden},
+	} {
+		if w := serve(h, http.MethodPost, "/v1/sshfp", "web-token", tc.body); w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+	w := serve(h, http.MethodPost, "/v1/sshfp", "web-token", `{"host":"Host.Web.Example.","keys":["ssh-ed25519 AAAA"]}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Added SSHFP") {
+		t.Fatalf("sshfp = %d %q", w.Code, w.Body.String())
+	}
+	if len(b.calls) != 1 || b.calls[0] != "sshfp host.web.example" {
+		t.Errorf("backend calls = %q", b.calls)
+	}
+
+	b.err = &UnsupportedError{Op: "SSHFP publishing", Provider: "cloudflare"}
+	w = serve(h, http.MethodPost, "/v1/sshfp", "web-token", `{"host":"host.web.example","keys":["ssh-ed25519 AAAA"]}`)
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("unsupported sshfp = %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestBackendErrorsStayInTheLog(t *testing.T) {
+	for _, tc := range []struct {
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{errors.New("fetchzone: TXT _acme-challenge.example.com \"secret\""), http.StatusInternalServerError, "Failed to set TXT record"},
+		{&UnsupportedError{Op: "replace mode", Provider: "rfc2136"}, http.StatusNotImplemented, "replace mode is not supported by the rfc2136 DNS provider"},
+	} {
+		rec := httptest.NewRecorder()
+		writeBackendError(rec, tc.err, "Failed to set TXT record", http.StatusInternalServerError)
+		if rec.Code != tc.wantStatus || strings.TrimSpace(rec.Body.String()) != tc.wantBody {
+			t.Errorf("%v: %d %q, want %d %q", tc.err, rec.Code, rec.Body.String(), tc.wantStatus, tc.wantBody)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// bundleName is the reserved path below /certs/ that serves every domain at once.
+const bundleName = "bundle.tar.gz"
+
+// buildBundle returns a gzipped tar archive containing {domain}/{file} for
+// every served file of every domain directory in baseDir that include
+// accepts. Symlinks (as used by certbot's live directory) are followed and
+// stored as regular files.
+func buildBundle(baseDir string, include func(domain string) bool) ([]byte, int, error) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	files := make([]string, 0, len(allowedCertFiles))
+	for name := range allowedCertFiles {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+
+	domains := 0
+	for _, entry := range entries {
+		domainDir := filepath.Join(baseDir, entry.Name())
+		if info, err := os.Stat(domainDir); err != nil || !info.IsDir() || !include(entry.Name()) {
+			continue
+		}
+		added := false
+		for _, name := range files {
+			path := filepath.Join(domainDir, name)
+			data, err := os.ReadFile(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			mode := int64(0644)
+			if name == "privkey.pem" {
+				mode = 0600
+			}
+			hdr := &tar.Header{
+				Name:    entry.Name() + "/" + name,
+				Mode:    mode,
+				Size:    int64(len(data)),
+				ModTime: modTime(path),
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return nil, 0, err
+			}
+			if _, err := tw.Write(data); err != nil {
+				return nil, 0, err
+			}
+			added = true
+		}
+		if added {
+			domains++
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), domains, nil
+}
+
+func modTime(path string) time.Time {
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime()
+	}
+	return time.Now()
+}
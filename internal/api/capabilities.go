@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// Capabilities describes what this instance supports, so generic clients
+// can adapt without per-host configuration.
+type Capabilities struct {
+	Providers   []string        `json:"providers"`
+	CertFormats []string        `json:"cert_formats"`
+	CertFiles   []string        `json:"cert_files"`
+	SetTxtModes []string        `json:"set_txt_modes"`
+	Features    map[string]bool `json:"features"`
+}
+
+// CertFiles returns the names of the files served under /certs/{domain}/.
+func CertFiles() []string {
+	files := make([]string, 0, len(allowedCertFiles))
+	for name := range allowedCertFiles {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// CapabilitiesHandler serves caps as JSON on GET /v1/capabilities. It needs
+// no authentication: the response only contains feature flags.
+func CapabilitiesHandler(caps Capabilities) http.HandlerFunc {
+	body, _ := json.MarshalIndent(caps, "", "  ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
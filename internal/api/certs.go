@@ -1,12 +1,22 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
 	"log"
-	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/fcrdns"
+	"acme-dns-tools/internal/logging"
+	"acme-dns-tools/internal/metrics"
+	"acme-dns-tools/internal/quota"
+	"acme-dns-tools/internal/signing"
+	"acme-dns-tools/internal/usage"
 )
 
 // allowedCertFiles lists the only file names that may be served.
@@ -18,42 +28,80 @@ var allowedCertFiles = map[string]bool{
 	"chain.pem":     true,
 }
 
+var certServes = metrics.NewCounterVec("dns_proxy_cert_serves_total",
+	"Certificate files served, by domain and file (.sig files included; bundles count as domain \"*\").", "domain", "file")
+
+// CertsConfig configures CertsHandler.
+type CertsConfig struct {
+	// Settings provide the bearer token, the FCrDNS allowlist and the
+	// per-host access map; they may be replaced at runtime.
+	Settings *LiveSettings
+	// BaseDir holds one directory per domain (typically /etc/letsencrypt/live).
+	// Clients of a tenant with a CERT_DIR use that instead.
+	BaseDir string
+	// Quotas, if non-nil, counts every download against the token's
+	// cert_download budget and rejects with 429 once it is exhausted.
+	Quotas *quota.Limiter
+	// Signer, if non-nil, serves a detached minisign signature for every
+	// file at /certs/{domain}/{file}.sig.
+	Signer *signing.Signer
+	// Usage, if non-nil, meters downloads and bytes served per token.
+	Usage *usage.Tracker
+	// FCrDNSCache, if non-nil, briefly remembers failed verifications.
+	FCrDNSCache *fcrdns.NegativeCache
+	// ClientAuth is CertAuthFCrDNS (the default when empty), CertAuthMTLS
+	// or CertAuthBoth.
+	ClientAuth string
+}
+
 // CertsHandler returns an http.HandlerFunc that serves certificate files from
-// certsBaseDir (typically /etc/letsencrypt/live) under the path
+// cfg.BaseDir (typically /etc/letsencrypt/live) under the path
 //
 //	GET /certs/{domain}/{file}
+//	GET /certs/{domain}/pins (SPKI pins and fingerprints, JSON)
+//	GET /certs/{domain}/meta (serial, SANs, validity, fullchain hash, JSON)
+//	GET /certs/{domain}/bundle.tar.gz (every file of the domain)
+//	GET /certs/bundle.tar.gz (every domain at once)
+//
+// Files, bundles and metadata carry an ETag; a matching If-None-Match gets
+// 304 Not Modified without counting against quotas. Files and bundles also
+// honor Range (with If-Range), so broken downloads can be resumed; each
+// request counts as a download.
 //
 // Authentication:
-//   - Bearer token check (Authorization: Bearer <token>)
+//   - Bearer token check (Authorization: Bearer <token>): CERT_BEARER_TOKEN
+//     or a client token granted "certs", possibly for some domains only
 //   - Forward-Confirmed Reverse DNS (FCrDNS) allowlist:
 //     client IP → PTR → A/AAAA → confirm original IP is present AND
-//     the resolved hostname is in dnsAllowlist.
-func CertsHandler(bearerToken string, dnsAllowlist []string, certsBaseDir string) http.HandlerFunc {
+//     the resolved hostname is in the DNS allowlist.
+//   - and/or, per cfg.ClientAuth, a client certificate signed by
+//     CERT_CLIENT_CA, whose name then stands in for the host name.
+func CertsHandler(cfg CertsConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		settings := cfg.Settings.Load()
 
 		// --- Bearer token ---
-		if r.Header.Get("Authorization") != "Bearer "+bearerToken {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		client := settings.Authorize(w, r, OpCerts, "certs")
+		if client == nil {
 			return
 		}
+		baseDir := cfg.baseDir(client)
 
-		// --- FCrDNS allowlist ---
-		clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			log.Printf("certs: cannot parse RemoteAddr %q: %v", r.RemoteAddr, err)
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
-		if !isAllowedByFCrDNS(clientIP, dnsAllowlist) {
-			log.Printf("certs: denied request from %s – not in DNS allowlist", clientIP)
-			http.Error(w, "Forbidden", http.StatusForbidden)
+		// --- FCrDNS allowlist and/or client certificate ---
+		clientIP, hostname, ok := identifyHost(w, r, cfg, settings)
+		if !ok {
 			return
 		}
 
 		// --- Parse /certs/{domain}/{file} ---
-		// http.ServeMux strips the registered prefix but we registered "/certs/",
-		// so r.URL.Path still contains the full path.
+		// http.ServeMux does not strip the registered "/certs/" prefix, so
+		// r.URL.Path still contains it (any BASE_PATH has been stripped already).
 		trimmed := strings.TrimPrefix(r.URL.Path, "/certs/")
+		if trimmed == bundleName {
+			logging.Annotate(r, "file", bundleName)
+			serveBundle(w, r, cfg, settings, client, clientIP, hostname)
+			return
+		}
 		parts := strings.SplitN(trimmed, "/", 2)
 		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
 			http.Error(w, "Bad Request – expected /certs/{domain}/{file}", http.StatusBadRequest)
@@ -62,22 +110,85 @@ func CertsHandler(bearerToken string, dnsAllowlist []string, certsBaseDir string
 		domain := parts[0]
 		fileName := parts[1]
 
+		// A ".sig" suffix requests the detached signature of the file
+		wantSignature := false
+		if cfg.Signer != nil && strings.HasSuffix(fileName, ".sig") {
+			wantSignature = true
+			fileName = strings.TrimSuffix(fileName, ".sig")
+		}
+
 		// --- Validate domain (no path traversal) ---
 		if strings.Contains(domain, "..") || strings.Contains(domain, "/") || strings.Contains(domain, "\\") {
 			http.Error(w, "Bad Request", http.StatusBadRequest)
 			return
 		}
+		// Certificate directories are named after the canonical domain
+		domain = dnsname.Canonical(domain)
+		if domain == "" || strings.HasPrefix(domain, ".") {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		logging.Annotate(r, "domain", domain, "file", parts[1])
+
+		// --- Client token scope ---
+		if !client.AllowsDomain(domain) {
+			log.Printf("certs: denied %s to client %s (%s) – not among its domains", domain, client.Name, client.ID())
+			metrics.AuthDenials.Inc("certs", metrics.ReasonScope)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// --- Pins (public data; not charged against quotas) ---
+		if fileName == pinsName && !wantSignature {
+			if !settings.CertAccess.allows(hostname, baseDir, domain) {
+				metrics.AuthDenials.Inc("certs", metrics.ReasonScope)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			servePins(w, filepath.Join(baseDir, domain), domain)
+			return
+		}
+
+		// --- Metadata (public data; not charged against quotas) ---
+		if fileName == metaName && !wantSignature {
+			if !settings.CertAccess.allows(hostname, baseDir, domain) {
+				metrics.AuthDenials.Inc("certs", metrics.ReasonScope)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			serveMeta(w, r, filepath.Join(baseDir, domain), domain)
+			return
+		}
 
 		// --- Validate file name (allowlist only) ---
-		if !allowedCertFiles[fileName] {
+		domainBundle := fileName == bundleName && !wantSignature
+		if !allowedCertFiles[fileName] && !domainBundle {
 			http.Error(w, "Not Found", http.StatusNotFound)
 			return
 		}
 
-		// --- Read file ---
+		// --- Per-host access map ---
+		if !settings.CertAccess.allows(hostname, baseDir, domain) {
+			log.Printf("certs: denied %s to %s (%s) – not among the names it serves", domain, hostname, clientIP)
+			metrics.AuthDenials.Inc("certs", metrics.ReasonScope)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// --- Read file, or archive every file of the domain ---
 		// filepath.Join is safe here because domain and fileName are already validated.
-		certPath := filepath.Join(certsBaseDir, domain, fileName)
-		data, err := os.ReadFile(certPath)
+		certPath := filepath.Join(baseDir, domain, fileName)
+		var data []byte
+		var err error
+		if domainBundle {
+			var domains int
+			data, domains, err = buildBundle(baseDir, func(d string) bool { return d == domain })
+			if err == nil && domains == 0 {
+				err = os.ErrNotExist
+			}
+		} else {
+			data, err = os.ReadFile(certPath)
+		}
 		if err != nil {
 			if os.IsNotExist(err) {
 				http.Error(w, "Not Found", http.StatusNotFound)
@@ -88,50 +199,98 @@ func CertsHandler(bearerToken string, dnsAllowlist []string, certsBaseDir string
 			return
 		}
 
+		// --- Unchanged since the client's copy (not charged against quotas) ---
+		if !wantSignature && notModified(w, r, etagOf(data)) {
+			return
+		}
+
+		// --- Quota ---
+		if cfg.Quotas != nil && !wantSignature {
+			if ok, retryAfter := client.AllowQuota(cfg.Quotas, quota.OpCertDownload); !ok {
+				log.Printf("certs: download quota exhausted for token %s (%s)", client.ID(), clientIP)
+				quota.WriteExceeded(w, retryAfter)
+				return
+			}
+		}
+
+		if wantSignature {
+			log.Printf("certs: served signature of %s to %s", certPath, clientIP)
+			certServes.Inc(domain, fileName+".sig")
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			w.Write(cfg.Signer.Sign(data, fileName))
+			return
+		}
+
 		log.Printf("certs: served %s to %s", certPath, clientIP)
-		w.Header().Set("Content-Type", "application/x-pem-file")
-		w.WriteHeader(http.StatusOK)
-		w.Write(data)
+		Audit(client, r, "cert_download", domain+"/"+fileName, "ok")
+		certServes.Inc(domain, fileName)
+		cfg.Usage.Record(client.ID(), quota.OpCertDownload, domain, len(data))
+		if domainBundle {
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Header().Set("Content-Disposition", `attachment; filename="`+domain+`.tar.gz"`)
+		} else {
+			w.Header().Set("Content-Type", "application/x-pem-file")
+		}
+		// Answers Range requests too, so clients can resume a broken
+		// download; If-Range against the ETag keeps them from mixing versions
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
 	}
 }
 
-// isAllowedByFCrDNS performs Forward-Confirmed Reverse DNS verification:
-//  1. Reverse lookup: clientIP → PTR record(s) → hostname(s)
-//  2. For each hostname in the DNS allowlist: forward lookup → IPs
-//  3. Allow only if the original clientIP appears in the forward IPs.
-//
-// This prevents spoofing via arbitrary PTR records: the admin must also control
-// the forward (A/AAAA) DNS for the allowed hostname.
-func isAllowedByFCrDNS(clientIP string, allowlist []string) bool {
-	if len(allowlist) == 0 {
-		return false
+// serveBundle sends the archive of every domain hostname may fetch. A bundle
+// counts as a single download against the quota.
+func serveBundle(w http.ResponseWriter, r *http.Request, cfg CertsConfig, settings *Settings, client *Client, clientIP, hostname string) {
+	baseDir := cfg.baseDir(client)
+	data, domains, err := buildBundle(baseDir, func(domain string) bool {
+		return client.AllowsDomain(domain) && settings.CertAccess.allows(hostname, baseDir, domain)
+	})
+	if err != nil {
+		log.Printf("certs: failed to build bundle: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if notModified(w, r, etagOf(data)) {
+		return
 	}
 
-	// Reverse lookup
-	ptrs, err := net.LookupAddr(clientIP)
-	if err != nil || len(ptrs) == 0 {
-		return false
+	if cfg.Quotas != nil {
+		if ok, retryAfter := client.AllowQuota(cfg.Quotas, quota.OpCertDownload); !ok {
+			log.Printf("certs: download quota exhausted for token %s (%s)", client.ID(), clientIP)
+			quota.WriteExceeded(w, retryAfter)
+			return
+		}
 	}
 
-	for _, ptr := range ptrs {
-		// net.LookupAddr returns FQDNs with a trailing dot
-		hostname := strings.TrimSuffix(ptr, ".")
+	log.Printf("certs: served bundle of %d domains to %s", domains, clientIP)
+	Audit(client, r, "cert_download", bundleName, "ok")
+	certServes.Inc("*", bundleName)
+	cfg.Usage.Record(client.ID(), quota.OpCertDownload, "", len(data))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+bundleName+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// baseDir returns the certificate directory of client's tenant, if it has
+// one, or cfg.BaseDir.
+func (cfg CertsConfig) baseDir(client *Client) string {
+	if client.Tenant != nil && client.Tenant.CertDir != "" {
+		return client.Tenant.CertDir
+	}
+	return cfg.BaseDir
+}
 
-		for _, allowed := range allowlist {
-			if !strings.EqualFold(hostname, allowed) {
-				continue
-			}
-			// Forward-confirm: resolve the allowed hostname → check clientIP is present
-			addrs, err := net.LookupHost(hostname)
-			if err != nil {
-				continue
-			}
-			for _, addr := range addrs {
-				if addr == clientIP {
-					return true
-				}
-			}
+func servePins(w http.ResponseWriter, dir, domain string) {
+	pins, err := pinsFor(dir, domain)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+		} else {
+			log.Printf("certs: failed to compute pins for %s: %v", domain, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
+		return
 	}
-	return false
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pins)
 }
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"acme-dns-tools/internal/netmatch"
+	"acme-dns-tools/internal/quota"
+	"acme-dns-tools/internal/signing"
+)
+
+func testSigner(t *testing.T) *signing.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := signing.LoadSigner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestCertsServesSignatures(t *testing.T) {
+	baseDir := t.TempDir()
+	os.MkdirAll(filepath.Join(baseDir, "example.com"), 0755)
+	os.WriteFile(filepath.Join(baseDir, "example.com", "fullchain.pem"), []byte("chain"), 0644)
+
+	// httptest requests come from 192.0.2.1
+	allow, err := netmatch.Parse("192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := CertsConfig{
+		Settings: NewLiveSettings(Settings{CertBearerToken: "cert-token", IPAllowlist: allow}),
+		BaseDir:  baseDir,
+		Quotas:   quota.New(map[string]quota.Limits{quota.OpCertDownload: {Hourly: 1}}),
+		Signer:   testSigner(t),
+	}
+	h := CertsHandler(cfg)
+
+	w := serve(h, http.MethodGet, "/certs/example.com/fullchain.pem.sig", "cert-token", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "\tfile:fullchain.pem\n") {
+		t.Fatalf("signature = %d %q", w.Code, w.Body.String())
+	}
+	if w := serve(h, http.MethodGet, "/certs/example.com/privkey.pem.sig", "cert-token", ""); w.Code != http.StatusNotFound {
+		t.Errorf("signature of a missing file = %d, want 404", w.Code)
+	}
+	if w := serve(h, http.MethodGet, "/certs/example.com/fullchain.pem.sig", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("signature without a token = %d, want 401", w.Code)
+	}
+
+	// Signatures leave the download budget of one file untouched
+	if w := serve(h, http.MethodGet, "/certs/example.com/fullchain.pem", "cert-token", ""); w.Code != http.StatusOK || w.Body.String() != "chain" {
+		t.Errorf("file after signatures = %d %q", w.Code, w.Body.String())
+	}
+
+	// Without a signing key, .sig is just an unknown file
+	cfg.Signer = nil
+	if w := serve(CertsHandler(cfg), http.MethodGet, "/certs/example.com/fullchain.pem.sig", "cert-token", ""); w.Code == http.StatusOK {
+		t.Errorf("signature without a signing key = %d", w.Code)
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"acme-dns-tools/internal/dnscheck"
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/logging"
+	"acme-dns-tools/internal/metrics"
+)
+
+// CheckTxtRequest is the body of POST /check_txt.
+type CheckTxtRequest struct {
+	Domain    string `json:"domain"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Resolvers bool   `json:"resolvers"` // also query the large public resolvers
+}
+
+// CheckTxtResponse is the answer of POST /check_txt.
+type CheckTxtResponse struct {
+	Name       string           `json:"name"`
+	Propagated bool             `json:"propagated"`
+	Servers    []CheckTxtServer `json:"servers"`
+}
+
+// CheckTxtServer is what one server answered on the last attempt.
+type CheckTxtServer struct {
+	Name   string   `json:"name"`
+	Found  bool     `json:"found"`
+	Values []string `json:"values"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// CheckTxtConfig bundles the dependencies of the /check_txt handler.
+type CheckTxtConfig struct {
+	Settings *LiveSettings
+	// Timeout bounds the wait; a shorter client deadline wins.
+	Timeout time.Duration
+	// Interval is the pause between checks.
+	Interval time.Duration
+}
+
+// CheckTxtHandler waits until every authoritative server of the zone (and,
+// if requested, every public resolver) returns a TXT value, so hooks can
+// call it between /set_txt and asking the CA to validate. It answers 200
+// once the value has propagated and 504 with the last answers otherwise.
+// Tokens granted set_txt may use it.
+func CheckTxtHandler(cfg CheckTxtConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := cfg.Settings.Load().Authorize(w, r, OpSetTxt, "check_txt")
+		if client == nil {
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req CheckTxtRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || req.Domain == "" || req.Key == "" || req.Value == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Domain, req.Key = dnsname.Record(req.Domain, req.Key)
+		logging.Annotate(r, "domain", req.Domain, "key", req.Key)
+		if !client.AllowsDomain(req.Domain) {
+			log.Printf("check_txt: refused %s to client %s (%s) – not among its domains", req.Domain, client.Name, client.ID())
+			metrics.AuthDenials.Inc("check_txt", metrics.ReasonScope)
+			http.Error(w, "Forbidden – token may not check this domain", http.StatusForbidden)
+			return
+		}
+		name := req.Key + "." + req.Domain
+
+		ctx, cancel, err := RequestContext(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+		ctx, cancelWait := context.WithTimeout(ctx, cfg.Timeout)
+		defer cancelWait()
+		// Nothing changes here, so stop polling once the client hangs up
+		stop := context.AfterFunc(r.Context(), cancelWait)
+		defer stop()
+
+		servers, err := dnscheck.Authoritative(name)
+		if err != nil {
+			log.Printf("check_txt: %v", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if req.Resolvers {
+			servers = append(servers, dnscheck.PublicResolvers...)
+		}
+
+		results, err := dnscheck.Wait(ctx, name, req.Value, servers, cfg.Interval)
+		resp := CheckTxtResponse{Name: name, Propagated: err == nil, Servers: make([]CheckTxtServer, 0, len(results))}
+		for _, res := range results {
+			srv := CheckTxtServer{Name: res.Server.Name, Found: res.Found, Values: res.Values}
+			if res.Err != nil {
+				srv.Error = res.Err.Error()
+			}
+			resp.Servers = append(resp.Servers, srv)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			log.Printf("check_txt: %s not visible from every server after waiting", name)
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"acme-dns-tools/internal/logging"
+	"acme-dns-tools/internal/metrics"
+)
+
+// Ways CertsHandler identifies the host behind a request (CERT_CLIENT_AUTH).
+const (
+	// CertAuthFCrDNS checks the client IP against CERT_IP_ALLOWLIST or its
+	// reverse DNS against CERT_DNS_ALLOWLIST.
+	CertAuthFCrDNS = "fcrdns"
+	// CertAuthMTLS takes the host name from a client certificate signed by
+	// CERT_CLIENT_CA: its first DNS SAN, or else its common name.
+	CertAuthMTLS = "mtls"
+	// CertAuthBoth requires both, with a certificate issued for the host
+	// name FCrDNS verified.
+	CertAuthBoth = "both"
+)
+
+// LoadClientCAs reads the PEM certificates that sign client certificates.
+func LoadClientCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", path)
+	}
+	return pool, nil
+}
+
+// certIdentity returns the host name a client certificate stands for.
+func certIdentity(cert *x509.Certificate) string {
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return cert.Subject.CommonName
+}
+
+// identifyHost establishes the host name behind r for the access map, as
+// configured by cfg.ClientAuth. Addresses in CERT_IP_ALLOWLIST pass without
+// FCrDNS and use the address as host name. If it cannot, it answers 403 and returns
+// false.
+func identifyHost(w http.ResponseWriter, r *http.Request, cfg CertsConfig, settings *Settings) (clientIP, hostname string, ok bool) {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		log.Printf("certs: cannot parse RemoteAddr %q: %v", r.RemoteAddr, err)
+		metrics.AuthDenials.Inc("certs", metrics.ReasonFCrDNS)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", "", false
+	}
+
+	switch {
+	case cfg.ClientAuth == CertAuthMTLS:
+		// The certificate below names the host
+	case settings.IPAllowlist.Contains(clientIP):
+		// Hosts on a VPN often have no PTR records; the address stands in
+		// for the host name
+		hostname = clientIP
+		logging.Annotate(r, "ip_allowlist", true)
+	case len(settings.DNSAllowlist) == 0:
+		// fcrdns treats a nil allowlist as "every host name"
+		log.Printf("certs: denied request from %s – not in IP allowlist", clientIP)
+		metrics.AuthDenials.Inc("certs", metrics.ReasonIPAllowlist)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", "", false
+	default:
+		res := cfg.FCrDNSCache.Verify(clientIP, settings.DNSAllowlist)
+		if !res.Allowed() {
+			log.Printf("certs: denied request from %s – not in IP or DNS allowlist", clientIP)
+			logging.Debugf("certs: FCrDNS for %s: PTR error %v, checks %+v", clientIP, res.PTRErr, res.Checks)
+			metrics.AuthDenials.Inc("certs", metrics.ReasonFCrDNS)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return "", "", false
+		}
+		hostname = res.Hostname
+		logging.Annotate(r, "ptr", hostname)
+	}
+	if cfg.ClientAuth != CertAuthMTLS && cfg.ClientAuth != CertAuthBoth {
+		return clientIP, hostname, true
+	}
+
+	// The TLS handshake has verified any certificate presented against
+	// CERT_CLIENT_CA; requests without one only reach the other endpoints
+	var cert *x509.Certificate
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cert = r.TLS.VerifiedChains[0][0]
+	}
+	var denied error
+	switch {
+	case cert == nil:
+		denied = errors.New("no client certificate")
+	case cfg.ClientAuth == CertAuthBoth && cert.VerifyHostname(hostname) != nil:
+		denied = fmt.Errorf("client certificate %q not issued for %s", certIdentity(cert), hostname)
+	}
+	if denied != nil {
+		log.Printf("certs: denied request from %s – %v", clientIP, denied)
+		metrics.AuthDenials.Inc("certs", metrics.ReasonClientCert)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", "", false
+	}
+	logging.Annotate(r, "client_cert", certIdentity(cert))
+	if cfg.ClientAuth == CertAuthMTLS {
+		hostname = certIdentity(cert)
+	}
+	return clientIP, hostname, true
+}
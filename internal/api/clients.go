@@ -0,0 +1,221 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"acme-dns-tools/internal/bearer"
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/logging"
+	"acme-dns-tools/internal/metrics"
+	"acme-dns-tools/internal/ratelimit"
+)
+
+// Operations a client credential can be granted.
+const (
+	OpSetTxt    = "set_txt"
+	OpDeleteTxt = "delete_txt"
+	OpSSHFP     = "sshfp"
+	OpCerts     = "certs"
+)
+
+var knownOperations = map[string]bool{OpSetTxt: true, OpDeleteTxt: true, OpSSHFP: true, OpCerts: true}
+
+// Client is a bearer token together with what it may do. The shared
+// DNS_RESOLVER_API_TOKEN and CERT_BEARER_TOKEN act as clients named "api"
+// and "certs" without domain restrictions; further clients come from
+//
+//	CLIENT.<name>.TOKEN=<token>
+//	CLIENT.<name>.OPERATIONS=set_txt,delete_txt,sshfp,certs   (default set_txt,delete_txt)
+//	CLIENT.<name>.DOMAINS=example.com,shop.example.org          (default: every domain)
+//	CLIENT.<name>.TENANT=customer                               (see Tenant)
+type Client struct {
+	Name       string
+	Token      string
+	Operations []string
+	// Domains limits the client to these names and their subdomains; empty
+	// means every domain.
+	Domains []string
+	// Tenant, if set, confines the client to the tenant's domains.
+	Tenant *Tenant
+}
+
+// ID returns the TokenID of the client's token.
+func (c *Client) ID() string {
+	return TokenID(c.Token)
+}
+
+// Can reports whether the client was granted op.
+func (c *Client) Can(op string) bool {
+	for _, o := range c.Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsDomain reports whether the client may act on domain.
+func (c *Client) AllowsDomain(domain string) bool {
+	if c.Tenant != nil && !c.Tenant.owns(domain) {
+		return false
+	}
+	if len(c.Domains) == 0 {
+		return true
+	}
+	for _, d := range c.Domains {
+		if dnsname.IsSubdomain(domain, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Client returns the client whose token r presents (or that a compatibility
+// endpoint authenticated), or nil.
+func (s *Settings) Client(r *http.Request) *Client {
+	if c, ok := r.Context().Value(compatClientKey{}).(*Client); ok {
+		return c
+	}
+	token := bearer.Token(r)
+	if token == "" {
+		return nil
+	}
+	switch {
+	case bearer.Equal(token, s.APIToken):
+		return &Client{Name: "api", Token: token, Operations: []string{OpSetTxt, OpDeleteTxt, OpSSHFP}}
+	case bearer.Equal(token, s.CertBearerToken):
+		return &Client{Name: "certs", Token: token, Operations: []string{OpCerts}}
+	}
+	for i := range s.Clients {
+		if bearer.Equal(token, s.Clients[i].Token) {
+			return &s.Clients[i]
+		}
+	}
+	return nil
+}
+
+// Authorize returns the client of r if it may perform op. Otherwise it
+// answers 401 (unknown token) or 403 (operation not granted), counts the
+// denial for endpoint and returns nil.
+func (s *Settings) Authorize(w http.ResponseWriter, r *http.Request, op, endpoint string) *Client {
+	client := s.Client(r)
+	if client == nil {
+		if token := bearer.Token(r); token != "" {
+			logging.Annotate(r, "token", TokenID(token))
+		}
+		metrics.AuthDenials.Inc(endpoint, metrics.ReasonBearer)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+	ratelimit.Authenticated(r)
+	logging.Annotate(r, "token", client.ID(), "client", client.Name)
+	if !client.Can(op) {
+		log.Printf("%s: refused client %s (%s) – %s not granted", endpoint, client.Name, client.ID(), op)
+		metrics.AuthDenials.Inc(endpoint, metrics.ReasonScope)
+		http.Error(w, "Forbidden – token may not use this operation", http.StatusForbidden)
+		return nil
+	}
+	return client
+}
+
+// ParseClients reads the CLIENT.<name>.* entries of cfg, sorted by name.
+// CLIENT.<name>.TENANT refers to one of tenants.
+func ParseClients(cfg map[string]string, tenants []Tenant) ([]Client, error) {
+	byName := make(map[string]*Client)
+	for k, v := range cfg {
+		rest, ok := strings.CutPrefix(k, "CLIENT.")
+		if !ok {
+			continue
+		}
+		i := strings.LastIndex(rest, ".")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s: expected CLIENT.<name>.TOKEN, OPERATIONS, DOMAINS or TENANT", k)
+		}
+		name, field := rest[:i], rest[i+1:]
+		c := byName[name]
+		if c == nil {
+			c = &Client{Name: name, Operations: []string{OpSetTxt, OpDeleteTxt}}
+			byName[name] = c
+		}
+		switch field {
+		case "TOKEN":
+			c.Token = v
+		case "OPERATIONS":
+			c.Operations = splitList(v)
+			for _, op := range c.Operations {
+				if !knownOperations[op] {
+					return nil, fmt.Errorf("%s: unknown operation %q", k, op)
+				}
+			}
+		case "DOMAINS":
+			c.Domains = nil
+			for _, d := range splitList(v) {
+				c.Domains = append(c.Domains, dnsname.Canonical(d))
+			}
+		case "TENANT":
+			c.Tenant = nil
+			for i := range tenants {
+				if tenants[i].Name == v {
+					c.Tenant = &tenants[i]
+				}
+			}
+			if c.Tenant == nil {
+				return nil, fmt.Errorf("%s: unknown tenant %q", k, v)
+			}
+		default:
+			return nil, fmt.Errorf("%s: expected CLIENT.<name>.TOKEN, OPERATIONS, DOMAINS or TENANT", k)
+		}
+	}
+
+	clients := make([]Client, 0, len(byName))
+	tokens := make(map[string]string)
+	for name, c := range byName {
+		if c.Token == "" {
+			return nil, fmt.Errorf("CLIENT.%s.TOKEN not set", name)
+		}
+		if other, dup := tokens[c.Token]; dup {
+			return nil, fmt.Errorf("clients %s and %s share a token", other, name)
+		}
+		tokens[c.Token] = name
+		// DOMAINS can narrow a tenant's client down, not widen it
+		if c.Tenant != nil {
+			for _, d := range c.Domains {
+				if !c.Tenant.owns(d) {
+					return nil, fmt.Errorf("CLIENT.%s.DOMAINS: %s is not a domain of tenant %s", name, d, c.Tenant.Name)
+				}
+			}
+		}
+		clients = append(clients, *c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Name < clients[j].Name })
+	return clients, nil
+}
+
+// Audit logs who performed an action, for every mutation and download.
+func Audit(client *Client, r *http.Request, action, target, outcome string) {
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	args := []any{"client", client.Name, "token", client.ID(), "client_ip", addr, "action", action, "target", target, "outcome", outcome}
+	if client.Tenant != nil {
+		args = append(args, "tenant", client.Tenant.Name)
+	}
+	slog.Info("audit", args...)
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// The compatibility endpoints (lego httpreq, acme-dns) authenticate callers
+// in their own format and then hand a translated request to the regular
+// /set_txt and /delete_txt handlers, so scope checks, ACME_CHALLENGE_ONLY,
+// quotas, history, auditing and challenge cleanup apply unchanged.
+
+type compatClientKey struct{}
+
+// withClient returns r carrying a client a compatibility endpoint has
+// already authenticated; Settings.Client returns it instead of looking at
+// the bearer token.
+func withClient(r *http.Request, c *Client) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), compatClientKey{}, c))
+}
+
+// forward replays r against next as method with body as JSON. The query
+// string is dropped, so a compatibility request is never a dry run.
+func forward(w http.ResponseWriter, r *http.Request, next http.Handler, method string, body any) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fr := r.Clone(r.Context())
+	fr.Method = method
+	fr.URL.RawQuery = ""
+	fr.Body = io.NopCloser(bytes.NewReader(b))
+	fr.ContentLength = int64(len(b))
+	fr.Header.Set("Content-Type", "application/json")
+	next.ServeHTTP(w, fr)
+}
+
+// recorder buffers a forwarded response so the caller can answer in its own
+// format.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: make(http.Header)}
+}
+
+func (rec *recorder) Header() http.Header { return rec.header }
+
+func (rec *recorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(p)
+}
+
+func (rec *recorder) ok() bool {
+	return rec.status == 0 || rec.status/100 == 2
+}
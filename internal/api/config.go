@@ -0,0 +1,90 @@
+package api
+
+import (
+	"time"
+
+	"acme-dns-tools/internal/config"
+	"acme-dns-tools/internal/server"
+)
+
+// Config holds every key of dns-proxy-api.conf besides the CLIENT.*,
+// TENANT.* and CERT_ACCESS.* families, with its type and default; durations
+// are in seconds. Startup fails listing all keys that are missing or
+// malformed; a reloaded file with problems is ignored.
+type Config struct {
+	// Tokens and allowlists (reloaded on change and on SIGHUP)
+	APIToken        string   `config:"DNS_RESOLVER_API_TOKEN,required" env:"API_KEY"`
+	CertBearerToken string   `config:"CERT_BEARER_TOKEN,required"`
+	CertDNSAllow    []string `config:"CERT_DNS_ALLOWLIST"`
+	CertIPAllow     []string `config:"CERT_IP_ALLOWLIST"`
+
+	// Listener
+	server.Config
+	TLSCert        string   `config:"TLS_CERT"`
+	TLSKey         string   `config:"TLS_KEY"`
+	BasePath       string   `config:"BASE_PATH"`
+	TrustedProxies []string `config:"TRUSTED_PROXIES"`
+
+	// Logging and metrics
+	LogFormat      string        `config:"LOG_FORMAT" oneof:"text,json"`
+	LogLevel       string        `config:"LOG_LEVEL" oneof:"info,debug"`
+	MetricsEnabled bool          `config:"METRICS_ENABLED"`
+	MetricsListen  string        `config:"METRICS_LISTEN"`
+	StatsDAddr     string        `config:"STATSD_ADDR"`
+	StatsDPrefix   string        `config:"STATSD_PREFIX"`
+	StatsDFlush    time.Duration `config:"STATSD_FLUSH_INTERVAL,positive" default:"10"`
+	PprofEnabled   bool          `config:"PPROF_ENABLED"`
+
+	// DNS changes
+	ExecCLI                 bool          `config:"EXEC_CLI"`
+	ValidateCredentials     bool          `config:"VALIDATE_CREDENTIALS" default:"true"`
+	ACMEChallengeOnly       bool          `config:"ACME_CHALLENGE_ONLY"`
+	PropagationTimeout      time.Duration `config:"PROPAGATION_TIMEOUT,positive" default:"120"`
+	PropagationInterval     time.Duration `config:"PROPAGATION_INTERVAL,positive" default:"5"`
+	CleanupTimeout          time.Duration `config:"CLEANUP_TIMEOUT" default:"30"`
+	HistoryFile             string        `config:"HISTORY_FILE"`
+	HTTPReqEnabled          bool          `config:"HTTPREQ_ENABLED"`
+	ACMEDNSZone             string        `config:"ACMEDNS_ZONE"`
+	ACMEDNSAccountsFile     string        `config:"ACMEDNS_ACCOUNTS_FILE" default:"/var/lib/acme-dns-tools/acmedns-accounts.json"`
+	ACMEDNSOpenRegistration bool          `config:"ACMEDNS_OPEN_REGISTRATION"`
+
+	// Certificate serving
+	CertBaseDir            string        `config:"CERT_BASE_DIR" default:"/etc/letsencrypt/live"`
+	CertBaseDirWritable    bool          `config:"CERT_BASE_DIR_WRITABLE"`
+	CertClientCA           string        `config:"CERT_CLIENT_CA"`
+	CertClientAuth         string        `config:"CERT_CLIENT_AUTH" oneof:"fcrdns,mtls,both"`
+	CertSigningKey         string        `config:"CERT_SIGNING_KEY"`
+	CertAuditLog           string        `config:"CERT_AUDIT_LOG"`
+	CertAuditLogMaxMB      int           `config:"CERT_AUDIT_LOG_MAX_MB,positive" default:"10"`
+	CertAuditLogKeep       int           `config:"CERT_AUDIT_LOG_KEEP" default:"5"`
+	FCrDNSTimeout          time.Duration `config:"FCRDNS_TIMEOUT,positive"`
+	FCrDNSNegativeCacheTTL time.Duration `config:"FCRDNS_NEGATIVE_CACHE_TTL" default:"30"`
+	CTMonitorDomains       []string      `config:"CT_MONITOR_DOMAINS"`
+	CTMonitorInterval      time.Duration `config:"CT_MONITOR_INTERVAL,positive" default:"3600"`
+	CTMonitorWebhook       string        `config:"CT_MONITOR_WEBHOOK"`
+
+	// Health, maintenance and abuse protection
+	DiskMinFreeMB            int           `config:"DISK_MIN_FREE_MB" default:"100"`
+	HealthCheckInterval      time.Duration `config:"HEALTH_CHECK_INTERVAL,positive" default:"60"`
+	AdminToken               string        `config:"ADMIN_API_TOKEN"`
+	MaintenanceMode          bool          `config:"MAINTENANCE_MODE"`
+	MaintenanceRetryAfter    time.Duration `config:"MAINTENANCE_RETRY_AFTER,positive" default:"300"`
+	QuotaMutationsHourly     int           `config:"QUOTA_MUTATIONS_HOURLY"`
+	QuotaMutationsDaily      int           `config:"QUOTA_MUTATIONS_DAILY"`
+	QuotaCertDownloadsHourly int           `config:"QUOTA_CERT_DOWNLOADS_HOURLY"`
+	QuotaCertDownloadsDaily  int           `config:"QUOTA_CERT_DOWNLOADS_DAILY"`
+	RateLimitRPS             float64       `config:"RATE_LIMIT_RPS"`
+	RateLimitBurst           int           `config:"RATE_LIMIT_BURST,positive"`
+	AuthLockoutFailures      int           `config:"AUTH_LOCKOUT_FAILURES"`
+	AuthLockout              time.Duration `config:"AUTH_LOCKOUT_SECONDS,positive" default:"60"`
+}
+
+// Schema checks dns-proxy-api.conf against Config.
+var Schema = config.SchemaOf(Config{})
+
+// DecodeConfig returns the typed view of a file Schema has checked.
+func DecodeConfig(v config.Values) Config {
+	var c Config
+	config.Decode(v, &c)
+	return c
+}
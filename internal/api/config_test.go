@@ -0,0 +1,22 @@
+package api
+
+import (
+	"slices"
+	"testing"
+
+	"acme-dns-tools/internal/logging"
+)
+
+// The allowed values in Config's tags must follow the constants they copy.
+func TestSchemaOneOf(t *testing.T) {
+	want := map[string][]string{
+		"LOG_FORMAT":       {logging.FormatText, logging.FormatJSON},
+		"LOG_LEVEL":        {logging.LevelInfo, logging.LevelDebug},
+		"CERT_CLIENT_AUTH": {CertAuthFCrDNS, CertAuthMTLS, CertAuthBoth},
+	}
+	for _, f := range Schema {
+		if w, ok := want[f.Key]; ok && !slices.Equal(f.OneOf, w) {
+			t.Errorf("%s allows %v, want %v", f.Key, f.OneOf, w)
+		}
+	}
+}
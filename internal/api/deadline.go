@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// deadlineMargin is kept back from the client's deadline so the response
+// still reaches it before it gives up.
+const deadlineMargin = 500 * time.Millisecond
+
+// RequestContext returns a context bounded by the deadline the client sent,
+// either as X-Request-Deadline (an RFC 3339 time) or as X-Request-Timeout
+// (seconds). Without either header the context has no deadline. It is not
+// derived from the request context: a client that hangs up must not abort a
+// zone edit half-way.
+func RequestContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	var deadline time.Time
+	if raw := r.Header.Get("X-Request-Deadline"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("X-Request-Deadline must be an RFC 3339 time, got %q", raw)
+		}
+		deadline = t
+	} else if raw := r.Header.Get("X-Request-Timeout"); raw != "" {
+		secs, err := strconv.ParseFloat(raw, 64)
+		if err != nil || secs <= 0 {
+			return nil, nil, fmt.Errorf("X-Request-Timeout must be a positive number of seconds, got %q", raw)
+		}
+		deadline = time.Now().Add(time.Duration(secs * float64(time.Second)))
+	}
+
+	if deadline.IsZero() {
+		ctx, cancel := context.WithCancel(context.Background())
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline.Add(-deadlineMargin))
+	return ctx, cancel, nil
+}
+
+// WriteDeadlineExceeded tells the client that its deadline expired while
+// the operation was running. The outcome is unknown rather than failed, so
+// the client knows to check (or retry an idempotent request) instead of
+// assuming nothing changed.
+func WriteDeadlineExceeded(w http.ResponseWriter, operation string) {
+	http.Error(w, "Gateway Timeout – request deadline exceeded while "+operation+"; the change may or may not have been applied", http.StatusGatewayTimeout)
+}
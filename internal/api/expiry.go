@@ -0,0 +1,46 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"acme-dns-tools/internal/metrics"
+)
+
+var certExpiryDays = metrics.NewGaugeVec("dns_proxy_cert_expiry_days",
+	"Days until the certificate of each domain in CERT_BASE_DIR expires (negative once expired).", "domain")
+
+var expiry struct {
+	mu      sync.Mutex
+	domains map[string]bool // series currently exported
+}
+
+// UpdateCertExpiry sets dns_proxy_cert_expiry_days for every domain
+// directory in baseDir and drops the series of domains that are gone.
+func UpdateCertExpiry(baseDir string) error {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		leaf, err := leafCertificate(filepath.Join(baseDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		seen[entry.Name()] = true
+		certExpiryDays.Set(time.Until(leaf.NotAfter).Hours()/24, entry.Name())
+	}
+
+	expiry.mu.Lock()
+	defer expiry.mu.Unlock()
+	for domain := range expiry.domains {
+		if !seen[domain] {
+			certExpiryDays.Delete(domain)
+		}
+	}
+	expiry.domains = seen
+	return nil
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"acme-dns-tools/internal/dnsname"
+)
+
+// HTTPReqRequest is the body lego's httpreq provider posts to /present and
+// /cleanup. By default it names the record and its value; with
+// HTTPREQ_MODE=RAW it carries the domain and key authorization instead.
+type HTTPReqRequest struct {
+	FQDN    string `json:"fqdn"`
+	Value   string `json:"value"`
+	Domain  string `json:"domain"`
+	Token   string `json:"token"`
+	KeyAuth string `json:"keyAuth"`
+}
+
+// HTTPReqHandler serves lego's httpreq contract (HTTPREQ_ENDPOINT set to
+// <api>/httpreq) by passing the record on to next, the /set_txt handler for
+// /present and the /delete_txt handler for /cleanup. The API token or a
+// client token is accepted as bearer token or as the HTTP basic auth
+// password (HTTPREQ_PASSWORD); the user name is ignored.
+func HTTPReqHandler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req HTTPReqRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		fqdn, value := req.FQDN, req.Value
+		if fqdn == "" && req.KeyAuth != "" {
+			// RAW mode: the value is the DNS-01 digest of the key authorization
+			sum := sha256.Sum256([]byte(req.KeyAuth))
+			fqdn, value = dnsname.ChallengeLabel+"."+req.Domain, base64.RawURLEncoding.EncodeToString(sum[:])
+		}
+		domain, key := dnsname.Record(fqdn, "")
+		if key == "" {
+			// A name lego reached by following a CNAME need not start with
+			// the challenge label; its first label becomes the key
+			key, domain, _ = strings.Cut(domain, ".")
+		}
+
+		r = r.Clone(r.Context())
+		if _, password, ok := r.BasicAuth(); ok {
+			r.Header.Set("Authorization", "Bearer "+password)
+		}
+		forward(w, r, next, http.MethodPost, DeleteTxtRequest{Domain: domain, Key: key, Value: value})
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPReqHandler(t *testing.T) {
+	backend := &fakeBackend{}
+	cfg := recordsConfig(t, backend)
+	present := HTTPReqHandler(SetTxtHandler(cfg))
+	cleanup := HTTPReqHandler(DeleteTxtHandler(cfg))
+	post := func(h http.Handler, password, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/httpreq/present?dry_run=true", strings.NewReader(body))
+		if password != "" {
+			r.SetBasicAuth("lego", password)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	sum := sha256.Sum256([]byte("token.thumbprint"))
+	digest := base64.RawURLEncoding.EncodeToString(sum[:])
+	for _, tc := range []struct {
+		name     string
+		h        http.Handler
+		password string
+		body     string
+		want     string
+	}{
+		{"default mode", present, "api-token", `{"fqdn": "_acme-challenge.Example.com.", "value": "v1"}`,
+			"set _acme-challenge.example.com v1 append"},
+		{"raw mode", present, "api-token", `{"domain": "example.com", "token": "token", "keyAuth": "token.thumbprint"}`,
+			"set _acme-challenge.example.com " + digest + " append"},
+		{"name reached by CNAME", present, "web-token", `{"fqdn": "abc123.web.example.", "value": "v2"}`,
+			"set abc123.web.example v2 append"},
+		{"cleanup", cleanup, "api-token", `{"fqdn": "_acme-challenge.example.com.", "value": "v1"}`,
+			"delete _acme-challenge.example.com v1"},
+	} {
+		backend.calls = nil
+		if w := post(tc.h, tc.password, tc.body); w.Code != http.StatusOK {
+			t.Errorf("%s: status %d %q", tc.name, w.Code, w.Body.String())
+			continue
+		}
+		// The query string is dropped, so ?dry_run=true did not turn it into a dry run
+		if len(backend.calls) != 1 || backend.calls[0] != tc.want {
+			t.Errorf("%s: backend calls = %q, want %q", tc.name, backend.calls, tc.want)
+		}
+	}
+
+	backend.calls = nil
+	for _, tc := range []struct {
+		name, password, body string
+		want                 int
+	}{
+		{"no credentials", "", `{"fqdn": "_acme-challenge.example.com.", "value": "v"}`, http.StatusUnauthorized},
+		{"wrong password", "guess", `{"fqdn": "_acme-challenge.example.com.", "value": "v"}`, http.StatusUnauthorized},
+		{"other domain", "web-token", `{"fqdn": "_acme-challenge.example.com.", "value": "v"}`, http.StatusForbidden},
+		{"bad JSON", "api-token", `{"fqdn":`, http.StatusBadRequest},
+		{"no value", "api-token", `{"fqdn": "_acme-challenge.example.com."}`, http.StatusBadRequest},
+	} {
+		if w := post(present, tc.password, tc.body); w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+	if len(backend.calls) != 0 {
+		t.Errorf("refused requests reached the backend: %q", backend.calls)
+	}
+
+	w := serve(present, http.MethodGet, "/httpreq/present", "api-token", "")
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("GET = %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"acme-dns-tools/internal/bearer"
+	"acme-dns-tools/internal/metrics"
+)
+
+// KeyAuthRequest is the body of POST /v1/keyauth.
+type KeyAuthRequest struct {
+	Token      string `json:"token"`      // challenge token from the CA
+	Thumbprint string `json:"thumbprint"` // RFC 7638 JWK thumbprint of the account key, base64url
+}
+
+// KeyAuthResponse is the answer of POST /v1/keyauth.
+type KeyAuthResponse struct {
+	KeyAuthorization string `json:"key_authorization"` // token.thumbprint
+	Value            string `json:"value"`             // TXT value for _acme-challenge
+}
+
+// DNS01Value returns the TXT record value for a DNS-01 challenge (RFC 8555
+// section 8.4): base64url(SHA-256(token "." thumbprint)) without padding.
+func DNS01Value(token, thumbprint string) string {
+	sum := sha256.Sum256([]byte(token + "." + thumbprint))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// KeyAuthHandler computes DNS-01 record values for clients that only have
+// the challenge token and the account key thumbprint, so hook scripts do not
+// each have to reimplement the hashing. token returns the current API token.
+func KeyAuthHandler(token func() string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !bearer.Matches(r, token()) {
+			metrics.AuthDenials.Inc("keyauth", metrics.ReasonBearer)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req KeyAuthRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || !isBase64URL(req.Token) || !isBase64URL(req.Thumbprint) {
+			http.Error(w, "Invalid request body – token and thumbprint must be base64url", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(KeyAuthResponse{
+			KeyAuthorization: req.Token + "." + req.Thumbprint,
+			Value:            DNS01Value(req.Token, req.Thumbprint),
+		})
+	}
+}
+
+// isBase64URL reports whether s is non-empty unpadded base64url text, which
+// both ACME tokens and JWK thumbprints are.
+func isBase64URL(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// The token of the RFC 8555 examples and the thumbprint of RFC 7638 section 3.1
+const (
+	testChallengeToken = "evaGxfADs6pSRb2LAv9IZf17Dt3juxGJ-PCt92wr-oA"
+	testThumbprint     = "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+	testDNS01Value     = "ZTRx1Ckl1-tM05o5zaizTTA0yUy5AGereMgSNWC6Ll8"
+)
+
+func TestDNS01Value(t *testing.T) {
+	if got := DNS01Value(testChallengeToken, testThumbprint); got != testDNS01Value {
+		t.Errorf("DNS01Value = %q, want %q", got, testDNS01Value)
+	}
+}
+
+func TestKeyAuthHandler(t *testing.T) {
+	token := "api-token"
+	h := KeyAuthHandler(func() string { return token })
+
+	w := serve(h, http.MethodPost, "/v1/keyauth", "api-token", `{"token": "`+testChallengeToken+`", "thumbprint": "`+testThumbprint+`"}`)
+	var resp KeyAuthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("keyauth = %d %q", w.Code, w.Body.String())
+	}
+	if resp.KeyAuthorization != testChallengeToken+"."+testThumbprint || resp.Value != testDNS01Value {
+		t.Errorf("keyauth = %+v", resp)
+	}
+
+	for _, tc := range []struct {
+		name, method, token, body string
+		want                      int
+	}{
+		{"no token", http.MethodPost, "", `{"token": "a", "thumbprint": "b"}`, http.StatusUnauthorized},
+		{"GET", http.MethodGet, "api-token", "", http.StatusMethodNotAllowed},
+		{"bad JSON", http.MethodPost, "api-token", `{"token":`, http.StatusBadRequest},
+		{"missing thumbprint", http.MethodPost, "api-token", `{"token": "a"}`, http.StatusBadRequest},
+		{"padded token", http.MethodPost, "api-token", `{"token": "YQ==", "thumbprint": "b"}`, http.StatusBadRequest},
+		{"dot in thumbprint", http.MethodPost, "api-token", `{"token": "a", "thumbprint": "b.c"}`, http.StatusBadRequest},
+	} {
+		if w := serve(h, tc.method, "/v1/keyauth", tc.token, tc.body); w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+
+	// The token is looked up per request, so a reloaded one applies at once
+	token = "rotated"
+	if w := serve(h, http.MethodPost, "/v1/keyauth", "api-token", `{"token": "a", "thumbprint": "b"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("old token after rotation = %d, want 401", w.Code)
+	}
+	if w := serve(h, http.MethodPost, "/v1/keyauth", "rotated", `{"token": "a", "thumbprint": "b"}`); w.Code != http.StatusOK {
+		t.Errorf("new token after rotation = %d, want 200", w.Code)
+	}
+}
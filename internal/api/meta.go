@@ -0,0 +1,99 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// metaName is the reserved file name below /certs/{domain}/ for metadata.
+const metaName = "meta"
+
+// CertMeta is the answer of GET /certs/{domain}/meta. Pollers compare
+// FullchainSHA256 (or send the ETag back) to learn whether anything changed
+// without downloading the private key.
+type CertMeta struct {
+	Domain          string    `json:"domain"`
+	Serial          string    `json:"serial"`
+	SANs            []string  `json:"sans"`
+	NotBefore       time.Time `json:"not_before"`
+	NotAfter        time.Time `json:"not_after"`
+	FullchainSHA256 string    `json:"fullchain_sha256"`
+	Files           []string  `json:"files"` // served files present, sorted
+}
+
+// metaFor describes the certificate in a domain directory.
+func metaFor(dir, domain string) (CertMeta, error) {
+	meta := CertMeta{Domain: domain}
+	cert, err := leafCertificate(dir)
+	if err != nil {
+		return meta, err
+	}
+	meta.Serial = strings.ToUpper(cert.SerialNumber.Text(16))
+	meta.SANs = cert.DNSNames
+	meta.NotBefore = cert.NotBefore.UTC()
+	meta.NotAfter = cert.NotAfter.UTC()
+
+	if data, err := os.ReadFile(filepath.Join(dir, "fullchain.pem")); err == nil {
+		sum := sha256.Sum256(data)
+		meta.FullchainSHA256 = hex.EncodeToString(sum[:])
+	}
+	meta.Files = []string{}
+	for name := range allowedCertFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			meta.Files = append(meta.Files, name)
+		}
+	}
+	sort.Strings(meta.Files)
+	return meta, nil
+}
+
+func serveMeta(w http.ResponseWriter, r *http.Request, dir, domain string) {
+	meta, err := metaFor(dir, domain)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+		} else {
+			log.Printf("certs: failed to read the certificate of %s: %v", domain, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if notModified(w, r, etagOf(data)) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
+
+// etagOf returns a strong ETag for a response body.
+func etagOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified sets the ETag header and, if r's If-None-Match lists it,
+// answers 304 Not Modified and returns true.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// pinsName is the reserved file name below /certs/{domain}/ for pins.
+const pinsName = "pins"
+
+// stagedCertFile, if present in a domain directory, holds the certificate
+// (or chain) that will replace the current one, so clients can add its pin
+// before the rotation.
+const stagedCertFile = "next.pem"
+
+// CertPins describes one certificate for pinning clients.
+type CertPins struct {
+	Subject           string    `json:"subject"`
+	Serial            string    `json:"serial"`
+	NotBefore         time.Time `json:"not_before"`
+	NotAfter          time.Time `json:"not_after"`
+	SPKISHA256        string    `json:"spki_sha256"`        // base64, as in pin-sha256="..."
+	FingerprintSHA256 string    `json:"fingerprint_sha256"` // hex of the DER certificate
+}
+
+// PinsResponse is the answer of GET /certs/{domain}/pins. Current and Next
+// list the leaf first, followed by the intermediates of the chain.
+type PinsResponse struct {
+	Domain  string     `json:"domain"`
+	Current []CertPins `json:"current"`
+	Next    []CertPins `json:"next,omitempty"`
+}
+
+// pinsFor computes the pins of the certificates in a domain directory.
+func pinsFor(dir, domain string) (PinsResponse, error) {
+	resp := PinsResponse{Domain: domain}
+
+	current, err := chainPins(filepath.Join(dir, "fullchain.pem"))
+	if err != nil {
+		return resp, err
+	}
+	resp.Current = current
+
+	next, err := chainPins(filepath.Join(dir, stagedCertFile))
+	if err != nil && !os.IsNotExist(err) {
+		return resp, err
+	}
+	resp.Next = next
+	return resp, nil
+}
+
+func chainPins(path string) ([]CertPins, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pins []CertPins
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		fp := sha256.Sum256(cert.Raw)
+		pins = append(pins, CertPins{
+			Subject:           cert.Subject.String(),
+			Serial:            strings.ToUpper(cert.SerialNumber.Text(16)),
+			NotBefore:         cert.NotBefore.UTC(),
+			NotAfter:          cert.NotAfter.UTC(),
+			SPKISHA256:        base64.StdEncoding.EncodeToString(spki[:]),
+			FingerprintSHA256: hex.EncodeToString(fp[:]),
+		})
+	}
+	if len(pins) == 0 {
+		return nil, errors.New("no certificate found in " + filepath.Base(path))
+	}
+	return pins, nil
+}
@@ -0,0 +1,16 @@
+package api
+
+import (
+	"strings"
+
+	"acme-dns-tools/internal/dnsname"
+)
+
+// IsACMEChallengeKey reports whether a TXT record key names an ACME challenge
+// record, i.e. is "_acme-challenge" or starts with "_acme-challenge.".
+// Mutation endpoints use it to refuse touching any other record when the
+// ACME_CHALLENGE_ONLY policy is enabled.
+func IsACMEChallengeKey(key string) bool {
+	key = dnsname.Canonical(key)
+	return key == dnsname.ChallengeLabel || strings.HasPrefix(key, dnsname.ChallengeLabel+".")
+}
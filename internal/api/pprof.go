@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"acme-dns-tools/internal/bearer"
+	"acme-dns-tools/internal/metrics"
+)
+
+// PprofHandler serves the net/http/pprof profiles below /admin/debug/pprof/
+// for holders of the admin token, so a long-running instance can be profiled
+// in place (e.g. go tool pprof -http=: with an Authorization header).
+func PprofHandler(adminToken string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !bearer.Matches(r, adminToken) {
+			metrics.AuthDenials.Inc("admin_pprof", metrics.ReasonBearer)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// pprof.Index expects its profiles directly below /debug/pprof/
+		r2 := new(http.Request)
+		*r2 = *r
+		u := *r.URL
+		u.Path = strings.TrimPrefix(r.URL.Path, "/admin")
+		r2.URL = &u
+		mux.ServeHTTP(w, r2)
+	})
+}
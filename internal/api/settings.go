@@ -0,0 +1,59 @@
+package api
+
+import (
+	"sync/atomic"
+
+	"acme-dns-tools/internal/netmatch"
+)
+
+// Settings are the parts of the configuration that may change while the
+// server runs: credentials and the certificate allowlists.
+type Settings struct {
+	// APIToken authenticates the DNS management endpoints.
+	APIToken string
+	// CertBearerToken must be presented to /certs/.
+	CertBearerToken string
+	// DNSAllowlist lists the hostnames allowed to fetch certs (FCrDNS).
+	DNSAllowlist []string
+	// IPAllowlist lists addresses allowed to fetch certs without FCrDNS.
+	IPAllowlist netmatch.List
+	// CertAccess, if non-empty, limits each verified host to the
+	// certificates covering the names it serves.
+	CertAccess CertAccess
+	// Clients are additional tokens restricted to some operations and
+	// domains.
+	Clients []Client
+	// Tenants group clients of separate customers; see Tenant.
+	Tenants []Tenant
+	// AdminToken authenticates the /admin/ endpoints.
+	AdminToken string
+}
+
+// LiveSettings holds the current Settings. Handlers load them once per
+// request, so a reload never mixes old and new values within one request.
+type LiveSettings struct {
+	p atomic.Pointer[Settings]
+}
+
+// NewLiveSettings returns a holder initialized with s.
+func NewLiveSettings(s Settings) *LiveSettings {
+	l := &LiveSettings{}
+	l.Store(s)
+	return l
+}
+
+// Load returns the current settings.
+func (l *LiveSettings) Load() *Settings {
+	return l.p.Load()
+}
+
+// Store replaces the settings.
+func (l *LiveSettings) Store(s Settings) {
+	l.p.Store(&s)
+}
+
+// APIToken returns the current DNS management token; handy as a
+// func() string for handlers outside this package.
+func (l *LiveSettings) APIToken() string {
+	return l.Load().APIToken
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"acme-dns-tools/internal/bearer"
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/quota"
+)
+
+// Tenant groups the client tokens of one customer of a shared instance.
+// Its clients only act on the tenant's zones, fetch certificates from its
+// certificate directory, share its quotas and only see its entries in the
+// record history:
+//
+//	TENANT.<name>.DOMAINS=customer.com,customer.net      (required; tenants may not overlap)
+//	TENANT.<name>.CERT_DIR=/srv/certs/customer/live       (default: CERT_BASE_DIR)
+//	TENANT.<name>.QUOTA_MUTATIONS_HOURLY=100              (and _DAILY, QUOTA_CERT_DOWNLOADS_*)
+//	CLIENT.<client>.TENANT=<name>
+type Tenant struct {
+	Name    string
+	Domains []string
+	// CertDir replaces CERT_BASE_DIR for the tenant's clients if set.
+	CertDir string
+	// Quotas are budgets shared by all clients of the tenant, on top of
+	// the per-token ones.
+	Quotas map[string]quota.Limits
+}
+
+// owns reports whether domain is one of the tenant's domains or below one.
+func (t *Tenant) owns(domain string) bool {
+	for _, d := range t.Domains {
+		if dnsname.IsSubdomain(domain, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantQuotaKeys maps the TENANT.<name>.QUOTA_* fields to their budgets.
+var tenantQuotaKeys = map[string]struct {
+	op    string
+	daily bool
+}{
+	"QUOTA_MUTATIONS_HOURLY":      {quota.OpMutation, false},
+	"QUOTA_MUTATIONS_DAILY":       {quota.OpMutation, true},
+	"QUOTA_CERT_DOWNLOADS_HOURLY": {quota.OpCertDownload, false},
+	"QUOTA_CERT_DOWNLOADS_DAILY":  {quota.OpCertDownload, true},
+}
+
+// ParseTenants reads the TENANT.<name>.* entries of cfg, sorted by name.
+func ParseTenants(cfg map[string]string) ([]Tenant, error) {
+	byName := make(map[string]*Tenant)
+	for k, v := range cfg {
+		rest, ok := strings.CutPrefix(k, "TENANT.")
+		if !ok {
+			continue
+		}
+		i := strings.LastIndex(rest, ".")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s: expected TENANT.<name>.DOMAINS, CERT_DIR or QUOTA_*", k)
+		}
+		name, field := rest[:i], rest[i+1:]
+		t := byName[name]
+		if t == nil {
+			t = &Tenant{Name: name, Quotas: make(map[string]quota.Limits)}
+			byName[name] = t
+		}
+		switch q, isQuota := tenantQuotaKeys[field]; {
+		case field == "DOMAINS":
+			t.Domains = nil
+			for _, d := range splitList(v) {
+				t.Domains = append(t.Domains, dnsname.Canonical(d))
+			}
+		case field == "CERT_DIR":
+			t.CertDir = v
+		case isQuota:
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s must be a non-negative integer, got %q", k, v)
+			}
+			lim := t.Quotas[q.op]
+			if q.daily {
+				lim.Daily = n
+			} else {
+				lim.Hourly = n
+			}
+			t.Quotas[q.op] = lim
+		default:
+			return nil, fmt.Errorf("%s: expected TENANT.<name>.DOMAINS, CERT_DIR or QUOTA_*", k)
+		}
+	}
+
+	tenants := make([]Tenant, 0, len(byName))
+	for name, t := range byName {
+		if len(t.Domains) == 0 {
+			return nil, fmt.Errorf("TENANT.%s.DOMAINS not set", name)
+		}
+		tenants = append(tenants, *t)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Name < tenants[j].Name })
+
+	// A name belongs to at most one tenant, or its records and
+	// certificates would be visible to both
+	for i := range tenants {
+		for j := i + 1; j < len(tenants); j++ {
+			for _, a := range tenants[i].Domains {
+				for _, b := range tenants[j].Domains {
+					if dnsname.IsSubdomain(a, b) || dnsname.IsSubdomain(b, a) {
+						return nil, fmt.Errorf("tenants %s and %s overlap (%s and %s)", tenants[i].Name, tenants[j].Name, a, b)
+					}
+				}
+			}
+		}
+	}
+	return tenants, nil
+}
+
+// TenantName returns the name of the client's tenant, or "".
+func (c *Client) TenantName() string {
+	if c.Tenant == nil {
+		return ""
+	}
+	return c.Tenant.Name
+}
+
+// AllowQuota consumes one unit of op from the client's token quota and, for
+// a tenant's client, from the budget the tenant's clients share. When either
+// is exhausted it returns false and the time until it resets.
+func (c *Client) AllowQuota(q *quota.Limiter, op string) (bool, time.Duration) {
+	if c.Tenant != nil {
+		if ok, retryAfter := q.AllowWithin("tenant:"+c.Tenant.Name, op, c.Tenant.Quotas[op]); !ok {
+			return false, retryAfter
+		}
+	}
+	return q.Allow(c.ID(), op)
+}
+
+// HistoryAccess authorizes reading the record history: DNS_RESOLVER_API_TOKEN
+// sees every entry, the client token of a tenant only the tenant's. It
+// returns the tenant to filter by ("" for all).
+func (l *LiveSettings) HistoryAccess(r *http.Request) (tenant string, ok bool) {
+	s := l.Load()
+	if bearer.Matches(r, s.APIToken) {
+		return "", true
+	}
+	if c := s.Client(r); c != nil && c.Tenant != nil {
+		return c.Tenant.Name, true
+	}
+	return "", false
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acme-dns-tools/internal/quota"
+)
+
+func tenantConfig() map[string]string {
+	return map[string]string{
+		"TENANT.one.DOMAINS":               "one.example, One.Example.net.",
+		"TENANT.one.CERT_DIR":              "/srv/certs/one",
+		"TENANT.one.QUOTA_MUTATIONS_DAILY": "2",
+		"TENANT.two.DOMAINS":               "two.example",
+		"CLIENT.one-a.TOKEN":               "token-one-a",
+		"CLIENT.one-a.TENANT":              "one",
+		"CLIENT.one-b.TOKEN":               "token-one-b",
+		"CLIENT.one-b.TENANT":              "one",
+		"CLIENT.one-b.DOMAINS":             "www.one.example",
+		"CLIENT.two.TOKEN":                 "token-two",
+		"CLIENT.two.TENANT":                "two",
+		"CLIENT.ops.TOKEN":                 "token-ops",
+	}
+}
+
+func TestParseTenants(t *testing.T) {
+	tenants, err := ParseTenants(tenantConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tenants) != 2 || tenants[0].Name != "one" || tenants[1].Name != "two" {
+		t.Fatalf("tenants = %+v, want one and two", tenants)
+	}
+	one := tenants[0]
+	if strings.Join(one.Domains, ",") != "one.example,one.example.net" {
+		t.Errorf("one.Domains = %v", one.Domains)
+	}
+	if one.CertDir != "/srv/certs/one" || one.Quotas[quota.OpMutation] != (quota.Limits{Daily: 2}) {
+		t.Errorf("one = %+v", one)
+	}
+
+	for name, cfg := range map[string]map[string]string{
+		"overlap":       {"TENANT.a.DOMAINS": "example.com", "TENANT.b.DOMAINS": "shop.example.com"},
+		"no domains":    {"TENANT.a.CERT_DIR": "/srv/a"},
+		"bad quota":     {"TENANT.a.DOMAINS": "example.com", "TENANT.a.QUOTA_MUTATIONS_DAILY": "-1"},
+		"unknown field": {"TENANT.a.DOMAINS": "example.com", "TENANT.a.TOKEN": "x"},
+	} {
+		if _, err := ParseTenants(cfg); err == nil {
+			t.Errorf("%s: ParseTenants succeeded", name)
+		}
+	}
+	// Names sharing a suffix without a label boundary do not overlap
+	if _, err := ParseTenants(map[string]string{"TENANT.a.DOMAINS": "example.com", "TENANT.b.DOMAINS": "badexample.com"}); err != nil {
+		t.Errorf("example.com and badexample.com: %v", err)
+	}
+}
+
+func TestTenantClientsStayInTheirDomains(t *testing.T) {
+	cfg := tenantConfig()
+	tenants, err := ParseTenants(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := ParseClients(cfg, tenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]*Client)
+	for i := range clients {
+		byName[clients[i].Name] = &clients[i]
+	}
+
+	for _, tc := range []struct {
+		client, domain string
+		want           bool
+	}{
+		{"one-a", "one.example", true},
+		{"one-a", "www.one.example.net", true},
+		{"one-a", "two.example", false},
+		{"one-b", "www.one.example", true},
+		{"one-b", "mail.one.example", false},
+		{"two", "one.example", false},
+		{"ops", "two.example", true},
+	} {
+		if got := byName[tc.client].AllowsDomain(tc.domain); got != tc.want {
+			t.Errorf("%s.AllowsDomain(%s) = %v, want %v", tc.client, tc.domain, got, tc.want)
+		}
+	}
+	if got := byName["one-a"].TenantName(); got != "one" {
+		t.Errorf("one-a tenant = %q, want one", got)
+	}
+
+	for name, extra := range map[string]map[string]string{
+		"unknown tenant":  {"CLIENT.x.TOKEN": "x", "CLIENT.x.TENANT": "three"},
+		"domains outside": {"CLIENT.x.TOKEN": "x", "CLIENT.x.TENANT": "one", "CLIENT.x.DOMAINS": "two.example"},
+	} {
+		for k, v := range cfg {
+			extra[k] = v
+		}
+		if _, err := ParseClients(extra, tenants); err == nil {
+			t.Errorf("%s: ParseClients succeeded", name)
+		}
+	}
+}
+
+func TestTenantQuotaIsShared(t *testing.T) {
+	cfg := tenantConfig()
+	tenants, _ := ParseTenants(cfg)
+	clients, err := ParseClients(cfg, tenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := quota.New(map[string]quota.Limits{})
+	oneA, oneB, ops := &clients[0], &clients[1], &clients[2]
+	// one-a and one-b share tenant one's daily budget of two
+	for i, tc := range []struct {
+		client *Client
+		want   bool
+	}{{oneA, true}, {oneB, true}, {oneA, false}} {
+		if ok, _ := tc.client.AllowQuota(q, quota.OpMutation); ok != tc.want {
+			t.Errorf("mutation %d by %s allowed = %v, want %v", i+1, tc.client.Name, ok, tc.want)
+		}
+	}
+	if ok, _ := ops.AllowQuota(q, quota.OpMutation); !ok {
+		t.Error("client ops is limited by a tenant it does not belong to")
+	}
+}
+
+func TestHistoryAccess(t *testing.T) {
+	cfg := tenantConfig()
+	tenants, _ := ParseTenants(cfg)
+	clients, err := ParseClients(cfg, tenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := NewLiveSettings(Settings{APIToken: "api-token", CertBearerToken: "cert-token", Clients: clients, Tenants: tenants})
+
+	for _, tc := range []struct {
+		token, tenant string
+		ok            bool
+	}{
+		{"api-token", "", true},
+		{"token-two", "two", true},
+		{"token-ops", "", false},
+		{"cert-token", "", false},
+		{"wrong", "", false},
+	} {
+		r := httptest.NewRequest("GET", "/v1/records/history", nil)
+		r.Header.Set("Authorization", "Bearer "+tc.token)
+		tenant, ok := live.HistoryAccess(r)
+		if tenant != tc.tenant || ok != tc.ok {
+			t.Errorf("HistoryAccess(%s) = %q, %v; want %q, %v", tc.token, tenant, ok, tc.tenant, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// TokenID returns a short, non-reversible identifier for a bearer token,
+// suitable for metric labels, quota keys and logs.
+func TokenID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,31 @@
+// Package bearer checks bearer tokens in constant time, so the time a
+// comparison takes tells a caller nothing about how close a guess was.
+package bearer
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Token returns the bearer token of r, or "" if there is none.
+func Token(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return token
+}
+
+// Equal reports whether a and b are the same token. It compares SHA-256
+// digests, so not even the length of the expected token leaks.
+func Equal(a, b string) bool {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// Matches reports whether r presents token. An empty token never matches.
+func Matches(r *http.Request, token string) bool {
+	return token != "" && Equal(Token(r), token)
+}
@@ -0,0 +1,230 @@
+// Package certfetch pulls certificates from the /certs/ endpoint of
+// dns-proxy-api and installs them on a downstream host.
+package certfetch
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFileSize bounds a downloaded file.
+const maxFileSize = 1 << 20
+
+// Client downloads certificate files with the certificate bearer token.
+type Client struct {
+	// BaseURL is the API root including any BASE_PATH, e.g.
+	// https://acme.example.com:5000/acme-dns.
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+	// Attempts is how often a download is tried before giving up.
+	Attempts int
+	// RetryDelay is the pause before the second attempt. It doubles for
+	// every further attempt, plus up to half of it at random, so hosts
+	// that failed together do not retry in lockstep.
+	RetryDelay time.Duration
+}
+
+// NewClient returns a Client with a 30 second request timeout that tries
+// every download three times.
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      token,
+		HTTP:       &http.Client{Timeout: 30 * time.Second},
+		Attempts:   3,
+		RetryDelay: 2 * time.Second,
+	}
+}
+
+// UseClientCertificate presents the certificate in certFile (PEM, with its
+// key in keyFile) during the TLS handshake, for APIs that identify hosts by
+// client certificate.
+func (c *Client) UseClientCertificate(certFile, keyFile string) error {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{pair}}
+	c.HTTP.Transport = transport
+	return nil
+}
+
+// Fetch returns /certs/{domain}/{file}. Network errors and 5xx answers are
+// retried; a download cut short is resumed where it broke off if the file
+// has not changed since (Range with If-Range).
+func (c *Client) Fetch(domain, file string) ([]byte, error) {
+	return c.FetchVerified(domain, file, "")
+}
+
+// FetchVerified is Fetch that also checks the data against sum, a hex
+// SHA-256 digest such as Meta.FullchainSHA256, unless sum is empty. A
+// mismatch is retried from scratch like a failed download.
+func (c *Client) FetchVerified(domain, file, sum string) ([]byte, error) {
+	url := c.BaseURL + "/certs/" + domain + "/" + file
+	var data []byte
+	var etag string
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		var err error
+		data, etag, retry, err = c.get(url, data, etag)
+		if err == nil && sum != "" {
+			if got := sha256.Sum256(data); !strings.EqualFold(hex.EncodeToString(got[:]), sum) {
+				err, retry = fmt.Errorf("GET %s: SHA-256 does not match fullchain_sha256 of /meta", url), true
+				data, etag = nil, ""
+			}
+		}
+		if err == nil {
+			return data, nil
+		}
+		if !retry || attempt >= c.Attempts {
+			if attempt > 1 {
+				err = fmt.Errorf("%w (after %d attempts)", err, attempt)
+			}
+			return nil, err
+		}
+		delay := c.RetryDelay << (attempt - 1)
+		time.Sleep(delay + rand.N(delay/2+1))
+	}
+}
+
+// get downloads url. If have holds the start of the version tagged etag, it
+// only asks for the rest. It returns the data received so far even on
+// failure, so the next attempt can resume, and whether to retry.
+func (c *Client) get(url string, have []byte, etag string) ([]byte, string, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if len(have) > 0 && etag != "" {
+		req.Header.Set("Range", "bytes="+strconv.Itoa(len(have))+"-")
+		req.Header.Set("If-Range", etag)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return have, etag, true, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The whole file, also when it changed since the broken attempt
+		have, etag = nil, resp.Header.Get("ETag")
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), "bytes "+strconv.Itoa(len(have))+"-") {
+			return nil, "", true, fmt.Errorf("GET %s: unexpected Content-Range %q", url, resp.Header.Get("Content-Range"))
+		}
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxFileSize))
+		return nil, "", resp.StatusCode >= 500, fmt.Errorf("GET %s: HTTP %d: %s", url, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFileSize-int64(len(have))))
+	have = append(have, body...)
+	if err != nil {
+		return have, etag, true, fmt.Errorf("GET %s: %w", url, err)
+	}
+	return have, etag, false, nil
+}
+
+// Meta is the part of /certs/{domain}/meta that identifies a certificate.
+type Meta struct {
+	Serial   string    `json:"serial"` // hex
+	NotAfter time.Time `json:"not_after"`
+	// FullchainSHA256 is the hex SHA-256 of fullchain.pem; empty if the
+	// server does not report it.
+	FullchainSHA256 string `json:"fullchain_sha256"`
+}
+
+// Meta returns /certs/{domain}/meta, which is much cheaper to poll than the
+// files and does not count against download quotas.
+func (c *Client) Meta(domain string) (Meta, error) {
+	var m Meta
+	data, err := c.Fetch(domain, "meta")
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("%s/meta: %w", domain, err)
+	}
+	return m, nil
+}
+
+// Describes reports whether m is about cert, in the sense of Same.
+func (m Meta) Describes(cert *x509.Certificate) bool {
+	return strings.EqualFold(m.Serial, cert.SerialNumber.Text(16)) && m.NotAfter.Equal(cert.NotAfter)
+}
+
+// Leaf returns the first certificate in PEM data.
+func Leaf(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// Same reports whether a and b are the same certificate as far as renewals
+// are concerned: same serial number and expiry.
+func Same(a, b *x509.Certificate) bool {
+	return a.SerialNumber.Cmp(b.SerialNumber) == 0 && a.NotAfter.Equal(b.NotAfter)
+}
+
+// KeyMatches reports an error unless the PEM private key belongs to cert, so
+// a download that raced a renewal never installs a mismatched pair.
+func KeyMatches(cert *x509.Certificate, keyPEM []byte) error {
+	key, err := parseKey(keyPEM)
+	if err != nil {
+		return err
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return errors.New("private key does not match the certificate")
+	}
+	return nil
+}
+
+// parseKey reads a PEM private key in PKCS#1, SEC 1 or PKCS#8 form.
+func parseKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no private key found")
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
@@ -0,0 +1,104 @@
+package certfetch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testClient(url string) *Client {
+	c := NewClient(url, "token")
+	c.RetryDelay = time.Millisecond
+	return c
+}
+
+func TestFetchResumes(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	var requests atomic.Int32
+	var rangeHeader, ifRange string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if requests.Add(1) == 1 {
+			// Break the connection half-way through
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:len(data)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		rangeHeader, ifRange = r.Header.Get("Range"), r.Header.Get("If-Range")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	got, err := testClient(srv.URL).Fetch("example.com", "fullchain.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Fetch returned %d bytes, want the %d of the file", len(got), len(data))
+	}
+	if want := "bytes=" + strconv.Itoa(len(data)/2) + "-"; rangeHeader != want || ifRange != `"v1"` {
+		t.Errorf("second request had Range %q and If-Range %q, want %q and \"v1\"", rangeHeader, ifRange, want)
+	}
+}
+
+func TestFetchRetries(t *testing.T) {
+	for _, tc := range []struct {
+		status   int
+		failures int32
+		wantErr  bool
+		requests int32
+	}{
+		{http.StatusServiceUnavailable, 2, false, 3},
+		{http.StatusServiceUnavailable, 3, true, 3},
+		{http.StatusNotFound, 1, true, 1},
+		{http.StatusUnauthorized, 1, true, 1},
+	} {
+		var requests atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) <= tc.failures {
+				http.Error(w, "no", tc.status)
+				return
+			}
+			w.Write([]byte("data"))
+		}))
+		_, err := testClient(srv.URL).Fetch("example.com", "fullchain.pem")
+		srv.Close()
+		if (err != nil) != tc.wantErr || requests.Load() != tc.requests {
+			t.Errorf("%d × HTTP %d: error %v after %d requests, want error %v after %d", tc.failures, tc.status, err, requests.Load(), tc.wantErr, tc.requests)
+		}
+	}
+}
+
+func TestFetchVerified(t *testing.T) {
+	data := []byte("fullchain")
+	sum := sha256.Sum256(data)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first answer is corrupted on the way
+		if requests.Add(1) == 1 {
+			w.Write([]byte("fullch@in"))
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+	c := testClient(srv.URL)
+
+	got, err := c.FetchVerified("example.com", "fullchain.pem", hex.EncodeToString(sum[:]))
+	if err != nil || !bytes.Equal(got, data) || requests.Load() != 2 {
+		t.Errorf("FetchVerified = %q, %v after %d requests; want %q after 2", got, err, requests.Load(), data)
+	}
+
+	wrong := strings.Repeat("0", 64)
+	if _, err := c.FetchVerified("example.com", "fullchain.pem", wrong); err == nil || !strings.Contains(err.Error(), "SHA-256") {
+		t.Errorf("FetchVerified with a wrong sum: %v, want a SHA-256 mismatch", err)
+	}
+}
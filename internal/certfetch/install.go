@@ -0,0 +1,185 @@
+package certfetch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// prevSuffix names the copy of the previous version kept for rollback until
+// Commit.
+const prevSuffix = ".prev"
+
+// File is one file to install.
+type File struct {
+	Path string
+	Data []byte
+	Mode os.FileMode
+}
+
+// Owner is the owner given to installed files; -1 leaves a field unchanged.
+type Owner struct {
+	UID, GID int
+}
+
+// Installation records what Install replaced, so it can be undone.
+type Installation struct {
+	paths   []string
+	hadPrev map[string]bool
+}
+
+// Install replaces every file atomically: the data is written to a temporary
+// file in the target directory, synced, given its mode and owner, and renamed
+// over the target, keeping the SELinux context of the file it replaces. The
+// version it replaces is kept as <path>.prev until Commit or Rollback, so an
+// old private key does not linger on disk. If any file fails, the files
+// already replaced are rolled back, so readers never see a half-written file
+// or a new certificate next to an old key for longer than one rename.
+func Install(files []File, owner Owner) (*Installation, error) {
+	inst := &Installation{hadPrev: make(map[string]bool)}
+	for _, f := range files {
+		if err := inst.installFile(f, owner); err != nil {
+			if rbErr := inst.Rollback(); rbErr != nil {
+				err = fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+			}
+			return nil, err
+		}
+	}
+	return inst, nil
+}
+
+func (inst *Installation) installFile(f File, owner Owner) error {
+	dir := filepath.Dir(f.Path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(f.Path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(f.Data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", f.Path, err)
+	}
+	if err := tmp.Chmod(f.Mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if owner.UID != -1 || owner.GID != -1 {
+		if err := tmp.Chown(owner.UID, owner.GID); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := copySecurityContext(f.Path, tmp.Name()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync %s: %w", f.Path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// Keep the current version: a hard link stays valid after the rename
+	// below replaces the directory entry.
+	prev := f.Path + prevSuffix
+	if err := os.Remove(prev); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	hadPrev := true
+	if err := os.Link(f.Path, prev); errors.Is(err, os.ErrNotExist) {
+		hadPrev = false
+	} else if err != nil {
+		return fmt.Errorf("keep previous %s: %w", f.Path, err)
+	}
+
+	if err := os.Rename(tmp.Name(), f.Path); err != nil {
+		return err
+	}
+	inst.paths = append(inst.paths, f.Path)
+	inst.hadPrev[f.Path] = hadPrev
+	return syncDir(dir)
+}
+
+// Rollback restores the versions Install replaced (files that did not exist
+// before are removed), newest first.
+func (inst *Installation) Rollback() error {
+	var errs []error
+	for i := len(inst.paths) - 1; i >= 0; i-- {
+		path := inst.paths[i]
+		var err error
+		if inst.hadPrev[path] {
+			err = os.Rename(path+prevSuffix, path)
+		} else {
+			err = os.Remove(path)
+		}
+		if err == nil {
+			err = syncDir(filepath.Dir(path))
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	inst.paths = nil
+	return errors.Join(errs...)
+}
+
+// ApplyPermissions gives installed files the mode (File.Data is unused) and
+// owner Install would give them, for a certificate that did not change while
+// the configured permissions did. Missing files are skipped. It reports
+// whether any file changed.
+func ApplyPermissions(files []File, owner Owner) (bool, error) {
+	changed := false
+	for _, f := range files {
+		fi, err := os.Stat(f.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return changed, err
+		}
+		if fi.Mode().Perm() != f.Mode {
+			if err := os.Chmod(f.Path, f.Mode); err != nil {
+				return changed, err
+			}
+			changed = true
+		}
+		if ownerDiffers(fi, owner) {
+			if err := os.Chown(f.Path, owner.UID, owner.GID); err != nil {
+				return changed, err
+			}
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// Commit removes the previous versions Install kept, once the new files are
+// in use. Rollback is not possible afterwards.
+func (inst *Installation) Commit() error {
+	var errs []error
+	for _, path := range inst.paths {
+		if inst.hadPrev[path] {
+			if err := os.Remove(path + prevSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	inst.paths = nil
+	return errors.Join(errs...)
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,110 @@
+package certfetch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestInstallKeepsPreviousVersion(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "example.com", "fullchain.pem")
+	key := filepath.Join(dir, "example.com", "privkey.pem")
+	noOwner := Owner{UID: -1, GID: -1}
+
+	if _, err := Install([]File{{Path: cert, Data: []byte("old cert"), Mode: 0644}, {Path: key, Data: []byte("old key"), Mode: 0600}}, noOwner); err != nil {
+		t.Fatal(err)
+	}
+	inst, err := Install([]File{{Path: cert, Data: []byte("new cert"), Mode: 0644}, {Path: key, Data: []byte("new key"), Mode: 0600}}, noOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, cert); got != "new cert" {
+		t.Errorf("fullchain.pem = %q, want the new version", got)
+	}
+	if got := readFile(t, cert+prevSuffix); got != "old cert" {
+		t.Errorf("fullchain.pem.prev = %q, want the old version", got)
+	}
+	info, err := os.Stat(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("privkey.pem mode = %o, want 600", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(filepath.Dir(cert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("directory holds %d entries, want the two files and their .prev copies (no temporary files)", len(entries))
+	}
+
+	// Once committed, no copy of the old key is left behind
+	if err := inst.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(key + prevSuffix); !os.IsNotExist(err) {
+		t.Errorf("privkey.pem.prev after Commit: %v, want it removed", err)
+	}
+	if got := readFile(t, key); got != "new key" {
+		t.Errorf("privkey.pem = %q after Commit, want the new version", got)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "fullchain.pem")
+	added := filepath.Join(dir, "chain.pem")
+	noOwner := Owner{UID: -1, GID: -1}
+
+	if _, err := Install([]File{{Path: cert, Data: []byte("old"), Mode: 0644}}, noOwner); err != nil {
+		t.Fatal(err)
+	}
+	inst, err := Install([]File{{Path: cert, Data: []byte("new"), Mode: 0644}, {Path: added, Data: []byte("chain"), Mode: 0644}}, noOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inst.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, cert); got != "old" {
+		t.Errorf("after rollback fullchain.pem = %q, want %q", got, "old")
+	}
+	if _, err := os.Stat(added); !os.IsNotExist(err) {
+		t.Errorf("after rollback chain.pem exists (err %v), want it removed", err)
+	}
+}
+
+func TestInstallFailureRollsBack(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "fullchain.pem")
+	noOwner := Owner{UID: -1, GID: -1}
+	if _, err := Install([]File{{Path: cert, Data: []byte("old"), Mode: 0644}}, noOwner); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory in place of the key makes the second rename fail
+	blocked := filepath.Join(dir, "privkey.pem")
+	if err := os.MkdirAll(filepath.Join(blocked, "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Install([]File{{Path: cert, Data: []byte("new"), Mode: 0644}, {Path: blocked, Data: []byte("key"), Mode: 0600}}, noOwner)
+	if err == nil {
+		t.Fatal("Install succeeded, want an error")
+	}
+	if got := readFile(t, cert); got != "old" {
+		t.Errorf("fullchain.pem = %q after a failed install, want %q", got, "old")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package certfetch
+
+import "os"
+
+// ownerDiffers cannot tell the owner of a file here, so any configured
+// owner is applied again.
+func ownerDiffers(fi os.FileInfo, owner Owner) bool {
+	return owner.UID != -1 || owner.GID != -1
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package certfetch
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownerDiffers reports whether the file described by fi needs a chown to
+// get owner.
+func ownerDiffers(fi os.FileInfo, owner Owner) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return owner.UID != -1 || owner.GID != -1
+	}
+	return (owner.UID != -1 && int(st.Uid) != owner.UID) || (owner.GID != -1 && int(st.Gid) != owner.GID)
+}
@@ -0,0 +1,404 @@
+package certfetch
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"hash"
+	"math/big"
+	"unicode/utf16"
+)
+
+// P12Options control how EncodePKCS12 protects the file.
+type P12Options struct {
+	Password string
+	// FriendlyName is the alias of the key entry, e.g. "unifi" for UniFi
+	// controllers; keytool and Windows show it.
+	FriendlyName string
+	// Legacy uses 3DES and a SHA-1 MAC instead of AES-256 and SHA-256,
+	// for Windows Server 2016 and older Java releases.
+	Legacy bool
+}
+
+// p12Iterations matches the OpenSSL default for keys and the MAC.
+const p12Iterations = 2048
+
+var (
+	oidData                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidShroudedKeyBag       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidX509Certificate      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBES2                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256       = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidPBEWithSHAAnd3DESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                 = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+// ASN.1 structures of RFC 7292 and RFC 8018, as far as writing needs them.
+type (
+	pfx struct {
+		Version  int
+		AuthSafe contentInfo
+		MacData  macData
+	}
+	contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue // [0] EXPLICIT, see explicit
+	}
+	macData struct {
+		Mac        digestInfo
+		MacSalt    []byte
+		Iterations int
+	}
+	digestInfo struct {
+		Algorithm algorithmIdentifier
+		Digest    []byte
+	}
+	algorithmIdentifier struct {
+		Algorithm  asn1.ObjectIdentifier
+		Parameters asn1.RawValue `asn1:"optional"`
+	}
+	safeBag struct {
+		ID         asn1.ObjectIdentifier
+		Value      asn1.RawValue  // [0] EXPLICIT, see explicit
+		Attributes []bagAttribute `asn1:"set,omitempty"`
+	}
+	bagAttribute struct {
+		ID     asn1.ObjectIdentifier
+		Values asn1.RawValue // SET OF
+	}
+	certBag struct {
+		ID   asn1.ObjectIdentifier
+		Data []byte `asn1:"tag:0,explicit"`
+	}
+	encryptedPrivateKeyInfo struct {
+		Algorithm algorithmIdentifier
+		Data      []byte
+	}
+	pbes2Params struct {
+		KeyDerivationFunc algorithmIdentifier
+		EncryptionScheme  algorithmIdentifier
+	}
+	pbkdf2Params struct {
+		Salt           []byte
+		IterationCount int
+		KeyLength      int `asn1:"optional"`
+		PRF            algorithmIdentifier
+	}
+	pbeParams struct {
+		Salt       []byte
+		Iterations int
+	}
+)
+
+// EncodePKCS12 packs the PEM certificates (leaf first, then its chain) and
+// PEM private key into a password-protected PKCS#12 (.p12/.pfx) file, for
+// Windows services, Java keystores and appliances that cannot read PEM.
+// The key is encrypted; the certificates are not, as in most exports.
+func EncodePKCS12(certsPEM, keyPEM []byte, opts P12Options) ([]byte, error) {
+	var certs [][]byte
+	for rest := certsPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			certs = append(certs, block.Bytes)
+		}
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	leaf, err := x509.ParseCertificate(certs[0])
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// The key and leaf are paired by localKeyId, the leaf's SHA-1 as usual
+	localKeyID := sha1.Sum(leaf.Raw)
+	leafAttrs, err := bagAttributes(opts.FriendlyName, localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	for i, der := range certs {
+		bag, err := asn1.Marshal(certBag{ID: oidX509Certificate, Data: der})
+		if err != nil {
+			return nil, err
+		}
+		sb := safeBag{ID: oidCertBag, Value: explicit(bag)}
+		if i == 0 {
+			sb.Attributes = leafAttrs
+		}
+		certBags = append(certBags, sb)
+	}
+
+	shrouded, err := encryptKey(pkcs8, opts)
+	if err != nil {
+		return nil, err
+	}
+	keyBags := []safeBag{{ID: oidShroudedKeyBag, Value: explicit(shrouded), Attributes: leafAttrs}}
+
+	var safes []contentInfo
+	for _, bags := range [][]safeBag{certBags, keyBags} {
+		contents, err := asn1.Marshal(bags)
+		if err != nil {
+			return nil, err
+		}
+		ci, err := dataContentInfo(contents)
+		if err != nil {
+			return nil, err
+		}
+		safes = append(safes, ci)
+	}
+	authSafe, err := asn1.Marshal(safes)
+	if err != nil {
+		return nil, err
+	}
+
+	newHash, macOID := sha256.New, oidSHA256
+	if opts.Legacy {
+		newHash, macOID = sha1.New, oidSHA1
+	}
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	macKey := pkcs12KDF(newHash, bmpPassword(opts.Password), salt, 3, p12Iterations, newHash().Size())
+	mac := hmac.New(newHash, macKey)
+	mac.Write(authSafe)
+
+	outer, err := dataContentInfo(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfx{
+		Version:  3,
+		AuthSafe: outer,
+		MacData: macData{
+			Mac:        digestInfo{Algorithm: algorithmIdentifier{Algorithm: macOID, Parameters: asn1.NullRawValue}, Digest: mac.Sum(nil)},
+			MacSalt:    salt,
+			Iterations: p12Iterations,
+		},
+	})
+}
+
+// dataContentInfo wraps content as a ContentInfo of type data.
+func dataContentInfo(content []byte) (contentInfo, error) {
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidData, Content: explicit(octets)}, nil
+}
+
+// explicit wraps DER in a [0] EXPLICIT tag. encoding/asn1 ignores the tag of
+// a RawValue field once its FullBytes are set, so the wrapper is built here.
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// bagAttributes returns the friendlyName (if any) and localKeyId attributes.
+func bagAttributes(friendlyName string, localKeyID []byte) ([]bagAttribute, error) {
+	var attrs []bagAttribute
+	if friendlyName != "" {
+		// BMPString, which encoding/asn1 cannot write itself
+		name := asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmpString(friendlyName)}
+		set, err := asn1.Marshal([]asn1.RawValue{name})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, bagAttribute{ID: oidFriendlyName, Values: asn1.RawValue{FullBytes: setOf(set)}})
+	}
+	set, err := asn1.Marshal([][]byte{localKeyID})
+	if err != nil {
+		return nil, err
+	}
+	attrs = append(attrs, bagAttribute{ID: oidLocalKeyID, Values: asn1.RawValue{FullBytes: setOf(set)}})
+	return attrs, nil
+}
+
+// setOf turns the DER of a SEQUENCE OF into a SET OF with the same elements
+// (a single element needs no sorting).
+func setOf(seq []byte) []byte {
+	set := append([]byte{}, seq...)
+	set[0] = 0x31
+	return set
+}
+
+// encryptKey returns the EncryptedPrivateKeyInfo of a PKCS#8 key: PBES2 with
+// PBKDF2-HMAC-SHA256 and AES-256-CBC, or pbeWithSHAAnd3-KeyTripleDES-CBC
+// for Legacy.
+func encryptKey(pkcs8 []byte, opts P12Options) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	var alg algorithmIdentifier
+	var block cipher.Block
+	var iv []byte
+	if opts.Legacy {
+		salt = salt[:8]
+		password := bmpPassword(opts.Password)
+		key := pkcs12KDF(sha1.New, password, salt, 1, p12Iterations, 24)
+		iv = pkcs12KDF(sha1.New, password, salt, 2, p12Iterations, 8)
+		params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: p12Iterations})
+		if err != nil {
+			return nil, err
+		}
+		alg = algorithmIdentifier{Algorithm: oidPBEWithSHAAnd3DESCBC, Parameters: asn1.RawValue{FullBytes: params}}
+		if block, err = des.NewTripleDESCipher(key); err != nil {
+			return nil, err
+		}
+	} else {
+		iv = make([]byte, aes.BlockSize)
+		if _, err := rand.Read(iv); err != nil {
+			return nil, err
+		}
+		// PBES2 takes the password as it is (UTF-8), unlike the PKCS#12 KDF
+		key := pbkdf2(sha256.New, []byte(opts.Password), salt, p12Iterations, 32)
+		kdf, err := asn1.Marshal(pbkdf2Params{
+			Salt:           salt,
+			IterationCount: p12Iterations,
+			PRF:            algorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+		})
+		if err != nil {
+			return nil, err
+		}
+		ivDER, err := asn1.Marshal(iv)
+		if err != nil {
+			return nil, err
+		}
+		params, err := asn1.Marshal(pbes2Params{
+			KeyDerivationFunc: algorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+			EncryptionScheme:  algorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivDER}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		alg = algorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}
+		if block, err = aes.NewCipher(key); err != nil {
+			return nil, err
+		}
+	}
+
+	// PKCS#7 padding
+	pad := block.BlockSize() - len(pkcs8)%block.BlockSize()
+	data := append(append([]byte{}, pkcs8...), make([]byte, pad)...)
+	for i := len(pkcs8); i < len(data); i++ {
+		data[i] = byte(pad)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: alg, Data: data})
+}
+
+// bmpString encodes s as big-endian UTF-16.
+func bmpString(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return b
+}
+
+// bmpPassword is the password as the PKCS#12 KDF takes it: a BMPString
+// with a terminating zero (RFC 7292 Appendix B.1).
+func bmpPassword(password string) []byte {
+	return append(bmpString(password), 0, 0)
+}
+
+// pkcs12KDF derives n bytes of key material for purpose id (1 key, 2 IV,
+// 3 MAC key) as in RFC 7292 Appendix B.2.
+func pkcs12KDF(newHash func() hash.Hash, password, salt []byte, id byte, iterations, n int) []byte {
+	h := newHash()
+	v := h.BlockSize()
+
+	fill := func(in []byte) []byte {
+		if len(in) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(in)+v-1)/v))
+		for i := range out {
+			out[i] = in[i%len(in)]
+		}
+		return out
+	}
+	D := make([]byte, v)
+	for i := range D {
+		D[i] = id
+	}
+	I := append(fill(salt), fill(password)...)
+
+	var out []byte
+	one := big.NewInt(1)
+	modulus := new(big.Int).Lsh(one, uint(v*8))
+	for len(out) < n {
+		h.Reset()
+		h.Write(D)
+		h.Write(I)
+		A := h.Sum(nil)
+		for r := 1; r < iterations; r++ {
+			h.Reset()
+			h.Write(A)
+			A = h.Sum(A[:0])
+		}
+		out = append(out, A...)
+
+		// I_j = (I_j + B + 1) mod 2^(8v) for every v-byte block of I
+		B := new(big.Int).SetBytes(fill(A)[:v])
+		B.Add(B, one)
+		for j := 0; j < len(I); j += v {
+			Ij := new(big.Int).SetBytes(I[j : j+v])
+			Ij.Add(Ij, B).Mod(Ij, modulus)
+			Ij.FillBytes(I[j : j+v])
+		}
+	}
+	return out[:n]
+}
+
+// pbkdf2 is PBKDF2 (RFC 8018 §5.2) with HMAC over newHash.
+func pbkdf2(newHash func() hash.Hash, password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(newHash, password)
+	var out []byte
+	for block := uint32(1); len(out) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLen]
+}
@@ -0,0 +1,154 @@
+package certfetch
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6070 test vectors.
+func TestPBKDF2(t *testing.T) {
+	for _, tc := range []struct {
+		iterations int
+		want       string
+	}{
+		{1, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{2, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{4096, "4b007901b765489abead49d926f721d065a429c1"},
+	} {
+		got := hex.EncodeToString(pbkdf2(sha1.New, []byte("password"), []byte("salt"), tc.iterations, 20))
+		if got != tc.want {
+			t.Errorf("pbkdf2 with %d iterations = %s, want %s", tc.iterations, got, tc.want)
+		}
+	}
+}
+
+// testCert returns a self-signed certificate and its key as PEM.
+func testCert(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})
+}
+
+func TestEncodePKCS12MAC(t *testing.T) {
+	certPEM, keyPEM := testCert(t)
+	for _, legacy := range []bool{false, true} {
+		data, err := EncodePKCS12(certPEM, keyPEM, P12Options{Password: "pässword", FriendlyName: "unifi", Legacy: legacy})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var p struct {
+			Version  int
+			AuthSafe struct {
+				ContentType asn1.ObjectIdentifier
+				Content     []byte `asn1:"tag:0,explicit"`
+			}
+			MacData macData
+		}
+		if rest, err := asn1.Unmarshal(data, &p); err != nil || len(rest) > 0 {
+			t.Fatalf("legacy=%v: cannot parse the PFX: %v", legacy, err)
+		}
+		if p.Version != 3 || !p.AuthSafe.ContentType.Equal(oidData) {
+			t.Errorf("legacy=%v: version %d, content type %v", legacy, p.Version, p.AuthSafe.ContentType)
+		}
+
+		newHash, wantOID := sha256.New, oidSHA256
+		if legacy {
+			newHash, wantOID = sha1.New, oidSHA1
+		}
+		if !p.MacData.Mac.Algorithm.Algorithm.Equal(wantOID) {
+			t.Errorf("legacy=%v: MAC algorithm %v, want %v", legacy, p.MacData.Mac.Algorithm.Algorithm, wantOID)
+		}
+		key := pkcs12KDF(newHash, bmpPassword("pässword"), p.MacData.MacSalt, 3, p.MacData.Iterations, newHash().Size())
+		mac := hmac.New(newHash, key)
+		mac.Write(p.AuthSafe.Content)
+		if !bytes.Equal(mac.Sum(nil), p.MacData.Mac.Digest) {
+			t.Errorf("legacy=%v: MAC does not verify", legacy)
+		}
+	}
+}
+
+func TestEncodePKCS12NoCertificate(t *testing.T) {
+	_, keyPEM := testCert(t)
+	if _, err := EncodePKCS12(keyPEM, keyPEM, P12Options{}); err == nil {
+		t.Error("EncodePKCS12 without a certificate succeeded")
+	}
+}
+
+// TestEncodePKCS12OpenSSL reads the files back with openssl, when installed.
+func TestEncodePKCS12OpenSSL(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl not installed")
+	}
+	certPEM, keyPEM := testCert(t)
+	for _, legacy := range []bool{false, true} {
+		data, err := EncodePKCS12(certPEM, keyPEM, P12Options{Password: "secret", FriendlyName: "unifi", Legacy: legacy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "cert.p12")
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		args := []string{"pkcs12", "-in", path, "-passin", "pass:secret", "-nodes"}
+		if legacy {
+			// OpenSSL 3 needs the legacy provider for 3DES; older releases
+			// do not know the flag, so retry without it
+			if out, err := exec.Command(openssl, append(args, "-legacy")...).CombinedOutput(); err == nil {
+				checkOpenSSL(t, legacy, string(out))
+				continue
+			}
+		}
+		out, err := exec.Command(openssl, args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("legacy=%v: openssl pkcs12: %v\n%s", legacy, err, out)
+		}
+		checkOpenSSL(t, legacy, string(out))
+	}
+}
+
+func checkOpenSSL(t *testing.T, legacy bool, out string) {
+	t.Helper()
+	for _, want := range []string{"friendlyName: unifi", "BEGIN CERTIFICATE", "BEGIN PRIVATE KEY"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("legacy=%v: openssl output lacks %q:\n%s", legacy, want, out)
+		}
+	}
+}
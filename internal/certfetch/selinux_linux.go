@@ -0,0 +1,34 @@
+package certfetch
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+const selinuxAttr = "security.selinux"
+
+// copySecurityContext labels the file to like the file from, so that to,
+// once renamed over from, keeps the SELinux context the consuming service
+// may read (e.g. cert_t) rather than the default of the directory. Files
+// and file systems without labels are left alone.
+func copySecurityContext(from, to string) error {
+	buf := make([]byte, 256)
+	n, err := syscall.Getxattr(from, selinuxAttr, buf)
+	if errors.Is(err, syscall.ERANGE) {
+		if n, err = syscall.Getxattr(from, selinuxAttr, nil); err == nil {
+			buf = make([]byte, n)
+			n, err = syscall.Getxattr(from, selinuxAttr, buf)
+		}
+	}
+	switch {
+	case errors.Is(err, syscall.ENODATA), errors.Is(err, syscall.ENOTSUP), errors.Is(err, syscall.ENOENT):
+		return nil
+	case err != nil:
+		return fmt.Errorf("read SELinux context of %s: %w", from, err)
+	}
+	if err := syscall.Setxattr(to, selinuxAttr, buf[:n], 0); err != nil {
+		return fmt.Errorf("keep SELinux context of %s: %w", from, err)
+	}
+	return nil
+}
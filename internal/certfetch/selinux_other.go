@@ -0,0 +1,8 @@
+//go:build !linux
+
+package certfetch
+
+// copySecurityContext is a no-op where there is no SELinux.
+func copySecurityContext(from, to string) error {
+	return nil
+}
@@ -0,0 +1,100 @@
+// Package challenges remembers the ACME challenge records this process has
+// created and not yet seen deleted, so they can be removed on shutdown
+// instead of being stranded in the zone by a redeploy mid-issuance.
+package challenges
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Record is an outstanding challenge record.
+type Record struct {
+	Domain string
+	Key    string
+	Value  string
+}
+
+// Tracker holds the outstanding records. A nil *Tracker tracks nothing, so
+// callers need not check whether cleanup is enabled.
+type Tracker struct {
+	mu      sync.Mutex
+	created map[Record]time.Time
+}
+
+// New returns an empty Tracker.
+func New() *Tracker {
+	return &Tracker{created: make(map[Record]time.Time)}
+}
+
+// Add records that rec was created.
+func (t *Tracker) Add(rec Record) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.created[rec] = time.Now()
+}
+
+// Remove forgets rec, e.g. after it was deleted.
+func (t *Tracker) Remove(rec Record) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.created, rec)
+}
+
+// RemoveName forgets every value of domain/key, e.g. after a replace.
+func (t *Tracker) RemoveName(domain, key string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for rec := range t.created {
+		if rec.Domain == domain && rec.Key == key {
+			delete(t.created, rec)
+		}
+	}
+}
+
+// Outstanding returns the tracked records, oldest first.
+func (t *Tracker) Outstanding() []Record {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	recs := make([]Record, 0, len(t.created))
+	for rec := range t.created {
+		recs = append(recs, rec)
+	}
+	sort.Slice(recs, func(i, j int) bool { return t.created[recs[i]].Before(t.created[recs[j]]) })
+	return recs
+}
+
+// Cleanup deletes every outstanding record with del until ctx expires. It
+// returns the number of records deleted and those left behind.
+func (t *Tracker) Cleanup(ctx context.Context, del func(ctx context.Context, rec Record) error, logf func(format string, args ...any)) (int, []Record) {
+	deleted := 0
+	var left []Record
+	for _, rec := range t.Outstanding() {
+		if ctx.Err() != nil {
+			left = append(left, rec)
+			continue
+		}
+		if err := del(ctx, rec); err != nil {
+			logf("cleanup: failed to delete %s.%s: %v", rec.Key, rec.Domain, err)
+			left = append(left, rec)
+			continue
+		}
+		t.Remove(rec)
+		deleted++
+	}
+	return deleted, left
+}
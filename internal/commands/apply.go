@@ -0,0 +1,126 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/manifest"
+)
+
+// ApplyCommand implements the apply command: it brings the TXT records listed
+// in a manifest file into existence and, with pruning, removes other values
+// of the same names.
+type ApplyCommand struct{}
+
+// Execute applies the manifest using a single cPanel account.
+func (c *ApplyCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]string) error {
+	return c.ExecuteMulti(func(string) (*cpanel.CPanelConfig, error) { return cpCfg, nil }, args)
+}
+
+// ExecuteMulti applies the manifest, resolving the cPanel account per domain.
+func (c *ApplyCommand) ExecuteMulti(configFor func(domain string) (*cpanel.CPanelConfig, error), args map[string]string) error {
+	m, err := manifest.Load(args["file"])
+	if err != nil {
+		return fmt.Errorf("failed to load manifest: %w", err)
+	}
+	prune := m.Prune || args["prune"] == "true"
+	dryRun := args["dry-run"] == "true"
+	suffix := ""
+	if dryRun {
+		suffix = " (dry run)"
+	}
+
+	// Group desired values by record name, keeping manifest order
+	type name struct{ domain, key string }
+	var names []name
+	desired := make(map[name][]string)
+	for _, rec := range m.Records {
+		// "EXAMPLE.com." and "example.com" must land in the same group
+		domain, key := dnsname.Record(rec.Domain, rec.Key)
+		n := name{domain, key}
+		if _, ok := desired[n]; !ok {
+			names = append(names, n)
+		}
+		desired[n] = append(desired[n], rec.Value)
+	}
+
+	var added, removed, unchanged, failed int
+	for _, n := range names {
+		label := n.key + "." + n.domain
+
+		cpCfg, err := configFor(n.domain)
+		if err != nil {
+			fmt.Printf("  failed %s: %v\n", label, err)
+			failed++
+			continue
+		}
+		current, err := cpCfg.TxtValues(n.domain, n.key)
+		if err != nil {
+			fmt.Printf("  failed %s: %v\n", label, err)
+			failed++
+			continue
+		}
+		present := make(map[string]bool, len(current))
+		for _, v := range current {
+			present[v] = true
+		}
+		want := make(map[string]bool, len(desired[n]))
+		for _, v := range desired[n] {
+			want[v] = true
+		}
+
+		for _, v := range desired[n] {
+			if present[v] {
+				unchanged++
+				continue
+			}
+			if !dryRun {
+				if err := cpCfg.CreateTxtRecord(n.domain, n.key, v); err != nil {
+					fmt.Printf("  failed %s TXT %q: %v\n", label, v, err)
+					failed++
+					continue
+				}
+			}
+			fmt.Printf("  add    %s TXT %q%s\n", label, v, suffix)
+			present[v] = true
+			added++
+		}
+
+		if !prune {
+			continue
+		}
+		for _, v := range current {
+			if want[v] {
+				continue
+			}
+			if !dryRun {
+				if err := cpCfg.DeleteTxtRecord(n.domain, n.key, v); err != nil {
+					fmt.Printf("  failed %s TXT %q: %v\n", label, v, err)
+					failed++
+					continue
+				}
+			}
+			fmt.Printf("  remove %s TXT %q%s\n", label, v, suffix)
+			removed++
+		}
+	}
+
+	fmt.Printf("Applied %s: %d added, %d removed, %d unchanged, %d failed.\n", args["file"], added, removed, unchanged, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d changes could not be applied", failed)
+	}
+	return nil
+}
+
+func (c *ApplyCommand) ValidateArgs(args map[string]string) error {
+	if args["file"] == "" {
+		return errors.New("-f/--file is required")
+	}
+	return nil
+}
+
+func (c *ApplyCommand) Usage() string {
+	return "apply -f <manifest> [--prune] [--dry-run]"
+}
@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"acme-dns-tools/internal/cpanel"
+)
+
+// fakeZone serves the ZoneEdit calls of the cPanel API for TXT records. An
+// add_zone_record of the value "fail" is refused.
+type fakeZone struct {
+	mu      sync.Mutex
+	records map[int][2]string // line -> name (FQDN) and value
+	next    int
+}
+
+func newFakeZone(t *testing.T, records ...[2]string) (*fakeZone, *cpanel.CPanelConfig) {
+	z := &fakeZone{records: make(map[int][2]string), next: 1}
+	for _, r := range records {
+		z.records[z.next] = r
+		z.next++
+	}
+	srv := httptest.NewServer(z)
+	t.Cleanup(srv.Close)
+	return z, &cpanel.CPanelConfig{URL: srv.URL, User: "user", APIKey: "key"}
+}
+
+func (z *fakeZone) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	zone := r.Form.Get("domain")
+	switch r.Form.Get("cpanel_jsonapi_func") {
+	case "fetchzone":
+		var recs []map[string]any
+		for line, rec := range z.records {
+			recs = append(recs, map[string]any{"Line": line, "name": rec[0], "type": "TXT", "txtdata": rec[1]})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"cpanelresult": map[string]any{"data": []any{map[string]any{"record": recs}}}})
+	case "add_zone_record":
+		if r.Form.Get("txtdata") == "fail" {
+			http.Error(w, "zone is locked", http.StatusInternalServerError)
+			return
+		}
+		z.records[z.next] = [2]string{r.Form.Get("name") + "." + zone + ".", r.Form.Get("txtdata")}
+		z.next++
+	case "remove_zone_record":
+		line, _ := strconv.Atoi(r.Form.Get("line"))
+		delete(z.records, line)
+		w.Write([]byte(`{"cpanelresult":{"data":[{"result":{"status":1,"newserial":2}}],"event":{"result":1}}}`))
+	default:
+		http.Error(w, "unknown function", http.StatusBadRequest)
+	}
+}
+
+// values returns the TXT values of name, sorted.
+func (z *fakeZone) values(name string) []string {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	var values []string
+	for _, rec := range z.records {
+		if rec[0] == name {
+			values = append(values, rec[1])
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "records.yaml")
+	os.WriteFile(path, []byte(`records:
+  - domain: Example.COM.
+    key: _acme-challenge
+    value: keep
+  - domain: example.com
+    key: _acme-challenge
+    value: new
+`), 0600)
+	const name = "_acme-challenge.example.com."
+	cmd := &ApplyCommand{}
+
+	z, cpCfg := newFakeZone(t, [2]string{name, "keep"}, [2]string{name, "stale"}, [2]string{"www.example.com.", "other"})
+	if err := cmd.Execute(cpCfg, map[string]string{"file": path, "prune": "true", "dry-run": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := z.values(name); strings.Join(got, ",") != "keep,stale" {
+		t.Errorf("dry run changed the zone: %q", got)
+	}
+
+	if err := cmd.Execute(cpCfg, map[string]string{"file": path}); err != nil {
+		t.Fatal(err)
+	}
+	if got := z.values(name); strings.Join(got, ",") != "keep,new,stale" {
+		t.Errorf("without pruning: %q, want keep, new and stale", got)
+	}
+
+	if err := cmd.Execute(cpCfg, map[string]string{"file": path, "prune": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := z.values(name); strings.Join(got, ",") != "keep,new" {
+		t.Errorf("with pruning: %q, want keep and new", got)
+	}
+	if got := z.values("www.example.com."); len(got) != 1 {
+		t.Errorf("pruning removed a record of another name: %q", got)
+	}
+}
+
+func TestApplyReportsFailures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.json")
+	os.WriteFile(path, []byte(`{"records": [
+		{"domain": "example.com", "key": "_acme-challenge", "value": "fail"},
+		{"domain": "example.com", "key": "_acme-challenge", "value": "ok"}
+	]}`), 0600)
+
+	z, cpCfg := newFakeZone(t)
+	err := (&ApplyCommand{}).Execute(cpCfg, map[string]string{"file": path})
+	if err == nil || !strings.Contains(err.Error(), "1 changes could not be applied") {
+		t.Errorf("Execute = %v", err)
+	}
+	// The failure does not stop the other changes
+	if got := z.values("_acme-challenge.example.com."); strings.Join(got, ",") != "ok" {
+		t.Errorf("zone = %q, want ok", got)
+	}
+
+	if err := (&ApplyCommand{}).Execute(cpCfg, map[string]string{"file": filepath.Join(t.TempDir(), "missing.yaml")}); err == nil {
+		t.Error("Execute succeeded without a manifest")
+	}
+	if err := (&ApplyCommand{}).ValidateArgs(map[string]string{}); err == nil {
+		t.Error("ValidateArgs accepted a missing --file")
+	}
+}
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/provider"
+)
+
+// CheckCredentialsCommand validates every configured cPanel account with a
+// read-only call. It exits non-zero if any account fails, which lets the API
+// (and deploy scripts) catch revoked or mistyped tokens early.
+type CheckCredentialsCommand struct{}
+
+func (c *CheckCredentialsCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]string) error {
+	return errors.New("check-credentials needs the whole config file")
+}
+
+func (c *CheckCredentialsCommand) ExecuteAccounts(cfg map[string]string, args map[string]string) error {
+	checks := cpanel.CheckCredentials(cfg)
+	if len(checks) == 0 {
+		return errors.New("no cPanel account configured")
+	}
+
+	failed := 0
+	for _, check := range checks {
+		name := check.Profile
+		if name == "" {
+			name = "default"
+		}
+		if check.Err != nil {
+			failed++
+			fmt.Printf("  error   %s: %v\n", name, check.Err)
+			continue
+		}
+		fmt.Printf("  ok      %s (%d zone(s))\n", name, len(check.Zones))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d cPanel account(s) failed validation", failed, len(checks))
+	}
+	return nil
+}
+
+// ExecuteProvider validates the credentials of a non-cPanel provider, if the
+// provider can check them without changing anything.
+func (c *CheckCredentialsCommand) ExecuteProvider(p provider.Provider, args map[string]string) error {
+	v, ok := p.(provider.Validator)
+	if !ok {
+		fmt.Println("  skipped (this DNS provider cannot check credentials)")
+		return nil
+	}
+	if err := v.Validate(); err != nil {
+		fmt.Printf("  error   %v\n", err)
+		return errors.New("DNS provider credentials failed validation")
+	}
+	fmt.Println("  ok")
+	return nil
+}
+
+func (c *CheckCredentialsCommand) ValidateArgs(args map[string]string) error {
+	return nil
+}
+
+func (c *CheckCredentialsCommand) Usage() string {
+	return "check-credentials"
+}
@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/dnscheck"
+	"acme-dns-tools/internal/dnsname"
+)
+
+// CheckTxtCommand implements the check-txt command: it verifies that a TXT
+// value is visible from every authoritative server of the zone and, with
+// --resolvers, from the large public resolvers CAs tend to agree with. With
+// --wait it polls until the value has propagated or --timeout expires.
+type CheckTxtCommand struct{}
+
+// Standalone marks the command as not needing cPanel credentials.
+func (c *CheckTxtCommand) Standalone() bool { return true }
+
+func (c *CheckTxtCommand) Execute(_ *cpanel.CPanelConfig, args map[string]string) error {
+	domain, key := dnsname.Record(args["domain"], args["key"])
+	name := key + "." + domain
+
+	servers, err := dnscheck.Authoritative(name)
+	if err != nil {
+		return fmt.Errorf("failed to find authoritative servers: %w", err)
+	}
+	if args["resolvers"] == "true" {
+		servers = append(servers, dnscheck.PublicResolvers...)
+	}
+
+	var results []dnscheck.Result
+	if args["wait"] == "true" {
+		timeout, _ := strconv.Atoi(args["timeout"])
+		interval, _ := strconv.Atoi(args["interval"])
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+		defer cancel()
+		fmt.Printf("Waiting up to %ds for TXT %s to propagate...\n", timeout, name)
+		results, err = dnscheck.Wait(ctx, name, args["value"], servers, time.Duration(interval)*time.Second)
+	} else {
+		results = dnscheck.Check(name, args["value"], servers)
+	}
+	fmt.Printf("TXT %s:\n", name)
+	for _, res := range results {
+		switch {
+		case res.Found:
+			fmt.Printf("  ok      %s\n", res.Server.Name)
+		case res.Err != nil:
+			fmt.Printf("  error   %s: %v\n", res.Server.Name, res.Err)
+		default:
+			fmt.Printf("  missing %s (has %q)\n", res.Server.Name, res.Values)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("TXT record is not visible from every server after %ss", args["timeout"])
+	}
+	if !dnscheck.AllFound(results) {
+		return errors.New("TXT record is not visible from every server yet")
+	}
+	fmt.Println("TXT record is visible from every server.")
+	return nil
+}
+
+func (c *CheckTxtCommand) ValidateArgs(args map[string]string) error {
+	if args["domain"] == "" {
+		return errors.New("--domain is required")
+	}
+	if args["key"] == "" {
+		return errors.New("--key is required")
+	}
+	if args["value"] == "" {
+		return errors.New("--value is required")
+	}
+	for _, name := range []string{"timeout", "interval"} {
+		if n, err := strconv.Atoi(args[name]); args["wait"] == "true" && (err != nil || n <= 0) {
+			return fmt.Errorf("--%s must be a positive number of seconds", name)
+		}
+	}
+	return nil
+}
+
+func (c *CheckTxtCommand) Usage() string {
+	return "check-txt --domain <domain> --key <key> --value <value> [--resolvers] [--wait [--timeout 120] [--interval 5]]"
+}
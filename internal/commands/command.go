@@ -1,6 +1,9 @@
 package commands
 
-import "acme-dns-tools/internal/cpanel"
+import (
+	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/provider"
+)
 
 // Command represents a DNS operation command
 type Command interface {
@@ -9,6 +12,34 @@ type Command interface {
 	Usage() string
 }
 
+// StandaloneCommand is implemented by commands that do not talk to cPanel.
+// The CLI does not load the cPanel config for them and passes a nil config.
+type StandaloneCommand interface {
+	Standalone() bool
+}
+
+// MultiDomainCommand is implemented by commands that may touch records in
+// several zones, each possibly served by a different cPanel account. The CLI
+// calls ExecuteMulti instead of Execute for them.
+type MultiDomainCommand interface {
+	ExecuteMulti(configFor func(domain string) (*cpanel.CPanelConfig, error), args map[string]string) error
+}
+
+// AccountsCommand is implemented by commands that work on every configured
+// cPanel account rather than on one domain. The CLI calls ExecuteAccounts
+// with the whole config file instead of Execute for them.
+type AccountsCommand interface {
+	ExecuteAccounts(cfg map[string]string, args map[string]string) error
+}
+
+// ProviderCommand is implemented by commands that also work with DNS
+// providers other than cPanel (DNS_PROVIDER in the config file). The CLI
+// calls ExecuteProvider instead of Execute for them when such a provider is
+// selected; other commands are refused.
+type ProviderCommand interface {
+	ExecuteProvider(p provider.Provider, args map[string]string) error
+}
+
 // CommandFactory creates command instances
 type CommandFactory interface {
 	CreateCommand(name string) (Command, error)
@@ -31,6 +62,26 @@ func (f *DefaultCommandFactory) CreateCommand(name string) (Command, error) {
 		return &EditTxtCommand{}, nil
 	case "list-txt":
 		return &ListTxtCommand{}, nil
+	case "export-zone":
+		return &ExportZoneCommand{}, nil
+	case "import-zone":
+		return &ImportZoneCommand{}, nil
+	case "delegate-cname":
+		return &DelegateCnameCommand{}, nil
+	case "verify-fcrdns":
+		return &VerifyFCrDNSCommand{}, nil
+	case "apply":
+		return &ApplyCommand{}, nil
+	case "check-txt":
+		return &CheckTxtCommand{}, nil
+	case "publish-tlsa":
+		return &PublishTlsaCommand{}, nil
+	case "publish-sshfp":
+		return &PublishSshfpCommand{}, nil
+	case "migrate-config":
+		return &MigrateConfigCommand{}, nil
+	case "check-credentials":
+		return &CheckCredentialsCommand{}, nil
 	default:
 		return nil, &UnknownCommandError{Command: name}
 	}
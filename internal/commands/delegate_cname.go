@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/zonefile"
+)
+
+// DelegateCnameCommand implements the delegate-cname command, which creates
+// the _acme-challenge.<domain> CNAME <target> record used for CNAME-delegated
+// DNS-01 validation (e.g. towards an acme-dns instance).
+type DelegateCnameCommand struct{}
+
+func (c *DelegateCnameCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]string) error {
+	domain := strings.TrimSuffix(args["domain"], ".")
+	target := dnsname.Fqdn(args["target"])
+	name := dnsname.Fqdn("_acme-challenge." + domain)
+	zone := cpanel.ZoneFor(domain)
+
+	if args["skip-check"] != "true" {
+		if err := checkDelegationTarget(target); err != nil {
+			return err
+		}
+	}
+
+	records, err := cpCfg.ExportZone(zone)
+	if err != nil {
+		return fmt.Errorf("failed to fetch zone '%s': %w", zone, err)
+	}
+	for _, rec := range records {
+		if !strings.EqualFold(rec.Name, name) {
+			continue
+		}
+		if rec.Type == "CNAME" && strings.EqualFold(rec.Data, target) {
+			fmt.Printf("Delegation already in place: %s CNAME %s\n", name, target)
+			return nil
+		}
+		// A CNAME cannot coexist with any other record of the same name
+		return fmt.Errorf("%s already has a %s record (%s); remove it before delegating", name, rec.Type, rec.Data)
+	}
+
+	rec := zonefile.Record{Name: name, TTL: 300, Class: "IN", Type: "CNAME", Data: target}
+	if err := cpCfg.ImportRecord(zone, rec); err != nil {
+		return fmt.Errorf("failed to create CNAME record: %w", err)
+	}
+
+	fmt.Printf("Created delegation: %s CNAME %s\n", name, target)
+	return nil
+}
+
+func (c *DelegateCnameCommand) ValidateArgs(args map[string]string) error {
+	if args["domain"] == "" {
+		return errors.New("--domain is required")
+	}
+	if args["target"] == "" {
+		return errors.New("--target is required")
+	}
+	target := dnsname.Canonical(args["target"])
+	if !isHostname(target) {
+		return fmt.Errorf("--target %q is not a valid host name", args["target"])
+	}
+	if dnsname.IsSubdomain(target, dnsname.ChallengeLabel+"."+args["domain"]) {
+		return errors.New("--target must not point back into the delegated name")
+	}
+	return nil
+}
+
+func (c *DelegateCnameCommand) Usage() string {
+	return "delegate-cname --domain <domain> --target <target> [--skip-check]"
+}
+
+// checkDelegationTarget makes sure the target exists in DNS, so a typo does
+// not silently break every future validation.
+func checkDelegationTarget(target string) error {
+	if _, err := net.LookupTXT(target); err == nil {
+		return nil
+	}
+	if _, err := net.LookupCNAME(target); err == nil {
+		return nil
+	}
+	if _, err := net.LookupHost(target); err == nil {
+		return nil
+	}
+	return fmt.Errorf("target %s does not resolve (no TXT, CNAME or address records); use --skip-check if it is not published yet", target)
+}
+
+// isHostname reports whether name is a syntactically valid DNS name.
+// Underscores are accepted since delegation targets often use them.
+func isHostname(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/provider"
 )
 
 // DeleteTxtCommand implements the delete-txt command
@@ -16,6 +17,24 @@ func (c *DeleteTxtCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]s
 	value := args["value"]
 
 	err := cpCfg.DeleteTxtRecord(domain, key, value)
+	if errors.Is(err, cpanel.ErrTxtRecordNotFound) && args["ignore-missing"] == "true" {
+		fmt.Println("TXT record not present; nothing to delete.")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete TXT record: %w", err)
+	}
+
+	fmt.Println("TXT record deleted successfully.")
+	return nil
+}
+
+func (c *DeleteTxtCommand) ExecuteProvider(p provider.Provider, args map[string]string) error {
+	err := p.DeleteTxtRecord(args["domain"], args["key"], args["value"])
+	if errors.Is(err, provider.ErrRecordNotFound) && args["ignore-missing"] == "true" {
+		fmt.Println("TXT record not present; nothing to delete.")
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to delete TXT record: %w", err)
 	}
@@ -38,5 +57,5 @@ func (c *DeleteTxtCommand) ValidateArgs(args map[string]string) error {
 }
 
 func (c *DeleteTxtCommand) Usage() string {
-	return "delete-txt --domain <domain> --key <key> --value <value>"
+	return "delete-txt --domain <domain> --key <key> --value <value> [--ignore-missing]"
 }
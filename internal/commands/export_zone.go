@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/zonefile"
+)
+
+// ExportZoneCommand implements the export-zone command
+type ExportZoneCommand struct{}
+
+func (c *ExportZoneCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]string) error {
+	zone := strings.TrimSuffix(args["domain"], ".")
+
+	records, err := cpCfg.ExportZone(zone)
+	if err != nil {
+		return fmt.Errorf("failed to export zone: %w", err)
+	}
+	zonefile.Sort(records)
+
+	var out io.Writer = os.Stdout
+	if path := args["output"]; path != "" {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if err := zonefile.Write(out, zone, records); err != nil {
+		return fmt.Errorf("failed to write zone file: %w", err)
+	}
+
+	if args["output"] != "" {
+		fmt.Printf("Exported %d records from zone '%s' to %s\n", len(records), zone, args["output"])
+	}
+	return nil
+}
+
+func (c *ExportZoneCommand) ValidateArgs(args map[string]string) error {
+	if args["domain"] == "" {
+		return errors.New("--domain is required")
+	}
+	return nil
+}
+
+func (c *ExportZoneCommand) Usage() string {
+	return "export-zone --domain <zone> [--output <file>]"
+}
@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/zonefile"
+)
+
+// ImportZoneCommand implements the import-zone command
+type ImportZoneCommand struct{}
+
+func (c *ImportZoneCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]string) error {
+	zone := strings.TrimSuffix(args["domain"], ".")
+	dryRun := args["dry-run"] == "true"
+
+	file, err := os.Open(args["file"])
+	if err != nil {
+		return fmt.Errorf("failed to open zone file: %w", err)
+	}
+	defer file.Close()
+
+	records, err := zonefile.Parse(file, zone)
+	if err != nil {
+		return fmt.Errorf("failed to parse zone file: %w", err)
+	}
+
+	existing, err := cpCfg.ExportZone(zone)
+	if err != nil {
+		return fmt.Errorf("failed to fetch current zone: %w", err)
+	}
+	present := make(map[string]bool, len(existing))
+	for _, rec := range existing {
+		present[recordIdentity(rec)] = true
+	}
+
+	var added, skipped, failed int
+	for _, rec := range records {
+		if !dnsname.IsSubdomain(rec.Name, zone) {
+			fmt.Printf("  skip   %s %s: outside zone '%s'\n", rec.Name, rec.Type, zone)
+			skipped++
+			continue
+		}
+		if rec.Type == "SOA" || (rec.Type == "NS" && dnsname.Equal(rec.Name, zone)) {
+			fmt.Printf("  skip   %s %s: managed by cPanel\n", rec.Name, rec.Type)
+			skipped++
+			continue
+		}
+		if present[recordIdentity(rec)] {
+			skipped++
+			continue
+		}
+		if dryRun {
+			fmt.Printf("  add    %s %s %s (dry run)\n", rec.Name, rec.Type, rec.Data)
+			added++
+			continue
+		}
+		if err := cpCfg.ImportRecord(zone, rec); err != nil {
+			fmt.Printf("  failed %s %s %s: %v\n", rec.Name, rec.Type, rec.Data, err)
+			failed++
+			continue
+		}
+		fmt.Printf("  add    %s %s %s\n", rec.Name, rec.Type, rec.Data)
+		present[recordIdentity(rec)] = true
+		added++
+	}
+
+	fmt.Printf("Imported zone '%s': %d added, %d already present or skipped, %d failed.\n", zone, added, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d records could not be imported", failed)
+	}
+	return nil
+}
+
+func (c *ImportZoneCommand) ValidateArgs(args map[string]string) error {
+	if args["domain"] == "" {
+		return errors.New("--domain is required")
+	}
+	if args["file"] == "" {
+		return errors.New("--file is required")
+	}
+	return nil
+}
+
+func (c *ImportZoneCommand) Usage() string {
+	return "import-zone --domain <zone> --file <zone-file> [--dry-run]"
+}
+
+// recordIdentity returns a comparison key for a record that ignores TTL and
+// formatting differences (quoting, case of names) between sources.
+func recordIdentity(rec zonefile.Record) string {
+	fields := zonefile.Fields(rec.Data)
+	data := strings.Join(fields, " ")
+	if rec.Type == "TXT" {
+		data = strings.Join(fields, "")
+	} else {
+		data = strings.ToLower(data)
+	}
+	return dnsname.Fqdn(rec.Name) + " " + rec.Type + " " + data
+}
@@ -0,0 +1,96 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"acme-dns-tools/internal/api"
+	"acme-dns-tools/internal/config"
+	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/provider"
+)
+
+// MigrateConfigCommand implements the migrate-config command. It merges
+// dns-proxy-api.conf and dns-proxy-cli.conf into one file that both
+// programs read (--config and --cli-config), checked against their schemas.
+type MigrateConfigCommand struct{}
+
+// Standalone marks the command as not needing cPanel credentials.
+func (c *MigrateConfigCommand) Standalone() bool { return true }
+
+func (c *MigrateConfigCommand) Execute(_ *cpanel.CPanelConfig, args map[string]string) error {
+	apiCfg, err := config.Load(args["api"])
+	if err != nil {
+		return fmt.Errorf("failed to read API config: %w", err)
+	}
+	cliCfg, err := config.Load(args["cli"])
+	if err != nil {
+		return fmt.Errorf("failed to read CLI config: %w", err)
+	}
+	sources := []config.Source{
+		{
+			Program:  "dns-proxy-api",
+			Path:     args["api"],
+			Values:   apiCfg,
+			Schema:   api.Schema,
+			Families: []string{"CLIENT.", "TENANT.", "CERT_ACCESS."},
+		},
+		{
+			Program:  "dns-proxy-cli",
+			Path:     args["cli"],
+			Values:   cliCfg,
+			Schema:   provider.Schema,
+			Families: []string{"profile.", "zone.", "fallback."},
+		},
+	}
+
+	// Write next to the output and rename, so a conflict leaves no
+	// half-written file
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if path := args["output"]; path != "" {
+		if file, err = os.CreateTemp(filepath.Dir(path), ".migrate-config-*"); err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+		out = file
+	}
+	warnings, err := config.Merge(out, sources...)
+	if err != nil {
+		return fmt.Errorf("cannot merge the config files:\n%w", err)
+	}
+	for _, w := range warnings {
+		log.Printf("warning: %s", w)
+	}
+	if file == nil {
+		return nil
+	}
+	// The merged file holds every token and API key of both
+	if err := file.Chmod(0600); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), args["output"]); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	fmt.Printf("Merged %s and %s into %s; use it as both --config and --cli-config\n", args["api"], args["cli"], args["output"])
+	return nil
+}
+
+func (c *MigrateConfigCommand) ValidateArgs(args map[string]string) error {
+	if args["api"] == "" || args["cli"] == "" {
+		return errors.New("--api and --cli are required")
+	}
+	return nil
+}
+
+func (c *MigrateConfigCommand) Usage() string {
+	return "migrate-config [--api <dns-proxy-api.conf>] [--cli <dns-proxy-cli.conf>] [--output <file>]"
+}
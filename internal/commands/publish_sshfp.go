@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"acme-dns-tools/internal/cpanel"
+)
+
+// sshfpAlgorithms maps OpenSSH key types to SSHFP algorithm numbers
+// (RFC 4255, 6594, 7479).
+var sshfpAlgorithms = map[string]int{
+	"ssh-rsa":             1,
+	"ssh-dss":             2,
+	"ecdsa-sha2-nistp256": 3,
+	"ecdsa-sha2-nistp384": 3,
+	"ecdsa-sha2-nistp521": 3,
+	"ssh-ed25519":         4,
+}
+
+// sshfpSHA256 is the SSHFP fingerprint type for SHA-256. SHA-1 (type 1) is
+// not published, as clients that support SSHFP all understand SHA-256.
+const sshfpSHA256 = 2
+
+// PublishSshfpCommand implements the publish-sshfp command: it computes
+// SSHFP records from a host's public keys and publishes them at the host
+// name, so clients with VerifyHostKeyDNS can check host keys without TOFU.
+type PublishSshfpCommand struct{}
+
+func (c *PublishSshfpCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]string) error {
+	var keys []string
+	for _, path := range strings.Split(args["key"], ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		lines, err := readPublicKeys(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		keys = append(keys, lines...)
+	}
+
+	ttl, _ := strconv.Atoi(args["ttl"])
+	return PublishSSHFP(os.Stdout, cpCfg, args["domain"], keys, ttl, args["prune"] == "true", args["dry-run"] == "true")
+}
+
+// PublishSSHFP publishes the SSHFP records of the OpenSSH public key lines
+// at host and writes the changes to w.
+func PublishSSHFP(w io.Writer, cpCfg *cpanel.CPanelConfig, host string, keys []string, ttl int, prune, dryRun bool) error {
+	want, err := sshfpRecords(keys)
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimSuffix(host, ".") + "."
+	added, removed, err := cpCfg.SetRecords(name, "SSHFP", ttl, want, prune, dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to publish SSHFP records: %w", err)
+	}
+
+	suffix := ""
+	if dryRun {
+		suffix = " (dry run)"
+	}
+	for _, data := range added {
+		fmt.Fprintf(w, "  add    %s SSHFP %s%s\n", name, strings.Join(data, " "), suffix)
+	}
+	for _, data := range removed {
+		fmt.Fprintf(w, "  remove %s SSHFP %s%s\n", name, strings.Join(data, " "), suffix)
+	}
+	fmt.Fprintf(w, "SSHFP records for %s: %d added, %d removed, %d unchanged.\n", name, len(added), len(removed), len(want)-len(added))
+	return nil
+}
+
+func (c *PublishSshfpCommand) ValidateArgs(args map[string]string) error {
+	if args["domain"] == "" {
+		return errors.New("--domain is required")
+	}
+	if args["key"] == "" {
+		return errors.New("--key is required")
+	}
+	if ttl, err := strconv.Atoi(args["ttl"]); err != nil || ttl <= 0 {
+		return errors.New("--ttl must be a positive number of seconds")
+	}
+	return nil
+}
+
+func (c *PublishSshfpCommand) Usage() string {
+	return "publish-sshfp --domain <host> --key <key.pub>[,<key.pub>...|-] [--ttl 3600] [--prune] [--dry-run]"
+}
+
+// sshfpRecords computes the SSHFP rdata of OpenSSH public key lines
+// ("<type> <base64> [comment]"). Duplicate keys yield one record.
+func sshfpRecords(keys []string) ([][]string, error) {
+	var records [][]string
+	seen := make(map[string]bool)
+	for _, line := range keys {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid public key %q", line)
+		}
+		algorithm, ok := sshfpAlgorithms[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %s", fields[0])
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s key: %w", fields[0], err)
+		}
+		// The blob starts with the key type again; a mismatch means a pasted
+		// line was mangled
+		if len(blob) < 4+len(fields[0]) || string(blob[4:4+len(fields[0])]) != fields[0] {
+			return nil, fmt.Errorf("invalid %s key: type does not match key data", fields[0])
+		}
+		sum := sha256.Sum256(blob)
+		fp := hex.EncodeToString(sum[:])
+		if seen[fp] {
+			continue
+		}
+		seen[fp] = true
+		records = append(records, []string{strconv.Itoa(algorithm), strconv.Itoa(sshfpSHA256), fp})
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no public key found")
+	}
+	return records, nil
+}
+
+// readPublicKeys returns the non-comment lines of a public key file, or of
+// stdin for "-".
+func readPublicKeys(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var keys []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, scanner.Err()
+}
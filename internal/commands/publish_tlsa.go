@@ -0,0 +1,139 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"acme-dns-tools/internal/cpanel"
+)
+
+// PublishTlsaCommand implements the publish-tlsa command: it computes TLSA
+// records for one or more certificates and publishes them at
+// _<port>._<proto>.<host>.
+//
+// A DANE-safe rollover publishes the new certificate's record next to the
+// old one and only prunes the old one once the TTL has passed; listing both
+// certificates with --prune does exactly that.
+type PublishTlsaCommand struct{}
+
+func (c *PublishTlsaCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]string) error {
+	usage, _ := strconv.Atoi(args["usage"])
+	selector, _ := strconv.Atoi(args["selector"])
+	matching, _ := strconv.Atoi(args["matching-type"])
+	ttl, _ := strconv.Atoi(args["ttl"])
+	name := fmt.Sprintf("_%s._%s.%s.", args["port"], args["proto"], strings.TrimSuffix(args["domain"], "."))
+
+	var want [][]string
+	for _, path := range strings.Split(args["cert"], ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		cert, err := readCertificate(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		assoc, err := tlsaAssociation(cert, selector, matching)
+		if err != nil {
+			return err
+		}
+		want = append(want, []string{strconv.Itoa(usage), strconv.Itoa(selector), strconv.Itoa(matching), assoc})
+	}
+
+	dryRun := args["dry-run"] == "true"
+	added, removed, err := cpCfg.SetRecords(name, "TLSA", ttl, want, args["prune"] == "true", dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to publish TLSA records: %w", err)
+	}
+
+	suffix := ""
+	if dryRun {
+		suffix = " (dry run)"
+	}
+	for _, data := range added {
+		fmt.Printf("  add    %s TLSA %s%s\n", name, strings.Join(data, " "), suffix)
+	}
+	for _, data := range removed {
+		fmt.Printf("  remove %s TLSA %s%s\n", name, strings.Join(data, " "), suffix)
+	}
+	fmt.Printf("TLSA records for %s: %d added, %d removed, %d unchanged.\n", name, len(added), len(removed), len(want)-len(added))
+	return nil
+}
+
+func (c *PublishTlsaCommand) ValidateArgs(args map[string]string) error {
+	if args["domain"] == "" {
+		return errors.New("--domain is required")
+	}
+	if args["cert"] == "" {
+		return errors.New("--cert is required")
+	}
+	if port, err := strconv.Atoi(args["port"]); err != nil || port <= 0 || port > 65535 {
+		return errors.New("--port must be a port number")
+	}
+	switch args["proto"] {
+	case "tcp", "udp", "sctp":
+	default:
+		return errors.New("--proto must be tcp, udp or sctp")
+	}
+	for _, field := range []struct {
+		name string
+		max  int
+	}{{"usage", 3}, {"selector", 1}, {"matching-type", 2}} {
+		if n, err := strconv.Atoi(args[field.name]); err != nil || n < 0 || n > field.max {
+			return fmt.Errorf("--%s must be between 0 and %d", field.name, field.max)
+		}
+	}
+	if ttl, err := strconv.Atoi(args["ttl"]); err != nil || ttl <= 0 {
+		return errors.New("--ttl must be a positive number of seconds")
+	}
+	return nil
+}
+
+func (c *PublishTlsaCommand) Usage() string {
+	return "publish-tlsa --domain <host> --cert <cert.pem>[,<cert.pem>...] [--port 443] [--proto tcp] [--usage 3] [--selector 1] [--matching-type 1] [--ttl 3600] [--prune] [--dry-run]"
+}
+
+// tlsaAssociation computes the certificate association data (RFC 6698
+// section 2.1) for the given selector and matching type.
+func tlsaAssociation(cert *x509.Certificate, selector, matching int) (string, error) {
+	data := cert.Raw // selector 0: full certificate
+	if selector == 1 {
+		data = cert.RawSubjectPublicKeyInfo
+	}
+	switch matching {
+	case 0:
+		return hex.EncodeToString(data), nil
+	case 1:
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	case 2:
+		sum := sha512.Sum512(data)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	return "", fmt.Errorf("unsupported matching type %d", matching)
+}
+
+// readCertificate returns the first certificate of a PEM file.
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
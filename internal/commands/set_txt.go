@@ -3,8 +3,11 @@ package commands
 import (
 	"errors"
 	"fmt"
+	"io"
+	"os"
 
 	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/provider"
 )
 
 // SetTxtCommand implements the set-txt command
@@ -15,7 +18,47 @@ func (c *SetTxtCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]stri
 	key := args["key"]
 	value := args["value"]
 
-	err := cpCfg.CreateTxtRecord(domain, key, value)
+	if args["dry-run"] == "true" {
+		return DryRunSetTxt(os.Stdout, cpanelLister{cpCfg}, domain, key, value, args["mode"])
+	}
+
+	var err error
+	if args["mode"] == "replace" {
+		err = cpCfg.ReplaceTxtRecords(domain, key, value)
+	} else {
+		err = cpCfg.CreateTxtRecord(domain, key, value)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set TXT record: %w", err)
+	}
+
+	fmt.Println("TXT record set successfully.")
+	return nil
+}
+
+func (c *SetTxtCommand) ExecuteProvider(p provider.Provider, args map[string]string) error {
+	domain := args["domain"]
+	key := args["key"]
+	value := args["value"]
+
+	if args["dry-run"] == "true" {
+		l, ok := p.(provider.Lister)
+		if !ok {
+			return errors.New("--dry-run is not supported by this DNS provider")
+		}
+		return DryRunSetTxt(os.Stdout, l, domain, key, value, args["mode"])
+	}
+
+	var err error
+	if args["mode"] == "replace" {
+		r, ok := p.(provider.Replacer)
+		if !ok {
+			return errors.New("--mode replace is not supported by this DNS provider")
+		}
+		err = r.ReplaceTxtRecords(domain, key, value)
+	} else {
+		err = p.CreateTxtRecord(domain, key, value)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to set TXT record: %w", err)
 	}
@@ -24,6 +67,40 @@ func (c *SetTxtCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]stri
 	return nil
 }
 
+// DryRunSetTxt validates the credentials against the zone and writes to w
+// what a real set-txt run would change, without changing anything.
+func DryRunSetTxt(w io.Writer, l provider.Lister, domain, key, value, mode string) error {
+	zone, current, err := l.TxtValues(domain, key)
+	if err != nil {
+		return fmt.Errorf("dry run: cannot read the zone of '%s.%s': %w", key, domain, err)
+	}
+
+	fmt.Fprintf(w, "Dry run: zone '%s', record '%s.%s', mode %s; credentials OK.\n", zone, key, domain, mode)
+	present := false
+	others := 0
+	for _, v := range current {
+		if v == value {
+			present = true
+		} else {
+			others++
+		}
+	}
+	switch {
+	case mode == "replace" && present:
+		fmt.Fprintln(w, "Would keep the existing record with this value.")
+	case present:
+		fmt.Fprintln(w, "Would add the record; the value is already present, so this creates a duplicate.")
+	default:
+		fmt.Fprintln(w, "Would add the record.")
+	}
+	if mode == "replace" {
+		fmt.Fprintf(w, "Would remove %d other value(s).\n", others)
+	} else if others > 0 {
+		fmt.Fprintf(w, "Would keep %d other value(s).\n", others)
+	}
+	return nil
+}
+
 func (c *SetTxtCommand) ValidateArgs(args map[string]string) error {
 	if args["domain"] == "" {
 		return errors.New("--domain is required")
@@ -34,9 +111,24 @@ func (c *SetTxtCommand) ValidateArgs(args map[string]string) error {
 	if args["value"] == "" {
 		return errors.New("--value is required")
 	}
+	switch args["mode"] {
+	case "", "append", "replace":
+	default:
+		return errors.New("--mode must be append or replace")
+	}
 	return nil
 }
 
 func (c *SetTxtCommand) Usage() string {
-	return "set-txt --domain <domain> --key <key> --value <value>"
+	return "set-txt --domain <domain> --key <key> --value <value> [--mode append|replace] [--dry-run]"
+}
+
+// cpanelLister reads TXT records through one cPanel account.
+type cpanelLister struct {
+	cpCfg *cpanel.CPanelConfig
+}
+
+func (l cpanelLister) TxtValues(domain, key string) (string, []string, error) {
+	values, err := l.cpCfg.TxtValues(domain, key)
+	return cpanel.ZoneFor(domain), values, err
 }
@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"acme-dns-tools/internal/cpanel"
+	"acme-dns-tools/internal/fcrdns"
+)
+
+// VerifyFCrDNSCommand implements the verify-fcrdns command. It runs the same
+// Forward-Confirmed Reverse DNS check the /certs endpoint performs and
+// explains which step fails.
+type VerifyFCrDNSCommand struct{}
+
+// Standalone marks the command as not needing cPanel credentials.
+func (c *VerifyFCrDNSCommand) Standalone() bool { return true }
+
+func (c *VerifyFCrDNSCommand) Execute(_ *cpanel.CPanelConfig, args map[string]string) error {
+	ip := args["ip"]
+	if ip == "" {
+		var err error
+		if ip, err = sourceIPFor(args["server"]); err != nil {
+			return err
+		}
+		fmt.Printf("Source address used to reach %s: %s\n", args["server"], ip)
+		if parsed := net.ParseIP(ip); parsed.IsPrivate() || parsed.IsLoopback() {
+			fmt.Println("  note: this is a private address; if you are behind NAT the server sees your")
+			fmt.Println("        public address instead. Re-run with --ip <public address>.")
+		}
+	}
+
+	var allowlist []string
+	if args["hostname"] != "" {
+		allowlist = []string{args["hostname"]}
+	}
+
+	res := fcrdns.Verify(ip, allowlist)
+
+	fmt.Printf("Step 1: reverse lookup (PTR) of %s\n", ip)
+	if res.PTRErr != nil || len(res.Checks) == 0 {
+		fmt.Printf("  FAIL: no PTR record found (%v)\n", res.PTRErr)
+		fmt.Println("  Ask the owner of the address block to publish a PTR record for this IP.")
+		return errors.New("FCrDNS verification failed at the reverse lookup")
+	}
+	for _, check := range res.Checks {
+		fmt.Printf("  found: %s\n", check.Hostname)
+	}
+
+	fmt.Println("Step 2: forward lookup (A/AAAA) of each PTR hostname")
+	for _, check := range res.Checks {
+		switch {
+		case !check.Listed:
+			fmt.Printf("  skip: %s is not %s\n", check.Hostname, args["hostname"])
+		case check.Err != nil:
+			fmt.Printf("  FAIL: %s does not resolve (%v)\n", check.Hostname, check.Err)
+		case !check.Confirmed:
+			fmt.Printf("  FAIL: %s resolves to %s, which does not include %s\n", check.Hostname, strings.Join(check.Addrs, ", "), ip)
+		default:
+			fmt.Printf("  ok:   %s resolves to %s\n", check.Hostname, strings.Join(check.Addrs, ", "))
+		}
+	}
+
+	if !res.Allowed() {
+		return errors.New("FCrDNS verification failed: no PTR hostname is forward-confirmed")
+	}
+	fmt.Printf("Verified hostname: %s\n", res.Hostname)
+	fmt.Printf("Make sure %s is listed in CERT_DNS_ALLOWLIST on the server.\n", res.Hostname)
+	return nil
+}
+
+func (c *VerifyFCrDNSCommand) ValidateArgs(args map[string]string) error {
+	if args["server"] == "" && args["ip"] == "" {
+		return errors.New("--server or --ip is required")
+	}
+	if args["ip"] != "" && net.ParseIP(args["ip"]) == nil {
+		return fmt.Errorf("--ip %q is not a valid IP address", args["ip"])
+	}
+	return nil
+}
+
+func (c *VerifyFCrDNSCommand) Usage() string {
+	return "verify-fcrdns --server <api-url> [--ip <addr>] [--hostname <expected-hostname>]"
+}
+
+// sourceIPFor returns the local address the kernel would use to reach the
+// API server. No packets are sent: connecting a UDP socket only picks a route.
+func sourceIPFor(server string) (string, error) {
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil || u.Hostname() == "" {
+		return "", fmt.Errorf("invalid --server %q", server)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+
+	conn, err := net.Dial("udp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return "", fmt.Errorf("cannot determine source address for %s: %w", server, err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
@@ -4,15 +4,30 @@ import (
 	"bufio"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
+// LoadConfig reads a KEY=value config file without a schema, exiting if it
+// cannot be read. Programs with a Schema use Schema.MustLoad instead.
 func LoadConfig(path string) map[string]string {
+	cfg, err := Load(path)
+	if err != nil {
+		log.Fatalf("Failed to load config file: %v", err)
+	}
+	return cfg
+}
+
+// Load reads a KEY=value config file. Unlike LoadConfig it returns errors,
+// for callers that must survive a broken file (e.g. on reload).
+func Load(path string) (map[string]string, error) {
 	cfg := make(map[string]string)
 
 	file, err := os.Open(path)
 	if err != nil {
-		log.Fatalf("Failed to open config file: %v", err)
+		return nil, err
 	}
 	defer file.Close()
 
@@ -29,8 +44,59 @@ func LoadConfig(path string) map[string]string {
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Fatalf("Failed to read config file: %v", err)
+		return nil, err
 	}
 
-	return cfg
+	return cfg, nil
+}
+
+// Watch polls path every interval and calls onChange with the new contents
+// whenever the file's modification time or size changes, or at once when the
+// process receives SIGHUP. Files that cannot be read are reported and
+// skipped; the next change is picked up again.
+func Watch(path string, interval time.Duration, onChange func(map[string]string)) {
+	watch(path, interval, Load, onChange)
+}
+
+// Watch is the package-level Watch for a file with a schema: changed files
+// get the same environment overrides, defaults and checks as at startup, and
+// invalid ones are reported instead of passed on.
+func (s Schema) Watch(path string, interval time.Duration, onChange func(Values)) {
+	watch(path, interval, s.Load, onChange)
+}
+
+func watch[T ~map[string]string](path string, interval time.Duration, load func(string) (T, error), onChange func(T)) {
+	var lastMod time.Time
+	var lastSize int64
+	if info, err := os.Stat(path); err == nil {
+		lastMod, lastSize = info.ModTime(), info.Size()
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		tick := time.NewTicker(interval)
+		for {
+			select {
+			case <-tick.C:
+				info, err := os.Stat(path)
+				if err != nil || (info.ModTime().Equal(lastMod) && info.Size() == lastSize) {
+					continue
+				}
+				lastMod, lastSize = info.ModTime(), info.Size()
+			case <-hup:
+				log.Printf("config: reloading %s (SIGHUP)", path)
+				if info, err := os.Stat(path); err == nil {
+					lastMod, lastSize = info.ModTime(), info.Size()
+				}
+			}
+
+			cfg, err := load(path)
+			if err != nil {
+				log.Printf("config: failed to reload %s: %v", path, err)
+				continue
+			}
+			onChange(cfg)
+		}
+	}()
 }
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// SchemaOf derives a Schema from the `config` tags of the struct v (or the
+// struct v points to), so each key a program reads is declared once, as a
+// field, and a misspelled one does not compile:
+//
+//	type settings struct {
+//		Token   string        `config:"API_TOKEN,required" env:"API_KEY"`
+//		Timeout time.Duration `config:"TIMEOUT,positive" default:"30"`
+//		Format  string        `config:"LOG_FORMAT" oneof:"text,json"`
+//	}
+//
+// The field type gives the Kind: string, bool, int (Count, or Positive with
+// the positive option), float64 (Number), []string (List) and time.Duration
+// (whole seconds, Count or Positive). Embedded structs add their fields.
+// SchemaOf panics on a field it cannot map, which a test of the program's
+// schema turns into a failure.
+func SchemaOf(v any) Schema {
+	var s Schema
+	eachField(reflect.TypeOf(v), func(f reflect.StructField, field Field) {
+		s = append(s, field)
+	})
+	return s
+}
+
+// Decode sets the tagged fields of the struct dst points to from v, which
+// Check has validated against SchemaOf(dst). Unset keys leave zero values.
+func Decode(v Values, dst any) {
+	rv := reflect.ValueOf(dst).Elem()
+	eachField(rv.Type(), func(f reflect.StructField, field Field) {
+		out := rv.FieldByIndex(f.Index)
+		switch {
+		case f.Type == durationType:
+			out.SetInt(int64(v.Seconds(field.Key)))
+		case f.Type.Kind() == reflect.String:
+			out.SetString(v[field.Key])
+		case f.Type.Kind() == reflect.Bool:
+			out.SetBool(v.Bool(field.Key))
+		case f.Type.Kind() == reflect.Int:
+			out.SetInt(int64(v.Int(field.Key)))
+		case f.Type.Kind() == reflect.Float64:
+			out.SetFloat(v.Float(field.Key))
+		default:
+			out.Set(reflect.ValueOf(v.List(field.Key)))
+		}
+	})
+}
+
+// eachField calls fn for every tagged field of the struct type t, including
+// those of embedded structs, with the Field its tags describe.
+func eachField(t reflect.Type, fn func(reflect.StructField, Field)) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	for _, f := range reflect.VisibleFields(t) {
+		tag, ok := f.Tag.Lookup("config")
+		if !ok {
+			continue
+		}
+		if !f.IsExported() {
+			panic(fmt.Sprintf("config: %s.%s: tagged field is not exported", t.Name(), f.Name))
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		field := Field{Key: name, Default: f.Tag.Get("default"), Env: f.Tag.Get("env")}
+		if oneOf := f.Tag.Get("oneof"); oneOf != "" {
+			field.OneOf = strings.Split(oneOf, ",")
+		}
+		positive := false
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "":
+			case "required":
+				field.Required = true
+			case "positive":
+				positive = true
+			default:
+				panic(fmt.Sprintf("config: %s.%s: unknown option %q", t.Name(), f.Name, opt))
+			}
+		}
+		switch {
+		case f.Type == durationType, f.Type.Kind() == reflect.Int:
+			field.Kind = Count
+			if positive {
+				field.Kind = Positive
+			}
+		case f.Type.Kind() == reflect.String:
+			field.Kind = String
+		case f.Type.Kind() == reflect.Bool:
+			field.Kind = Bool
+		case f.Type.Kind() == reflect.Float64:
+			field.Kind = Number
+		case f.Type == reflect.TypeOf([]string(nil)):
+			field.Kind = List
+		default:
+			panic(fmt.Sprintf("config: %s.%s: unsupported type %s", t.Name(), f.Name, f.Type))
+		}
+		if positive && field.Kind != Positive {
+			panic(fmt.Sprintf("config: %s.%s: positive needs an int or time.Duration", t.Name(), f.Name))
+		}
+		fn(f, field)
+	}
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testListener struct {
+	Addr    string        `config:"LISTEN_ADDR" default:":5000"`
+	Timeout time.Duration `config:"TIMEOUT,positive" default:"30"`
+}
+
+type testConfig struct {
+	Token   string   `config:"API_TOKEN,required" env:"API_KEY"`
+	Format  string   `config:"LOG_FORMAT" oneof:"text,json"`
+	Metrics bool     `config:"METRICS_ENABLED"`
+	Limit   int      `config:"LIMIT"`
+	Rate    float64  `config:"RATE"`
+	Domains []string `config:"DOMAINS"`
+	testListener
+	Untagged string
+}
+
+func TestSchemaOf(t *testing.T) {
+	want := Schema{
+		{Key: "API_TOKEN", Kind: String, Required: true, Env: "API_KEY"},
+		{Key: "LOG_FORMAT", Kind: String, OneOf: []string{"text", "json"}},
+		{Key: "METRICS_ENABLED", Kind: Bool},
+		{Key: "LIMIT", Kind: Count},
+		{Key: "RATE", Kind: Number},
+		{Key: "DOMAINS", Kind: List},
+		{Key: "LISTEN_ADDR", Kind: String, Default: ":5000"},
+		{Key: "TIMEOUT", Kind: Positive, Default: "30"},
+	}
+	if got := SchemaOf(testConfig{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("SchemaOf =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestSchemaOfRejectsUnsupportedFields(t *testing.T) {
+	for name, v := range map[string]any{
+		"type": struct {
+			N int64 `config:"N"`
+		}{},
+		"option": struct {
+			S string `config:"S,secret"`
+		}{},
+		"positive": struct {
+			B bool `config:"B,positive"`
+		}{},
+		"unexported": struct {
+			s string `config:"S"`
+		}{},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: SchemaOf did not panic", name)
+				}
+			}()
+			SchemaOf(v)
+		}()
+	}
+}
+
+func TestDecode(t *testing.T) {
+	t.Setenv(EnvPrefix+"API_KEY", "from-env")
+	s := SchemaOf(testConfig{})
+	v := s.Apply(map[string]string{
+		"API_TOKEN":       "from-file",
+		"METRICS_ENABLED": "true",
+		"LIMIT":           "7",
+		"RATE":            "0.5",
+		"DOMAINS":         "a.example, b.example,",
+		"CLIENT.x.TOKEN":  "passed through",
+	})
+	if err := s.Check(v); err != nil {
+		t.Fatal(err)
+	}
+	var c testConfig
+	Decode(v, &c)
+	want := testConfig{
+		Token:        "from-env",
+		Metrics:      true,
+		Limit:        7,
+		Rate:         0.5,
+		Domains:      []string{"a.example", "b.example"},
+		testListener: testListener{Addr: ":5000", Timeout: 30 * time.Second},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("Decode = %+v, want %+v", c, want)
+	}
+	if v["CLIENT.x.TOKEN"] != "passed through" {
+		t.Error("keys outside the schema were dropped")
+	}
+}
+
+func TestCheckListsEveryProblem(t *testing.T) {
+	s := SchemaOf(testConfig{})
+	err := s.Check(s.Apply(map[string]string{"LOG_FORMAT": "xml", "LIMIT": "-1", "TIMEOUT": "0"}))
+	if err == nil {
+		t.Fatal("Check accepted an invalid config")
+	}
+	for _, key := range []string{"API_TOKEN", "LOG_FORMAT", "LIMIT", "TIMEOUT"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error does not mention %s:\n%v", key, err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Source is one KEY=value file to merge, with what its program reads: the
+// keys of Schema and those starting with one of Families (e.g. "CLIENT.").
+type Source struct {
+	Program  string // e.g. dns-proxy-api
+	Path     string
+	Values   map[string]string
+	Schema   Schema
+	Families []string
+}
+
+// reads reports whether the program of s reads key.
+func (s Source) reads(key string) bool {
+	for _, f := range s.Schema {
+		if f.Key == key {
+			return true
+		}
+	}
+	for _, prefix := range s.Families {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge writes the files of sources to w as one file every program can be
+// pointed at, since each passes over the keys of the others. Keys are
+// grouped by the first program that reads them: schema keys in schema
+// order, then families sorted. A key set in several files is written once.
+//
+// Merge returns an error listing every conflict (a key set to different
+// values in two files) and every value a program would reject, and writes
+// nothing then. Keys no program reads, most likely misspelled or obsolete,
+// are written commented out and returned as warnings.
+func Merge(w io.Writer, sources ...Source) (warnings []string, err error) {
+	merged := make(Values)
+	from := make(map[string]string) // key -> path of the file it was first seen in
+	var errs []error
+	for _, src := range sources {
+		for _, k := range sortedKeys(src.Values) {
+			v := src.Values[k]
+			if prev, ok := merged[k]; ok {
+				if prev != v {
+					errs = append(errs, fmt.Errorf("%s conflicts: %q in %s, %q in %s", k, prev, from[k], v, src.Path))
+				}
+				continue
+			}
+			merged[k], from[k] = v, src.Path
+		}
+	}
+	for _, src := range sources {
+		if err := src.Schema.Check(merged); err != nil {
+			errs = append(errs, fmt.Errorf("%s would reject the merged file:\n%w", src.Program, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	bw := bufio.NewWriter(w)
+	paths := make([]string, len(sources))
+	for i, src := range sources {
+		paths[i] = src.Path
+	}
+	fmt.Fprintf(bw, "# Merged from %s\n", strings.Join(paths, " and "))
+
+	written := make(map[string]bool)
+	for _, src := range sources {
+		var keys []string
+		for _, f := range src.Schema {
+			if _, ok := merged[f.Key]; ok && !written[f.Key] {
+				keys = append(keys, f.Key)
+			}
+		}
+		for _, k := range sortedKeys(merged) {
+			if !written[k] && !slices.Contains(keys, k) && src.reads(k) {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "\n# %s\n", src.Program)
+		for _, k := range keys {
+			fmt.Fprintf(bw, "%s=%s\n", k, merged[k])
+			written[k] = true
+		}
+	}
+
+	var unknown []string
+	for _, k := range sortedKeys(merged) {
+		if !written[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		fmt.Fprintf(bw, "\n# Not read by any program (misspelled or obsolete?)\n")
+		for _, k := range unknown {
+			fmt.Fprintf(bw, "# %s=%s\n", k, merged[k])
+			warnings = append(warnings, fmt.Sprintf("%s (from %s) is not read by any program; commented out", k, from[k]))
+		}
+	}
+	return warnings, bw.Flush()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func mergeSources(api, cli map[string]string) []Source {
+	return []Source{
+		{
+			Program:  "api",
+			Path:     "api.conf",
+			Values:   api,
+			Schema:   Schema{{Key: "API_TOKEN", Required: true}, {Key: "LOG_FORMAT", OneOf: []string{"text", "json"}}, {Key: "PROPAGATION_TIMEOUT", Kind: Positive}},
+			Families: []string{"CLIENT."},
+		},
+		{
+			Program:  "cli",
+			Path:     "cli.conf",
+			Values:   cli,
+			Schema:   Schema{{Key: "cpanel_url"}, {Key: "LOG_FORMAT", OneOf: []string{"text", "json"}}},
+			Families: []string{"zone."},
+		},
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var out strings.Builder
+	warnings, err := Merge(&out, mergeSources(
+		map[string]string{"PROPAGATION_TIMEOUT": "60", "API_TOKEN": "t", "CLIENT.b.TOKEN": "b", "CLIENT.a.TOKEN": "a", "LOG_FORMAT": "json"},
+		map[string]string{"cpanel_url": "https://cpanel.example.com:2083", "LOG_FORMAT": "json", "zone.example.com": "main", "cpanel_ulr": "typo"},
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# Merged from api.conf and cli.conf
+
+# api
+API_TOKEN=t
+LOG_FORMAT=json
+PROPAGATION_TIMEOUT=60
+CLIENT.a.TOKEN=a
+CLIENT.b.TOKEN=b
+
+# cli
+cpanel_url=https://cpanel.example.com:2083
+zone.example.com=main
+
+# Not read by any program (misspelled or obsolete?)
+# cpanel_ulr=typo
+`
+	if out.String() != want {
+		t.Errorf("Merge wrote\n%s\nwant\n%s", out.String(), want)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "cpanel_ulr (from cli.conf)") {
+		t.Errorf("warnings = %q, want one for cpanel_ulr", warnings)
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	var out strings.Builder
+	_, err := Merge(&out, mergeSources(
+		map[string]string{"API_TOKEN": "t", "LOG_FORMAT": "json", "PROPAGATION_TIMEOUT": "2m"},
+		map[string]string{"LOG_FORMAT": "text"},
+	)...)
+	if err == nil {
+		t.Fatal("Merge accepted conflicting files")
+	}
+	for _, want := range []string{`LOG_FORMAT conflicts: "json" in api.conf, "text" in cli.conf`, "PROPAGATION_TIMEOUT"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error lacks %q:\n%v", want, err)
+		}
+	}
+	if out.Len() > 0 {
+		t.Errorf("Merge wrote %q despite the conflict", out.String())
+	}
+
+	if _, err := Merge(&out, mergeSources(map[string]string{}, map[string]string{})...); err == nil || !strings.Contains(err.Error(), "API_TOKEN is required") {
+		t.Errorf("Merge without API_TOKEN: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix starts the environment variables that override config keys:
+// ACME_DNS_TOOLS_LISTEN_ADDR overrides LISTEN_ADDR, for containers and
+// secret stores that hand values in through the environment.
+const EnvPrefix = "ACME_DNS_TOOLS_"
+
+// Kind is the type of a config value, enforced by Schema.Check.
+type Kind int
+
+const (
+	String   Kind = iota
+	Bool          // true or false
+	Count         // non-negative integer, 0 usually meaning off or unlimited
+	Positive      // positive integer, e.g. seconds or megabytes
+	Number        // non-negative decimal number
+	List          // comma-separated items
+)
+
+// Field describes one config key.
+type Field struct {
+	Key      string
+	Kind     Kind
+	Default  string   // value when neither the file nor the environment sets one
+	Required bool     // must be set, to a non-empty value
+	OneOf    []string // allowed values, if restricted
+	Env      string   // additional short environment name, without EnvPrefix
+}
+
+// Schema lists the keys a program understands. Keys outside it (such as
+// CLIENT.<name>.TOKEN) are passed through unchecked.
+type Schema []Field
+
+// Load reads the KEY=value file at path, applies environment overrides and
+// defaults, and checks the values. The error lists every missing or invalid
+// key at once rather than stopping at the first.
+func (s Schema) Load(path string) (Values, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	v := s.Apply(cfg)
+	if err := s.Check(v); err != nil {
+		return v, fmt.Errorf("%s: %w", path, err)
+	}
+	return v, nil
+}
+
+// MustLoad is Load for startup: it exits, listing the problems, if the file
+// is missing or invalid.
+func (s Schema) MustLoad(path string) Values {
+	v, err := s.Load(path)
+	if err != nil {
+		log.Fatalf("Failed to load config file: %v", err)
+	}
+	return v
+}
+
+// Apply overlays the environment variables of the schema's keys on cfg and
+// fills in defaults for keys that are still unset.
+func (s Schema) Apply(cfg map[string]string) Values {
+	v := Values(cfg)
+	for _, f := range s {
+		names := []string{EnvPrefix + strings.ToUpper(f.Key)}
+		if f.Env != "" {
+			names = append(names, EnvPrefix+f.Env)
+		}
+		for _, name := range names {
+			if val, ok := os.LookupEnv(name); ok {
+				v[f.Key] = strings.TrimSpace(val)
+			}
+		}
+		if v[f.Key] == "" && f.Default != "" {
+			v[f.Key] = f.Default
+		}
+	}
+	return v
+}
+
+// Check validates v against the schema and joins all problems into one
+// error, one line per key.
+func (s Schema) Check(v Values) error {
+	var errs []error
+	for _, f := range s {
+		raw := v[f.Key]
+		if raw == "" {
+			if f.Required {
+				errs = append(errs, fmt.Errorf("%s is required", f.Key))
+			}
+			continue
+		}
+		if err := f.check(raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s %v, got %q", f.Key, err, raw))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f Field) check(raw string) error {
+	if len(f.OneOf) > 0 && !slices.Contains(f.OneOf, raw) {
+		return fmt.Errorf("must be one of %s", strings.Join(f.OneOf, ", "))
+	}
+	switch f.Kind {
+	case Bool:
+		if raw != "true" && raw != "false" {
+			return errors.New("must be true or false")
+		}
+	case Count:
+		if n, err := strconv.Atoi(raw); err != nil || n < 0 {
+			return errors.New("must be a non-negative integer")
+		}
+	case Positive:
+		if n, err := strconv.Atoi(raw); err != nil || n <= 0 {
+			return errors.New("must be a positive integer")
+		}
+	case Number:
+		if n, err := strconv.ParseFloat(raw, 64); err != nil || n < 0 {
+			return errors.New("must be a non-negative number")
+		}
+	}
+	return nil
+}
+
+// Values is a config checked against a Schema. Its typed accessors assume
+// the check passed and return zero values for unset keys.
+type Values map[string]string
+
+// Bool reports whether key is true.
+func (v Values) Bool(key string) bool {
+	return v[key] == "true"
+}
+
+// Int returns the integer value of key.
+func (v Values) Int(key string) int {
+	n, _ := strconv.Atoi(v[key])
+	return n
+}
+
+// Float returns the decimal value of key.
+func (v Values) Float(key string) float64 {
+	n, _ := strconv.ParseFloat(v[key], 64)
+	return n
+}
+
+// Seconds returns the value of key as a number of seconds.
+func (v Values) Seconds(key string) time.Duration {
+	return time.Duration(v.Int(key)) * time.Second
+}
+
+// List returns the non-empty, trimmed items of a comma-separated key.
+func (v Values) List(key string) []string {
+	var items []string
+	for _, item := range strings.Split(v[key], ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
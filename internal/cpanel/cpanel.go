@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 )
 
@@ -15,6 +16,9 @@ type CPanelConfig struct {
 	URL    string
 	User   string
 	APIKey string
+	// Proxy, if set, is used for every call to the cPanel API instead of the
+	// HTTP_PROXY/HTTPS_PROXY environment (http://, https:// or socks5://).
+	Proxy string
 }
 
 // TxtRecord represents a TXT DNS record
@@ -32,7 +36,41 @@ func NewCPanelConfig(cfg map[string]string) (*CPanelConfig, error) {
 	if url == "" || user == "" || apikey == "" {
 		return nil, errors.New("config incomplete: missing url, user or apikey")
 	}
-	return &CPanelConfig{URL: url, User: user, APIKey: apikey}, nil
+	proxy := cfg["cpanel_proxy"]
+	if proxy != "" {
+		if err := validateProxy(proxy); err != nil {
+			return nil, err
+		}
+	}
+	return &CPanelConfig{URL: url, User: user, APIKey: apikey, Proxy: proxy}, nil
+}
+
+// apiRequest POSTs an API 2 call to the cPanel JSON API and returns the raw
+// response body. The caller is responsible for setting the module/func params.
+func (c *CPanelConfig) apiRequest(params url.Values) ([]byte, error) {
+	params.Set("cpanel_jsonapi_user", c.User)
+	params.Set("cpanel_jsonapi_apiversion", "2")
+
+	fullURL := fmt.Sprintf("%s/json-api/cpanel", c.URL)
+	req, err := http.NewRequest("POST", fullURL, bytes.NewBufferString(params.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("cpanel %s:%s", c.User, c.APIKey))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := c.httpClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %d: %s", resp.StatusCode, string(body))
+	}
+	return body, nil
 }
 
 func (c *CPanelConfig) CreateTxtRecord(domain, key, value string) error {
@@ -46,7 +84,7 @@ func (c *CPanelConfig) CreateTxtRecord(domain, key, value string) error {
 		recordName = key
 	}
 
-	fmt.Printf("DEBUG: Creating TXT record - zone='%s', recordName='%s', value='%s'\n", zone, recordName, value)
+	debugLog.Printf("Creating TXT record - zone='%s', recordName='%s', value='%s'\n", zone, recordName, value)
 
 	data := url.Values{}
 	data.Set("cpanel_jsonapi_user", c.User)
@@ -68,7 +106,7 @@ func (c *CPanelConfig) CreateTxtRecord(domain, key, value string) error {
 	req.Header.Set("Authorization", fmt.Sprintf("cpanel %s:%s", c.User, c.APIKey))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
+	client := c.httpClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("request failed: %w", err)
@@ -84,6 +122,10 @@ func (c *CPanelConfig) CreateTxtRecord(domain, key, value string) error {
 	return nil
 }
 
+// ErrTxtRecordNotFound is returned by DeleteTxtRecord when no record has the
+// given name and value.
+var ErrTxtRecordNotFound = errors.New("TXT record not found for deletion")
+
 func (c *CPanelConfig) DeleteTxtRecord(domain, key, value string) error {
 	// Extract the actual zone and record name
 	zone, recordName := extractZoneAndName(domain)
@@ -95,7 +137,7 @@ func (c *CPanelConfig) DeleteTxtRecord(domain, key, value string) error {
 		recordName = key
 	}
 
-	fmt.Printf("DEBUG: Using zone='%s', recordName='%s'\n", zone, recordName)
+	debugLog.Printf("Using zone='%s', recordName='%s'\n", zone, recordName)
 
 	// 1. Fetch all zone records using cPanel API v2
 	fetchData := url.Values{}
@@ -114,7 +156,7 @@ func (c *CPanelConfig) DeleteTxtRecord(domain, key, value string) error {
 	req.Header.Set("Authorization", fmt.Sprintf("cpanel %s:%s", c.User, c.APIKey))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
+	client := c.httpClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("fetch request failed: %w", err)
@@ -127,7 +169,7 @@ func (c *CPanelConfig) DeleteTxtRecord(domain, key, value string) error {
 	}
 
 	// Debug: log the fetch response
-	fmt.Printf("DEBUG: fetchzone response: %s\n", string(body))
+	debugLog.Printf("fetchzone response: %s\n", string(body))
 
 	// 2. Parse cPanel API v2 response and find the record
 	var fetchResp struct {
@@ -147,12 +189,12 @@ func (c *CPanelConfig) DeleteTxtRecord(domain, key, value string) error {
 	}
 
 	// Debug: log what we're searching for
-	fmt.Printf("DEBUG: Looking for TXT record with name='%s' and txtdata='%s'\n", recordName+"."+zone+".", value)
+	debugLog.Printf("Looking for TXT record with name='%s' and txtdata='%s'\n", recordName+"."+zone+".", value)
 
 	var foundID *int
 	for _, data := range fetchResp.CPanelResult.Data {
 		for _, rec := range data.Record {
-			fmt.Printf("DEBUG: Found record - Line: %d, Name: '%s', Type: '%s', TxtData: '%s'\n",
+			debugLog.Printf("Found record - Line: %d, Name: '%s', Type: '%s', TxtData: '%s'\n",
 				rec.Line, rec.Name, rec.Type, rec.TxtData)
 
 			// Check if this is our TXT record
@@ -167,10 +209,10 @@ func (c *CPanelConfig) DeleteTxtRecord(domain, key, value string) error {
 		}
 	}
 	if foundID == nil {
-		return fmt.Errorf("TXT record not found for deletion")
+		return ErrTxtRecordNotFound
 	}
 
-	fmt.Printf("DEBUG: Found record to delete with line: %d\n", *foundID)
+	debugLog.Printf("Found record to delete with line: %d\n", *foundID)
 
 	// 3. Remove the record by line using cPanel API v2
 	delData := url.Values{}
@@ -200,7 +242,7 @@ func (c *CPanelConfig) DeleteTxtRecord(domain, key, value string) error {
 	}
 
 	// Debug: log delete response
-	fmt.Printf("DEBUG: remove_zone_record response: %s\n", string(delBody))
+	debugLog.Printf("remove_zone_record response: %s\n", string(delBody))
 
 	// Parse and validate the delete response
 	var delResult struct {
@@ -231,7 +273,7 @@ func (c *CPanelConfig) DeleteTxtRecord(domain, key, value string) error {
 		return fmt.Errorf("remove_zone_record failed: %s", delResult.CPanelResult.Data[0].Result.StatusMsg)
 	}
 
-	fmt.Printf("DEBUG: Record successfully deleted. New serial: %v\n",
+	debugLog.Printf("Record successfully deleted. New serial: %v\n",
 		delResult.CPanelResult.Data[0].Result.NewSerial)
 
 	return nil
@@ -248,7 +290,7 @@ func (c *CPanelConfig) EditTxtRecord(domain, key, oldValue, newValue string) err
 		recordName = key
 	}
 
-	fmt.Printf("DEBUG: Using zone='%s', recordName='%s'\n", zone, recordName)
+	debugLog.Printf("Using zone='%s', recordName='%s'\n", zone, recordName)
 
 	// 1. Fetch all zone records using cPanel API v2 to find the record to edit
 	fetchData := url.Values{}
@@ -267,7 +309,7 @@ func (c *CPanelConfig) EditTxtRecord(domain, key, oldValue, newValue string) err
 	req.Header.Set("Authorization", fmt.Sprintf("cpanel %s:%s", c.User, c.APIKey))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
+	client := c.httpClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("fetch request failed: %w", err)
@@ -280,7 +322,7 @@ func (c *CPanelConfig) EditTxtRecord(domain, key, oldValue, newValue string) err
 	}
 
 	// Debug: log the fetch response
-	fmt.Printf("DEBUG: fetchzone response: %s\n", string(body))
+	debugLog.Printf("fetchzone response: %s\n", string(body))
 
 	// 2. Parse cPanel API v2 response and find the record
 	var fetchResp struct {
@@ -300,12 +342,12 @@ func (c *CPanelConfig) EditTxtRecord(domain, key, oldValue, newValue string) err
 	}
 
 	// Debug: log what we're searching for
-	fmt.Printf("DEBUG: Looking for TXT record with name='%s' and txtdata='%s'\n", recordName+"."+zone+".", oldValue)
+	debugLog.Printf("Looking for TXT record with name='%s' and txtdata='%s'\n", recordName+"."+zone+".", oldValue)
 
 	var foundLine *int
 	for _, data := range fetchResp.CPanelResult.Data {
 		for _, rec := range data.Record {
-			fmt.Printf("DEBUG: Found record - Line: %d, Name: '%s', Type: '%s', TxtData: '%s'\n",
+			debugLog.Printf("Found record - Line: %d, Name: '%s', Type: '%s', TxtData: '%s'\n",
 				rec.Line, rec.Name, rec.Type, rec.TxtData)
 
 			// Check if this is our TXT record
@@ -323,7 +365,7 @@ func (c *CPanelConfig) EditTxtRecord(domain, key, oldValue, newValue string) err
 		return fmt.Errorf("TXT record not found for editing")
 	}
 
-	fmt.Printf("DEBUG: Found record to edit at line: %d\n", *foundLine)
+	debugLog.Printf("Found record to edit at line: %d\n", *foundLine)
 
 	// 3. Edit the record using cPanel API v2 edit_zone_record
 	editData := url.Values{}
@@ -358,17 +400,80 @@ func (c *CPanelConfig) EditTxtRecord(domain, key, oldValue, newValue string) err
 	}
 
 	// Debug: log edit response
-	fmt.Printf("DEBUG: edit_zone_record response: %s\n", string(editBody))
+	debugLog.Printf("edit_zone_record response: %s\n", string(editBody))
+
+	return nil
+}
+
+// ReplaceTxtRecords makes value the only TXT record for key in domain: the new
+// value is added if missing, then every other value for the same name is
+// removed. Unlike CreateTxtRecord this discards concurrent challenges.
+func (c *CPanelConfig) ReplaceTxtRecords(domain, key, value string) error {
+	zone, recordName := recordNameFor(domain, key)
+	fullName := recordName + "." + zone + "."
 
+	records, err := c.fetchZone(zone)
+	if err != nil {
+		return err
+	}
+	exists := false
+	for _, rec := range records {
+		if rec.Type == "TXT" && rec.Name == fullName && rec.TxtData == value {
+			exists = true
+		}
+	}
+	if !exists {
+		if err := c.CreateTxtRecord(domain, key, value); err != nil {
+			return err
+		}
+		// Line numbers may have changed; fetch again before removing
+		if records, err = c.fetchZone(zone); err != nil {
+			return err
+		}
+	}
+
+	var stale []int
+	for _, rec := range records {
+		if rec.Type == "TXT" && rec.Name == fullName && rec.TxtData != value {
+			stale = append(stale, rec.Line)
+		}
+	}
+	// Removing a record shifts the lines after it, so go from the bottom up
+	sort.Sort(sort.Reverse(sort.IntSlice(stale)))
+	for _, line := range stale {
+		debugLog.Printf("Replacing: removing stale TXT record at line %d\n", line)
+		if err := c.removeZoneRecord(zone, line); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// TxtValues returns the values of the TXT records named exactly key within
+// domain. Unlike ListTxtRecords it does not match by prefix.
+func (c *CPanelConfig) TxtValues(domain, key string) ([]string, error) {
+	zone, recordName := recordNameFor(domain, key)
+	fullName := recordName + "." + zone + "."
+
+	records, err := c.fetchZone(zone)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, rec := range records {
+		if rec.Type == "TXT" && rec.Name == fullName {
+			values = append(values, rec.TxtData)
+		}
+	}
+	return values, nil
+}
+
 // ListTxtRecords lists all TXT records for a given domain with optional filtering by key
 func (c *CPanelConfig) ListTxtRecords(domain, keyFilter string) ([]TxtRecord, error) {
 	// Extract the actual zone
 	zone, recordPrefix := extractZoneAndName(domain)
 
-	fmt.Printf("DEBUG: Listing TXT records for zone='%s', recordPrefix='%s', keyFilter='%s'\n", zone, recordPrefix, keyFilter)
+	debugLog.Printf("Listing TXT records for zone='%s', recordPrefix='%s', keyFilter='%s'\n", zone, recordPrefix, keyFilter)
 
 	// 1. Fetch all zone records using cPanel API v2
 	fetchData := url.Values{}
@@ -387,7 +492,7 @@ func (c *CPanelConfig) ListTxtRecords(domain, keyFilter string) ([]TxtRecord, er
 	req.Header.Set("Authorization", fmt.Sprintf("cpanel %s:%s", c.User, c.APIKey))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
+	client := c.httpClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("fetch request failed: %w", err)
@@ -451,6 +556,23 @@ func (c *CPanelConfig) ListTxtRecords(domain, keyFilter string) ([]TxtRecord, er
 	return records, nil
 }
 
+// ZoneFor returns the cPanel zone that holds records for domain.
+func ZoneFor(domain string) string {
+	zone, _ := extractZoneAndName(domain)
+	return zone
+}
+
+// recordNameFor returns the zone and the zone-relative record name for a TXT
+// record identified by key within domain, e.g. ("haos.example.com",
+// "_acme-challenge") -> ("example.com", "_acme-challenge.haos").
+func recordNameFor(domain, key string) (zone, recordName string) {
+	zone, recordName = extractZoneAndName(domain)
+	if recordName != "" {
+		return zone, key + "." + recordName
+	}
+	return zone, key
+}
+
 // extractZoneAndName extracts the zone and record name from a full domain
 // For example: "_acme-challenge.haos.iveronsoft.ro" -> zone: "iveronsoft.ro", name: "_acme-challenge.haos"
 func extractZoneAndName(fullDomain string) (zone, name string) {
@@ -0,0 +1,116 @@
+package cpanel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// CredentialCheck is the outcome of validating one configured account.
+type CredentialCheck struct {
+	Profile string   // "" for the default account
+	Zones   []string // zones the account may edit
+	Err     error
+}
+
+// Zones returns the DNS zones the account may edit, via
+// ZoneEdit::fetchzones. It changes nothing, which makes it the call used to
+// validate credentials.
+func (c *CPanelConfig) Zones() ([]string, error) {
+	params := url.Values{}
+	params.Set("cpanel_jsonapi_module", "ZoneEdit")
+	params.Set("cpanel_jsonapi_func", "fetchzones")
+
+	body, err := c.apiRequest(params)
+	if err != nil {
+		if strings.Contains(err.Error(), "HTTP status 401") || strings.Contains(err.Error(), "HTTP status 403") {
+			return nil, fmt.Errorf("credentials for %s at %s were rejected: %w", c.User, c.URL, err)
+		}
+		return nil, err
+	}
+
+	var result struct {
+		CPanelResult struct {
+			Data []struct {
+				Zones map[string]json.RawMessage `json:"zones"`
+			} `json:"data"`
+			Error string `json:"error"`
+			Event struct {
+				Result int `json:"result"`
+			} `json:"event"`
+		} `json:"cpanelresult"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse fetchzones response: %w", err)
+	}
+	if result.CPanelResult.Event.Result != 1 {
+		return nil, fmt.Errorf("fetchzones failed for %s: %s", c.User, result.CPanelResult.Error)
+	}
+
+	var zones []string
+	for _, data := range result.CPanelResult.Data {
+		for zone := range data.Zones {
+			zones = append(zones, zone)
+		}
+	}
+	sort.Strings(zones)
+	return zones, nil
+}
+
+// CheckCredentials validates every configured account with a read-only call
+// and makes sure each zone route points at an account that serves the zone,
+// so bad credentials show up before the next renewal needs them.
+func CheckCredentials(cfg map[string]string) []CredentialCheck {
+	var checks []CredentialCheck
+	for _, profile := range Profiles(cfg) {
+		check := CredentialCheck{Profile: profile}
+		cpCfg, err := NewCPanelConfigForProfile(cfg, profile)
+		if err == nil {
+			check.Zones, err = cpCfg.Zones()
+		}
+		if err == nil {
+			err = checkZoneRoutes(cfg, profile, check.Zones)
+		}
+		check.Err = err
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// checkZoneRoutes reports zone routes to profile whose zone the account does
+// not serve (neither the zone itself nor a parent of it).
+func checkZoneRoutes(cfg map[string]string, profile string, zones []string) error {
+	served := make(map[string]bool, len(zones))
+	for _, z := range zones {
+		served[strings.ToLower(z)] = true
+	}
+	var missing []string
+	for k, v := range cfg {
+		if !strings.HasPrefix(k, zonePrefix) || v != profile {
+			continue
+		}
+		route := strings.TrimPrefix(k, zonePrefix)
+		ok := false
+		for name := route; name != ""; {
+			if served[name] {
+				ok = true
+				break
+			}
+			i := strings.Index(name, ".")
+			if i < 0 {
+				break
+			}
+			name = name[i+1:]
+		}
+		if !ok {
+			missing = append(missing, route)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("zone routes not served by this account: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package cpanel
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+// debugLog receives the verbose trace of every cPanel API call. Binaries can
+// redirect it (e.g. through a redacting writer) with SetDebugOutput.
+var debugLog = log.New(os.Stdout, "DEBUG: ", 0)
+
+// SetDebugOutput sets the destination of the cPanel call trace.
+func SetDebugOutput(w io.Writer) {
+	debugLog.SetOutput(w)
+}
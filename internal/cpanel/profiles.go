@@ -0,0 +1,112 @@
+package cpanel
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"acme-dns-tools/internal/dnsname"
+)
+
+// Credential profiles let one config file hold several cPanel accounts:
+//
+//	cpanel_url=...                      # default account (optional)
+//	profile.<name>.cpanel_url=...       # named account
+//	profile.<name>.cpanel_user=...
+//	profile.<name>.cpanel_apikey=...
+//	zone.<zone>=<name>                  # route a zone (and its subdomains)
+//
+// Domains without a matching zone route use the default account.
+const (
+	profilePrefix = "profile."
+	zonePrefix    = "zone."
+)
+
+// NewCPanelConfigForDomain returns the credentials that manage domain,
+// following the zone routing map. The most specific zone route wins.
+func NewCPanelConfigForDomain(cfg map[string]string, domain string) (*CPanelConfig, error) {
+	profile := ProfileForDomain(cfg, domain)
+	cpCfg, err := NewCPanelConfigForProfile(cfg, profile)
+	if errors.Is(err, errUnknownProfile) {
+		return nil, fmt.Errorf("zone route for %s refers to unknown profile %q", domain, profile)
+	}
+	return cpCfg, err
+}
+
+var errUnknownProfile = errors.New("unknown profile")
+
+// NewCPanelConfigForProfile returns the credentials of a named profile, or of
+// the default account for "".
+func NewCPanelConfigForProfile(cfg map[string]string, profile string) (*CPanelConfig, error) {
+	if profile == "" {
+		return NewCPanelConfig(cfg)
+	}
+
+	prefix := profilePrefix + profile + "."
+	sub := make(map[string]string)
+	for k, v := range cfg {
+		if strings.HasPrefix(k, prefix) {
+			sub[strings.TrimPrefix(k, prefix)] = v
+		}
+	}
+	if len(sub) == 0 {
+		return nil, fmt.Errorf("profile %q: %w", profile, errUnknownProfile)
+	}
+	cpCfg, err := NewCPanelConfig(sub)
+	if err != nil {
+		return nil, fmt.Errorf("profile %q: %w", profile, err)
+	}
+	return cpCfg, nil
+}
+
+// Profiles returns the configured accounts: "" for the default account (if
+// it has a URL) followed by the named profiles, sorted.
+func Profiles(cfg map[string]string) []string {
+	var names []string
+	if cfg["cpanel_url"] != "" {
+		names = append(names, "")
+	}
+	seen := make(map[string]bool)
+	for k := range cfg {
+		if !strings.HasPrefix(k, profilePrefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(k, profilePrefix)
+		if i := strings.LastIndex(rest, "."); i > 0 && !seen[rest[:i]] {
+			seen[rest[:i]] = true
+			names = append(names, rest[:i])
+		}
+	}
+	sort.Strings(names[len(names)-len(seen):])
+	return names
+}
+
+// ProfileForDomain returns the profile name routed to domain, or "" for the
+// default account.
+func ProfileForDomain(cfg map[string]string, domain string) string {
+	name := dnsname.Canonical(domain)
+	for name != "" {
+		if profile, ok := cfg[zonePrefix+name]; ok {
+			return profile
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return ""
+}
+
+// APIKeys returns the API keys of the default account and of every profile,
+// so callers can register them for redaction.
+func APIKeys(cfg map[string]string) []string {
+	var keys []string
+	for k, v := range cfg {
+		if k == "cpanel_apikey" || (strings.HasPrefix(k, profilePrefix) && strings.HasSuffix(k, ".cpanel_apikey")) {
+			keys = append(keys, v)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,187 @@
+package cpanel
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limit handling: cPanel/WHM answers bursts with HTTP 429 (or, behind
+// some front ends, a 200/503 whose body says "too many requests"). Such calls
+// are retried after the advertised delay, and every later call to the same
+// host is spaced out until the panel stops complaining.
+const (
+	maxRateLimitRetries = 6
+	minPacing           = time.Second
+	maxPacing           = 30 * time.Second
+	maxRetryAfter       = 2 * time.Minute
+)
+
+var pacers = struct {
+	mu     sync.Mutex
+	byHost map[string]*pacer
+}{byHost: make(map[string]*pacer)}
+
+// pacer spaces out calls to one cPanel host.
+type pacer struct {
+	mu       sync.Mutex
+	interval time.Duration // current minimum spacing, 0 when not throttled
+	next     time.Time     // earliest start of the next call
+}
+
+func pacerFor(host string) *pacer {
+	pacers.mu.Lock()
+	defer pacers.mu.Unlock()
+	p, ok := pacers.byHost[host]
+	if !ok {
+		p = &pacer{}
+		pacers.byHost[host] = p
+	}
+	return p
+}
+
+// wait blocks until the caller may send its next request.
+func (p *pacer) wait() {
+	p.mu.Lock()
+	now := time.Now()
+	start := now
+	if p.next.After(now) {
+		start = p.next
+	}
+	p.next = start.Add(p.interval)
+	p.mu.Unlock()
+	time.Sleep(time.Until(start))
+}
+
+// throttled slows down later calls and returns how long to wait before
+// retrying the rejected one.
+func (p *pacer) throttled(retryAfter time.Duration) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.interval = min(max(p.interval*2, minPacing), maxPacing)
+	delay := max(retryAfter, p.interval)
+	if next := time.Now().Add(delay); next.After(p.next) {
+		p.next = next
+	}
+	return delay
+}
+
+// succeeded lets the spacing decay again once calls go through.
+func (p *pacer) succeeded() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.interval = p.interval * 3 / 4
+	if p.interval < minPacing/4 {
+		p.interval = 0
+	}
+}
+
+// pacedTransport applies the per-host pacer and retries rate-limited calls.
+type pacedTransport struct {
+	next http.RoundTripper
+}
+
+func (t *pacedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := pacerFor(req.URL.Host)
+	for attempt := 0; ; attempt++ {
+		p.wait()
+		resp, err := t.next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		limited, retryAfter, resp, err := rateLimited(resp)
+		if err != nil {
+			return nil, err
+		}
+		if !limited {
+			p.succeeded()
+			return resp, nil
+		}
+		if attempt == maxRateLimitRetries || req.GetBody == nil && req.Body != nil {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		delay := p.throttled(retryAfter)
+		log.Printf("cpanel: rate limited by %s, retrying in %s (attempt %d of %d)", req.URL.Host, delay, attempt+1, maxRateLimitRetries)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		// The pacer holds the next attempt back by delay
+	}
+}
+
+// rateLimited reports whether resp is a rate limit rejection. The body may
+// have to be inspected, so a replacement response is returned.
+func rateLimited(resp *http.Response) (bool, time.Duration, *http.Response, error) {
+	retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true, retryAfter, resp, nil
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return false, 0, resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return false, 0, nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if strings.Contains(strings.ToLower(string(body)), "too many requests") {
+		return true, retryAfter, resp, nil
+	}
+	return false, 0, resp, nil
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	var d time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	}
+	return min(max(d, 0), maxRetryAfter)
+}
+
+// httpClient returns the client for calls to the cPanel API. Without an
+// explicit proxy the standard HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment
+// variables apply.
+func (c *CPanelConfig) httpClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.Proxy != "" {
+		// Validated by NewCPanelConfig
+		if proxyURL, err := url.Parse(c.Proxy); err == nil {
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+	return &http.Client{Transport: &pacedTransport{next: transport}}
+}
+
+func validateProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid cpanel_proxy %q", proxy)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		return nil
+	default:
+		return fmt.Errorf("invalid cpanel_proxy %q: scheme must be http, https or socks5", proxy)
+	}
+}
@@ -0,0 +1,209 @@
+package cpanel
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The API 2 ZoneEdit functions only know the classic record types. Newer
+// types (TLSA, SSHFP, ...) are managed through the UAPI DNS module, which
+// edits a zone as a whole: reads return every record with its line index,
+// and edits must quote the zone serial they are based on.
+
+// ParsedRecord is a record as returned by UAPI DNS::parse_zone.
+type ParsedRecord struct {
+	Line  int    // line index, used to remove the record
+	Name  string // fully qualified, with trailing dot
+	TTL   int
+	Type  string   // e.g. "TLSA"
+	Data  []string // rdata fields
+	IsSOA bool
+}
+
+// RecordChange is a record to add with MassEditZone.
+type RecordChange struct {
+	Name string // fully qualified, with trailing dot
+	TTL  int
+	Type string
+	Data []string // rdata fields
+}
+
+// uapiRequest calls a UAPI function and returns its "data" member.
+func (c *CPanelConfig) uapiRequest(module, fn string, params url.Values) (json.RawMessage, error) {
+	fullURL := fmt.Sprintf("%s/execute/%s/%s?%s", c.URL, module, fn, params.Encode())
+	req, err := http.NewRequest("GET", fullURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("cpanel %s:%s", c.User, c.APIKey))
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %d: %s", resp.StatusCode, string(body))
+	}
+	debugLog.Printf("%s::%s response: %s\n", module, fn, string(body))
+
+	var result struct {
+		Status int             `json:"status"`
+		Errors []string        `json:"errors"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse %s::%s response: %w", module, fn, err)
+	}
+	if result.Status != 1 {
+		return nil, fmt.Errorf("%s::%s failed: %s", module, fn, strings.Join(result.Errors, "; "))
+	}
+	return result.Data, nil
+}
+
+// ParseZone returns every record of zone and the zone's current serial.
+func (c *CPanelConfig) ParseZone(zone string) ([]ParsedRecord, string, error) {
+	data, err := c.uapiRequest("DNS", "parse_zone", url.Values{"zone": {zone}})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var raw []struct {
+		Type       string   `json:"type"` // "record", "comment", "control"
+		LineIndex  int      `json:"line_index"`
+		RecordType string   `json:"record_type"`
+		DnameB64   string   `json:"dname_b64"`
+		TTL        int      `json:"ttl"`
+		DataB64    []string `json:"data_b64"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, "", fmt.Errorf("failed to parse zone data: %w", err)
+	}
+
+	var records []ParsedRecord
+	serial := ""
+	for _, r := range raw {
+		if r.Type != "record" {
+			continue
+		}
+		rec := ParsedRecord{Line: r.LineIndex, TTL: r.TTL, Type: r.RecordType, IsSOA: r.RecordType == "SOA"}
+		name, err := base64.StdEncoding.DecodeString(r.DnameB64)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid record name at line %d", r.LineIndex)
+		}
+		rec.Name = absoluteName(string(name), zone)
+		for _, f := range r.DataB64 {
+			v, err := base64.StdEncoding.DecodeString(f)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid record data at line %d", r.LineIndex)
+			}
+			rec.Data = append(rec.Data, string(v))
+		}
+		// SOA rdata: mname rname serial refresh retry expire minimum
+		if rec.IsSOA && len(rec.Data) >= 3 {
+			serial = rec.Data[2]
+		}
+		records = append(records, rec)
+	}
+	if serial == "" {
+		return nil, "", fmt.Errorf("zone %s has no SOA serial", zone)
+	}
+	return records, serial, nil
+}
+
+// MassEditZone adds and removes records in one edit based on serial, as
+// returned by ParseZone. Removals are line indexes from the same ParseZone.
+func (c *CPanelConfig) MassEditZone(zone, serial string, add []RecordChange, remove []int) error {
+	params := url.Values{"zone": {zone}, "serial": {serial}}
+	for _, rec := range add {
+		entry, err := json.Marshal(map[string]any{
+			"dname":       rec.Name,
+			"ttl":         rec.TTL,
+			"record_type": rec.Type,
+			"data":        rec.Data,
+		})
+		if err != nil {
+			return err
+		}
+		params.Add("add", string(entry))
+	}
+	for _, line := range remove {
+		params.Add("remove", strconv.Itoa(line))
+	}
+	_, err := c.uapiRequest("DNS", "mass_edit_zone", params)
+	return err
+}
+
+// absoluteName qualifies a zone-relative owner name.
+func absoluteName(name, zone string) string {
+	switch {
+	case strings.HasSuffix(name, "."):
+		return name
+	case name == "" || name == "@":
+		return zone + "."
+	default:
+		return name + "." + zone + "."
+	}
+}
+
+// SetRecords makes sure a record with each rdata in want exists at name
+// (fully qualified) with type rtype. With prune, other records of that type
+// at name are removed in the same edit. Nothing is changed with dryRun. It
+// returns the rdata added and removed (or that would be).
+func (c *CPanelConfig) SetRecords(name, rtype string, ttl int, want [][]string, prune, dryRun bool) (added, removed [][]string, err error) {
+	zone := ZoneFor(strings.TrimSuffix(name, "."))
+	records, serial, err := c.ParseZone(zone)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	present := make(map[string]bool)
+	wanted := make(map[string]bool)
+	for _, data := range want {
+		wanted[rdataKey(data)] = true
+	}
+	var removeLines []int
+	for _, rec := range records {
+		if rec.Type != rtype || !strings.EqualFold(rec.Name, name) {
+			continue
+		}
+		key := rdataKey(rec.Data)
+		present[key] = true
+		if prune && !wanted[key] {
+			removeLines = append(removeLines, rec.Line)
+			removed = append(removed, rec.Data)
+		}
+	}
+
+	var changes []RecordChange
+	for _, data := range want {
+		if present[rdataKey(data)] {
+			continue
+		}
+		present[rdataKey(data)] = true
+		changes = append(changes, RecordChange{Name: name, TTL: ttl, Type: rtype, Data: data})
+		added = append(added, data)
+	}
+
+	if dryRun || (len(changes) == 0 && len(removeLines) == 0) {
+		return added, removed, nil
+	}
+	if err := c.MassEditZone(zone, serial, changes, removeLines); err != nil {
+		return nil, nil, err
+	}
+	return added, removed, nil
+}
+
+// rdataKey compares rdata case-insensitively, as hex digests may be
+// published in either case.
+func rdataKey(data []string) string {
+	return strings.ToLower(strings.Join(data, " "))
+}
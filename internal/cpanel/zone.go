@@ -0,0 +1,248 @@
+package cpanel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/zonefile"
+)
+
+// zoneRecordTypes lists the record types that can be exported and imported.
+// SOA records are owned by cPanel and are never exported.
+var zoneRecordTypes = map[string]bool{
+	"A":     true,
+	"AAAA":  true,
+	"CNAME": true,
+	"MX":    true,
+	"NS":    true,
+	"TXT":   true,
+	"SRV":   true,
+	"CAA":   true,
+}
+
+// flexString accepts both JSON strings and numbers; cPanel is not consistent
+// about which one it returns for fields such as ttl and preference.
+type flexString string
+
+func (f *flexString) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*f = flexString(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*f = flexString(n.String())
+	return nil
+}
+
+// zoneRecord mirrors a record as returned by ZoneEdit::fetchzone.
+type zoneRecord struct {
+	Line       int        `json:"Line"`
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`
+	Class      string     `json:"class"`
+	TTL        flexString `json:"ttl"`
+	Address    string     `json:"address"`
+	Cname      string     `json:"cname"`
+	Exchange   string     `json:"exchange"`
+	Preference flexString `json:"preference"`
+	NSDName    string     `json:"nsdname"`
+	TxtData    string     `json:"txtdata"`
+	Priority   flexString `json:"priority"`
+	Weight     flexString `json:"weight"`
+	Port       flexString `json:"port"`
+	Target     string     `json:"target"`
+	Flag       flexString `json:"flag"`
+	Tag        string     `json:"tag"`
+	Value      string     `json:"value"`
+}
+
+// ExportZone fetches every record of zone that has a zone-file representation
+// supported by this tool. Record order follows the zone's line numbers.
+func (c *CPanelConfig) ExportZone(zone string) ([]zonefile.Record, error) {
+	raw, err := c.fetchZone(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []zonefile.Record
+	for _, rec := range raw {
+		if !zoneRecordTypes[rec.Type] {
+			continue
+		}
+		data, ok := rec.zoneData()
+		if !ok {
+			continue
+		}
+		ttl, _ := strconv.Atoi(string(rec.TTL))
+		class := rec.Class
+		if class == "" {
+			class = "IN"
+		}
+		records = append(records, zonefile.Record{
+			Name:  dnsname.Fqdn(rec.Name),
+			TTL:   ttl,
+			Class: class,
+			Type:  rec.Type,
+			Data:  data,
+		})
+	}
+	return records, nil
+}
+
+// ImportRecord adds a single record to zone via ZoneEdit::add_zone_record.
+func (c *CPanelConfig) ImportRecord(zone string, rec zonefile.Record) error {
+	if !zoneRecordTypes[rec.Type] {
+		return fmt.Errorf("unsupported record type %s", rec.Type)
+	}
+	if rec.Type == "NS" && strings.EqualFold(dnsname.Fqdn(rec.Name), dnsname.Fqdn(zone)) {
+		return fmt.Errorf("apex NS records are managed by cPanel")
+	}
+
+	params := url.Values{}
+	params.Set("cpanel_jsonapi_module", "ZoneEdit")
+	params.Set("cpanel_jsonapi_func", "add_zone_record")
+	params.Set("domain", zone)
+	params.Set("name", dnsname.Fqdn(rec.Name))
+	params.Set("type", rec.Type)
+	params.Set("class", "IN")
+	if rec.TTL > 0 {
+		params.Set("ttl", strconv.Itoa(rec.TTL))
+	}
+
+	fields := zonefile.Fields(rec.Data)
+	want := map[string]int{"MX": 2, "SRV": 4, "CAA": 3}[rec.Type]
+	if len(fields) < 1 || len(fields) < want {
+		return fmt.Errorf("malformed %s data %q", rec.Type, rec.Data)
+	}
+
+	switch rec.Type {
+	case "A", "AAAA":
+		params.Set("address", fields[0])
+	case "CNAME":
+		params.Set("cname", fields[0])
+	case "NS":
+		params.Set("nsdname", fields[0])
+	case "TXT":
+		params.Set("txtdata", strings.Join(fields, ""))
+	case "MX":
+		params.Set("preference", fields[0])
+		params.Set("exchange", fields[1])
+	case "SRV":
+		params.Set("priority", fields[0])
+		params.Set("weight", fields[1])
+		params.Set("port", fields[2])
+		params.Set("target", fields[3])
+	case "CAA":
+		params.Set("flag", fields[0])
+		params.Set("tag", fields[1])
+		params.Set("value", strings.Join(fields[2:], " "))
+	}
+
+	body, err := c.apiRequest(params)
+	if err != nil {
+		return err
+	}
+	return checkZoneEditResult("add_zone_record", body)
+}
+
+// fetchZone returns all records of zone as reported by ZoneEdit::fetchzone.
+func (c *CPanelConfig) fetchZone(zone string) ([]zoneRecord, error) {
+	params := url.Values{}
+	params.Set("cpanel_jsonapi_module", "ZoneEdit")
+	params.Set("cpanel_jsonapi_func", "fetchzone")
+	params.Set("domain", zone)
+	params.Set("customonly", "0")
+
+	body, err := c.apiRequest(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var fetchResp struct {
+		CPanelResult struct {
+			Data []struct {
+				Record []zoneRecord `json:"record"`
+			} `json:"data"`
+		} `json:"cpanelresult"`
+	}
+	if err := json.Unmarshal(body, &fetchResp); err != nil {
+		return nil, fmt.Errorf("failed to parse fetchzone response: %w", err)
+	}
+
+	var records []zoneRecord
+	for _, data := range fetchResp.CPanelResult.Data {
+		records = append(records, data.Record...)
+	}
+	return records, nil
+}
+
+// removeZoneRecord deletes the record at line via ZoneEdit::remove_zone_record.
+func (c *CPanelConfig) removeZoneRecord(zone string, line int) error {
+	params := url.Values{}
+	params.Set("cpanel_jsonapi_module", "ZoneEdit")
+	params.Set("cpanel_jsonapi_func", "remove_zone_record")
+	params.Set("domain", zone)
+	params.Set("line", strconv.Itoa(line))
+
+	body, err := c.apiRequest(params)
+	if err != nil {
+		return err
+	}
+	return checkZoneEditResult("remove_zone_record", body)
+}
+
+// zoneData renders the record data in zone-file presentation format.
+func (r zoneRecord) zoneData() (string, bool) {
+	switch r.Type {
+	case "A", "AAAA":
+		return r.Address, r.Address != ""
+	case "CNAME":
+		return dnsname.Fqdn(r.Cname), r.Cname != ""
+	case "NS":
+		return dnsname.Fqdn(r.NSDName), r.NSDName != ""
+	case "TXT":
+		return zonefile.Quote(r.TxtData), true
+	case "MX":
+		return fmt.Sprintf("%s %s", r.Preference, dnsname.Fqdn(r.Exchange)), r.Exchange != ""
+	case "SRV":
+		return fmt.Sprintf("%s %s %s %s", r.Priority, r.Weight, r.Port, dnsname.Fqdn(r.Target)), r.Target != ""
+	case "CAA":
+		return fmt.Sprintf("%s %s %s", r.Flag, r.Tag, zonefile.Quote(r.Value)), r.Tag != ""
+	}
+	return "", false
+}
+
+// checkZoneEditResult validates the status fields of a ZoneEdit API 2 response.
+func checkZoneEditResult(fn string, body []byte) error {
+	var result struct {
+		CPanelResult struct {
+			Data []struct {
+				Result struct {
+					StatusMsg string `json:"statusmsg"`
+					Status    int    `json:"status"`
+				} `json:"result"`
+			} `json:"data"`
+			Event struct {
+				Result int `json:"result"`
+			} `json:"event"`
+		} `json:"cpanelresult"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", fn, err)
+	}
+	if result.CPanelResult.Event.Result != 1 {
+		return fmt.Errorf("%s failed: event result was %d", fn, result.CPanelResult.Event.Result)
+	}
+	if len(result.CPanelResult.Data) > 0 && result.CPanelResult.Data[0].Result.Status != 1 {
+		return fmt.Errorf("%s failed: %s", fn, result.CPanelResult.Data[0].Result.StatusMsg)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+// Package ctmonitor watches Certificate Transparency logs (via crt.sh) for
+// certificates issued for managed domains and alerts when one shows up that
+// is not among the certificates held locally, i.e. was not issued or fetched
+// by this host.
+package ctmonitor
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"acme-dns-tools/internal/metrics"
+)
+
+const crtshURL = "https://crt.sh/"
+
+var (
+	unknownCerts = metrics.NewCounterVec("dns_proxy_ct_unknown_certificates_total",
+		"Certificates found in CT logs that are not held locally.", "domain")
+	pollErrors = metrics.NewCounterVec("dns_proxy_ct_poll_errors_total",
+		"Failed CT log queries.", "domain")
+)
+
+// Config configures a Monitor.
+type Config struct {
+	// Domains to watch; subdomains are included.
+	Domains []string
+	// CertDirs are scanned (recursively) for PEM certificates considered known.
+	CertDirs []string
+	// Interval between polls.
+	Interval time.Duration
+	// Webhook, if set, receives a JSON POST for every alert.
+	Webhook string
+}
+
+// Alert describes a certificate that was found in CT but not locally.
+type Alert struct {
+	Domain    string `json:"domain"`
+	CrtShID   int64  `json:"crtsh_id"`
+	Serial    string `json:"serial"`
+	Issuer    string `json:"issuer"`
+	Names     string `json:"names"`
+	NotBefore string `json:"not_before"`
+	NotAfter  string `json:"not_after"`
+	URL       string `json:"url"`
+}
+
+type crtshEntry struct {
+	ID           int64  `json:"id"`
+	IssuerName   string `json:"issuer_name"`
+	NameValue    string `json:"name_value"`
+	NotBefore    string `json:"not_before"`
+	NotAfter     string `json:"not_after"`
+	SerialNumber string `json:"serial_number"`
+}
+
+// Monitor polls CT logs in the background.
+type Monitor struct {
+	cfg     Config
+	client  *http.Client
+	alerted map[int64]bool
+}
+
+// New returns a Monitor for cfg.
+func New(cfg Config) *Monitor {
+	return &Monitor{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 60 * time.Second},
+		alerted: make(map[int64]bool),
+	}
+}
+
+// Run polls forever; start it in its own goroutine.
+func (m *Monitor) Run() {
+	log.Printf("ct: monitoring %s every %s", strings.Join(m.cfg.Domains, ", "), m.cfg.Interval)
+	for {
+		m.poll()
+		time.Sleep(m.cfg.Interval)
+	}
+}
+
+func (m *Monitor) poll() {
+	known := knownSerials(m.cfg.CertDirs)
+
+	for _, domain := range m.cfg.Domains {
+		entries, err := m.query(domain)
+		if err != nil {
+			log.Printf("ct: query for %s failed: %v", domain, err)
+			pollErrors.Inc(domain)
+			continue
+		}
+		for _, e := range entries {
+			if m.alerted[e.ID] || known[normalizeSerial(e.SerialNumber)] {
+				continue
+			}
+			m.alerted[e.ID] = true
+			unknownCerts.Inc(domain)
+			m.alert(Alert{
+				Domain:    domain,
+				CrtShID:   e.ID,
+				Serial:    e.SerialNumber,
+				Issuer:    e.IssuerName,
+				Names:     strings.ReplaceAll(e.NameValue, "\n", ","),
+				NotBefore: e.NotBefore,
+				NotAfter:  e.NotAfter,
+				URL:       fmt.Sprintf("%s?id=%d", crtshURL, e.ID),
+			})
+		}
+	}
+}
+
+// query returns the unexpired CT entries for domain and its subdomains.
+func (m *Monitor) query(domain string) ([]crtshEntry, error) {
+	var all []crtshEntry
+	seen := make(map[int64]bool)
+	for _, q := range []string{domain, "%." + domain} {
+		u := fmt.Sprintf("%s?q=%s&output=json&exclude=expired", crtshURL, url.QueryEscape(q))
+		resp, err := m.client.Get(u)
+		if err != nil {
+			return nil, err
+		}
+		var entries []crtshEntry
+		err = json.NewDecoder(resp.Body).Decode(&entries)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse crt.sh response: %w", err)
+		}
+		for _, e := range entries {
+			if !seen[e.ID] {
+				seen[e.ID] = true
+				all = append(all, e)
+			}
+		}
+	}
+	return all, nil
+}
+
+func (m *Monitor) alert(a Alert) {
+	log.Printf("ct: ALERT unknown certificate for %s: serial %s issued by %q for %s (%s)",
+		a.Domain, a.Serial, a.Issuer, a.Names, a.URL)
+	if m.cfg.Webhook == "" {
+		return
+	}
+	body, _ := json.Marshal(a)
+	resp, err := m.client.Post(m.cfg.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("ct: webhook failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("ct: webhook returned HTTP status %d", resp.StatusCode)
+	}
+}
+
+// knownSerials collects the serial numbers of every certificate in dirs.
+func knownSerials(dirs []string) map[string]bool {
+	known := make(map[string]bool)
+	for _, dir := range dirs {
+		filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err != nil || !strings.HasSuffix(path, ".pem") {
+				return nil
+			}
+			// certbot's live directory only contains symlinks; follow them
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil
+			}
+			for {
+				var block *pem.Block
+				block, data = pem.Decode(data)
+				if block == nil {
+					break
+				}
+				if block.Type != "CERTIFICATE" {
+					continue
+				}
+				if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+					known[cert.SerialNumber.Text(16)] = true
+				}
+			}
+			return nil
+		})
+	}
+	return known
+}
+
+// normalizeSerial converts crt.sh's zero-padded hex serial to the format of
+// big.Int.Text(16).
+func normalizeSerial(s string) string {
+	s = strings.TrimLeft(strings.ToLower(s), "0")
+	if s == "" {
+		return "0"
+	}
+	return s
+}
@@ -0,0 +1,135 @@
+// Package dnscheck verifies that a TXT record is visible from the zone's
+// authoritative servers and, optionally, from large public resolvers.
+//
+// The authoritative check catches secondaries that have not synced yet; the
+// public resolvers catch anycast nodes or caches that still serve an old
+// answer, both of which make CA validation fail although the record exists.
+package dnscheck
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"acme-dns-tools/internal/dnsname"
+)
+
+// Server is a DNS server to query.
+type Server struct {
+	Name string // for display, e.g. "ns1.example.com" or "google"
+	Addr string // host:port
+}
+
+// PublicResolvers are queried in addition to the authoritative servers when
+// requested.
+var PublicResolvers = []Server{
+	{Name: "google", Addr: "8.8.8.8:53"},
+	{Name: "cloudflare", Addr: "1.1.1.1:53"},
+	{Name: "quad9", Addr: "9.9.9.9:53"},
+}
+
+// Timeout bounds every single query.
+const Timeout = 5 * time.Second
+
+// Result is the answer of one server.
+type Result struct {
+	Server Server
+	Values []string
+	Err    error
+	Found  bool // the expected value is among Values
+}
+
+// Authoritative returns the name servers of the zone containing name, found
+// by walking up from name to the first label with NS records.
+func Authoritative(name string) ([]Server, error) {
+	name = dnsname.Canonical(name)
+	original := name
+	for name != "" {
+		nss, err := net.LookupNS(name)
+		if err == nil && len(nss) > 0 {
+			servers := make([]Server, 0, len(nss))
+			for _, ns := range nss {
+				host := strings.TrimSuffix(ns.Host, ".")
+				servers = append(servers, Server{Name: host, Addr: net.JoinHostPort(host, "53")})
+			}
+			return servers, nil
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return nil, errors.New("no NS records found for " + original + " or any parent")
+}
+
+// Check queries every server in parallel for the TXT records of name and
+// reports whether value is among them.
+func Check(name, value string, servers []Server) []Result {
+	return check(context.Background(), name, value, servers)
+}
+
+// Wait repeats Check every interval until every server returns value or ctx
+// ends. It returns the last results in both cases, so callers can show what
+// the servers still answer; the error is ctx.Err() on timeout.
+func Wait(ctx context.Context, name, value string, servers []Server, interval time.Duration) ([]Result, error) {
+	for {
+		results := check(ctx, name, value, servers)
+		if AllFound(results) {
+			return results, nil
+		}
+		select {
+		case <-ctx.Done():
+			return results, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+func check(ctx context.Context, name, value string, servers []Server) []Result {
+	results := make([]Result, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func(i int, srv Server) {
+			defer wg.Done()
+			res := Result{Server: srv}
+			res.Values, res.Err = lookupTXT(ctx, name, srv.Addr)
+			for _, v := range res.Values {
+				if v == value {
+					res.Found = true
+				}
+			}
+			results[i] = res
+		}(i, srv)
+	}
+	wg.Wait()
+	return results
+}
+
+// AllFound reports whether every server returned the value.
+func AllFound(results []Result) bool {
+	for _, res := range results {
+		if !res.Found {
+			return false
+		}
+	}
+	return len(results) > 0
+}
+
+// lookupTXT asks one specific server, bypassing the system resolver.
+func lookupTXT(ctx context.Context, name, addr string) ([]string, error) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	return resolver.LookupTXT(ctx, name)
+}
@@ -0,0 +1,53 @@
+// Package dnsname canonicalizes domain names and record keys, so that every
+// code path (API, CLI, providers, /certs) treats "EXAMPLE.com." and
+// "example.com" as the same name.
+package dnsname
+
+import "strings"
+
+// ChallengeLabel is the label ACME DNS-01 validation looks up.
+const ChallengeLabel = "_acme-challenge"
+
+// Canonical returns name without surrounding whitespace and trailing dot,
+// in lower case. DNS names compare case-insensitively, and cPanel, the
+// resolver and the certificate directories all use the lower-case form.
+func Canonical(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// Fqdn returns the canonical name with a trailing dot.
+func Fqdn(name string) string {
+	return Canonical(name) + "."
+}
+
+// Equal reports whether two names are the same once canonicalized.
+func Equal(a, b string) bool {
+	return Canonical(a) == Canonical(b)
+}
+
+// IsSubdomain reports whether name is domain or below it.
+func IsSubdomain(name, domain string) bool {
+	name, domain = Canonical(name), Canonical(domain)
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// Record canonicalizes the (domain, key) pair that identifies a TXT record,
+// whose full name is key + "." + domain. Hook scripts commonly pass the
+// challenge name in either field, so:
+//
+//   - a key that spells out the full name ("_acme-challenge.example.com"
+//     with domain "example.com") is made relative to domain;
+//   - a domain that already carries the challenge label
+//     ("_acme-challenge.example.com") loses it when the key is empty or is
+//     the challenge label itself.
+func Record(domain, key string) (string, string) {
+	domain, key = Canonical(domain), Canonical(key)
+
+	if domain != "" && strings.HasSuffix(key, "."+domain) {
+		key = strings.TrimSuffix(key, "."+domain)
+	}
+	if rest, ok := strings.CutPrefix(domain, ChallengeLabel+"."); ok && (key == "" || key == ChallengeLabel) {
+		domain, key = rest, ChallengeLabel
+	}
+	return domain, key
+}
@@ -0,0 +1,68 @@
+package dnsname
+
+import "testing"
+
+func TestCanonical(t *testing.T) {
+	for in, want := range map[string]string{
+		"example.com":    "example.com",
+		"EXAMPLE.com.":   "example.com",
+		" Example.COM. ": "example.com",
+		".":              "",
+		"":               "",
+	} {
+		if got := Canonical(in); got != want {
+			t.Errorf("Canonical(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := Fqdn("WWW.Example.com"); got != "www.example.com." {
+		t.Errorf("Fqdn = %q, want www.example.com.", got)
+	}
+	if !Equal("Example.com.", "example.COM") {
+		t.Error("Equal ignores neither case nor the trailing dot")
+	}
+}
+
+func TestIsSubdomain(t *testing.T) {
+	tests := []struct {
+		name, domain string
+		want         bool
+	}{
+		{"example.com", "example.com", true},
+		{"www.example.com", "example.com", true},
+		{"a.b.example.com", "example.com", true},
+		{"www.example.com.", "example.com", true},
+		{"www.example.com", "example.com.", true},
+		{"WWW.Example.COM", "example.com", true},
+		{"www.example.com", "EXAMPLE.com.", true},
+		{"badexample.com", "example.com", false},
+		{"example.com.evil.net", "example.com", false},
+		{"example.com", "www.example.com", false},
+		{"com", "example.com", false},
+		{"example.co", "example.com", false},
+	}
+	for _, tt := range tests {
+		if got := IsSubdomain(tt.name, tt.domain); got != tt.want {
+			t.Errorf("IsSubdomain(%q, %q) = %v, want %v", tt.name, tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		domain, key         string
+		wantDomain, wantKey string
+	}{
+		{"example.com", "_acme-challenge", "example.com", "_acme-challenge"},
+		{"Example.com.", "_ACME-challenge.example.com.", "example.com", "_acme-challenge"},
+		{"_acme-challenge.example.com", "", "example.com", "_acme-challenge"},
+		{"_acme-challenge.example.com", "_acme-challenge", "example.com", "_acme-challenge"},
+		{"_acme-challenge.example.com", "other", "_acme-challenge.example.com", "other"},
+		{"example.com", "_acme-challenge.www", "example.com", "_acme-challenge.www"},
+	}
+	for _, tt := range tests {
+		d, k := Record(tt.domain, tt.key)
+		if d != tt.wantDomain || k != tt.wantKey {
+			t.Errorf("Record(%q, %q) = %q, %q; want %q, %q", tt.domain, tt.key, d, k, tt.wantDomain, tt.wantKey)
+		}
+	}
+}
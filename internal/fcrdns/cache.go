@@ -0,0 +1,87 @@
+package fcrdns
+
+import (
+	"sync"
+	"time"
+
+	"acme-dns-tools/internal/metrics"
+)
+
+var (
+	verifications = metrics.NewCounterVec("dns_proxy_fcrdns_verifications_total",
+		"FCrDNS verifications that performed DNS lookups, by result.", "result")
+	negativeHits = metrics.NewCounterVec("dns_proxy_fcrdns_negative_cache_hits_total",
+		"FCrDNS verifications answered from the negative cache without DNS lookups.")
+)
+
+// NegativeCache remembers failed verifications per IP for a short time, so a
+// misconfigured client polling every few seconds does not cause a lookup
+// storm against the resolver. Successes are never cached: a client whose
+// DNS is fixed is let in as soon as the entry expires, and revoking a host's
+// DNS takes effect immediately.
+//
+// A nil *NegativeCache performs every verification.
+type NegativeCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]negativeEntry
+}
+
+type negativeEntry struct {
+	res     Result
+	expires time.Time
+}
+
+// NewNegativeCache returns a cache keeping failures for ttl.
+func NewNegativeCache(ttl time.Duration) *NegativeCache {
+	return &NegativeCache{ttl: ttl, entries: make(map[string]negativeEntry)}
+}
+
+// Verify is like the package-level Verify, but answers from the cache while
+// a recent failure for ip is remembered.
+func (c *NegativeCache) Verify(ip string, allowlist []string) Result {
+	if c == nil {
+		return countVerification(Verify(ip, allowlist))
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	if e, ok := c.entries[ip]; ok && now.Before(e.expires) {
+		c.mu.Unlock()
+		negativeHits.Inc()
+		return e.res
+	}
+	c.mu.Unlock()
+
+	res := countVerification(Verify(ip, allowlist))
+	if !res.Allowed() {
+		c.mu.Lock()
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.entries[ip] = negativeEntry{res: res, expires: now.Add(c.ttl)}
+		c.mu.Unlock()
+	}
+	return res
+}
+
+// Reset forgets every cached failure, e.g. after the allowlist changed.
+func (c *NegativeCache) Reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.entries = make(map[string]negativeEntry)
+	c.mu.Unlock()
+}
+
+func countVerification(res Result) Result {
+	if res.Allowed() {
+		verifications.Inc("allowed")
+	} else {
+		verifications.Inc("denied")
+	}
+	return res
+}
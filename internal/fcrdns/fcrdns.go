@@ -0,0 +1,131 @@
+// Package fcrdns implements Forward-Confirmed Reverse DNS verification and
+// records the outcome of every step, so callers can both enforce it and
+// explain why a client was rejected.
+package fcrdns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"acme-dns-tools/internal/dnsname"
+)
+
+// LookupTimeout bounds each DNS step of Verify: the reverse lookup, and the
+// forward lookups (which run in parallel). A verification therefore takes at
+// most about twice this long, however slow the resolver is.
+var LookupTimeout = 3 * time.Second
+
+// Check is the forward-confirmation of a single PTR hostname.
+type Check struct {
+	Hostname  string   // PTR hostname, without trailing dot
+	Listed    bool     // hostname is in the allowlist
+	Addrs     []string // forward (A/AAAA) lookup result
+	Err       error    // forward lookup error, if any
+	Confirmed bool     // the client IP is among Addrs
+}
+
+// Result describes a complete verification.
+type Result struct {
+	IP       string
+	PTRErr   error   // reverse lookup error, if any
+	Checks   []Check // one per PTR hostname
+	Hostname string  // verified hostname; empty if verification failed
+}
+
+// Allowed reports whether the client passed verification.
+func (r Result) Allowed() bool {
+	return r.Hostname != ""
+}
+
+// Verify performs Forward-Confirmed Reverse DNS verification:
+//  1. Reverse lookup: ip → PTR record(s) → hostname(s)
+//  2. For each hostname in the allowlist: forward lookup → IPs
+//  3. Allow only if the original ip appears in the forward IPs.
+//
+// This prevents spoofing via arbitrary PTR records: the admin must also control
+// the forward (A/AAAA) DNS for the allowed hostname.
+//
+// A nil allowlist lists every hostname, which is useful for diagnostics; an
+// empty non-nil allowlist rejects everything.
+func Verify(ip string, allowlist []string) Result {
+	res := Result{IP: ip}
+	if allowlist != nil && len(allowlist) == 0 {
+		return res
+	}
+
+	// Reverse lookup
+	ctx, cancel := context.WithTimeout(context.Background(), LookupTimeout)
+	ptrs, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	cancel()
+	if err != nil || len(ptrs) == 0 {
+		res.PTRErr = err
+		return res
+	}
+
+	// Forward-confirm every listed hostname in parallel, so one slow name
+	// does not add its timeout to the others
+	res.Checks = make([]Check, len(ptrs))
+	var wg sync.WaitGroup
+	for i, ptr := range ptrs {
+		// net.LookupAddr returns FQDNs with a trailing dot
+		check := Check{Hostname: dnsname.Canonical(ptr)}
+		check.Listed = allowlist == nil || isListed(check.Hostname, allowlist)
+		res.Checks[i] = check
+		if !check.Listed {
+			continue
+		}
+		wg.Add(1)
+		go func(check *Check) {
+			defer wg.Done()
+			// Forward-confirm: resolve the hostname → check ip is present
+			ctx, cancel := context.WithTimeout(context.Background(), LookupTimeout)
+			defer cancel()
+			check.Addrs, check.Err = net.DefaultResolver.LookupHost(ctx, check.Hostname)
+			check.Confirmed = check.Err == nil && containsIP(check.Addrs, ip)
+		}(&res.Checks[i])
+	}
+	wg.Wait()
+
+	// The first confirmed hostname in PTR order wins
+	for _, check := range res.Checks {
+		if check.Confirmed {
+			res.Hostname = check.Hostname
+			break
+		}
+	}
+	return res
+}
+
+func isListed(hostname string, allowlist []string) bool {
+	for _, allowed := range allowlist {
+		if Matches(hostname, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether hostname matches an allowlist entry: an exact host
+// name, or a "*.suffix" pattern that matches any name below suffix (one or
+// more labels deep) but not suffix itself. Comparison is case-insensitive.
+func Matches(hostname, pattern string) bool {
+	hostname = dnsname.Canonical(hostname)
+	pattern = dnsname.Canonical(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(hostname, "."+suffix)
+	}
+	return hostname == pattern
+}
+
+func containsIP(addrs []string, ip string) bool {
+	want := net.ParseIP(ip)
+	for _, addr := range addrs {
+		if addr == ip || (want != nil && want.Equal(net.ParseIP(addr))) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+// Package history keeps an append-only log of record operations performed
+// through the API, for auditing and for debugging failed issuances.
+//
+// Entries are stored one JSON object per line, so the file can also be
+// inspected with standard tools (jq, grep, tail -f).
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/metrics"
+)
+
+// Operations recorded in Entry.Operation.
+const (
+	OpCreate = "create"
+	OpDelete = "delete"
+)
+
+// Outcomes recorded in Entry.Outcome.
+const (
+	OutcomeOK    = "ok"
+	OutcomeError = "error"
+)
+
+// maxSummary bounds the stored provider response summary.
+const maxSummary = 300
+
+// Entry is one record operation. Record values are deliberately not stored.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Domain    string    `json:"domain"`
+	Key       string    `json:"key"`
+	Mode      string    `json:"mode,omitempty"`
+	Token     string    `json:"token"`                // api.TokenID of the caller
+	TokenName string    `json:"token_name,omitempty"` // client credential name ("api" for the shared token)
+	Tenant    string    `json:"tenant,omitempty"`     // tenant of the client, if any
+	Client    string    `json:"client"`               // remote address of the caller
+	Outcome   string    `json:"outcome"`
+	Summary   string    `json:"summary,omitempty"`
+}
+
+// Store appends entries to a file. A nil *Store discards entries, so callers
+// need not check whether history is enabled.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Open returns a Store writing to path, creating the file and its directory
+// if necessary.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return &Store{path: path}, nil
+}
+
+// Path returns the file the store writes to.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Append records e, filling in the time if unset.
+func (s *Store) Append(e Entry) error {
+	if s == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if len(e.Summary) > maxSummary {
+		e.Summary = e.Summary[:maxSummary] + "…"
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Query returns up to limit entries for domain and its subdomains (all
+// domains if domain is empty) made by clients of tenant (everyone's if
+// tenant is empty), newest first.
+func (s *Store) Query(domain, tenant string, limit int) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	domain = dnsname.Canonical(domain)
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if domain != "" && !dnsname.IsSubdomain(e.Domain, domain) {
+			continue
+		}
+		if tenant != "" && e.Tenant != tenant {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Newest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// Handler serves GET /v1/records/history?domain=<domain>[&limit=<n>] to
+// callers that access admits. Callers it returns a tenant for only see the
+// entries of that tenant.
+func (s *Store) Handler(access func(r *http.Request) (tenant string, ok bool)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant, ok := access(r)
+		if !ok {
+			metrics.AuthDenials.Inc("records_history", metrics.ReasonBearer)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit := 100
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		entries, err := s.Query(r.URL.Query().Get("domain"), tenant, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read history: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if entries == nil {
+			entries = []Entry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+// Verify checks that the file can still be appended to and that every line
+// is a valid entry. A line cut short by a full disk is the typical finding.
+func (s *Store) Verify() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	corrupt, first, n := 0, 0, 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		n++
+		var e Entry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			if corrupt == 0 {
+				first = n
+			}
+			corrupt++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if corrupt > 0 {
+		return fmt.Errorf("%s: %d corrupt line(s), first at line %d", s.path, corrupt, first)
+	}
+	return nil
+}
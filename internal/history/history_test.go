@@ -0,0 +1,162 @@
+package history
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "state", "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAppendAndQuery(t *testing.T) {
+	s := openStore(t)
+	for _, e := range []Entry{
+		{Operation: OpCreate, Domain: "example.com", Key: "_acme-challenge", Outcome: OutcomeOK},
+		{Operation: OpCreate, Domain: "www.example.com", Key: "_acme-challenge", Tenant: "one", Outcome: OutcomeOK},
+		{Operation: OpDelete, Domain: "example.net", Key: "_acme-challenge", Tenant: "one", Outcome: OutcomeError, Summary: strings.Repeat("x", 2*maxSummary)},
+		{Operation: OpDelete, Domain: "example.com", Key: "_acme-challenge", Outcome: OutcomeOK},
+	} {
+		if err := s.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		domain, tenant string
+		limit          int
+		want           []string // operation and domain, newest first
+	}{
+		{"", "", 0, []string{"delete example.com", "delete example.net", "create www.example.com", "create example.com"}},
+		{"Example.COM.", "", 0, []string{"delete example.com", "create www.example.com", "create example.com"}},
+		{"example.com", "", 2, []string{"delete example.com", "create www.example.com"}},
+		{"", "one", 0, []string{"delete example.net", "create www.example.com"}},
+		{"example.org", "", 0, nil},
+	} {
+		entries, err := s.Query(tc.domain, tc.tenant, tc.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range entries {
+			if e.Time.IsZero() {
+				t.Errorf("entry %+v has no time", e)
+			}
+			got = append(got, e.Operation+" "+e.Domain)
+		}
+		if strings.Join(got, ", ") != strings.Join(tc.want, ", ") {
+			t.Errorf("Query(%q, %q, %d) = %q, want %q", tc.domain, tc.tenant, tc.limit, got, tc.want)
+		}
+	}
+
+	entries, _ := s.Query("example.net", "", 0)
+	if len(entries) != 1 || len([]rune(entries[0].Summary)) != maxSummary+1 {
+		t.Errorf("summary was not truncated to %d characters: %d", maxSummary, len(entries[0].Summary))
+	}
+}
+
+func TestNilStoreDiscards(t *testing.T) {
+	var s *Store
+	if err := s.Append(Entry{Domain: "example.com"}); err != nil {
+		t.Errorf("Append on a nil store = %v", err)
+	}
+	if err := s.Verify(); err != nil {
+		t.Errorf("Verify on a nil store = %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s := openStore(t)
+	s.Append(Entry{Operation: OpCreate, Domain: "a.example", Tenant: "one", Outcome: OutcomeOK})
+	s.Append(Entry{Operation: OpCreate, Domain: "b.example", Tenant: "two", Outcome: OutcomeOK})
+
+	access := func(r *http.Request) (string, bool) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer admin":
+			return "", true
+		case "Bearer one":
+			return "one", true
+		}
+		return "", false
+	}
+	h := s.Handler(access)
+	get := func(method, target, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	for _, tc := range []struct {
+		method, target, token string
+		want                  int
+		domains               []string
+	}{
+		{http.MethodGet, "/v1/records/history", "", http.StatusUnauthorized, nil},
+		{http.MethodPost, "/v1/records/history", "admin", http.StatusMethodNotAllowed, nil},
+		{http.MethodGet, "/v1/records/history?limit=0", "admin", http.StatusBadRequest, nil},
+		{http.MethodGet, "/v1/records/history", "admin", http.StatusOK, []string{"b.example", "a.example"}},
+		{http.MethodGet, "/v1/records/history?limit=1", "admin", http.StatusOK, []string{"b.example"}},
+		{http.MethodGet, "/v1/records/history", "one", http.StatusOK, []string{"a.example"}},
+		{http.MethodGet, "/v1/records/history?domain=b.example", "one", http.StatusOK, []string{}},
+	} {
+		w := get(tc.method, tc.target, tc.token)
+		if w.Code != tc.want {
+			t.Errorf("%s %s as %q = %d, want %d", tc.method, tc.target, tc.token, w.Code, tc.want)
+			continue
+		}
+		if tc.want != http.StatusOK {
+			continue
+		}
+		var entries []Entry
+		if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+			t.Fatalf("%s: %v", tc.target, err)
+		}
+		got := []string{}
+		for _, e := range entries {
+			got = append(got, e.Domain)
+		}
+		if strings.Join(got, ",") != strings.Join(tc.domains, ",") {
+			t.Errorf("%s as %q = %q, want %q", tc.target, tc.token, got, tc.domains)
+		}
+	}
+}
+
+func TestVerifyFindsCorruptLines(t *testing.T) {
+	s := openStore(t)
+	s.Append(Entry{Operation: OpCreate, Domain: "example.com"})
+	if err := s.Verify(); err != nil {
+		t.Fatalf("Verify on a clean file = %v", err)
+	}
+
+	// A write cut short by a full disk
+	f, err := os.OpenFile(s.Path(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"operation":"create","dom` + "\n")
+	f.Close()
+	s.Append(Entry{Operation: OpDelete, Domain: "example.com"})
+
+	err = s.Verify()
+	if err == nil || !strings.Contains(err.Error(), "1 corrupt line(s), first at line 2") {
+		t.Errorf("Verify = %v", err)
+	}
+	// Queries skip the corrupt line
+	if entries, err := s.Query("", "", 0); err != nil || len(entries) != 2 {
+		t.Errorf("Query = %d entries, %v", len(entries), err)
+	}
+}
@@ -0,0 +1,172 @@
+// Package logging routes the standard logger through log/slog in text or
+// JSON format and adds a process-wide log level, so verbose diagnostics can
+// be switched on at runtime (admin endpoint or SIGUSR1) without restarting
+// and losing in-memory state.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"acme-dns-tools/internal/bearer"
+	"acme-dns-tools/internal/metrics"
+)
+
+// Levels accepted by SetLevel.
+const (
+	LevelInfo  = "info"
+	LevelDebug = "debug"
+)
+
+// Formats accepted by Setup.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var (
+	debug     atomic.Bool
+	slogLevel = new(slog.LevelVar)
+)
+
+// Setup makes slog write records in format ("text" when empty, or "json")
+// to w and sends the standard logger through it, so every log.Printf line
+// becomes an INFO record with a timestamp and level.
+func Setup(w io.Writer, format string) error {
+	h, err := NewHandler(w, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// NewHandler returns a slog handler for format that follows the log level.
+func NewHandler(w io.Writer, format string) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: slogLevel}
+	switch format {
+	case "", FormatText:
+		return slog.NewTextHandler(w, opts), nil
+	case FormatJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (expected text or json)", format)
+	}
+}
+
+// SetLevel sets the log level to LevelInfo or LevelDebug.
+func SetLevel(level string) error {
+	switch level {
+	case LevelInfo:
+		setDebug(false)
+	case LevelDebug:
+		setDebug(true)
+	default:
+		return fmt.Errorf("unknown log level %q (expected info or debug)", level)
+	}
+	return nil
+}
+
+func setDebug(on bool) {
+	debug.Store(on)
+	if on {
+		slogLevel.Set(slog.LevelDebug)
+	} else {
+		slogLevel.Set(slog.LevelInfo)
+	}
+}
+
+// Level returns the current log level.
+func Level() string {
+	if debug.Load() {
+		return LevelDebug
+	}
+	return LevelInfo
+}
+
+// DebugEnabled reports whether debug output is on, for callers that want to
+// avoid building expensive messages.
+func DebugEnabled() bool {
+	return debug.Load()
+}
+
+// Debugf logs like log.Printf, but only at debug level.
+func Debugf(format string, args ...any) {
+	if debug.Load() {
+		slog.Debug(fmt.Sprintf(format, args...))
+	}
+}
+
+// DebugWriter returns a writer that passes output on to w only at debug
+// level, e.g. for the cPanel call trace.
+func DebugWriter(w io.Writer) io.Writer {
+	return debugWriter{w}
+}
+
+type debugWriter struct {
+	w io.Writer
+}
+
+func (dw debugWriter) Write(p []byte) (int, error) {
+	if !debug.Load() {
+		return len(p), nil
+	}
+	return dw.w.Write(p)
+}
+
+// ToggleOnSIGUSR1 flips between info and debug whenever the process receives
+// SIGUSR1.
+func ToggleOnSIGUSR1() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		for range ch {
+			setDebug(!debug.Load())
+			log.Printf("logging: level set to %s (SIGUSR1)", Level())
+		}
+	}()
+}
+
+// AdminHandler serves the log level on /admin/log-level: GET returns it,
+// PUT or POST with {"level":"debug"} changes it.
+func AdminHandler(adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !bearer.Matches(r, adminToken) {
+			metrics.AuthDenials.Inc("admin_log_level", metrics.ReasonBearer)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var req struct {
+				Level string `json:"level"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if err := SetLevel(req.Level); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("logging: level set to %s (admin endpoint)", Level())
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"level": Level()})
+	}
+}
@@ -0,0 +1,151 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// fields collects what handlers attach to the record of their request.
+type fields struct {
+	mu    sync.Mutex
+	attrs []any
+}
+
+type fieldsKey struct{}
+
+// Annotate attaches key/value pairs (as for slog.Info) to the log record of
+// r's request, e.g. the domain or the token ID once the handler knows them.
+// A key annotated again keeps its latest value. It does nothing for requests
+// not wrapped by Requests or Audit.
+func Annotate(r *http.Request, args ...any) {
+	f, ok := r.Context().Value(fieldsKey{}).(*fields)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+next:
+	for i := 0; i+1 < len(args); i += 2 {
+		for j := 0; j+1 < len(f.attrs); j += 2 {
+			if f.attrs[j] == args[i] {
+				f.attrs[j+1] = args[i+1]
+				continue next
+			}
+		}
+		f.attrs = append(f.attrs, args[i], args[i+1])
+	}
+}
+
+// withFields returns r carrying a fields holder, reusing an existing one so
+// nested wrappers log the same annotations.
+func withFields(r *http.Request) (*http.Request, *fields) {
+	if f, ok := r.Context().Value(fieldsKey{}).(*fields); ok {
+		return r, f
+	}
+	f := &fields{}
+	return r.WithContext(context.WithValue(r.Context(), fieldsKey{}, f)), f
+}
+
+// statusRecorder remembers the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(p)
+	sr.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// Outcome classifies a status code for log records: ok, denied (401/403),
+// rejected (other 4xx) or error.
+func Outcome(status int) string {
+	switch {
+	case status < 400:
+		return "ok"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "denied"
+	case status < 500:
+		return "rejected"
+	default:
+		return "error"
+	}
+}
+
+// serve runs next and returns the attributes of the finished request.
+func serve(next http.Handler, w http.ResponseWriter, r *http.Request) (int, []any) {
+	r, f := withFields(r)
+	rec := &statusRecorder{ResponseWriter: w}
+	start := time.Now()
+	next.ServeHTTP(rec, r)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	clientIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	attrs := []any{
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", rec.status,
+		"outcome", Outcome(rec.status),
+		"bytes", rec.bytes,
+		"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+		"client_ip", clientIP,
+	}
+	f.mu.Lock()
+	attrs = append(attrs, f.attrs...)
+	f.mu.Unlock()
+	return rec.status, attrs
+}
+
+// Requests logs one "request" record per request handled by next, with the
+// method, path, status, latency, client address and whatever the handler
+// attached with Annotate. Successful requests for one of the quiet paths
+// (health checks, scrapes) are only logged at debug level; they are matched
+// against the full request path, base path included.
+func Requests(next http.Handler, quiet ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, _ = withFields(r)
+		status, attrs := serve(next, w, r)
+		level := slog.LevelInfo
+		for _, q := range quiet {
+			if status < 400 && r.URL.Path == q {
+				level = slog.LevelDebug
+			}
+		}
+		slog.Log(r.Context(), level, "request", attrs...)
+	})
+}
+
+// Audit writes the same record as Requests for every request handled by
+// next to logger, e.g. a separate file kept for compliance.
+func Audit(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, attrs := serve(next, w, r)
+		logger.Info("request", attrs...)
+	})
+}
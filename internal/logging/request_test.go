@@ -0,0 +1,37 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestsQuietPaths(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	h := Requests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/fail/readyz" {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+		}
+	}), "/api/readyz", "/api/fail/readyz")
+
+	for _, tc := range []struct {
+		path, level string
+	}{
+		{"/api/readyz", "DEBUG"},
+		{"/api/fail/readyz", "INFO"},  // failures are always worth a record
+		{"/api/certs/readyz", "INFO"}, // not the health check
+		{"/readyz", "INFO"},
+	} {
+		buf.Reset()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tc.path, nil))
+		if !strings.Contains(buf.String(), "level="+tc.level) {
+			t.Errorf("%s logged %q, want level %s", tc.path, buf.String(), tc.level)
+		}
+	}
+}
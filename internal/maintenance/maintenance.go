@@ -0,0 +1,123 @@
+// Package maintenance implements an operator-controlled maintenance mode in
+// which mutating endpoints are refused with 503 while read-only endpoints
+// keep working.
+package maintenance
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"acme-dns-tools/internal/bearer"
+	"acme-dns-tools/internal/metrics"
+)
+
+// Status describes the current maintenance state.
+type Status struct {
+	Enabled bool       `json:"enabled"`
+	Reason  string     `json:"reason,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+// Mode holds the maintenance state shared by all handlers.
+type Mode struct {
+	retryAfter time.Duration
+
+	mu     sync.RWMutex
+	status Status
+}
+
+// New returns a disabled Mode. retryAfter is advertised to clients in the
+// Retry-After header of refused requests.
+func New(retryAfter time.Duration) *Mode {
+	return &Mode{retryAfter: retryAfter}
+}
+
+// Enable switches maintenance mode on.
+func (m *Mode) Enable(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.status.Enabled {
+		now := time.Now().UTC()
+		m.status.Since = &now
+	}
+	m.status.Enabled = true
+	m.status.Reason = reason
+}
+
+// Disable switches maintenance mode off.
+func (m *Mode) Disable() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status = Status{}
+}
+
+// Status returns a snapshot of the current state.
+func (m *Mode) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+// Guard wraps a mutating handler so it is refused while maintenance mode is on.
+func (m *Mode) Guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := m.Status()
+		if st.Enabled {
+			w.Header().Set("Retry-After", strconv.Itoa(int(m.retryAfter.Seconds())))
+			msg := "Service Unavailable – maintenance in progress"
+			if st.Reason != "" {
+				msg += ": " + st.Reason
+			}
+			http.Error(w, msg, http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminHandler returns the admin endpoint for maintenance mode:
+//
+//	GET    – current status
+//	POST   – enable; optional JSON body {"reason": "..."}
+//	DELETE – disable
+//
+// Requests must carry Authorization: Bearer <adminToken>.
+func (m *Mode) AdminHandler(adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !bearer.Matches(r, adminToken) {
+			metrics.AuthDenials.Inc("admin_maintenance", metrics.ReasonBearer)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req struct {
+				Reason string `json:"reason"`
+			}
+			if r.ContentLength != 0 {
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					http.Error(w, "Invalid request body", http.StatusBadRequest)
+					return
+				}
+			}
+			m.Enable(req.Reason)
+			log.Printf("maintenance: enabled (reason: %q)", req.Reason)
+		case http.MethodDelete:
+			m.Disable()
+			log.Println("maintenance: disabled")
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.Status())
+	}
+}
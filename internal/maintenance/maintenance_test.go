@@ -0,0 +1,106 @@
+package maintenance
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGuard(t *testing.T) {
+	m := New(90 * time.Second)
+	h := m.Guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("changed"))
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/set_txt", nil))
+		return w
+	}
+
+	if w := serve(); w.Code != http.StatusOK || w.Body.String() != "changed" {
+		t.Fatalf("disabled: %d %q", w.Code, w.Body.String())
+	}
+
+	m.Enable("zone migration")
+	w := serve()
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "90" {
+		t.Errorf("enabled: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(w.Body.String(), "zone migration") {
+		t.Errorf("enabled: body %q does not give the reason", w.Body.String())
+	}
+
+	m.Disable()
+	if w := serve(); w.Code != http.StatusOK {
+		t.Errorf("disabled again: %d", w.Code)
+	}
+}
+
+func TestEnableKeepsSince(t *testing.T) {
+	m := New(time.Minute)
+	m.Enable("first")
+	since := m.Status().Since
+	m.Enable("second")
+	st := m.Status()
+	if !st.Enabled || st.Reason != "second" || st.Since == nil || !st.Since.Equal(*since) {
+		t.Errorf("status after re-enabling = %+v, want reason second since %v", st, since)
+	}
+	m.Disable()
+	if st := m.Status(); st.Enabled || st.Since != nil || st.Reason != "" {
+		t.Errorf("status after disabling = %+v", st)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	m := New(time.Minute)
+	h := m.AdminHandler("admin-token")
+	call := func(method, token, body string) (*httptest.ResponseRecorder, Status) {
+		r := httptest.NewRequest(method, "/admin/maintenance", strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		var st Status
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+				t.Fatalf("%s: %v", method, err)
+			}
+		}
+		return w, st
+	}
+
+	if w, _ := call(http.MethodPost, "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("no token: %d", w.Code)
+	}
+	if w, _ := call(http.MethodPost, "api-token", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: %d", w.Code)
+	}
+	if m.Status().Enabled {
+		t.Fatal("unauthorized request enabled maintenance mode")
+	}
+
+	if w, _ := call(http.MethodPost, "admin-token", "{"); w.Code != http.StatusBadRequest {
+		t.Errorf("bad body: %d", w.Code)
+	}
+	if _, st := call(http.MethodPost, "admin-token", `{"reason":"upgrade"}`); !st.Enabled || st.Reason != "upgrade" || st.Since == nil {
+		t.Errorf("POST: %+v", st)
+	}
+	if _, st := call(http.MethodGet, "admin-token", ""); !st.Enabled || st.Reason != "upgrade" {
+		t.Errorf("GET: %+v", st)
+	}
+	if _, st := call(http.MethodDelete, "admin-token", ""); st.Enabled {
+		t.Errorf("DELETE: %+v", st)
+	}
+	if _, st := call(http.MethodPost, "admin-token", ""); !st.Enabled || st.Reason != "" {
+		t.Errorf("POST without body: %+v", st)
+	}
+
+	w, _ := call(http.MethodPut, "admin-token", "")
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, POST, DELETE" {
+		t.Errorf("PUT: %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+}
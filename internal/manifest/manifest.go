@@ -0,0 +1,180 @@
+// Package manifest reads declarative record manifests for `dns-proxy-cli apply`.
+//
+// A manifest is either JSON or the following YAML subset (block style only,
+// no anchors or multi-line scalars):
+//
+//	prune: false
+//	records:
+//	  - domain: example.com
+//	    key: _acme-challenge
+//	    value: "token-1"
+//	  - domain: example.com
+//	    key: _acme-challenge
+//	    value: "token-2"
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Record is a desired TXT record.
+type Record struct {
+	Domain string `json:"domain"`
+	Key    string `json:"key"`
+	Type   string `json:"type,omitempty"` // only TXT is supported for now
+	Value  string `json:"value"`
+}
+
+// Manifest is the desired state.
+type Manifest struct {
+	// Prune removes values of the listed names that are not in the manifest.
+	Prune   bool     `json:"prune"`
+	Records []Record `json:"records"`
+}
+
+// Load reads and validates a manifest file.
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m *Manifest
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		m = &Manifest{}
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, fmt.Errorf("invalid JSON manifest: %w", err)
+		}
+	} else if m, err = parseYAML(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	for i, rec := range m.Records {
+		if rec.Type == "" {
+			m.Records[i].Type = "TXT"
+		}
+		if rec.Domain == "" || rec.Key == "" || rec.Value == "" {
+			return nil, fmt.Errorf("record %d: domain, key and value are required", i+1)
+		}
+		if !strings.EqualFold(m.Records[i].Type, "TXT") {
+			return nil, fmt.Errorf("record %d: unsupported type %q (only TXT)", i+1, rec.Type)
+		}
+	}
+	return m, nil
+}
+
+func parseYAML(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	inRecords := false
+	var cur *Record
+
+	for scanner.Scan() {
+		lineNo++
+		raw := stripComment(scanner.Text())
+		if strings.TrimSpace(raw) == "" || strings.TrimSpace(raw) == "---" {
+			continue
+		}
+		indent := len(raw) - len(strings.TrimLeft(raw, " "))
+		line := strings.TrimSpace(raw)
+
+		if indent == 0 {
+			inRecords = false
+			key, value, ok := splitKV(line)
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key: value", lineNo)
+			}
+			switch key {
+			case "prune":
+				switch value {
+				case "true", "yes":
+					m.Prune = true
+				case "false", "no", "":
+					m.Prune = false
+				default:
+					return nil, fmt.Errorf("line %d: prune must be true or false", lineNo)
+				}
+			case "records":
+				inRecords = true
+			default:
+				return nil, fmt.Errorf("line %d: unknown key %q", lineNo, key)
+			}
+			continue
+		}
+
+		if !inRecords {
+			return nil, fmt.Errorf("line %d: unexpected indentation", lineNo)
+		}
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			m.Records = append(m.Records, Record{})
+			cur = &m.Records[len(m.Records)-1]
+			line = strings.TrimSpace(strings.TrimPrefix(line, "-"))
+			if line == "" {
+				continue
+			}
+		}
+		if cur == nil {
+			return nil, fmt.Errorf("line %d: expected a list item", lineNo)
+		}
+		key, value, ok := splitKV(line)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", lineNo)
+		}
+		switch key {
+		case "domain":
+			cur.Domain = value
+		case "key":
+			cur.Key = value
+		case "type":
+			cur.Type = value
+		case "value":
+			cur.Value = value
+		default:
+			return nil, fmt.Errorf("line %d: unknown record field %q", lineNo, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// splitKV splits "key: value" and unquotes the value.
+func splitKV(line string) (key, value string, ok bool) {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	key = strings.TrimSpace(line[:i])
+	value = strings.TrimSpace(line[i+1:])
+	if len(value) >= 2 && (value[0] == '"' && value[len(value)-1] == '"' || value[0] == '\'' && value[len(value)-1] == '\'') {
+		value = value[1 : len(value)-1]
+	}
+	return key, value, key != ""
+}
+
+// stripComment removes a " #" comment that is not inside quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,81 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeManifest(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	want := &Manifest{
+		Prune: true,
+		Records: []Record{
+			{Domain: "example.com", Key: "_acme-challenge", Type: "TXT", Value: "token #1"},
+			{Domain: "example.com", Key: "_acme-challenge", Type: "txt", Value: "token-2"},
+		},
+	}
+	for name, content := range map[string]string{
+		"records.yaml": `---
+# desired challenge records
+prune: yes
+records:
+  - domain: example.com   # the zone
+    key: _acme-challenge
+    value: "token #1"
+  -
+    domain: example.com
+    key: '_acme-challenge'
+    type: txt
+    value: token-2
+`,
+		"records.json": `{"prune": true, "records": [
+			{"domain": "example.com", "key": "_acme-challenge", "value": "token #1"},
+			{"domain": "example.com", "key": "_acme-challenge", "type": "txt", "value": "token-2"}
+		]}`,
+	} {
+		m, err := Load(writeManifest(t, name, content))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(m, want) {
+			t.Errorf("%s: Load =\n%+v\nwant\n%+v", name, m, want)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		content, want string
+	}{
+		{"records:\n  - domain: example.com\n    key: _acme-challenge\n", "record 1: domain, key and value are required"},
+		{"records:\n  - domain: example.com\n    key: www\n    type: CNAME\n    value: x\n", `record 1: unsupported type "CNAME"`},
+		{"prune: maybe\n", "line 1: prune must be true or false"},
+		{"zones:\n", `line 1: unknown key "zones"`},
+		{"prune: true\n  domain: example.com\n", "line 2: unexpected indentation"},
+		{"records:\n    domain: example.com\n", "line 2: expected a list item"},
+		{"records:\n  - domain: example.com\n    ttl: 300\n", `line 3: unknown record field "ttl"`},
+		{"records:\n  - just a value\n", "line 2: expected key: value"},
+		{`{"records": [`, "invalid JSON manifest"},
+	} {
+		_, err := Load(writeManifest(t, "records.yaml", tc.content))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Load(%q) = %v, want %q", tc.content, err, tc.want)
+		}
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Load of a missing file = %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// GaugeVec is a value that can go up and down, partitioned by labels.
+type GaugeVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu          sync.Mutex
+	values      map[string]float64  // keyed by the rendered label set
+	labelValues map[string][]string // same keys, for exporters
+}
+
+// NewGaugeVec creates a gauge and registers it for export.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{name: name, help: help, labels: labels,
+		values: make(map[string]float64), labelValues: make(map[string][]string)}
+	registry.mu.Lock()
+	registry.gauges = append(registry.gauges, g)
+	registry.mu.Unlock()
+	return g
+}
+
+// Set sets the gauge for the given label values, which must be passed in
+// the order the labels were declared.
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	if len(labelValues) != len(g.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", g.name, len(g.labels), len(labelValues)))
+	}
+	key := renderLabels(g.labels, labelValues)
+	g.mu.Lock()
+	g.values[key] = v
+	if _, ok := g.labelValues[key]; !ok {
+		g.labelValues[key] = append([]string(nil), labelValues...)
+	}
+	g.mu.Unlock()
+}
+
+// Delete removes the series for the given label values, e.g. for a domain
+// that no longer exists.
+func (g *GaugeVec) Delete(labelValues ...string) {
+	key := renderLabels(g.labels, labelValues)
+	g.mu.Lock()
+	delete(g.values, key)
+	delete(g.labelValues, key)
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) write(b *strings.Builder) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", g.name, g.help)
+	fmt.Fprintf(b, "# TYPE %s gauge\n", g.name)
+	keys := make([]string, 0, len(g.values))
+	for k := range g.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s%s %s\n", g.name, k, strconv.FormatFloat(g.values[k], 'g', -1, 64))
+	}
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets suit request and provider call durations in seconds.
+var DefaultBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// HistogramVec counts observations (e.g. durations in seconds) in
+// cumulative buckets, partitioned by labels.
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram // keyed by the rendered label set
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates a histogram with the given upper bucket bounds
+// (ascending) and registers it for export.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets,
+		series: make(map[string]*histogram)}
+	registry.mu.Lock()
+	registry.histograms = append(registry.histograms, h)
+	registry.mu.Unlock()
+	return h
+}
+
+// Observe records v for the given label values, which must be passed in the
+// order the labels were declared.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", h.name, len(h.labels), len(labelValues)))
+	}
+	key := renderLabels(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// ObserveSince records the seconds elapsed since start.
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(b *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", h.name, h.help)
+	fmt.Fprintf(b, "# TYPE %s histogram\n", h.name)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, withLabel(k, "le", strconv.FormatFloat(bound, 'g', -1, 64)), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, withLabel(k, "le", "+Inf"), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", h.name, k, strconv.FormatFloat(s.sum, 'g', -1, 64))
+		fmt.Fprintf(b, "%s_count%s %d\n", h.name, k, s.count)
+	}
+}
+
+// withLabel adds name="value" to a rendered label set.
+func withLabel(rendered, name, value string) string {
+	label := fmt.Sprintf(`%s="%s"`, name, value)
+	if rendered == "" {
+		return "{" + label + "}"
+	}
+	return strings.TrimSuffix(rendered, "}") + "," + label + "}"
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequests = NewCounterVec("dns_proxy_http_requests_total",
+		"HTTP requests handled, by endpoint and status code.", "endpoint", "code")
+	httpDuration = NewHistogramVec("dns_proxy_http_request_duration_seconds",
+		"Time to answer HTTP requests, by endpoint.", DefaultBuckets, "endpoint")
+)
+
+// Instrument counts the requests handled by next and their duration under
+// the given endpoint name (rather than the path, which would let clients
+// create arbitrary series).
+func Instrument(endpoint string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &codeRecorder{ResponseWriter: w, code: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		httpDuration.ObserveSince(start, endpoint)
+		httpRequests.Inc(endpoint, strconv.Itoa(rec.code))
+	})
+}
+
+// codeRecorder remembers the status code of a response.
+type codeRecorder struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (cr *codeRecorder) WriteHeader(code int) {
+	if !cr.wroteHeader {
+		cr.code, cr.wroteHeader = code, true
+	}
+	cr.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cr *codeRecorder) Unwrap() http.ResponseWriter {
+	return cr.ResponseWriter
+}
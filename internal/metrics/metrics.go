@@ -0,0 +1,122 @@
+// Package metrics keeps process-wide counters, gauges and histograms and
+// exposes them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Reasons used with AuthDenials.
+const (
+	ReasonBearer      = "bearer_token"
+	ReasonFCrDNS      = "fcrdns"
+	ReasonIPAllowlist = "ip_allowlist"
+	ReasonClientCert  = "client_cert"
+	ReasonScope       = "scope"
+)
+
+// AuthDenials counts requests rejected by an authentication or authorization
+// check, labeled by endpoint and by the check that rejected them.
+var AuthDenials = NewCounterVec("dns_proxy_auth_denials_total",
+	"Requests rejected by authentication or authorization checks.", "endpoint", "reason")
+
+var registry struct {
+	mu         sync.Mutex
+	counters   []*CounterVec
+	gauges     []*GaugeVec
+	histograms []*HistogramVec
+}
+
+// CounterVec is a monotonically increasing counter partitioned by labels.
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu          sync.Mutex
+	values      map[string]uint64   // keyed by the rendered label set
+	labelValues map[string][]string // same keys, for exporters
+}
+
+// NewCounterVec creates a counter and registers it for export.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels,
+		values: make(map[string]uint64), labelValues: make(map[string][]string)}
+	registry.mu.Lock()
+	registry.counters = append(registry.counters, c)
+	registry.mu.Unlock()
+	return c
+}
+
+// Inc increments the counter for the given label values, which must be passed
+// in the order the labels were declared.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter for the given label values by n.
+func (c *CounterVec) Add(n uint64, labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", c.name, len(c.labels), len(labelValues)))
+	}
+	key := renderLabels(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += n
+	if _, ok := c.labelValues[key]; !ok {
+		c.labelValues[key] = append([]string(nil), labelValues...)
+	}
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(b *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(b, "# TYPE %s counter\n", c.name)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s%s %d\n", c.name, k, c.values[k])
+	}
+}
+
+// Handler serves all registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+		registry.mu.Lock()
+		for _, c := range registry.counters {
+			c.write(&b)
+		}
+		for _, g := range registry.gauges {
+			g.write(&b)
+		}
+		for _, h := range registry.histograms {
+			h.write(&b)
+		}
+		registry.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(b.String()))
+	})
+}
+
+func renderLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf(`%s="%s"`, name, escaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxStatsDPacket keeps datagrams below common path MTUs.
+const maxStatsDPacket = 1400
+
+// StatsDConfig configures StartStatsD.
+type StatsDConfig struct {
+	Addr     string        // host:port of the StatsD daemon (UDP)
+	Prefix   string        // prepended to every metric name, e.g. "dns_proxy."
+	Interval time.Duration // flush interval
+}
+
+// StartStatsD pushes every registered metric to a StatsD daemon in the
+// background, as an alternative to scraping Handler. Each flush sends the
+// counters' increase since the previous flush and the gauges' current
+// values; label values become dot-separated name
+// components, e.g. prefix.dns_proxy_auth_denials_total.certs.fcrdns.
+func StartStatsD(cfg StatsDConfig) error {
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		sent := make(map[string]uint64)
+		for range time.Tick(cfg.Interval) {
+			if err := flushStatsD(conn, cfg.Prefix, sent); err != nil {
+				log.Printf("statsd: flush failed: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// flushStatsD sends the counter deltas since the last flush; sent holds the
+// totals already reported.
+func flushStatsD(conn net.Conn, prefix string, sent map[string]uint64) error {
+	var lines []string
+	registry.mu.Lock()
+	for _, c := range registry.counters {
+		c.mu.Lock()
+		for key, total := range c.values {
+			name := statsDName(prefix, c.name, c.labelValues[key])
+			if delta := total - sent[name]; delta > 0 {
+				lines = append(lines, fmt.Sprintf("%s:%d|c", name, delta))
+				sent[name] = total
+			}
+		}
+		c.mu.Unlock()
+	}
+	// Gauges are sent as absolute values on every flush
+	for _, g := range registry.gauges {
+		g.mu.Lock()
+		for key, v := range g.values {
+			name := statsDName(prefix, g.name, g.labelValues[key])
+			lines = append(lines, fmt.Sprintf("%s:%s|g", name, strconv.FormatFloat(v, 'f', -1, 64)))
+		}
+		g.mu.Unlock()
+	}
+	registry.mu.Unlock()
+
+	// Batch lines into as few datagrams as possible
+	var packet strings.Builder
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsDPacket {
+			if _, err := conn.Write([]byte(packet.String())); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		if _, err := conn.Write([]byte(packet.String())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statsDName builds a Graphite-safe metric path.
+func statsDName(prefix, name string, labelValues []string) string {
+	parts := []string{name}
+	for _, v := range labelValues {
+		if v == "" {
+			v = "none"
+		}
+		parts = append(parts, statsDSanitizer.Replace(v))
+	}
+	return prefix + strings.Join(parts, ".")
+}
+
+// statsDSanitizer replaces characters with special meaning in StatsD lines
+// or Graphite paths.
+var statsDSanitizer = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", " ", "_", "\n", "_", "/", "_")
@@ -0,0 +1,84 @@
+// Package netmatch matches client addresses against lists of IP addresses
+// and CIDR ranges, and recovers the client address of requests that arrive
+// through trusted reverse proxies.
+package netmatch
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// List is a set of IPv4 and IPv6 ranges; a single address is a range of one.
+type List []netip.Prefix
+
+// Parse reads a comma-separated list such as "10.8.0.0/16, 192.0.2.7, 2001:db8::/32".
+func Parse(s string) (List, error) {
+	var l List
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			p, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR range %q", item)
+			}
+			l = append(l, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q", item)
+		}
+		l = append(l, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return l, nil
+}
+
+// Contains reports whether ip (in text form) lies in one of the ranges.
+// IPv4-mapped IPv6 addresses match IPv4 ranges.
+func (l List) Contains(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range l {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustProxies makes r.RemoteAddr the address of the actual client when the
+// connection comes from one of the trusted proxies: the rightmost address in
+// X-Forwarded-For that is not itself a trusted proxy. The header is ignored
+// on connections from anywhere else, so clients cannot spoof their address.
+func TrustProxies(trusted List, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil || !trusted.Contains(host) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(forwarded[i])
+			if _, err := netip.ParseAddr(ip); err != nil {
+				break
+			}
+			if !trusted.Contains(ip) {
+				r2 := r.WithContext(r.Context())
+				r2.RemoteAddr = net.JoinHostPort(ip, "0")
+				next.ServeHTTP(w, r2)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
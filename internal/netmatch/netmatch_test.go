@@ -0,0 +1,92 @@
+package netmatch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContains(t *testing.T) {
+	l, err := Parse("10.8.0.0/16, 192.0.2.7, 2001:db8::/32, 2001:db8:ffff::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.8.0.1":         true,
+		"10.8.255.255":     true,
+		"10.9.0.0":         false,
+		"192.0.2.7":        true,
+		"192.0.2.8":        false,
+		"::ffff:10.8.1.1":  true, // IPv4-mapped
+		"2001:db8::1":      true,
+		"2001:DB8:1::":     true,
+		"2001:db9::1":      false,
+		"2001:db8:ffff::1": true,
+		"not an address":   false,
+		"":                 false,
+	} {
+		if got := l.Contains(ip); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestParseMasksHostBits(t *testing.T) {
+	l, err := Parse("10.8.3.4/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Contains("10.8.200.1") {
+		t.Error("10.8.3.4/16 does not contain 10.8.200.1")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{"10.8.0.0/33", "10.8.0", "example.com", "2001:db8::/129"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", s)
+		}
+	}
+	if l, err := Parse(" , "); err != nil || len(l) != 0 {
+		t.Errorf("Parse of an empty list = %v, %v", l, err)
+	}
+}
+
+func TestTrustProxies(t *testing.T) {
+	trusted, err := Parse("10.0.0.0/8, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"untrusted peer ignores the header", "192.0.2.1:5000", []string{"198.51.100.9"}, "192.0.2.1:5000"},
+		{"single proxy", "10.0.0.1:5000", []string{"198.51.100.9"}, "198.51.100.9:0"},
+		{"rightmost untrusted hop wins over a spoofed left entry", "10.0.0.1:5000", []string{"203.0.113.66, 198.51.100.9, 10.0.0.2"}, "198.51.100.9:0"},
+		{"repeated headers are joined", "10.0.0.1:5000", []string{"203.0.113.66", "198.51.100.9"}, "198.51.100.9:0"},
+		{"IPv6 proxy and client", "[fd00::1]:5000", []string{"2001:db8::9, fd00::2"}, "[2001:db8::9]:0"},
+		{"garbage stops the walk", "10.0.0.1:5000", []string{"198.51.100.9, junk, 10.0.0.2"}, "10.0.0.1:5000"},
+		{"only proxies in the chain", "10.0.0.1:5000", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.1:5000"},
+		{"no header", "10.0.0.1:5000", nil, "10.0.0.1:5000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := TrustProxies(trusted, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("RemoteAddr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
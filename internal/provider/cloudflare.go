@@ -0,0 +1,227 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	cloudflareAPI = "https://api.cloudflare.com/client/v4"
+	cloudflareTTL = 120
+)
+
+// cloudflareProvider writes records through the Cloudflare v4 API with a
+// scoped API token (Zone:Read and DNS:Edit on the zones it serves).
+type cloudflareProvider struct {
+	token   string
+	baseURL string
+	client  *http.Client
+}
+
+func newCloudflare(cfg map[string]string) (Provider, error) {
+	token := cfg["cloudflare_api_token"]
+	if token == "" {
+		return nil, errors.New("config incomplete: missing cloudflare_api_token")
+	}
+	return &cloudflareProvider{
+		token:   token,
+		baseURL: cloudflareAPI,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl,omitempty"`
+}
+
+// request calls the API and decodes the "result" member of the response
+// envelope into out (if non-nil).
+func (p *cloudflareProvider) request(method, path string, query url.Values, body, out any) error {
+	u := p.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloudflare request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("cloudflare response read failed: %w", err)
+	}
+
+	var envelope struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("cloudflare %s %s: HTTP %d: invalid response", method, path, resp.StatusCode)
+	}
+	if !envelope.Success {
+		var msgs []string
+		for _, e := range envelope.Errors {
+			msgs = append(msgs, fmt.Sprintf("%s (code %d)", e.Message, e.Code))
+		}
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return fmt.Errorf("cloudflare API token was rejected: %s", strings.Join(msgs, "; "))
+		}
+		return fmt.Errorf("cloudflare %s %s: HTTP %d: %s", method, path, resp.StatusCode, strings.Join(msgs, "; "))
+	}
+	if out != nil {
+		if err := json.Unmarshal(envelope.Result, out); err != nil {
+			return fmt.Errorf("cloudflare %s %s: invalid result: %w", method, path, err)
+		}
+	}
+	return nil
+}
+
+// zoneID returns the ID of the most specific zone the token can see that
+// contains name.
+func (p *cloudflareProvider) zoneID(name string) (string, error) {
+	id, _, err := p.zone(name)
+	return id, err
+}
+
+// zone returns the ID and name of the most specific zone the token can see
+// that contains name.
+func (p *cloudflareProvider) zone(name string) (string, string, error) {
+	for candidate := name; strings.Contains(candidate, "."); candidate = candidate[strings.Index(candidate, ".")+1:] {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := p.request(http.MethodGet, "/zones", url.Values{"name": {candidate}}, nil, &zones); err != nil {
+			return "", "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, candidate, nil
+		}
+	}
+	return "", "", fmt.Errorf("no Cloudflare zone found for %s", name)
+}
+
+// records returns the TXT records at name in zone.
+func (p *cloudflareProvider) records(zone, name string) ([]cloudflareRecord, error) {
+	var recs []cloudflareRecord
+	query := url.Values{"type": {"TXT"}, "name": {name}, "per_page": {"100"}}
+	err := p.request(http.MethodGet, "/zones/"+zone+"/dns_records", query, nil, &recs)
+	return recs, err
+}
+
+func (p *cloudflareProvider) CreateTxtRecord(domain, key, value string) error {
+	name := recordName(domain, key)
+	zone, err := p.zoneID(name)
+	if err != nil {
+		return err
+	}
+	rec := cloudflareRecord{Type: "TXT", Name: name, Content: value, TTL: cloudflareTTL}
+	return p.request(http.MethodPost, "/zones/"+zone+"/dns_records", nil, rec, nil)
+}
+
+func (p *cloudflareProvider) DeleteTxtRecord(domain, key, value string) error {
+	name := recordName(domain, key)
+	zone, err := p.zoneID(name)
+	if err != nil {
+		return err
+	}
+	recs, err := p.records(zone, name)
+	if err != nil {
+		return err
+	}
+	deleted := false
+	for _, rec := range recs {
+		if strings.Trim(rec.Content, `"`) != value {
+			continue
+		}
+		if err := p.request(http.MethodDelete, "/zones/"+zone+"/dns_records/"+rec.ID, nil, nil, nil); err != nil {
+			return err
+		}
+		deleted = true
+	}
+	if !deleted {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (p *cloudflareProvider) ReplaceTxtRecords(domain, key, value string) error {
+	name := recordName(domain, key)
+	zone, err := p.zoneID(name)
+	if err != nil {
+		return err
+	}
+	recs, err := p.records(zone, name)
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if err := p.request(http.MethodDelete, "/zones/"+zone+"/dns_records/"+rec.ID, nil, nil, nil); err != nil {
+			return err
+		}
+	}
+	rec := cloudflareRecord{Type: "TXT", Name: name, Content: value, TTL: cloudflareTTL}
+	return p.request(http.MethodPost, "/zones/"+zone+"/dns_records", nil, rec, nil)
+}
+
+func (p *cloudflareProvider) TxtValues(domain, key string) (string, []string, error) {
+	name := recordName(domain, key)
+	id, zone, err := p.zone(name)
+	if err != nil {
+		return "", nil, err
+	}
+	recs, err := p.records(id, name)
+	if err != nil {
+		return "", nil, err
+	}
+	values := make([]string, 0, len(recs))
+	for _, rec := range recs {
+		values = append(values, strings.Trim(rec.Content, `"`))
+	}
+	return zone, values, nil
+}
+
+// Validate checks the API token with the token verification endpoint.
+func (p *cloudflareProvider) Validate() error {
+	var result struct {
+		Status string `json:"status"`
+	}
+	if err := p.request(http.MethodGet, "/user/tokens/verify", nil, nil, &result); err != nil {
+		return err
+	}
+	if result.Status != "active" {
+		return fmt.Errorf("cloudflare API token is %s", result.Status)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"errors"
+
+	"acme-dns-tools/internal/cpanel"
+)
+
+// cpanelProvider writes records through the cPanel API, picking the account
+// per domain from the credential profiles.
+type cpanelProvider struct {
+	cfg map[string]string
+}
+
+func newCPanel(cfg map[string]string) (Provider, error) {
+	if len(cpanel.Profiles(cfg)) == 0 {
+		return nil, errors.New("config incomplete: missing url, user or apikey")
+	}
+	return &cpanelProvider{cfg: cfg}, nil
+}
+
+func (p *cpanelProvider) CreateTxtRecord(domain, key, value string) error {
+	c, err := cpanel.NewCPanelConfigForDomain(p.cfg, domain)
+	if err != nil {
+		return err
+	}
+	return c.CreateTxtRecord(domain, key, value)
+}
+
+func (p *cpanelProvider) DeleteTxtRecord(domain, key, value string) error {
+	c, err := cpanel.NewCPanelConfigForDomain(p.cfg, domain)
+	if err != nil {
+		return err
+	}
+	err = c.DeleteTxtRecord(domain, key, value)
+	if errors.Is(err, cpanel.ErrTxtRecordNotFound) {
+		return ErrRecordNotFound
+	}
+	return err
+}
+
+func (p *cpanelProvider) ReplaceTxtRecords(domain, key, value string) error {
+	c, err := cpanel.NewCPanelConfigForDomain(p.cfg, domain)
+	if err != nil {
+		return err
+	}
+	return c.ReplaceTxtRecords(domain, key, value)
+}
+
+func (p *cpanelProvider) TxtValues(domain, key string) (string, []string, error) {
+	c, err := cpanel.NewCPanelConfigForDomain(p.cfg, domain)
+	if err != nil {
+		return "", nil, err
+	}
+	values, err := c.TxtValues(domain, key)
+	return cpanel.ZoneFor(domain), values, err
+}
+
+// Validate checks every configured account.
+func (p *cpanelProvider) Validate() error {
+	for _, check := range cpanel.CheckCredentials(p.cfg) {
+		if check.Err != nil {
+			name := check.Profile
+			if name == "" {
+				name = "default"
+			}
+			return errors.New(name + ": " + check.Err.Error())
+		}
+	}
+	return nil
+}
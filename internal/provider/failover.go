@@ -0,0 +1,485 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"acme-dns-tools/internal/dnsname"
+	"acme-dns-tools/internal/metrics"
+)
+
+// A zone served by a second DNS service (e.g. a secondary that also accepts
+// dynamic updates) can get a fallback provider with its own credentials:
+//
+//	fallback.<zone>.DNS_PROVIDER=rfc2136
+//	fallback.<zone>.rfc2136_server=ns2.example.net
+//	fallback.<zone>.rfc2136_tsig_key=...
+//
+// Changes below the zone switch to the fallback after FAILOVER_AFTER
+// consecutive failures of the primary and go back to it once it works again,
+// probing it every FAILOVER_COOLDOWN seconds. The failure count, the switch
+// and which provider created each record are kept in FAILOVER_STATE_FILE,
+// so dns-proxy-cli, which runs once per change, fails over too and a record
+// is always deleted where it was created.
+const fallbackPrefix = "fallback."
+
+// Defaults of FAILOVER_AFTER and FAILOVER_COOLDOWN.
+const (
+	defaultFailoverAfter    = 3
+	defaultFailoverCooldown = 10 * time.Minute
+)
+
+// recordRetention is how long the state remembers where a record was
+// created; challenge records are deleted within minutes.
+const recordRetention = 7 * 24 * time.Hour
+
+var failovers = metrics.NewCounterVec("dns_proxy_provider_failovers_total",
+	"Switches of a zone between its primary DNS provider and its fallback (to fallback or primary).", "zone", "to")
+
+// failover sends the changes of each zone with a fallback to its fallback
+// while the primary keeps failing.
+type failover struct {
+	primary   Provider
+	fallbacks map[string]Provider // by zone
+	names     map[string]string   // provider names, by zone and "" for the primary
+	after     int
+	cooldown  time.Duration
+	webhook   string
+	client    *http.Client
+	now       func() time.Time
+	statePath string // "" keeps the state in memory
+
+	mu    sync.Mutex
+	state failoverState
+}
+
+// failoverState is what the failover remembers between changes.
+type failoverState struct {
+	Zones map[string]*zoneState `json:"zones"`
+	// Records maps the records created in zones with a fallback to the
+	// provider that created them. The keys are hashes of the name and
+	// value, so challenge values are not stored.
+	Records map[string]createdRecord `json:"records"`
+}
+
+type zoneState struct {
+	Failures   int       `json:"failures"`    // consecutive primary failures
+	FailedOver bool      `json:"failed_over"` // changes go to the fallback
+	RetryAt    time.Time `json:"retry_at"`
+}
+
+type createdRecord struct {
+	Fallback bool      `json:"fallback"`
+	Created  time.Time `json:"created"`
+}
+
+// HasFallback reports whether cfg configures a fallback provider for any zone.
+func HasFallback(cfg map[string]string) bool {
+	for k := range cfg {
+		if strings.HasPrefix(k, fallbackPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackConfigs splits the fallback.<zone>.<key> entries of cfg by zone.
+// Zones contain dots and keys do not, so the key starts after the last dot.
+func fallbackConfigs(cfg map[string]string) map[string]map[string]string {
+	zones := make(map[string]map[string]string)
+	for k, v := range cfg {
+		rest, ok := strings.CutPrefix(k, fallbackPrefix)
+		if !ok {
+			continue
+		}
+		i := strings.LastIndex(rest, ".")
+		if i <= 0 {
+			continue
+		}
+		zone := dnsname.Canonical(rest[:i])
+		if zones[zone] == nil {
+			zones[zone] = make(map[string]string)
+		}
+		zones[zone][rest[i+1:]] = v
+	}
+	return zones
+}
+
+// newFailover wraps primary with the fallbacks configured in cfg.
+func newFailover(cfg map[string]string, primary Provider) (*failover, error) {
+	f := &failover{
+		primary:   primary,
+		fallbacks: make(map[string]Provider),
+		names:     map[string]string{"": Name(cfg)},
+		after:     defaultFailoverAfter,
+		cooldown:  defaultFailoverCooldown,
+		webhook:   cfg["FAILOVER_WEBHOOK"],
+		client:    &http.Client{Timeout: 5 * time.Second},
+		now:       time.Now,
+		statePath: cfg["FAILOVER_STATE_FILE"],
+		state:     failoverState{Zones: make(map[string]*zoneState), Records: make(map[string]createdRecord)},
+	}
+	for zone, sub := range fallbackConfigs(cfg) {
+		name := Name(sub)
+		newProvider, ok := constructors[name]
+		if !ok {
+			return nil, fmt.Errorf("fallback for %s: unknown DNS_PROVIDER %q (supported: %s)", zone, name, strings.Join(Names(), ", "))
+		}
+		p, err := newProvider(sub)
+		if err != nil {
+			return nil, fmt.Errorf("fallback for %s: %s provider: %w", zone, name, err)
+		}
+		f.fallbacks[zone], f.names[zone] = p, name
+	}
+	if v := cfg["FAILOVER_AFTER"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("FAILOVER_AFTER must be a positive integer, got %q", v)
+		}
+		f.after = n
+	}
+	if v := cfg["FAILOVER_COOLDOWN"]; v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs < 1 {
+			return nil, fmt.Errorf("FAILOVER_COOLDOWN must be a positive number of seconds, got %q", v)
+		}
+		f.cooldown = time.Duration(secs) * time.Second
+	}
+	return f, nil
+}
+
+// zoneFor returns the most specific zone with a fallback that contains
+// name, or "".
+func (f *failover) zoneFor(name string) string {
+	best := ""
+	for zone := range f.fallbacks {
+		if dnsname.IsSubdomain(name, zone) && len(zone) > len(best) {
+			best = zone
+		}
+	}
+	return best
+}
+
+// load refreshes the state from FAILOVER_STATE_FILE, which other processes
+// may have changed. An unreadable file leaves the state in memory as it is.
+// Callers hold f.mu.
+func (f *failover) load() {
+	if f.statePath == "" {
+		return
+	}
+	data, err := os.ReadFile(f.statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	var st failoverState
+	if err == nil {
+		err = json.Unmarshal(data, &st)
+	}
+	if err != nil {
+		log.Printf("provider: reading failover state: %v", err)
+		return
+	}
+	if st.Zones == nil {
+		st.Zones = make(map[string]*zoneState)
+	}
+	if st.Records == nil {
+		st.Records = make(map[string]createdRecord)
+	}
+	f.state = st
+}
+
+// save writes the state to FAILOVER_STATE_FILE, replacing it atomically;
+// of two processes saving at once, the last one wins. Callers hold f.mu.
+func (f *failover) save() {
+	if f.statePath == "" {
+		return
+	}
+	for id, rec := range f.state.Records {
+		if f.now().Sub(rec.Created) > recordRetention {
+			delete(f.state.Records, id)
+		}
+	}
+	if err := writeState(f.statePath, f.state); err != nil {
+		log.Printf("provider: saving failover state: %v", err)
+	}
+}
+
+func writeState(path string, st failoverState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// zone returns the state of zone. Callers hold f.mu.
+func (f *failover) zone(zone string) *zoneState {
+	st := f.state.Zones[zone]
+	if st == nil {
+		st = &zoneState{}
+		f.state.Zones[zone] = st
+	}
+	return st
+}
+
+// recordID identifies a record in the state without storing its value.
+func recordID(domain, key, value string) string {
+	sum := sha256.Sum256([]byte(recordName(domain, key) + " " + value))
+	return hex.EncodeToString(sum[:16])
+}
+
+// do runs op against the provider currently responsible for the record and
+// reports whether that was the fallback.
+func (f *failover) do(domain, key string, op func(Provider) error) (bool, error) {
+	zone := f.zoneFor(recordName(domain, key))
+	if zone == "" {
+		return false, op(f.primary)
+	}
+
+	f.mu.Lock()
+	f.load()
+	st := f.zone(zone)
+	onFallback := st.FailedOver && f.now().Before(st.RetryAt)
+	f.mu.Unlock()
+	if onFallback {
+		return true, op(f.fallbacks[zone])
+	}
+
+	err := op(f.primary)
+	// A record that is already gone says nothing about the primary's health
+	if err == nil || errors.Is(err, ErrRecordNotFound) {
+		f.mu.Lock()
+		f.load()
+		st := f.zone(zone)
+		recovered := st.FailedOver
+		if st.Failures > 0 || recovered {
+			st.Failures, st.FailedOver = 0, false
+			f.save()
+		}
+		f.mu.Unlock()
+		if recovered {
+			f.alert(zone, "primary", nil)
+		}
+		return false, err
+	}
+
+	f.mu.Lock()
+	f.load()
+	st = f.zone(zone)
+	st.Failures++
+	switched := !st.FailedOver && st.Failures >= f.after
+	useFallback := st.FailedOver || switched
+	if useFallback {
+		// A failed probe after the cooldown goes straight back to the fallback
+		st.FailedOver, st.Failures = true, 0
+		st.RetryAt = f.now().Add(f.cooldown)
+	}
+	f.save()
+	f.mu.Unlock()
+	if !useFallback {
+		return false, err
+	}
+	if switched {
+		f.alert(zone, "fallback", err)
+	}
+	if ferr := op(f.fallbacks[zone]); ferr != nil {
+		return true, fmt.Errorf("%w; fallback %s provider: %w", err, f.names[zone], ferr)
+	}
+	return true, nil
+}
+
+// created remembers which provider created a record, for deleting it there.
+func (f *failover) created(domain, key, value string, onFallback bool) {
+	if f.zoneFor(recordName(domain, key)) == "" {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.load()
+	f.state.Records[recordID(domain, key, value)] = createdRecord{Fallback: onFallback, Created: f.now()}
+	f.save()
+}
+
+// alerts tracks failover webhooks still being delivered.
+var alerts sync.WaitGroup
+
+// WaitAlerts waits up to timeout for failover webhooks still being
+// delivered, for processes about to exit, and reports whether all were.
+func WaitAlerts(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		alerts.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// alert logs a switch of zone to the primary or fallback provider and posts
+// it to FAILOVER_WEBHOOK in the background, within the client's timeout.
+func (f *failover) alert(zone, to string, cause error) {
+	failovers.Inc(zone, to)
+	event := struct {
+		Zone     string    `json:"zone"`
+		To       string    `json:"to"`
+		Provider string    `json:"provider"`
+		Error    string    `json:"error,omitempty"`
+		Time     time.Time `json:"time"`
+	}{Zone: zone, To: to, Time: f.now().UTC()}
+	if to == "fallback" {
+		event.Provider = f.names[zone]
+		event.Error = cause.Error()
+		log.Printf("provider: ALERT %s failed %d times in a row for %s; switching to the fallback %s provider for %s: %v",
+			f.names[""], f.after, zone, f.names[zone], f.cooldown, cause)
+	} else {
+		event.Provider = f.names[""]
+		log.Printf("provider: %s works again for %s; leaving the fallback", f.names[""], zone)
+	}
+	if f.webhook == "" {
+		return
+	}
+	body, _ := json.Marshal(event)
+	alerts.Add(1)
+	go func() {
+		defer alerts.Done()
+		resp, err := f.client.Post(f.webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("provider: failover webhook failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("provider: failover webhook returned HTTP status %d", resp.StatusCode)
+		}
+	}()
+}
+
+func (f *failover) CreateTxtRecord(domain, key, value string) error {
+	onFallback, err := f.do(domain, key, func(p Provider) error {
+		return p.CreateTxtRecord(domain, key, value)
+	})
+	if err == nil {
+		f.created(domain, key, value, onFallback)
+	}
+	return err
+}
+
+// DeleteTxtRecord deletes a record with the provider that created it, so
+// one created on the fallback is not left there once the primary is back.
+// Records the state does not know go to the provider currently in use.
+func (f *failover) DeleteTxtRecord(domain, key, value string) error {
+	id := recordID(domain, key, value)
+	if zone := f.zoneFor(recordName(domain, key)); zone != "" {
+		f.mu.Lock()
+		f.load()
+		rec, known := f.state.Records[id]
+		f.mu.Unlock()
+		if known {
+			p := f.primary
+			if rec.Fallback {
+				p = f.fallbacks[zone]
+			}
+			err := p.DeleteTxtRecord(domain, key, value)
+			if err == nil || errors.Is(err, ErrRecordNotFound) {
+				f.forget(id)
+			}
+			return err
+		}
+	}
+	_, err := f.do(domain, key, func(p Provider) error {
+		return p.DeleteTxtRecord(domain, key, value)
+	})
+	return err
+}
+
+// forget drops a deleted record from the state.
+func (f *failover) forget(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.load()
+	delete(f.state.Records, id)
+	f.save()
+}
+
+func (f *failover) ReplaceTxtRecords(domain, key, value string) error {
+	onFallback, err := f.do(domain, key, func(p Provider) error {
+		r, ok := p.(Replacer)
+		if !ok {
+			return errors.New("replace mode is not supported by this DNS provider")
+		}
+		return r.ReplaceTxtRecords(domain, key, value)
+	})
+	if err == nil {
+		f.created(domain, key, value, onFallback)
+	}
+	return err
+}
+
+// TxtValues reads from the provider currently in use for the record,
+// without counting failures: dry runs do not trigger a switch.
+func (f *failover) TxtValues(domain, key string) (string, []string, error) {
+	p := f.primary
+	if zone := f.zoneFor(recordName(domain, key)); zone != "" {
+		f.mu.Lock()
+		f.load()
+		if st := f.state.Zones[zone]; st != nil && st.FailedOver {
+			p = f.fallbacks[zone]
+		}
+		f.mu.Unlock()
+	}
+	l, ok := p.(Lister)
+	if !ok {
+		return "", nil, errors.New("listing TXT records is not supported by this DNS provider")
+	}
+	return l.TxtValues(domain, key)
+}
+
+// Validate checks the primary and every fallback, so broken fallback
+// credentials show up before they are needed.
+func (f *failover) Validate() error {
+	if v, ok := f.primary.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+	for zone, p := range f.fallbacks {
+		if v, ok := p.(Validator); ok {
+			if err := v.Validate(); err != nil {
+				return fmt.Errorf("fallback for %s: %w", zone, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProvider records the values it was asked to create and delete and
+// fails while err is set.
+type fakeProvider struct {
+	err     error
+	created []string
+	deleted []string
+}
+
+func (p *fakeProvider) CreateTxtRecord(domain, key, value string) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.created = append(p.created, value)
+	return nil
+}
+
+func (p *fakeProvider) DeleteTxtRecord(domain, key, value string) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.deleted = append(p.deleted, value)
+	return nil
+}
+
+func newTestFailover(t *testing.T, cfg map[string]string) (*failover, *fakeProvider, *fakeProvider, *time.Time) {
+	t.Helper()
+	primary, fallback := &fakeProvider{}, &fakeProvider{}
+	f, err := newFailover(cfg, primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.fallbacks["example.com"] = fallback
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+	return f, primary, fallback, &now
+}
+
+func TestFailoverSwitchesAfterRepeatedFailures(t *testing.T) {
+	f, primary, fallback, now := newTestFailover(t, map[string]string{
+		"fallback.example.com.DNS_PROVIDER":   "rfc2136",
+		"fallback.example.com.rfc2136_server": "192.0.2.53",
+		"FAILOVER_AFTER":                      "2",
+		"FAILOVER_COOLDOWN":                   "60",
+	})
+	primary.err = errors.New("cPanel is down")
+
+	if err := f.CreateTxtRecord("example.com", "_acme-challenge", "one"); err == nil {
+		t.Fatal("first failure was not reported")
+	}
+	if err := f.CreateTxtRecord("www.example.com", "_acme-challenge", "two"); err != nil {
+		t.Fatalf("second failure was not retried on the fallback: %v", err)
+	}
+	if err := f.CreateTxtRecord("example.com", "_acme-challenge", "three"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fallback.created; len(got) != 2 || got[0] != "two" || got[1] != "three" {
+		t.Errorf("fallback created %q, want [two three]", got)
+	}
+
+	// Zones without a fallback are not affected
+	if err := f.CreateTxtRecord("example.org", "_acme-challenge", "four"); err == nil {
+		t.Error("example.org has no fallback but the change succeeded")
+	}
+
+	// After the cooldown the primary is probed; a failure goes straight back
+	*now = now.Add(time.Minute)
+	if err := f.CreateTxtRecord("example.com", "_acme-challenge", "five"); err != nil {
+		t.Fatalf("failed probe was not retried on the fallback: %v", err)
+	}
+	primary.err = nil
+	if err := f.CreateTxtRecord("example.com", "_acme-challenge", "six"); err != nil {
+		t.Fatal(err)
+	}
+	if len(primary.created) != 0 {
+		t.Errorf("primary used within the cooldown: %q", primary.created)
+	}
+
+	*now = now.Add(time.Minute)
+	if err := f.CreateTxtRecord("example.com", "_acme-challenge", "seven"); err != nil {
+		t.Fatal(err)
+	}
+	if got := primary.created; len(got) != 1 || got[0] != "seven" {
+		t.Errorf("primary created %q after recovering, want [seven]", got)
+	}
+	if f.state.Zones["example.com"].FailedOver {
+		t.Error("zone still failed over after the primary recovered")
+	}
+}
+
+func TestFailoverDeletesWhereCreated(t *testing.T) {
+	f, primary, fallback, now := newTestFailover(t, map[string]string{
+		"fallback.example.com.DNS_PROVIDER":   "rfc2136",
+		"fallback.example.com.rfc2136_server": "192.0.2.53",
+		"FAILOVER_AFTER":                      "1",
+		"FAILOVER_COOLDOWN":                   "60",
+	})
+	if err := f.CreateTxtRecord("example.com", "_acme-challenge", "on-primary"); err != nil {
+		t.Fatal(err)
+	}
+	primary.err = errors.New("primary down")
+	if err := f.CreateTxtRecord("example.com", "_acme-challenge", "on-fallback"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Back on the primary, the record created on the fallback is still deleted there
+	primary.err = nil
+	*now = now.Add(time.Hour)
+	if err := f.CreateTxtRecord("example.com", "_acme-challenge", "probe"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.DeleteTxtRecord("example.com", "_acme-challenge", "on-fallback"); err != nil {
+		t.Fatal(err)
+	}
+	// and while failed over, the one created on the primary is deleted there
+	primary.err = errors.New("primary down")
+	f.CreateTxtRecord("example.com", "_acme-challenge", "switch")
+	primary.err = nil
+	if err := f.DeleteTxtRecord("example.com", "_acme-challenge", "on-primary"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fallback.deleted) != 1 || fallback.deleted[0] != "on-fallback" || len(primary.deleted) != 1 || primary.deleted[0] != "on-primary" {
+		t.Errorf("primary deleted %q and fallback %q, want [on-primary] and [on-fallback]", primary.deleted, fallback.deleted)
+	}
+	if len(f.state.Records) != 2 {
+		t.Errorf("state remembers %d records, want the 2 not deleted", len(f.state.Records))
+	}
+}
+
+// TestFailoverStateFile runs every change in a new failover, as
+// dns-proxy-cli does, sharing the state through FAILOVER_STATE_FILE.
+func TestFailoverStateFile(t *testing.T) {
+	cfg := map[string]string{
+		"fallback.example.com.DNS_PROVIDER":   "rfc2136",
+		"fallback.example.com.rfc2136_server": "192.0.2.53",
+		"FAILOVER_AFTER":                      "2",
+		"FAILOVER_STATE_FILE":                 filepath.Join(t.TempDir(), "state", "failover.json"),
+	}
+	primary, fallback := &fakeProvider{err: errors.New("primary down")}, &fakeProvider{}
+	run := func() *failover {
+		f, err := newFailover(cfg, primary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.fallbacks["example.com"] = fallback
+		return f
+	}
+
+	if err := run().CreateTxtRecord("example.com", "_acme-challenge", "one"); err == nil {
+		t.Fatal("first failure was not reported")
+	}
+	if err := run().CreateTxtRecord("example.com", "_acme-challenge", "two"); err != nil {
+		t.Fatalf("second run did not fail over: %v", err)
+	}
+	primary.err = nil
+	if err := run().DeleteTxtRecord("example.com", "_acme-challenge", "two"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fallback.deleted) != 1 || len(primary.deleted) != 0 {
+		t.Errorf("primary deleted %q and fallback %q, want the record deleted on the fallback", primary.deleted, fallback.deleted)
+	}
+
+	data, err := os.ReadFile(cfg["FAILOVER_STATE_FILE"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "two") {
+		t.Errorf("state file stores record values: %s", data)
+	}
+}
+
+func TestFailoverIgnoresMissingRecords(t *testing.T) {
+	f, primary, fallback, _ := newTestFailover(t, map[string]string{
+		"fallback.example.com.DNS_PROVIDER":   "rfc2136",
+		"fallback.example.com.rfc2136_server": "192.0.2.53",
+		"FAILOVER_AFTER":                      "1",
+	})
+	primary.err = ErrRecordNotFound
+	fallback.err = errors.New("fallback must not be used")
+	if err := f.DeleteTxtRecord("example.com", "_acme-challenge", "one"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("DeleteTxtRecord = %v, want ErrRecordNotFound from the primary", err)
+	}
+}
+
+func TestFailoverReportsBothErrors(t *testing.T) {
+	f, primary, fallback, _ := newTestFailover(t, map[string]string{
+		"fallback.example.com.DNS_PROVIDER":   "rfc2136",
+		"fallback.example.com.rfc2136_server": "192.0.2.53",
+		"FAILOVER_AFTER":                      "1",
+	})
+	primary.err = errors.New("primary down")
+	fallback.err = errors.New("fallback down")
+	err := f.CreateTxtRecord("example.com", "_acme-challenge", "one")
+	if !errors.Is(err, primary.err) || !errors.Is(err, fallback.err) {
+		t.Errorf("error %q does not wrap both failures", err)
+	}
+}
+
+func TestFailoverWebhook(t *testing.T) {
+	events := make(chan map[string]string, 2)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]string
+		json.NewDecoder(r.Body).Decode(&event)
+		<-release
+		events <- event
+	}))
+	defer srv.Close()
+
+	f, primary, _, now := newTestFailover(t, map[string]string{
+		"fallback.example.com.DNS_PROVIDER":   "rfc2136",
+		"fallback.example.com.rfc2136_server": "192.0.2.53",
+		"FAILOVER_AFTER":                      "1",
+		"FAILOVER_WEBHOOK":                    srv.URL,
+	})
+	// The changes do not wait for the webhook
+	start := time.Now()
+	primary.err = errors.New("primary down")
+	f.CreateTxtRecord("example.com", "_acme-challenge", "one")
+	primary.err = nil
+	*now = now.Add(time.Hour)
+	f.CreateTxtRecord("example.com", "_acme-challenge", "two")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("changes took %s while the webhook hung", elapsed)
+	}
+	close(release)
+	if !WaitAlerts(5 * time.Second) {
+		t.Fatal("webhooks not delivered")
+	}
+
+	// Deliveries run concurrently, so match the events by direction
+	got := map[string]map[string]string{}
+	for range 2 {
+		event := <-events
+		got[event["to"]] = event
+	}
+	for _, want := range []map[string]string{
+		{"zone": "example.com", "to": "fallback", "provider": "rfc2136", "error": "primary down"},
+		{"zone": "example.com", "to": "primary", "provider": "cpanel"},
+	} {
+		for k, v := range want {
+			if got[want["to"]][k] != v {
+				t.Errorf("webhook event to %s: %s = %q, want %q", want["to"], k, got[want["to"]][k], v)
+			}
+		}
+	}
+}
+
+func TestNewWithFallback(t *testing.T) {
+	cfg := map[string]string{
+		"DNS_PROVIDER":                             "cloudflare",
+		"cloudflare_api_token":                     "cf-token",
+		"fallback.Example.com.DNS_PROVIDER":        "rfc2136",
+		"fallback.Example.com.rfc2136_server":      "192.0.2.53",
+		"fallback.Example.com.rfc2136_tsig_key":    "key",
+		"fallback.Example.com.rfc2136_tsig_secret": "c2VjcmV0",
+	}
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := p.(*failover)
+	if !ok {
+		t.Fatalf("New returned %T, want the failover wrapper", p)
+	}
+	if _, ok := f.fallbacks["example.com"].(*rfc2136Provider); !ok {
+		t.Errorf("fallback for example.com is %T, want the rfc2136 provider", f.fallbacks["example.com"])
+	}
+	if secrets := Secrets(cfg); len(secrets) != 2 {
+		t.Errorf("Secrets = %q, want the Cloudflare token and the fallback TSIG secret", secrets)
+	}
+
+	cfg["fallback.example.org.DNS_PROVIDER"] = "carrier-pigeon"
+	if _, err := New(cfg); err == nil {
+		t.Error("New accepted an unknown fallback provider")
+	}
+}
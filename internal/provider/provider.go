@@ -0,0 +1,148 @@
+// Package provider abstracts the DNS host that challenge records are written
+// to, so the same API and CLI can front cPanel, Cloudflare or any server
+// that accepts RFC 2136 dynamic updates.
+//
+// The provider is selected with DNS_PROVIDER in dns-proxy-cli.conf; its
+// credentials live in the same file:
+//
+//	DNS_PROVIDER=cpanel         # default: cpanel_url, cpanel_user, cpanel_apikey, profiles
+//	DNS_PROVIDER=cloudflare     # cloudflare_api_token
+//	DNS_PROVIDER=rfc2136        # rfc2136_server, rfc2136_tsig_key, rfc2136_tsig_secret, ...
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"acme-dns-tools/internal/config"
+)
+
+// Provider creates and deletes TXT records. The record name is
+// key + "." + domain, as everywhere else in the tools.
+type Provider interface {
+	CreateTxtRecord(domain, key, value string) error
+	DeleteTxtRecord(domain, key, value string) error
+}
+
+// Replacer is implemented by providers that can replace every value of a
+// TXT record with one value (set-txt --mode replace).
+type Replacer interface {
+	ReplaceTxtRecords(domain, key, value string) error
+}
+
+// Lister is implemented by providers that can read the current values of a
+// TXT record, which dry runs compare against. It also returns the zone the
+// record belongs to.
+type Lister interface {
+	TxtValues(domain, key string) (zone string, values []string, err error)
+}
+
+// Validator is implemented by providers that can check their credentials
+// without changing anything.
+type Validator interface {
+	Validate() error
+}
+
+// ErrRecordNotFound is returned by DeleteTxtRecord when the provider reports
+// that no record has the given name and value. Providers whose protocol
+// cannot tell (RFC 2136) treat such deletes as successful.
+var ErrRecordNotFound = errors.New("TXT record not found")
+
+// Default is the provider used when DNS_PROVIDER is not set.
+const Default = "cpanel"
+
+// Schema lists the keys of dns-proxy-cli.conf. Which credentials are
+// required depends on the provider, so New checks those; the schema makes
+// them overridable from the environment (ACME_DNS_TOOLS_CPANEL_APIKEY).
+var Schema = config.Schema{
+	{Key: "DNS_PROVIDER"},
+	{Key: "cpanel_url"},
+	{Key: "cpanel_user"},
+	{Key: "cpanel_apikey"},
+	{Key: "cpanel_proxy"},
+	{Key: "cloudflare_api_token"},
+	{Key: "rfc2136_server"},
+	{Key: "rfc2136_zone"},
+	{Key: "rfc2136_tsig_key"},
+	{Key: "rfc2136_tsig_algorithm"},
+	{Key: "rfc2136_tsig_secret"},
+	{Key: "FAILOVER_AFTER", Kind: config.Positive},
+	{Key: "FAILOVER_COOLDOWN", Kind: config.Positive},
+	{Key: "FAILOVER_WEBHOOK"},
+	{Key: "FAILOVER_STATE_FILE", Default: "/var/lib/acme-dns-tools/failover.json"},
+}
+
+var constructors = map[string]func(cfg map[string]string) (Provider, error){
+	"cpanel":     newCPanel,
+	"cloudflare": newCloudflare,
+	"rfc2136":    newRFC2136,
+}
+
+// Name returns the provider selected by cfg.
+func Name(cfg map[string]string) string {
+	if name := strings.ToLower(strings.TrimSpace(cfg["DNS_PROVIDER"])); name != "" {
+		return name
+	}
+	return Default
+}
+
+// Names returns the supported providers, sorted.
+func Names() []string {
+	names := make([]string, 0, len(constructors))
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns the provider selected by DNS_PROVIDER in cfg.
+func New(cfg map[string]string) (Provider, error) {
+	name := Name(cfg)
+	newProvider, ok := constructors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown DNS_PROVIDER %q (supported: %s)", name, strings.Join(Names(), ", "))
+	}
+	p, err := newProvider(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s provider: %w", name, err)
+	}
+	if HasFallback(cfg) {
+		f, err := newFailover(cfg, p)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	return p, nil
+}
+
+// Secrets returns the credentials in cfg of every provider, fallbacks
+// included, so callers can register them for redaction.
+func Secrets(cfg map[string]string) []string {
+	var secrets []string
+	for k, v := range cfg {
+		key := k
+		if strings.HasPrefix(k, fallbackPrefix) {
+			key = k[strings.LastIndex(k, ".")+1:]
+			if key == "cpanel_apikey" {
+				secrets = append(secrets, v)
+			}
+		}
+		if (key == "cloudflare_api_token" || key == "rfc2136_tsig_secret") && v != "" {
+			secrets = append(secrets, v)
+		}
+	}
+	return secrets
+}
+
+// recordName returns the fully qualified name of the TXT record key within
+// domain, without trailing dot.
+func recordName(domain, key string) string {
+	if key == "" {
+		return domain
+	}
+	return key + "." + domain
+}
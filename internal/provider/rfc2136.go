@@ -0,0 +1,672 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"acme-dns-tools/internal/dnsname"
+)
+
+const (
+	rfc2136TTL     = 120
+	rfc2136Timeout = 10 * time.Second
+	tsigFudge      = 300
+)
+
+// tsigAlgorithms maps the rfc2136_tsig_algorithm setting to the algorithm
+// name sent on the wire and its hash.
+var tsigAlgorithms = map[string]struct {
+	name string
+	hash func() hash.Hash
+}{
+	"hmac-sha1":   {"hmac-sha1.", sha1.New},
+	"hmac-sha256": {"hmac-sha256.", sha256.New},
+	"hmac-sha512": {"hmac-sha512.", sha512.New},
+}
+
+// rfc2136Provider sends DNS UPDATE messages (RFC 2136) over TCP to a primary
+// server, signed with TSIG (RFC 8945) when a key is configured.
+type rfc2136Provider struct {
+	server    string
+	zone      string
+	keyName   string
+	secret    []byte
+	algorithm string
+}
+
+func newRFC2136(cfg map[string]string) (Provider, error) {
+	server := cfg["rfc2136_server"]
+	if server == "" {
+		return nil, errors.New("config incomplete: missing rfc2136_server")
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	p := &rfc2136Provider{
+		server:    server,
+		zone:      dnsname.Canonical(cfg["rfc2136_zone"]),
+		keyName:   dnsname.Canonical(cfg["rfc2136_tsig_key"]),
+		algorithm: strings.ToLower(cfg["rfc2136_tsig_algorithm"]),
+	}
+	if p.algorithm == "" {
+		p.algorithm = "hmac-sha256"
+	}
+	if _, ok := tsigAlgorithms[p.algorithm]; !ok {
+		return nil, fmt.Errorf("unsupported rfc2136_tsig_algorithm %q (supported: hmac-sha1, hmac-sha256, hmac-sha512)", p.algorithm)
+	}
+	secret := cfg["rfc2136_tsig_secret"]
+	if (p.keyName == "") != (secret == "") {
+		return nil, errors.New("rfc2136_tsig_key and rfc2136_tsig_secret must be set together")
+	}
+	if secret != "" {
+		var err error
+		if p.secret, err = base64.StdEncoding.DecodeString(secret); err != nil {
+			return nil, fmt.Errorf("rfc2136_tsig_secret is not valid base64: %w", err)
+		}
+	}
+	return p, nil
+}
+
+func (p *rfc2136Provider) CreateTxtRecord(domain, key, value string) error {
+	name := recordName(domain, key)
+	return p.update(name, dnsRR{name: name, rtype: dnsTypeTXT, class: dnsClassIN, ttl: rfc2136TTL, rdata: txtRdata(value)})
+}
+
+// DeleteTxtRecord removes one value. DNS UPDATE does not say whether the
+// record existed, so a missing record is not reported.
+func (p *rfc2136Provider) DeleteTxtRecord(domain, key, value string) error {
+	name := recordName(domain, key)
+	return p.update(name, dnsRR{name: name, rtype: dnsTypeTXT, class: dnsClassNONE, rdata: txtRdata(value)})
+}
+
+// ReplaceTxtRecords deletes the TXT RRset and adds value in one message, so
+// the server applies both or neither.
+func (p *rfc2136Provider) ReplaceTxtRecords(domain, key, value string) error {
+	name := recordName(domain, key)
+	return p.update(name,
+		dnsRR{name: name, rtype: dnsTypeTXT, class: dnsClassANY},
+		dnsRR{name: name, rtype: dnsTypeTXT, class: dnsClassIN, ttl: rfc2136TTL, rdata: txtRdata(value)},
+	)
+}
+
+// TxtValues queries the server for the TXT record, so dry runs see the
+// primary's data rather than a possibly stale resolver cache.
+func (p *rfc2136Provider) TxtValues(domain, key string) (string, []string, error) {
+	name := recordName(domain, key)
+	zone, err := p.zoneFor(name)
+	if err != nil {
+		return "", nil, err
+	}
+	_, resp, err := p.exchange(newDNSMessage(dnsOpQuery, dnsRR{name: name, rtype: dnsTypeTXT, class: dnsClassIN}, nil))
+	var rcodeErr *dnsRcodeError
+	if errors.As(err, &rcodeErr) && rcodeErr.rcode == dnsRcodeNXDomain {
+		return zone, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	values, err := txtAnswers(resp, name)
+	if err != nil {
+		return "", nil, fmt.Errorf("rfc2136 server %s: %w", p.server, err)
+	}
+	return zone, values, nil
+}
+
+// Validate sends a (signed) SOA query for rfc2136_zone, which fails with
+// NOTAUTH when the server rejects the key. Without a configured zone it only
+// checks that the server accepts connections.
+func (p *rfc2136Provider) Validate() error {
+	if p.zone == "" {
+		conn, err := net.DialTimeout("tcp", p.server, rfc2136Timeout)
+		if err != nil {
+			return fmt.Errorf("rfc2136 server %s: %w", p.server, err)
+		}
+		return conn.Close()
+	}
+	resp, _, err := p.exchange(newDNSMessage(dnsOpQuery, dnsRR{name: p.zone, rtype: dnsTypeSOA, class: dnsClassIN}, nil))
+	if err != nil {
+		return err
+	}
+	if resp.ancount == 0 {
+		return fmt.Errorf("rfc2136 server %s is not authoritative for %s", p.server, p.zone)
+	}
+	return nil
+}
+
+// update sends one UPDATE message for the zone containing name.
+func (p *rfc2136Provider) update(name string, rrs ...dnsRR) error {
+	zone, err := p.zoneFor(name)
+	if err != nil {
+		return err
+	}
+	_, _, err = p.exchange(newDNSMessage(dnsOpUpdate, dnsRR{name: zone, rtype: dnsTypeSOA, class: dnsClassIN}, rrs))
+	return err
+}
+
+// zoneFor returns rfc2136_zone, or otherwise the closest enclosing name the
+// server answers an SOA query for.
+func (p *rfc2136Provider) zoneFor(name string) (string, error) {
+	if p.zone != "" {
+		if !dnsname.IsSubdomain(name, p.zone) {
+			return "", fmt.Errorf("%s is not in rfc2136_zone %s", name, p.zone)
+		}
+		return p.zone, nil
+	}
+	for candidate := name; strings.Contains(candidate, "."); candidate = candidate[strings.Index(candidate, ".")+1:] {
+		resp, _, err := p.exchange(newDNSMessage(dnsOpQuery, dnsRR{name: candidate, rtype: dnsTypeSOA, class: dnsClassIN}, nil))
+		var rcodeErr *dnsRcodeError
+		if errors.As(err, &rcodeErr) && rcodeErr.rcode != dnsRcodeNotAuth {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if resp.ancount > 0 {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("rfc2136 server %s is not authoritative for any zone containing %s", p.server, name)
+}
+
+// exchange signs msg, sends it over TCP and returns the response header and
+// message. A response code other than NOERROR is returned as a
+// *dnsRcodeError.
+func (p *rfc2136Provider) exchange(msg *dnsMessage) (dnsHeader, []byte, error) {
+	wire, err := msg.pack()
+	if err != nil {
+		return dnsHeader{}, nil, err
+	}
+	var requestMAC []byte
+	if p.keyName != "" {
+		if wire, requestMAC, err = p.sign(wire, msg.id, time.Now()); err != nil {
+			return dnsHeader{}, nil, err
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", p.server, rfc2136Timeout)
+	if err != nil {
+		return dnsHeader{}, nil, fmt.Errorf("rfc2136 server %s: %w", p.server, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(rfc2136Timeout))
+
+	out := make([]byte, 2, 2+len(wire))
+	binary.BigEndian.PutUint16(out, uint16(len(wire)))
+	if _, err := conn.Write(append(out, wire...)); err != nil {
+		return dnsHeader{}, nil, fmt.Errorf("rfc2136 server %s: %w", p.server, err)
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return dnsHeader{}, nil, fmt.Errorf("rfc2136 server %s: %w", p.server, err)
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return dnsHeader{}, nil, fmt.Errorf("rfc2136 server %s: %w", p.server, err)
+	}
+
+	hdr, err := parseDNSHeader(resp)
+	if err != nil {
+		return dnsHeader{}, nil, fmt.Errorf("rfc2136 server %s: %w", p.server, err)
+	}
+	if hdr.id != msg.id {
+		return dnsHeader{}, nil, fmt.Errorf("rfc2136 server %s: response ID mismatch", p.server)
+	}
+	if p.keyName != "" {
+		signed, err := p.verify(resp, requestMAC, time.Now())
+		if err != nil {
+			return dnsHeader{}, nil, fmt.Errorf("rfc2136 server %s: %w", p.server, err)
+		}
+		// An error response may come unsigned, e.g. FORMERR for a message
+		// the server could not parse, but success must be authenticated
+		if !signed && hdr.rcode == 0 {
+			return dnsHeader{}, nil, fmt.Errorf("rfc2136 server %s: response is not signed with TSIG", p.server)
+		}
+	}
+	if hdr.rcode != 0 {
+		return hdr, resp, &dnsRcodeError{rcode: hdr.rcode}
+	}
+	return hdr, resp, nil
+}
+
+// sign appends a TSIG record to the packed message wire (RFC 8945 §4.3)
+// and returns the signed message and its MAC, which the MAC of the
+// response covers.
+func (p *rfc2136Provider) sign(wire []byte, id uint16, now time.Time) ([]byte, []byte, error) {
+	keyName, err := packName(p.keyName)
+	if err != nil {
+		return nil, nil, err
+	}
+	algName, err := packName(tsigAlgorithms[p.algorithm].name)
+	if err != nil {
+		return nil, nil, err
+	}
+	t := tsigRR{algorithm: algName, timeSigned: uint64(now.Unix()), fudge: tsigFudge, origID: id}
+	t.mac = p.tsigMAC(nil, wire, keyName, t)
+
+	rdata := append([]byte{}, algName...)
+	rdata = append(rdata, t.timers()...)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(t.mac)))
+	rdata = append(rdata, t.mac...)
+	rdata = binary.BigEndian.AppendUint16(rdata, id)
+	rdata = append(rdata, 0, 0, 0, 0) // error, other len
+
+	out := append([]byte{}, wire...)
+	out = append(out, keyName...)
+	out = binary.BigEndian.AppendUint16(out, dnsTypeTSIG)
+	out = binary.BigEndian.AppendUint16(out, dnsClassANY)
+	out = binary.BigEndian.AppendUint32(out, 0)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(rdata)))
+	out = append(out, rdata...)
+	binary.BigEndian.PutUint16(out[10:], binary.BigEndian.Uint16(out[10:])+1) // ARCOUNT
+	return out, t.mac, nil
+}
+
+// verify checks the TSIG record of a response to a request signed with
+// requestMAC (RFC 8945 §5.3). It reports whether the response was signed at
+// all; a signature that is present but wrong, stale or made with another key
+// is an error.
+func (p *rfc2136Provider) verify(resp, requestMAC []byte, now time.Time) (bool, error) {
+	msg, t, err := splitTSIG(resp)
+	if err != nil || t == nil {
+		return false, err
+	}
+	keyName, err := packName(p.keyName)
+	if err != nil {
+		return true, err
+	}
+	algName, err := packName(tsigAlgorithms[p.algorithm].name)
+	if err != nil {
+		return true, err
+	}
+	if !bytes.Equal(t.keyName, keyName) || !bytes.Equal(t.algorithm, algName) {
+		return true, errors.New("response is signed with another TSIG key")
+	}
+	// The server sends no MAC along with BADSIG and BADKEY
+	if t.errorCode != 0 {
+		name, ok := tsigErrorNames[t.errorCode]
+		if !ok {
+			name = fmt.Sprintf("TSIG error %d", t.errorCode)
+		}
+		return true, fmt.Errorf("server rejected the TSIG signature: %s", name)
+	}
+
+	// The MAC may be truncated, but not below half the hash or 10 bytes (§5.2.2.1)
+	sum := p.tsigMAC(requestMAC, msg, keyName, *t)
+	if len(t.mac) > len(sum) || len(t.mac) < max(10, len(sum)/2) || !hmac.Equal(t.mac, sum[:len(t.mac)]) {
+		return true, errors.New("response TSIG signature does not verify")
+	}
+	if skew := int64(t.timeSigned) - now.Unix(); skew > int64(t.fudge) || -skew > int64(t.fudge) {
+		return true, fmt.Errorf("response TSIG time is %ds off, more than the allowed %ds", skew, t.fudge)
+	}
+	return true, nil
+}
+
+// tsigMAC computes the MAC of msg, which must not contain the TSIG record
+// and carry the original ID (RFC 8945 §4.3.3). For a response, requestMAC is
+// the MAC of the request it answers.
+func (p *rfc2136Provider) tsigMAC(requestMAC, msg, keyName []byte, t tsigRR) []byte {
+	mac := hmac.New(tsigAlgorithms[p.algorithm].hash, p.secret)
+	if requestMAC != nil {
+		mac.Write(binary.BigEndian.AppendUint16(nil, uint16(len(requestMAC))))
+		mac.Write(requestMAC)
+	}
+	mac.Write(msg)
+	mac.Write(keyName)
+	mac.Write(binary.BigEndian.AppendUint16(nil, dnsClassANY))
+	mac.Write([]byte{0, 0, 0, 0}) // TTL
+	mac.Write(t.algorithm)
+	mac.Write(t.timers())
+	mac.Write(binary.BigEndian.AppendUint16(nil, t.errorCode))
+	mac.Write(binary.BigEndian.AppendUint16(nil, uint16(len(t.other))))
+	mac.Write(t.other)
+	return mac.Sum(nil)
+}
+
+// tsigRR is the TSIG record of a message, with names in uncompressed wire
+// form.
+type tsigRR struct {
+	keyName    []byte
+	algorithm  []byte
+	timeSigned uint64 // 48 bit
+	fudge      uint16
+	mac        []byte
+	origID     uint16
+	errorCode  uint16
+	other      []byte
+}
+
+// timers returns the time signed and fudge fields.
+func (t tsigRR) timers() []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(t.timeSigned>>32))
+	b = binary.BigEndian.AppendUint32(b, uint32(t.timeSigned))
+	return binary.BigEndian.AppendUint16(b, t.fudge)
+}
+
+var tsigErrorNames = map[uint16]string{
+	16: "BADSIG",
+	17: "BADKEY",
+	18: "BADTIME",
+	22: "BADTRUNC",
+}
+
+// splitTSIG returns msg without its TSIG record, ARCOUNT decremented and the
+// original ID restored, as the MAC covers it, along with the record. The
+// record is nil if the last additional record is not a TSIG.
+func splitTSIG(msg []byte) ([]byte, *tsigRR, error) {
+	errMalformed := errors.New("malformed DNS response")
+	if len(msg) < 12 {
+		return nil, nil, errMalformed
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	rrcount := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	if rrcount == 0 || binary.BigEndian.Uint16(msg[10:]) == 0 {
+		return msg, nil, nil
+	}
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		_, next, err := readName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return nil, nil, errMalformed
+		}
+		off = next + 4
+	}
+	start := off
+	var rdata []byte
+	var rtype uint16
+	var owner []byte
+	for i := 0; i < rrcount; i++ {
+		start = off
+		name, next, err := readName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			return nil, nil, errMalformed
+		}
+		rtype = binary.BigEndian.Uint16(msg[next:])
+		rdlen := int(binary.BigEndian.Uint16(msg[next+8:]))
+		if next+10+rdlen > len(msg) {
+			return nil, nil, errMalformed
+		}
+		owner, rdata = name, msg[next+10:next+10+rdlen]
+		off = next + 10 + rdlen
+	}
+	if rtype != dnsTypeTSIG {
+		return msg, nil, nil
+	}
+
+	// Algorithm names are never compressed (RFC 8945 §4.2)
+	t := &tsigRR{keyName: owner}
+	_, n, err := readName(rdata, 0)
+	if err != nil || n+10 > len(rdata) {
+		return nil, nil, errMalformed
+	}
+	t.algorithm = rdata[:n]
+	t.timeSigned = uint64(binary.BigEndian.Uint16(rdata[n:]))<<32 | uint64(binary.BigEndian.Uint32(rdata[n+2:]))
+	t.fudge = binary.BigEndian.Uint16(rdata[n+6:])
+	macLen := int(binary.BigEndian.Uint16(rdata[n+8:]))
+	n += 10
+	if n+macLen+6 > len(rdata) {
+		return nil, nil, errMalformed
+	}
+	t.mac = rdata[n : n+macLen]
+	n += macLen
+	t.origID = binary.BigEndian.Uint16(rdata[n:])
+	t.errorCode = binary.BigEndian.Uint16(rdata[n+2:])
+	otherLen := int(binary.BigEndian.Uint16(rdata[n+4:]))
+	if n+6+otherLen != len(rdata) {
+		return nil, nil, errMalformed
+	}
+	t.other = rdata[n+6:]
+
+	stripped := append([]byte{}, msg[:start]...)
+	binary.BigEndian.PutUint16(stripped[0:], t.origID)
+	binary.BigEndian.PutUint16(stripped[10:], binary.BigEndian.Uint16(stripped[10:])-1)
+	return stripped, t, nil
+}
+
+// readName decodes the possibly compressed name at off in msg into
+// uncompressed lowercase wire form, and returns the offset after it.
+func readName(msg []byte, off int) ([]byte, int, error) {
+	var name []byte
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return nil, 0, errors.New("truncated name")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return append(name, 0), end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return nil, 0, errors.New("invalid name compression")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		case l > 63 || off+1+l > len(msg):
+			return nil, 0, errors.New("invalid label")
+		default:
+			name = append(name, byte(l))
+			name = append(name, bytes.ToLower(msg[off+1:off+1+l])...)
+			off += 1 + l
+		}
+	}
+}
+
+// DNS wire format, limited to what UPDATE and SOA queries need.
+
+const (
+	dnsOpQuery  = 0
+	dnsOpUpdate = 5
+
+	dnsTypeSOA  = 6
+	dnsTypeTXT  = 16
+	dnsTypeTSIG = 250
+
+	dnsClassIN   = 1
+	dnsClassNONE = 254
+	dnsClassANY  = 255
+)
+
+type dnsRR struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+	rdata []byte
+}
+
+// dnsMessage is a query (question in first) or an update (zone in first,
+// updates in rrs).
+type dnsMessage struct {
+	id     uint16
+	opcode int
+	first  dnsRR
+	rrs    []dnsRR
+}
+
+func newDNSMessage(opcode int, first dnsRR, rrs []dnsRR) *dnsMessage {
+	var id [2]byte
+	rand.Read(id[:])
+	return &dnsMessage{id: binary.BigEndian.Uint16(id[:]), opcode: opcode, first: first, rrs: rrs}
+}
+
+func (m *dnsMessage) pack() ([]byte, error) {
+	b := binary.BigEndian.AppendUint16(nil, m.id)
+	b = binary.BigEndian.AppendUint16(b, uint16(m.opcode)<<11)
+	b = binary.BigEndian.AppendUint16(b, 1) // QDCOUNT / ZOCOUNT
+	b = binary.BigEndian.AppendUint16(b, 0) // ANCOUNT / PRCOUNT
+	b = binary.BigEndian.AppendUint16(b, uint16(len(m.rrs)))
+	b = binary.BigEndian.AppendUint16(b, 0) // ARCOUNT
+
+	name, err := packName(m.first.name)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, name...)
+	b = binary.BigEndian.AppendUint16(b, m.first.rtype)
+	b = binary.BigEndian.AppendUint16(b, m.first.class)
+	for _, rr := range m.rrs {
+		name, err := packName(rr.name)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, name...)
+		b = binary.BigEndian.AppendUint16(b, rr.rtype)
+		b = binary.BigEndian.AppendUint16(b, rr.class)
+		b = binary.BigEndian.AppendUint32(b, rr.ttl)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(rr.rdata)))
+		b = append(b, rr.rdata...)
+	}
+	return b, nil
+}
+
+// packName encodes a domain name as uncompressed, lowercase labels.
+func packName(name string) ([]byte, error) {
+	name = dnsname.Canonical(name)
+	var b []byte
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > 63 {
+				return nil, fmt.Errorf("invalid DNS name %q", name)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0), nil
+}
+
+// txtRdata encodes value as TXT character-strings of at most 255 bytes.
+func txtRdata(value string) []byte {
+	var b []byte
+	for {
+		chunk := value
+		if len(chunk) > 255 {
+			chunk = chunk[:255]
+		}
+		b = append(b, byte(len(chunk)))
+		b = append(b, chunk...)
+		value = value[len(chunk):]
+		if value == "" {
+			return b
+		}
+	}
+}
+
+// txtAnswers returns the values of the TXT records at name in the answer
+// section of msg, each with its character-strings joined.
+func txtAnswers(msg []byte, name string) ([]string, error) {
+	errMalformed := errors.New("malformed DNS response")
+	want, err := packName(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) < 12 {
+		return nil, errMalformed
+	}
+	off := 12
+	for i := binary.BigEndian.Uint16(msg[4:]); i > 0; i-- {
+		_, next, err := readName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return nil, errMalformed
+		}
+		off = next + 4
+	}
+	var values []string
+	for i := binary.BigEndian.Uint16(msg[6:]); i > 0; i-- {
+		owner, next, err := readName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			return nil, errMalformed
+		}
+		rtype := binary.BigEndian.Uint16(msg[next:])
+		rdata := msg[next+10:]
+		rdlen := int(binary.BigEndian.Uint16(msg[next+8:]))
+		if rdlen > len(rdata) {
+			return nil, errMalformed
+		}
+		rdata, off = rdata[:rdlen], next+10+rdlen
+		if rtype != dnsTypeTXT || !bytes.Equal(owner, want) {
+			continue
+		}
+		var value []byte
+		for len(rdata) > 0 {
+			l := int(rdata[0])
+			if 1+l > len(rdata) {
+				return nil, errMalformed
+			}
+			value = append(value, rdata[1:1+l]...)
+			rdata = rdata[1+l:]
+		}
+		values = append(values, string(value))
+	}
+	return values, nil
+}
+
+type dnsHeader struct {
+	id      uint16
+	rcode   int
+	ancount uint16
+}
+
+func parseDNSHeader(b []byte) (dnsHeader, error) {
+	if len(b) < 12 {
+		return dnsHeader{}, errors.New("short DNS response")
+	}
+	return dnsHeader{
+		id:      binary.BigEndian.Uint16(b[0:]),
+		rcode:   int(binary.BigEndian.Uint16(b[2:]) & 0xf),
+		ancount: binary.BigEndian.Uint16(b[6:]),
+	}, nil
+}
+
+const (
+	dnsRcodeNXDomain = 3
+	dnsRcodeNotAuth  = 9
+)
+
+var dnsRcodeNames = map[int]string{
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+}
+
+type dnsRcodeError struct {
+	rcode int
+}
+
+func (e *dnsRcodeError) Error() string {
+	name, ok := dnsRcodeNames[e.rcode]
+	if !ok {
+		name = fmt.Sprintf("rcode %d", e.rcode)
+	}
+	if e.rcode == dnsRcodeNotAuth {
+		return "rfc2136 server answered NOTAUTH (TSIG key rejected or server not authoritative)"
+	}
+	return "rfc2136 server answered " + name
+}
@@ -0,0 +1,211 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The golden messages below were computed independently from the RFC 2136
+// and RFC 8945 layouts: an UPDATE replacing the TXT records of
+// _acme-challenge.example.com with "token-value", signed with
+// hmac-sha256 key update-key.example.com at time 1700000000, and the
+// server's signed NOERROR answer one second later.
+const (
+	goldenSecret   = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	goldenUpdate   = "123428000001000000020000076578616d706c6503636f6d00000600010f5f61636d652d6368616c6c656e6765076578616d706c6503636f6d00001000ff0000000000000f5f61636d652d6368616c6c656e6765076578616d706c6503636f6d000010000100000078000c0b746f6b656e2d76616c7565"
+	goldenMAC      = "e875cbfb2285c10076f50f9fcaf0741c1a05042c8900622bd3744c7c87b0346e"
+	goldenSigned   = "123428000001000000020001076578616d706c6503636f6d00000600010f5f61636d652d6368616c6c656e6765076578616d706c6503636f6d00001000ff0000000000000f5f61636d652d6368616c6c656e6765076578616d706c6503636f6d000010000100000078000c0b746f6b656e2d76616c75650a7570646174652d6b6579076578616d706c6503636f6d0000fa00ff00000000003d0b686d61632d7368613235360000006553f100012c0020e875cbfb2285c10076f50f9fcaf0741c1a05042c8900622bd3744c7c87b0346e123400000000"
+	goldenResponse = "1234a8000001000000000001076578616d706c6503636f6d00000600010a7570646174652d6b6579076578616d706c6503636f6d0000fa00ff00000000003d0b686d61632d7368613235360000006553f101012c0020210fc6a8927e32f3f711088de4eddfba7270cc12c3401d2d0e568883f29aec2d123400000000"
+)
+
+var goldenTime = time.Unix(1700000000, 0)
+
+func goldenProvider(t *testing.T) *rfc2136Provider {
+	t.Helper()
+	p, err := newRFC2136(map[string]string{
+		"rfc2136_server":      "192.0.2.53",
+		"rfc2136_zone":        "example.com",
+		"rfc2136_tsig_key":    "update-key.example.com.",
+		"rfc2136_tsig_secret": goldenSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.(*rfc2136Provider)
+}
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPackUpdate(t *testing.T) {
+	name := recordName("example.com", "_acme-challenge")
+	msg := &dnsMessage{
+		id:     0x1234,
+		opcode: dnsOpUpdate,
+		first:  dnsRR{name: "example.com", rtype: dnsTypeSOA, class: dnsClassIN},
+		rrs: []dnsRR{
+			{name: name, rtype: dnsTypeTXT, class: dnsClassANY},
+			{name: name, rtype: dnsTypeTXT, class: dnsClassIN, ttl: rfc2136TTL, rdata: txtRdata("token-value")},
+		},
+	}
+	got, err := msg.pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := unhex(t, goldenUpdate); !bytes.Equal(got, want) {
+		t.Errorf("pack =\n%x\nwant\n%x", got, want)
+	}
+}
+
+func TestSign(t *testing.T) {
+	p := goldenProvider(t)
+	signed, mac, err := p.sign(unhex(t, goldenUpdate), 0x1234, goldenTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := unhex(t, goldenMAC); !bytes.Equal(mac, want) {
+		t.Errorf("MAC = %x, want %x", mac, want)
+	}
+	if want := unhex(t, goldenSigned); !bytes.Equal(signed, want) {
+		t.Errorf("signed message =\n%x\nwant\n%x", signed, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	p := goldenProvider(t)
+	requestMAC := unhex(t, goldenMAC)
+	now := goldenTime.Add(2 * time.Second)
+
+	signed, err := p.verify(unhex(t, goldenResponse), requestMAC, now)
+	if err != nil || !signed {
+		t.Fatalf("verify of the golden response = %v, %v; want signed and valid", signed, err)
+	}
+
+	// The key name may be compressed to a pointer at the zone name
+	compressed := strings.Replace(goldenResponse, "0a7570646174652d6b6579076578616d706c6503636f6d00", "0a7570646174652d6b6579c00c", 1)
+	if _, err := p.verify(unhex(t, compressed), requestMAC, now); err != nil {
+		t.Errorf("verify with a compressed key name: %v", err)
+	}
+
+	unsigned := unhex(t, goldenResponse[:58])
+	unsigned[11] = 0 // ARCOUNT
+	if signed, err := p.verify(unsigned, requestMAC, now); signed || err != nil {
+		t.Errorf("verify of an unsigned response = %v, %v; want unsigned without error", signed, err)
+	}
+
+	tests := []struct {
+		name    string
+		resp    []byte
+		request []byte
+		now     time.Time
+		want    string
+	}{
+		{"tampered message", flip(unhex(t, goldenResponse), 3), requestMAC, now, "does not verify"},
+		{"tampered MAC", flip(unhex(t, goldenResponse), 100), requestMAC, now, "does not verify"},
+		{"answer to another request", unhex(t, goldenResponse), flip(requestMAC, 0), now, "does not verify"},
+		{"stale signature", unhex(t, goldenResponse), requestMAC, goldenTime.Add(10 * time.Minute), "time is -599s off"},
+		{"BADSIG", badsig(t), requestMAC, now, "BADSIG"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.verify(tt.resp, tt.request, tt.now)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("verify = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+
+	other := goldenProvider(t)
+	other.secret = []byte("another secret")
+	if _, err := other.verify(unhex(t, goldenResponse), requestMAC, now); err == nil {
+		t.Error("verify with another secret succeeded")
+	}
+	other = goldenProvider(t)
+	other.keyName = "other-key.example.com"
+	if _, err := other.verify(unhex(t, goldenResponse), requestMAC, now); err == nil || !strings.Contains(err.Error(), "another TSIG key") {
+		t.Errorf("verify with another key name = %v", err)
+	}
+}
+
+// badsig is a NOTAUTH answer carrying TSIG error BADSIG and no MAC.
+func badsig(t *testing.T) []byte {
+	t.Helper()
+	hdr := "1234a8090001000000000001076578616d706c6503636f6d0000060001"
+	tsig := "0a7570646174652d6b6579076578616d706c6503636f6d0000fa00ff00000000001d0b686d61632d7368613235360000006553f101012c0000123400100000"
+	return unhex(t, hdr+tsig)
+}
+
+func flip(b []byte, i int) []byte {
+	b = append([]byte{}, b...)
+	b[i] ^= 1
+	return b
+}
+
+// TestExchangeRejectsUnsignedSuccess makes sure a NOERROR answer without a
+// TSIG, e.g. from an attacker on the path, is not taken as applied.
+func TestExchangeRejectsUnsignedSuccess(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		resp := append([]byte{0, 12}, req[:12]...)
+		resp[4] |= 0x80 // QR
+		clear(resp[6:]) // no records
+		conn.Write(resp)
+	}()
+
+	p := goldenProvider(t)
+	p.server = ln.Addr().String()
+	err = p.ReplaceTxtRecords("example.com", "_acme-challenge", "token-value")
+	if err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Errorf("ReplaceTxtRecords = %v, want an unsigned response error", err)
+	}
+}
+
+func TestTxtAnswers(t *testing.T) {
+	// Answer for _acme-challenge.example.com TXT: one value split over two
+	// character-strings, one plain value under a compressed owner name and
+	// an unrelated CNAME.
+	msg := unhex(t, "123484000001000300000000"+
+		"0f5f61636d652d6368616c6c656e6765076578616d706c6503636f6d0000100001"+
+		"c00c00100001000000780008036f6e650374776f"+
+		"0f5f61636d652d6368616c6c656e6765076578616d706c6503636f6d0000100001000000780006057468726565"+
+		"c00c00050001000000780002c01c")
+	got, err := txtAnswers(msg, "_acme-challenge.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"onetwo", "three"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("txtAnswers = %q, want %q", got, want)
+	}
+	if _, err := txtAnswers(msg[:len(msg)-3], "_acme-challenge.example.com"); err == nil {
+		t.Error("txtAnswers accepted a truncated message")
+	}
+}
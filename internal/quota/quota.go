@@ -0,0 +1,103 @@
+// Package quota enforces per-token operation budgets over fixed hourly and
+// daily windows, limiting what a leaked automation credential can do.
+package quota
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"acme-dns-tools/internal/metrics"
+)
+
+// Operation classes that can be limited.
+const (
+	OpMutation     = "mutation"
+	OpCertDownload = "cert_download"
+)
+
+var (
+	consumed = metrics.NewCounterVec("dns_proxy_quota_consumed_total",
+		"Operations counted against a token's quota.", "token", "operation")
+	exceeded = metrics.NewCounterVec("dns_proxy_quota_exceeded_total",
+		"Operations rejected because a token's quota was exhausted.", "token", "operation")
+)
+
+// Limits is the budget for one operation class. Zero means unlimited.
+type Limits struct {
+	Hourly int
+	Daily  int
+}
+
+// Limiter tracks usage per token and operation class.
+type Limiter struct {
+	limits map[string]Limits
+	now    func() time.Time
+
+	mu    sync.Mutex
+	usage map[string]*usage
+}
+
+type usage struct {
+	hour      time.Time
+	hourCount int
+	day       time.Time
+	dayCount  int
+}
+
+// New returns a Limiter enforcing limits, keyed by operation class.
+func New(limits map[string]Limits) *Limiter {
+	return &Limiter{limits: limits, now: time.Now, usage: make(map[string]*usage)}
+}
+
+// Allow consumes one unit of op for tokenID. When the budget is exhausted it
+// returns false and the time until the exhausted window resets.
+func (l *Limiter) Allow(tokenID, op string) (bool, time.Duration) {
+	return l.AllowWithin(tokenID, op, l.limits[op])
+}
+
+// AllowWithin is Allow with limits of its own instead of those of the
+// Limiter, for budgets shared by several tokens (e.g. "tenant:<name>").
+func (l *Limiter) AllowWithin(key, op string, lim Limits) (bool, time.Duration) {
+	now := l.now().UTC()
+	hour := now.Truncate(time.Hour)
+	day := now.Truncate(24 * time.Hour)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	u := l.usage[key+"|"+op]
+	if u == nil {
+		u = &usage{}
+		l.usage[key+"|"+op] = u
+	}
+	if !u.hour.Equal(hour) {
+		u.hour, u.hourCount = hour, 0
+	}
+	if !u.day.Equal(day) {
+		u.day, u.dayCount = day, 0
+	}
+
+	if lim.Daily > 0 && u.dayCount >= lim.Daily {
+		exceeded.Inc(key, op)
+		return false, day.Add(24 * time.Hour).Sub(now)
+	}
+	if lim.Hourly > 0 && u.hourCount >= lim.Hourly {
+		exceeded.Inc(key, op)
+		return false, hour.Add(time.Hour).Sub(now)
+	}
+
+	u.hourCount++
+	u.dayCount++
+	consumed.Inc(key, op)
+	return true, 0
+}
+
+// WriteExceeded sends a 429 response with a Retry-After header.
+func WriteExceeded(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(retryAfter.Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, fmt.Sprintf("Too Many Requests – quota exhausted, retry in %ds", seconds), http.StatusTooManyRequests)
+}
@@ -0,0 +1,92 @@
+package quota
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newLimiter(limits map[string]Limits, now *time.Time) *Limiter {
+	l := New(limits)
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func TestHourlyLimit(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	l := newLimiter(map[string]Limits{OpMutation: {Hourly: 2}}, &now)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a", OpMutation); !ok {
+			t.Fatalf("operation %d refused", i+1)
+		}
+	}
+	ok, retry := l.Allow("a", OpMutation)
+	if ok {
+		t.Fatal("third operation in the hour allowed")
+	}
+	if retry != 30*time.Minute {
+		t.Errorf("retry after %s, want 30m until the next hour", retry)
+	}
+
+	if ok, _ := l.Allow("b", OpMutation); !ok {
+		t.Error("another token was refused, want separate budgets")
+	}
+	if ok, _ := l.Allow("a", OpCertDownload); !ok {
+		t.Error("an unlimited operation class was refused")
+	}
+
+	now = now.Add(30 * time.Minute)
+	if ok, _ := l.Allow("a", OpMutation); !ok {
+		t.Error("refused in the next hour, want the hourly window reset")
+	}
+}
+
+func TestDailyLimit(t *testing.T) {
+	now := time.Date(2024, 1, 1, 22, 0, 0, 0, time.UTC)
+	l := newLimiter(map[string]Limits{OpCertDownload: {Hourly: 10, Daily: 3}}, &now)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a", OpCertDownload); !ok {
+			t.Fatalf("download %d refused", i+1)
+		}
+		now = now.Add(time.Minute)
+	}
+	now = now.Add(time.Hour)
+	ok, retry := l.Allow("a", OpCertDownload)
+	if ok {
+		t.Fatal("fourth download of the day allowed in a new hour")
+	}
+	if want := 57 * time.Minute; retry != want {
+		t.Errorf("retry after %s, want %s until midnight UTC", retry, want)
+	}
+
+	now = now.Add(retry)
+	if ok, _ := l.Allow("a", OpCertDownload); !ok {
+		t.Error("refused after midnight, want the daily window reset")
+	}
+}
+
+func TestRefusalsDoNotConsume(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	l := newLimiter(map[string]Limits{OpMutation: {Hourly: 1, Daily: 2}}, &now)
+	l.Allow("a", OpMutation)
+	for i := 0; i < 5; i++ {
+		l.Allow("a", OpMutation)
+	}
+	now = now.Add(time.Hour)
+	if ok, _ := l.Allow("a", OpMutation); !ok {
+		t.Error("refused operations counted against the daily budget")
+	}
+}
+
+func TestWriteExceeded(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteExceeded(w, 90*time.Second+time.Millisecond)
+	if w.Code != 429 {
+		t.Errorf("status = %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "91" {
+		t.Errorf("Retry-After = %q, want 91", got)
+	}
+}
@@ -0,0 +1,223 @@
+// Package ratelimit throttles clients per IP address with a token bucket and
+// locks out addresses that keep presenting invalid credentials, so tokens
+// cannot be guessed by brute force.
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"acme-dns-tools/internal/metrics"
+)
+
+// MaxLockout caps the doubling lockout period.
+const MaxLockout = time.Hour
+
+var (
+	rejected = metrics.NewCounterVec("dns_proxy_rate_limited_total",
+		"Requests rejected with 429 by the per-IP rate limit (reason rate) or an authentication lockout (reason lockout).", "reason")
+	lockouts = metrics.NewCounterVec("dns_proxy_auth_lockouts_total",
+		"Client addresses locked out after repeated authentication failures.")
+)
+
+// Config sets the limits; a zero field disables its check.
+type Config struct {
+	// Rate is the sustained number of requests per second per address.
+	Rate float64
+	// Burst is how many requests an idle address may send at once.
+	Burst int
+	// LockoutFailures is the number of consecutive 401 answers after
+	// which an address is locked out.
+	LockoutFailures int
+	// Lockout is the first lockout period. Each further lockout of the
+	// same address doubles it, up to MaxLockout; the count is forgotten
+	// after a successful authentication or MaxLockout without failures.
+	Lockout time.Duration
+}
+
+// Limiter keeps the state of every client address.
+type Limiter struct {
+	cfg Config
+	now func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*client
+}
+
+type client struct {
+	tokens      float64
+	refilled    time.Time
+	failures    int // consecutive 401s
+	lastFailure time.Time
+	lockouts    int // lockouts so far, for the doubling
+	lockedUntil time.Time
+}
+
+// New returns a Limiter enforcing cfg.
+func New(cfg Config) *Limiter {
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	l := &Limiter{cfg: cfg, now: time.Now, clients: make(map[string]*client)}
+	go l.prune()
+	return l
+}
+
+// admit decides whether a request from ip may proceed. Otherwise it
+// returns how long the client should wait and why it was refused.
+func (l *Limiter) admit(ip string) (bool, time.Duration, string) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.clients[ip]
+	if c == nil {
+		c = &client{tokens: float64(l.cfg.Burst), refilled: now}
+		l.clients[ip] = c
+	}
+
+	if now.Before(c.lockedUntil) {
+		return false, c.lockedUntil.Sub(now), "lockout"
+	}
+	if l.cfg.Rate <= 0 {
+		return true, 0, ""
+	}
+	c.tokens += now.Sub(c.refilled).Seconds() * l.cfg.Rate
+	if c.tokens > float64(l.cfg.Burst) {
+		c.tokens = float64(l.cfg.Burst)
+	}
+	c.refilled = now
+	if c.tokens < 1 {
+		return false, time.Duration((1 - c.tokens) / l.cfg.Rate * float64(time.Second)), "rate"
+	}
+	c.tokens--
+	return true, 0, ""
+}
+
+// fail records a 401 answer to ip and locks it out once it reaches the
+// threshold.
+func (l *Limiter) fail(ip string) {
+	if l.cfg.LockoutFailures <= 0 {
+		return
+	}
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.clients[ip]
+	if c == nil {
+		return
+	}
+	if now.Sub(c.lastFailure) > MaxLockout {
+		c.failures, c.lockouts = 0, 0
+	}
+	c.failures++
+	c.lastFailure = now
+	if c.failures < l.cfg.LockoutFailures {
+		return
+	}
+	period := l.cfg.Lockout << c.lockouts
+	if period > MaxLockout || period <= 0 {
+		period = MaxLockout
+	}
+	c.lockedUntil = now.Add(period)
+	c.failures = 0
+	c.lockouts++
+	lockouts.Inc()
+	log.Printf("ratelimit: locked out %s for %s after %d failed authentications (lockout #%d)", ip, period, l.cfg.LockoutFailures, c.lockouts)
+}
+
+// succeed forgets the failures of ip.
+func (l *Limiter) succeed(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c := l.clients[ip]; c != nil {
+		c.failures, c.lockouts = 0, 0
+	}
+}
+
+// prune drops addresses that have a full bucket and nothing to remember.
+func (l *Limiter) prune() {
+	for range time.Tick(time.Minute) {
+		now := l.now()
+		l.mu.Lock()
+		for ip, c := range l.clients {
+			idle := l.cfg.Rate <= 0 || now.Sub(c.refilled).Seconds()*l.cfg.Rate+c.tokens >= float64(l.cfg.Burst)
+			if idle && now.After(c.lockedUntil) && now.Sub(c.lastFailure) > MaxLockout {
+				delete(l.clients, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+type authKey struct{}
+
+// Authenticated tells the Limiter wrapping r that r carried valid
+// credentials, which clears the failure count of its address. Only
+// explicit successes count, so requests to unauthenticated endpoints
+// cannot be used to reset it.
+func Authenticated(r *http.Request) {
+	if ok, found := r.Context().Value(authKey{}).(*atomic.Bool); found {
+		ok.Store(true)
+	}
+}
+
+// Handler answers 429 Too Many Requests, with Retry-After, to addresses over
+// the rate or locked out, and counts the 401 answers of next. Requests for
+// the exempt paths (e.g. health checks) are passed through untouched.
+func (l *Limiter) Handler(next http.Handler, exempt ...string) http.Handler {
+	skip := make(map[string]bool, len(exempt))
+	for _, p := range exempt {
+		skip[p] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if skip[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if ok, retryAfter, reason := l.admit(ip); !ok {
+			rejected.Inc(reason)
+			seconds := int(retryAfter.Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			http.Error(w, "Too Many Requests – retry in "+strconv.Itoa(seconds)+"s", http.StatusTooManyRequests)
+			return
+		}
+
+		authenticated := new(atomic.Bool)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), authKey{}, authenticated)))
+		switch {
+		case rec.status == http.StatusUnauthorized:
+			l.fail(ip)
+		case authenticated.Load():
+			l.succeed(ip)
+		}
+	})
+}
+
+// statusRecorder remembers the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// clock is a manually advanced time source.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newLimiter returns a Limiter on a manual clock, without the prune loop.
+func newLimiter(cfg Config) (*Limiter, *clock) {
+	clk := &clock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	return &Limiter{cfg: cfg, now: clk.now, clients: make(map[string]*client)}, clk
+}
+
+func TestRefill(t *testing.T) {
+	l, clk := newLimiter(Config{Rate: 2, Burst: 3})
+	const ip = "192.0.2.1"
+
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := l.admit(ip); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, wait, reason := l.admit(ip)
+	if ok || reason != "rate" {
+		t.Fatalf("admit after the burst = %v, %q; want refused for rate", ok, reason)
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("retry after %s, want 500ms at 2 requests per second", wait)
+	}
+
+	clk.advance(250 * time.Millisecond)
+	if ok, _, _ := l.admit(ip); ok {
+		t.Error("admitted after a quarter second, want half a token short")
+	}
+	clk.advance(250 * time.Millisecond)
+	if ok, _, _ := l.admit(ip); !ok {
+		t.Error("refused after half a second, want one token refilled")
+	}
+
+	// An idle client never gets more than the burst
+	clk.advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		l.admit(ip)
+	}
+	if ok, _, _ := l.admit(ip); ok {
+		t.Error("admitted a fourth request after an hour idle, want the bucket capped at the burst")
+	}
+
+	if ok, _, _ := l.admit("192.0.2.2"); !ok {
+		t.Error("another address was refused, want separate buckets")
+	}
+}
+
+func TestLockoutDoublesUpToCap(t *testing.T) {
+	l, clk := newLimiter(Config{LockoutFailures: 3, Lockout: 20 * time.Minute})
+	const ip = "192.0.2.1"
+
+	for _, want := range []time.Duration{20 * time.Minute, 40 * time.Minute, MaxLockout, MaxLockout} {
+		l.admit(ip)
+		for i := 0; i < 3; i++ {
+			l.fail(ip)
+		}
+		ok, wait, reason := l.admit(ip)
+		if ok || reason != "lockout" {
+			t.Fatalf("admit after 3 failures = %v, %q; want a lockout", ok, reason)
+		}
+		if wait != want {
+			t.Errorf("lockout = %s, want %s", wait, want)
+		}
+		clk.advance(wait)
+		if ok, _, _ := l.admit(ip); !ok {
+			t.Fatalf("still refused once the %s lockout ended", wait)
+		}
+	}
+}
+
+func TestFailuresBelowThreshold(t *testing.T) {
+	l, _ := newLimiter(Config{LockoutFailures: 3, Lockout: time.Minute})
+	const ip = "192.0.2.1"
+	l.admit(ip)
+	l.fail(ip)
+	l.fail(ip)
+	if ok, _, _ := l.admit(ip); !ok {
+		t.Error("locked out after 2 of 3 failures")
+	}
+}
+
+func TestSuccessResetsLockout(t *testing.T) {
+	l, clk := newLimiter(Config{LockoutFailures: 2, Lockout: time.Minute})
+	const ip = "192.0.2.1"
+
+	l.admit(ip)
+	l.fail(ip)
+	l.fail(ip)
+	clk.advance(time.Minute)
+	l.succeed(ip)
+
+	// Earlier failures no longer count, and the lockout starts over at 1m
+	l.fail(ip)
+	if ok, _, _ := l.admit(ip); !ok {
+		t.Fatal("locked out after one failure following a success")
+	}
+	l.fail(ip)
+	if _, wait, _ := l.admit(ip); wait != time.Minute {
+		t.Errorf("lockout after a success = %s, want the first period again (1m)", wait)
+	}
+}
+
+func TestFailuresForgottenAfterMaxLockout(t *testing.T) {
+	l, clk := newLimiter(Config{LockoutFailures: 2, Lockout: time.Minute})
+	const ip = "192.0.2.1"
+	l.admit(ip)
+	l.fail(ip)
+	clk.advance(MaxLockout + time.Second)
+	l.fail(ip)
+	if ok, _, _ := l.admit(ip); !ok {
+		t.Error("failures more than MaxLockout apart caused a lockout")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	l, _ := newLimiter(Config{LockoutFailures: 2, Lockout: time.Minute})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		Authenticated(r)
+	})
+	h := l.Handler(next, "/readyz")
+	do := func(path, auth string) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("Authorization", auth)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	do("/set_txt", "Bearer bad")
+	if code := do("/set_txt", "Bearer good"); code != http.StatusOK {
+		t.Fatalf("valid request after one failure = %d, want 200", code)
+	}
+	do("/set_txt", "Bearer bad")
+	if code := do("/set_txt", "Bearer good"); code != http.StatusOK {
+		t.Fatalf("second failure counted together with the one before the success (status %d)", code)
+	}
+	do("/set_txt", "Bearer bad")
+	do("/set_txt", "Bearer bad")
+	if code := do("/set_txt", "Bearer good"); code != http.StatusTooManyRequests {
+		t.Errorf("valid request during a lockout = %d, want 429", code)
+	}
+	if code := do("/readyz", ""); code != http.StatusUnauthorized {
+		t.Errorf("exempt path during a lockout = %d, want it passed through", code)
+	}
+}
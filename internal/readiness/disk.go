@@ -0,0 +1,87 @@
+package readiness
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"acme-dns-tools/internal/metrics"
+)
+
+var (
+	diskFreeBytes = metrics.NewGaugeVec("dns_proxy_disk_free_bytes",
+		"Free disk space available to the process, by checked directory.", "path")
+	checkOK = metrics.NewGaugeVec("dns_proxy_health_check_ok",
+		"1 if the named readiness check passed on its last run, 0 otherwise.", "check")
+)
+
+// DiskCheck verifies a directory the service depends on.
+type DiskCheck struct {
+	Name     string // check name reported on /readyz and in metrics
+	Dir      string
+	MinFree  uint64 // bytes; 0 skips the free space check
+	Writable bool   // also check that a file can be created in Dir
+}
+
+// Run performs the check and records the free space metric.
+func (d DiskCheck) Run() error {
+	info, err := os.Stat(d.Dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", d.Dir)
+	}
+	if _, err := os.ReadDir(d.Dir); err != nil {
+		return err
+	}
+
+	if free, ok := freeBytes(d.Dir); ok {
+		diskFreeBytes.Set(float64(free), d.Dir)
+		if d.MinFree > 0 && free < d.MinFree {
+			return fmt.Errorf("%s: only %d MB free (minimum %d MB)", d.Dir, free>>20, d.MinFree>>20)
+		}
+	}
+
+	if d.Writable {
+		f, err := os.CreateTemp(d.Dir, ".dns-proxy-health-*")
+		if err != nil {
+			return fmt.Errorf("%s is not writable: %w", d.Dir, err)
+		}
+		name := f.Name()
+		_, err = f.Write([]byte("ok\n"))
+		f.Close()
+		os.Remove(name)
+		if err != nil {
+			return fmt.Errorf("%s is not writable: %w", d.Dir, err)
+		}
+	}
+	return nil
+}
+
+// RunEvery runs check now and then every interval, recording the result
+// under name and logging changes.
+func (c *Checks) RunEvery(name string, interval time.Duration, check func() error, logf func(format string, args ...any)) {
+	run := func(last error) error {
+		err := check()
+		c.Set(name, err)
+		if err != nil {
+			checkOK.Set(0, name)
+			if last == nil || last.Error() != err.Error() {
+				logf("readiness: %s check failed: %v", name, err)
+			}
+		} else {
+			checkOK.Set(1, name)
+			if last != nil {
+				logf("readiness: %s check passes again", name)
+			}
+		}
+		return err
+	}
+	last := run(nil)
+	go func() {
+		for range time.Tick(interval) {
+			last = run(last)
+		}
+	}()
+}
@@ -0,0 +1,70 @@
+// Package readiness tracks named health checks and serves them on /readyz,
+// so orchestrators and monitors stop routing to an instance that could not
+// complete a renewal right now.
+package readiness
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Status is the body of GET /readyz. Failing only names the checks; the
+// details are logged, as the endpoint needs no authentication.
+type Status struct {
+	Ready   bool     `json:"ready"`
+	Failing []string `json:"failing,omitempty"`
+}
+
+// Checks holds the latest result of every named check.
+type Checks struct {
+	mu     sync.RWMutex
+	failed map[string]error
+}
+
+// New returns a Checks with nothing failing.
+func New() *Checks {
+	return &Checks{failed: make(map[string]error)}
+}
+
+// Set records the result of check name; a nil err marks it as passing.
+func (c *Checks) Set(name string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		delete(c.failed, name)
+		return
+	}
+	c.failed[name] = err
+}
+
+// Status returns a snapshot of the current state.
+func (c *Checks) Status() Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	st := Status{Ready: len(c.failed) == 0}
+	for name := range c.failed {
+		st.Failing = append(st.Failing, name)
+	}
+	sort.Strings(st.Failing)
+	return st
+}
+
+// Handler serves the state on GET /readyz: 200 when every check passes,
+// 503 otherwise.
+func (c *Checks) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		st := c.Status()
+		w.Header().Set("Content-Type", "application/json")
+		if !st.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(st)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package readiness
+
+// freeBytes is not implemented on this platform; free space is not checked.
+func freeBytes(dir string) (uint64, bool) {
+	return 0, false
+}
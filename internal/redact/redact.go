@@ -0,0 +1,103 @@
+// Package redact removes secrets (API tokens, ACME challenge values and
+// private key material) from text before it is logged or sent to a client.
+package redact
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const placeholder = "[REDACTED]"
+
+// minSecretLen keeps very short values from being registered, since
+// replacing them would mangle unrelated text.
+const minSecretLen = 6
+
+var (
+	mu      sync.RWMutex
+	secrets = map[string][]string{} // by set, see SetSecrets
+)
+
+var patterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// PEM private keys, complete or truncated.
+	{regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----.*?(-----END [A-Z0-9 ]*PRIVATE KEY-----|$)`), "[REDACTED PRIVATE KEY]"},
+	// Authorization header values (Bearer tokens, cPanel user:token pairs).
+	{regexp.MustCompile(`(?i)\b(bearer)\s+[^\s'"]+`), "$1 " + placeholder},
+	{regexp.MustCompile(`\b(cpanel [^\s:'"]+):[^\s'"]+`), "$1:" + placeholder},
+	// TXT data and challenge values as printed by the cPanel client, either
+	// as key='value' / Key: value or as JSON "txtdata":"value".
+	{regexp.MustCompile(`(?i)("?\b(?:txtdata|value|oldvalue|newvalue)"?\s*[=:]\s*)('[^']*'|"(?:[^"\\]|\\.)*"|[^\s,}]+)`), "${1}" + placeholder},
+}
+
+// AddSecret registers a literal value (a token or API key) that must never
+// appear in redacted output.
+func AddSecret(s string) {
+	if len(s) < minSecretLen {
+		return
+	}
+	mu.Lock()
+	secrets[""] = append(secrets[""], s)
+	mu.Unlock()
+}
+
+// SetSecrets replaces the values registered under set, e.g. the tokens read
+// from a config file on every reload, so rotated tokens do not pile up.
+func SetSecrets(set string, values ...string) {
+	var keep []string
+	for _, v := range values {
+		if len(v) >= minSecretLen {
+			keep = append(keep, v)
+		}
+	}
+	mu.Lock()
+	if len(keep) == 0 {
+		delete(secrets, set)
+	} else {
+		secrets[set] = keep
+	}
+	mu.Unlock()
+}
+
+// String returns s with all registered secrets, the given extra values and
+// known secret patterns replaced by a placeholder.
+func String(s string, extra ...string) string {
+	mu.RLock()
+	for _, set := range secrets {
+		for _, secret := range set {
+			s = strings.ReplaceAll(s, secret, placeholder)
+		}
+	}
+	mu.RUnlock()
+	for _, secret := range extra {
+		if len(secret) >= minSecretLen {
+			s = strings.ReplaceAll(s, secret, placeholder)
+		}
+	}
+	for _, p := range patterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+// Writer wraps w so that everything written through it is redacted. It is
+// meant for log.SetOutput: the log package issues one Write per entry, so
+// patterns never straddle two writes.
+func Writer(w io.Writer) io.Writer {
+	return writer{w: w}
+}
+
+type writer struct {
+	w io.Writer
+}
+
+func (rw writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,20 @@
+package redact
+
+import "testing"
+
+func TestSetSecretsReplacesSet(t *testing.T) {
+	defer SetSecrets("test")
+
+	SetSecrets("test", "old-token-123")
+	if got := String("token old-token-123"); got != "token "+placeholder {
+		t.Errorf("String = %q, want the old token redacted", got)
+	}
+
+	SetSecrets("test", "new-token-456")
+	if got := String("old-token-123 new-token-456"); got != "old-token-123 "+placeholder {
+		t.Errorf("String = %q, want only the new token redacted", got)
+	}
+	if n := len(secrets["test"]); n != 1 {
+		t.Errorf("set holds %d secrets after two reloads, want 1", n)
+	}
+}
@@ -0,0 +1,109 @@
+// Package server runs the HTTP listeners with timeouts and a graceful
+// shutdown, so a systemd restart lets in-flight requests finish instead of
+// cutting them off mid-way through a DNS change.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultAddr is the listen address used without LISTEN_ADDR.
+const DefaultAddr = ":5000"
+
+// readHeaderTimeout bounds how long a client may take to send its headers.
+const readHeaderTimeout = 10 * time.Second
+
+// Options are the listener settings of a config file.
+type Options struct {
+	Addr            string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration // how long Serve waits for in-flight requests
+}
+
+// Config is the listener part of a config file, for config.SchemaOf and
+// config.Decode; timeouts are in seconds.
+type Config struct {
+	ListenAddr      string        `config:"LISTEN_ADDR" default:":5000"`
+	ReadTimeout     time.Duration `config:"HTTP_READ_TIMEOUT,positive" default:"30"`
+	WriteTimeout    time.Duration `config:"HTTP_WRITE_TIMEOUT,positive"`
+	IdleTimeout     time.Duration `config:"HTTP_IDLE_TIMEOUT,positive" default:"120"`
+	ShutdownTimeout time.Duration `config:"SHUTDOWN_TIMEOUT,positive" default:"30"`
+}
+
+// Options returns the listener settings of c, with writeTimeout unless
+// HTTP_WRITE_TIMEOUT is set.
+func (c Config) Options(writeTimeout time.Duration) Options {
+	o := Options{
+		Addr:            c.ListenAddr,
+		ReadTimeout:     c.ReadTimeout,
+		WriteTimeout:    c.WriteTimeout,
+		IdleTimeout:     c.IdleTimeout,
+		ShutdownTimeout: c.ShutdownTimeout,
+	}
+	if o.Addr == "" {
+		o.Addr = DefaultAddr
+	}
+	if o.WriteTimeout == 0 {
+		o.WriteTimeout = writeTimeout
+	}
+	return o
+}
+
+// Server returns an http.Server for handler with the configured timeouts.
+func (o Options) Server(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              o.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       o.ReadTimeout,
+		WriteTimeout:      o.WriteTimeout,
+		IdleTimeout:       o.IdleTimeout,
+	}
+}
+
+// Serve runs srv (over TLS when certFile and keyFile are set) until the
+// process receives SIGTERM or SIGINT. It then calls onSignal, if non-nil,
+// stops accepting connections and waits up to shutdownTimeout for in-flight
+// requests. It returns nil once they have finished, or the error that
+// stopped the listener.
+func Serve(srv *http.Server, certFile, keyFile string, shutdownTimeout time.Duration, onSignal func(os.Signal)) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sigs)
+
+	done := make(chan error, 1)
+	go func() {
+		sig := <-sigs
+		if onSignal != nil {
+			onSignal(sig)
+		}
+		log.Printf("received %s, waiting up to %s for in-flight requests", sig, shutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		done <- srv.Shutdown(ctx)
+	}()
+
+	var err error
+	if certFile != "" && keyFile != "" {
+		err = srv.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Package signing produces detached Ed25519 signatures for served files in
+// the minisign format, so clients can verify files end-to-end even when TLS
+// is terminated by an intermediate proxy.
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// minisign's identifier for non-prehashed Ed25519 signatures.
+var algorithm = []byte("Ed")
+
+// Signer signs data with a server-held Ed25519 key.
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID [8]byte
+}
+
+// LoadSigner reads a PEM-encoded PKCS#8 Ed25519 private key, as produced by
+// `openssl genpkey -algorithm ed25519`.
+func LoadSigner(path string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("expected an Ed25519 key, got %T", parsed)
+	}
+
+	s := &Signer{key: key}
+	// The key ID only has to be stable; derive it from the public key.
+	sum := sha256.Sum256(key.Public().(ed25519.PublicKey))
+	copy(s.keyID[:], sum[:8])
+	return s, nil
+}
+
+// PublicKey returns the public key in minisign format, for use with
+// `minisign -V -P <key>`.
+func (s *Signer) PublicKey() string {
+	buf := append(append(append([]byte{}, algorithm...), s.keyID[:]...), s.key.Public().(ed25519.PublicKey)...)
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// Sign returns a minisign signature file for data. name is recorded in the
+// trusted (signed) comment together with the signing time.
+func (s *Signer) Sign(data []byte, name string) []byte {
+	sig := ed25519.Sign(s.key, data)
+	sigBlob := append(append(append([]byte{}, algorithm...), s.keyID[:]...), sig...)
+
+	trusted := fmt.Sprintf("timestamp:%d\tfile:%s", time.Now().Unix(), name)
+	global := ed25519.Sign(s.key, append(append([]byte{}, sig...), trusted...))
+
+	return []byte(fmt.Sprintf("untrusted comment: signature from dns-proxy-api\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(sigBlob),
+		trusted,
+		base64.StdEncoding.EncodeToString(global)))
+}
@@ -0,0 +1,98 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeKey(t *testing.T, key any) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestSignVerifies checks a signature the way minisign -V does.
+func TestSignVerifies(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSigner(writeKey(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := base64.StdEncoding.DecodeString(s.PublicKey())
+	if err != nil || len(pub) != 2+8+ed25519.PublicKeySize || string(pub[:2]) != "Ed" {
+		t.Fatalf("public key %q: %v", s.PublicKey(), err)
+	}
+	keyID, pubKey := pub[2:10], ed25519.PublicKey(pub[10:])
+
+	data := []byte("-----BEGIN CERTIFICATE-----\n...\n")
+	lines := strings.Split(strings.TrimSuffix(string(s.Sign(data, "fullchain.pem")), "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "untrusted comment: ") {
+		t.Fatalf("signature file = %q", lines)
+	}
+	blob, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(blob) != 2+8+ed25519.SignatureSize {
+		t.Fatalf("signature line %q: %v", lines[1], err)
+	}
+	if string(blob[:2]) != "Ed" || !bytes.Equal(blob[2:10], keyID) {
+		t.Errorf("signature algorithm or key ID does not match the public key")
+	}
+	sig := blob[10:]
+	if !ed25519.Verify(pubKey, data, sig) {
+		t.Error("signature does not verify")
+	}
+	if ed25519.Verify(pubKey, append(data, '\n'), sig) {
+		t.Error("signature verifies for altered data")
+	}
+
+	trusted, ok := strings.CutPrefix(lines[2], "trusted comment: ")
+	if !ok || !strings.HasPrefix(trusted, "timestamp:") || !strings.HasSuffix(trusted, "\tfile:fullchain.pem") {
+		t.Errorf("trusted comment = %q", lines[2])
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || !ed25519.Verify(pubKey, append(append([]byte{}, sig...), trusted...), global) {
+		t.Errorf("global signature over the trusted comment does not verify: %v", err)
+	}
+}
+
+func TestLoadSignerErrors(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notPEM := filepath.Join(t.TempDir(), "key.txt")
+	os.WriteFile(notPEM, []byte("not a key"), 0600)
+	badDER := filepath.Join(t.TempDir(), "bad.pem")
+	os.WriteFile(badDER, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")}), 0600)
+
+	for path, want := range map[string]string{
+		filepath.Join(t.TempDir(), "missing.pem"): "no such file",
+		notPEM:             "no PEM block",
+		badDER:             "failed to parse private key",
+		writeKey(t, ecKey): "expected an Ed25519 key",
+	} {
+		if _, err := LoadSigner(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("LoadSigner(%s) = %v, want %q", filepath.Base(path), err, want)
+		}
+	}
+}
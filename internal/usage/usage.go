@@ -0,0 +1,162 @@
+// Package usage meters what each token consumes (operations, bytes served,
+// distinct domains) since the process started, for chargeback reports and
+// for spotting anomalous consumers.
+package usage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"acme-dns-tools/internal/bearer"
+	"acme-dns-tools/internal/metrics"
+)
+
+// Tracker aggregates usage per token. A nil *Tracker discards everything.
+type Tracker struct {
+	mu      sync.Mutex
+	since   time.Time
+	byToken map[string]*tokenUsage
+}
+
+type tokenUsage struct {
+	ops       map[string]uint64
+	bytes     uint64
+	domains   map[string]bool
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// Row is the usage of one token.
+type Row struct {
+	Token         string            `json:"token"`
+	Operations    map[string]uint64 `json:"operations"`
+	BytesServed   uint64            `json:"bytes_served"`
+	UniqueDomains int               `json:"unique_domains"`
+	FirstSeen     time.Time         `json:"first_seen"`
+	LastSeen      time.Time         `json:"last_seen"`
+}
+
+// Report is the full usage report.
+type Report struct {
+	Since  time.Time `json:"since"`
+	Tokens []Row     `json:"tokens"`
+}
+
+// New returns an empty Tracker.
+func New() *Tracker {
+	return &Tracker{since: time.Now().UTC(), byToken: make(map[string]*tokenUsage)}
+}
+
+// Record counts one operation by tokenID on domain (may be empty when the
+// operation is not tied to a single domain) that served n bytes.
+func (t *Tracker) Record(tokenID, op, domain string, n int) {
+	if t == nil {
+		return
+	}
+	now := time.Now().UTC()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u, ok := t.byToken[tokenID]
+	if !ok {
+		u = &tokenUsage{ops: make(map[string]uint64), domains: make(map[string]bool), firstSeen: now}
+		t.byToken[tokenID] = u
+	}
+	u.ops[op]++
+	u.bytes += uint64(n)
+	if domain != "" {
+		u.domains[domain] = true
+	}
+	u.lastSeen = now
+}
+
+// Report returns the usage of every token, ordered by token.
+func (t *Tracker) Report() Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := Report{Since: t.since, Tokens: []Row{}}
+	for token, u := range t.byToken {
+		ops := make(map[string]uint64, len(u.ops))
+		for op, n := range u.ops {
+			ops[op] = n
+		}
+		report.Tokens = append(report.Tokens, Row{
+			Token:         token,
+			Operations:    ops,
+			BytesServed:   u.bytes,
+			UniqueDomains: len(u.domains),
+			FirstSeen:     u.firstSeen,
+			LastSeen:      u.lastSeen,
+		})
+	}
+	sort.Slice(report.Tokens, func(i, j int) bool { return report.Tokens[i].Token < report.Tokens[j].Token })
+	return report
+}
+
+// AdminHandler serves GET /admin/usage as JSON, or as CSV with ?format=csv.
+func (t *Tracker) AdminHandler(adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !bearer.Matches(r, adminToken) {
+			metrics.AuthDenials.Inc("admin_usage", metrics.ReasonBearer)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		report := t.Report()
+		switch r.URL.Query().Get("format") {
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(report)
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+			writeCSV(w, report)
+		default:
+			http.Error(w, "Invalid format – expected json or csv", http.StatusBadRequest)
+		}
+	}
+}
+
+// writeCSV writes one line per token with a column per operation type.
+func writeCSV(w http.ResponseWriter, report Report) {
+	opSet := make(map[string]bool)
+	for _, row := range report.Tokens {
+		for op := range row.Operations {
+			opSet[op] = true
+		}
+	}
+	ops := make([]string, 0, len(opSet))
+	for op := range opSet {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	cw := csv.NewWriter(w)
+	header := append([]string{"token"}, ops...)
+	header = append(header, "bytes_served", "unique_domains", "first_seen", "last_seen")
+	cw.Write(header)
+	for _, row := range report.Tokens {
+		record := []string{row.Token}
+		for _, op := range ops {
+			record = append(record, strconv.FormatUint(row.Operations[op], 10))
+		}
+		record = append(record,
+			strconv.FormatUint(row.BytesServed, 10),
+			strconv.Itoa(row.UniqueDomains),
+			row.FirstSeen.Format(time.RFC3339),
+			row.LastSeen.Format(time.RFC3339))
+		cw.Write(record)
+	}
+	cw.Flush()
+}
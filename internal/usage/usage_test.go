@@ -0,0 +1,100 @@
+package usage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	tr := New()
+	tr.Record("tok-b", "mutation", "example.com", 0)
+	tr.Record("tok-a", "cert_download", "example.com", 1200)
+	tr.Record("tok-a", "cert_download", "www.example.com", 800)
+	tr.Record("tok-a", "cert_download", "example.com", 1000)
+	tr.Record("tok-a", "mutation", "", 0)
+
+	report := tr.Report()
+	if len(report.Tokens) != 2 || report.Tokens[0].Token != "tok-a" || report.Tokens[1].Token != "tok-b" {
+		t.Fatalf("tokens = %+v, want tok-a then tok-b", report.Tokens)
+	}
+	a := report.Tokens[0]
+	if want := map[string]uint64{"cert_download": 3, "mutation": 1}; !reflect.DeepEqual(a.Operations, want) {
+		t.Errorf("tok-a operations = %v, want %v", a.Operations, want)
+	}
+	if a.BytesServed != 3000 || a.UniqueDomains != 2 {
+		t.Errorf("tok-a = %d bytes, %d domains; want 3000 and 2", a.BytesServed, a.UniqueDomains)
+	}
+	if a.FirstSeen.IsZero() || a.LastSeen.Before(a.FirstSeen) || a.FirstSeen.Before(report.Since) {
+		t.Errorf("tok-a seen %v to %v, since %v", a.FirstSeen, a.LastSeen, report.Since)
+	}
+
+	// The report is a copy
+	a.Operations["mutation"] = 99
+	if tr.Report().Tokens[0].Operations["mutation"] != 1 {
+		t.Error("changing a report changed the tracker")
+	}
+}
+
+func TestNilTrackerDiscards(t *testing.T) {
+	var tr *Tracker
+	tr.Record("tok", "mutation", "example.com", 0)
+}
+
+func TestAdminHandler(t *testing.T) {
+	tr := New()
+	tr.Record("tok-a", "mutation", "example.com", 0)
+	tr.Record("tok-b", "cert_download", "example.com", 512)
+	h := tr.AdminHandler("admin-token")
+	get := func(method, target, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	for _, tc := range []struct {
+		method, target, token string
+		want                  int
+	}{
+		{http.MethodGet, "/admin/usage", "", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/usage", "api-token", http.StatusUnauthorized},
+		{http.MethodPost, "/admin/usage", "admin-token", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/admin/usage?format=xml", "admin-token", http.StatusBadRequest},
+	} {
+		if w := get(tc.method, tc.target, tc.token); w.Code != tc.want {
+			t.Errorf("%s %s as %q = %d, want %d", tc.method, tc.target, tc.token, w.Code, tc.want)
+		}
+	}
+
+	w := get(http.MethodGet, "/admin/usage", "admin-token")
+	var report Report
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || len(report.Tokens) != 2 {
+		t.Fatalf("JSON report = %q, %v", w.Body.String(), err)
+	}
+
+	w = get(http.MethodGet, "/admin/usage?format=csv", "admin-token")
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("CSV Content-Type = %q", ct)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHeader := []string{"token", "cert_download", "mutation", "bytes_served", "unique_domains", "first_seen", "last_seen"}
+	if len(rows) != 3 || !reflect.DeepEqual(rows[0], wantHeader) {
+		t.Fatalf("CSV = %q", rows)
+	}
+	if got := rows[1][:5]; !reflect.DeepEqual(got, []string{"tok-a", "0", "1", "0", "1"}) {
+		t.Errorf("tok-a row = %q", got)
+	}
+	if got := rows[2][:5]; !reflect.DeepEqual(got, []string{"tok-b", "1", "0", "512", "1"}) {
+		t.Errorf("tok-b row = %q", got)
+	}
+}
@@ -0,0 +1,307 @@
+// Package zonefile reads and writes DNS records in the RFC 1035 master file
+// ("zone file") format, as understood by BIND and most DNS providers.
+package zonefile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"acme-dns-tools/internal/dnsname"
+)
+
+const defaultTTL = 14400
+
+// Record represents a single resource record.
+// Name is always fully qualified with a trailing dot; Data holds the record
+// data in presentation format (e.g. `10 mail.example.com.` for MX).
+type Record struct {
+	Name  string
+	TTL   int
+	Class string
+	Type  string
+	Data  string
+}
+
+// Write serializes records to w in zone-file format. Names inside origin are
+// written relative to it, so the output can be re-imported under another zone.
+func Write(w io.Writer, origin string, records []Record) error {
+	origin = dnsname.Fqdn(origin)
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "$ORIGIN %s\n", origin)
+	for _, rec := range records {
+		class := rec.Class
+		if class == "" {
+			class = "IN"
+		}
+		fmt.Fprintf(bw, "%-30s %-6d %-2s %-6s %s\n", relativeName(rec.Name, origin), rec.TTL, class, rec.Type, rec.Data)
+	}
+	return bw.Flush()
+}
+
+// Parse reads a zone file from r. Relative names are completed with origin
+// (or with the last $ORIGIN directive). Parenthesized multi-line records are
+// joined before parsing; $INCLUDE is not supported.
+func Parse(r io.Reader, origin string) ([]Record, error) {
+	origin = dnsname.Fqdn(origin)
+	ttl := defaultTTL
+	lastName := origin
+
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	pending := ""
+	depth := 0
+	for scanner.Scan() {
+		lineNo++
+		line, delta := ungroup(stripComment(scanner.Text()))
+		depth += delta
+		if depth < 0 {
+			return nil, fmt.Errorf("line %d: unbalanced parentheses", lineNo)
+		}
+		pending += line + " "
+		if depth > 0 {
+			continue
+		}
+		line, pending = pending, ""
+		startsBlank := line != "" && (line[0] == ' ' || line[0] == '\t')
+
+		fields := tokenize(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "$ORIGIN":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: $ORIGIN requires a name", lineNo)
+			}
+			origin = absoluteName(fields[1], origin)
+			continue
+		case "$TTL":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: $TTL requires a value", lineNo)
+			}
+			v, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid $TTL %q", lineNo, fields[1])
+			}
+			ttl = v
+			continue
+		case "$INCLUDE":
+			return nil, fmt.Errorf("line %d: $INCLUDE is not supported", lineNo)
+		}
+
+		rec := Record{TTL: ttl, Class: "IN"}
+		if startsBlank {
+			rec.Name = lastName
+		} else {
+			rec.Name = absoluteName(fields[0], origin)
+			fields = fields[1:]
+		}
+
+		// TTL and class are both optional and may appear in either order.
+		for len(fields) > 0 {
+			if v, err := strconv.Atoi(fields[0]); err == nil {
+				rec.TTL = v
+			} else if isClass(fields[0]) {
+				rec.Class = strings.ToUpper(fields[0])
+			} else {
+				break
+			}
+			fields = fields[1:]
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected record type and data", lineNo)
+		}
+
+		rec.Type = strings.ToUpper(fields[0])
+		rec.Data = strings.Join(absoluteTargets(rec.Type, fields[1:], origin), " ")
+		lastName = rec.Name
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses at end of file")
+	}
+	return records, nil
+}
+
+// Fields splits record data into its fields, removing quotes from quoted
+// strings. For TXT records the character-strings are returned separately;
+// join them to obtain the record value.
+func Fields(data string) []string {
+	var out []string
+	for _, f := range tokenize(data) {
+		if len(f) >= 2 && f[0] == '"' && f[len(f)-1] == '"' {
+			f = unquote(f[1 : len(f)-1])
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// Quote returns s as one or more quoted character-strings, split at the
+// 255-byte limit of a single TXT string.
+func Quote(s string) string {
+	var parts []string
+	for {
+		chunk := s
+		if len(chunk) > 255 {
+			chunk = s[:255]
+		}
+		r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+		parts = append(parts, `"`+r.Replace(chunk)+`"`)
+		s = s[len(chunk):]
+		if s == "" {
+			break
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Sort orders records by name and type so exports are stable and diffable.
+func Sort(records []Record) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return records[i].Type < records[j].Type
+	})
+}
+
+func relativeName(name, origin string) string {
+	name = dnsname.Fqdn(name)
+	if strings.EqualFold(name, origin) {
+		return "@"
+	}
+	if strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(origin)) {
+		return name[:len(name)-len(origin)-1]
+	}
+	return name
+}
+
+func absoluteName(name, origin string) string {
+	if name == "@" {
+		return origin
+	}
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "." + origin
+}
+
+// absoluteTargets completes relative domain names inside the record data of
+// types whose data references another name.
+func absoluteTargets(rrtype string, data []string, origin string) []string {
+	idx := map[string]int{"CNAME": 0, "NS": 0, "PTR": 0, "MX": 1, "SRV": 3}
+	i, ok := idx[rrtype]
+	if !ok || i >= len(data) {
+		return data
+	}
+	out := append([]string(nil), data...)
+	out[i] = absoluteName(out[i], origin)
+	return out
+}
+
+func isClass(s string) bool {
+	switch strings.ToUpper(s) {
+	case "IN", "CH", "HS", "CS":
+		return true
+	}
+	return false
+}
+
+// stripComment removes a trailing ';' comment that is not inside quotes.
+func stripComment(line string) string {
+	inQuote := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuote = !inQuote
+		case ';':
+			if !inQuote {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// ungroup replaces the grouping parentheses of a multi-line record with
+// spaces and returns how many more it opens than closes. Parentheses inside
+// quoted strings are record data and are left alone.
+func ungroup(line string) (string, int) {
+	b := []byte(line)
+	depth := 0
+	inQuote := false
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuote = !inQuote
+		case '(', ')':
+			if inQuote {
+				continue
+			}
+			if b[i] == '(' {
+				depth++
+			} else {
+				depth--
+			}
+			b[i] = ' '
+		}
+	}
+	return string(b), depth
+}
+
+// tokenize splits a line on whitespace, keeping quoted strings (with their
+// quotes) together as single tokens.
+func tokenize(line string) []string {
+	var fields []string
+	var cur strings.Builder
+	inQuote := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line):
+			cur.WriteByte(c)
+			cur.WriteByte(line[i+1])
+			i++
+		case c == '"':
+			inQuote = !inQuote
+			cur.WriteByte(c)
+		case (c == ' ' || c == '\t') && !inQuote:
+			if cur.Len() > 0 {
+				fields = append(fields, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if cur.Len() > 0 {
+		fields = append(fields, cur.String())
+	}
+	return fields
+}
+
+func unquote(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,92 @@
+package zonefile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+		want []Record
+	}{
+		{
+			name: "parentheses inside quotes are data",
+			zone: `@ 300 IN TXT "v=spf1 (note) -all"` + "\n" +
+				`smile 300 IN TXT "smile :)"` + "\n",
+			want: []Record{
+				{Name: "example.com.", TTL: 300, Class: "IN", Type: "TXT", Data: `"v=spf1 (note) -all"`},
+				{Name: "smile.example.com.", TTL: 300, Class: "IN", Type: "TXT", Data: `"smile :)"`},
+			},
+		},
+		{
+			name: "escaped quote does not end the string",
+			zone: `@ 300 IN TXT "a \" (b"` + "\n",
+			want: []Record{
+				{Name: "example.com.", TTL: 300, Class: "IN", Type: "TXT", Data: `"a \" (b"`},
+			},
+		},
+		{
+			name: "multi-line record",
+			zone: "@ 3600 IN SOA ns1.example.com. hostmaster.example.com. (\n" +
+				"    2024010101 ; serial\n" +
+				"    7200 3600 1209600 300 )\n" +
+				`txt IN TXT ( "part one" ; comment (` + "\n" +
+				`    "part (two)" )` + "\n",
+			want: []Record{
+				{Name: "example.com.", TTL: 3600, Class: "IN", Type: "SOA", Data: "ns1.example.com. hostmaster.example.com. 2024010101 7200 3600 1209600 300"},
+				{Name: "txt.example.com.", TTL: defaultTTL, Class: "IN", Type: "TXT", Data: `"part one" "part (two)"`},
+			},
+		},
+		{
+			name: "$ORIGIN and $TTL",
+			zone: "$TTL 600\n" +
+				"www IN A 192.0.2.1\n" +
+				"$ORIGIN sub.example.com.\n" +
+				"host A 192.0.2.2\n" +
+				"     MX 10 mail\n" +
+				"$ORIGIN deeper\n" +
+				"@ 60 CNAME target.example.net.\n",
+			want: []Record{
+				{Name: "www.example.com.", TTL: 600, Class: "IN", Type: "A", Data: "192.0.2.1"},
+				{Name: "host.sub.example.com.", TTL: 600, Class: "IN", Type: "A", Data: "192.0.2.2"},
+				{Name: "host.sub.example.com.", TTL: 600, Class: "IN", Type: "MX", Data: "10 mail.sub.example.com."},
+				{Name: "deeper.sub.example.com.", TTL: 60, Class: "IN", Type: "CNAME", Data: "target.example.net."},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.zone), "example.com")
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, zone := range []string{
+		"@ IN SOA ns1 host ( 1 2 3\n",
+		"@ IN TXT ( \"a\" ))\n",
+		"$TTL soon\n",
+		"$INCLUDE other.zone\n",
+	} {
+		if _, err := Parse(strings.NewReader(zone), "example.com"); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", zone)
+		}
+	}
+}
+
+func TestFieldsRoundTrip(t *testing.T) {
+	value := `v=spf1 (note) "quoted" \ -all`
+	got := strings.Join(Fields(Quote(value)), "")
+	if got != value {
+		t.Errorf("Fields(Quote(%q)) = %q", value, got)
+	}
+}